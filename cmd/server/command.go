@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ms-user/conf"
+	"os"
+)
+
+const usage = `usage:
+  server                            start the HTTP server
+  server config print [--redacted]  print the resolved configuration as YAML
+`
+
+// runCommand runs the sub-command of args and returns the exit code
+func runCommand(args []string) int {
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		return configPrint(args[2:])
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+func configPrint(args []string) int {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redacted := fs.Bool("redacted", false, "replace the secrets by [REDACTED]")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := conf.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err = conf.Print(os.Stdout, cfg, *redacted); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"gitlab.com/goxp/cloud0/logger"
 	"ms-user/conf"
+	"ms-user/pkg/repo"
 	"ms-user/pkg/route"
+	"ms-user/pkg/tracing"
 	"ms-user/pkg/utils"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const (
@@ -14,24 +20,43 @@ const (
 )
 
 func main() {
-	conf.SetEnv()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	if err := conf.SetEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	logger.Init(APPNAME)
 	utils.LoadMessageError()
 
-	// TO DEBUG - No need config when deploy
-	_ = os.Setenv("PORT", conf.LoadEnv().Port)
-	_ = os.Setenv("DB_HOST", conf.LoadEnv().DBHost)
-	_ = os.Setenv("DB_PORT", conf.LoadEnv().DBPort)
-	_ = os.Setenv("DB_USER", conf.LoadEnv().DBUser)
-	_ = os.Setenv("DB_PASS", conf.LoadEnv().DBPass)
-	_ = os.Setenv("DB_NAME", conf.LoadEnv().DBName)
-	_ = os.Setenv("ENABLE_DB", conf.LoadEnv().EnableDB)
+	conf.ExportCloud0Env()
+	if conf.LoadEnv().DBDriver == repo.DriverSQLite {
+		// cloud0 DB setup is Postgres oriented (DSN, schema prefix), the sqlite file is opened by route.NewService
+		_ = os.Setenv("ENABLE_DB", "false")
+	}
 
-	app := route.NewService()
-	ctx := context.Background()
-	err := app.Start(ctx)
+	tracer, err := tracing.Init(tracing.Config{
+		Exporter:    conf.LoadEnv().TracesExporter,
+		Endpoint:    conf.LoadEnv().OTLPEndpoint,
+		ServiceName: conf.LoadEnv().TraceServiceName,
+		Headers:     tracing.ParseHeaders(conf.LoadEnv().OTLPHeaders),
+	})
 	if err != nil {
+		logger.Tag("main").Fatal(err)
+	}
+
+	// SIGTERM or SIGINT cancels ctx, which starts the shutdown of the server
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	app := route.NewService()
+	if err = app.Start(ctx); err != nil {
 		logger.Tag("main").Error(err)
 	}
-	os.Clearenv()
+	// flush the spans still queued, ctx is already done
+	shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_ = tracer.Shutdown(shutCtx)
+	cancel()
 }
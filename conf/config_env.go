@@ -1,30 +1,127 @@
 package conf
 
-import (
-	"github.com/caarlos0/env/v6"
-)
-
-// AppConfig presents app conf
+// AppConfig presents app conf, fields tagged redact:"true" are hidden by `config print --redacted`
 type AppConfig struct {
+	Env       string `env:"ENV" envDefault:"stg"`
 	Port      string `env:"PORT" envDefault:"8000"`
 	LogFormat string `env:"LOG_FORMAT" envDefault:"text"`
+	DBDriver  string `env:"DB_DRIVER" envDefault:"postgres"`
+	DBDSN     string `env:"DB_DSN" redact:"true"`
 	DBHost    string `env:"DB_HOST" envDefault:"localhost"`
 	DBPort    string `env:"DB_PORT" envDefault:"5432"`
 	DBUser    string `env:"DB_USER" envDefault:"root"`
-	DBPass    string `env:"DB_PASS" envDefault:"password"`
+	DBPass    string `env:"DB_PASS" envDefault:"password" redact:"true"`
 	DBName    string `env:"DB_NAME" envDefault:"ms_user_tutorial"`
 	EnableDB  string `env:"ENABLE_DB" envDefault:"true"`
 
+	ReadyCheckTimeoutMs int `env:"READY_CHECK_TIMEOUT_MS" envDefault:"2000"`
+
+	HTTPReadTimeoutMs       int `env:"HTTP_READ_TIMEOUT_MS" envDefault:"15000"`
+	HTTPReadHeaderTimeoutMs int `env:"HTTP_READ_HEADER_TIMEOUT_MS" envDefault:"5000"`
+	HTTPWriteTimeoutMs      int `env:"HTTP_WRITE_TIMEOUT_MS" envDefault:"60000"`
+	HTTPIdleTimeoutMs       int `env:"HTTP_IDLE_TIMEOUT_MS" envDefault:"120000"`
+	HTTPMaxHeaderBytes      int `env:"HTTP_MAX_HEADER_BYTES" envDefault:"1048576"`
+	// ShutdownTimeoutMs bounds the drain of in-flight requests, then the stop of the background workers
+	ShutdownTimeoutMs int `env:"SHUTDOWN_TIMEOUT_MS" envDefault:"15000"`
+
+	// GRPCPort serves the gRPC API, 0 disables it
+	GRPCPort            int `env:"GRPC_PORT" envDefault:"9090"`
+	GRPCMaxRecvMsgBytes int `env:"GRPC_MAX_RECV_MSG_BYTES" envDefault:"4194304"`
+
+	CORSAllowOrigins     []string `env:"CORS_ALLOW_ORIGINS" envDefault:"http://localhost:3000"`
+	CORSAllowMethods     []string `env:"CORS_ALLOW_METHODS" envDefault:"GET,POST,PUT,PATCH,DELETE,OPTIONS"`
+	CORSAllowHeaders     []string `env:"CORS_ALLOW_HEADERS" envDefault:"Origin,Content-Type,Authorization,X-Request-ID"`
+	CORSExposeHeaders    []string `env:"CORS_EXPOSE_HEADERS" envDefault:"Content-Length,X-Request-ID"`
+	CORSAllowCredentials bool     `env:"CORS_ALLOW_CREDENTIALS" envDefault:"true"`
+	CORSMaxAgeSeconds    int      `env:"CORS_MAX_AGE_SECONDS" envDefault:"600"`
+
+	HSTSMaxAgeSeconds     int    `env:"HSTS_MAX_AGE_SECONDS" envDefault:"31536000"`
+	FrameOptions          string `env:"FRAME_OPTIONS" envDefault:"DENY"`
+	ContentSecurityPolicy string `env:"CONTENT_SECURITY_POLICY" envDefault:"default-src 'none'; frame-ancestors 'none'"`
+	// TrustedProxies are the CIDRs or IPs whose X-Forwarded-For and X-Real-IP headers are believed
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envDefault:"127.0.0.1,::1"`
+
+	TracesExporter   string `env:"OTEL_TRACES_EXPORTER" envDefault:"none"`
+	OTLPEndpoint     string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" envDefault:"http://localhost:4318"`
+	OTLPHeaders      string `env:"OTEL_EXPORTER_OTLP_HEADERS" redact:"true"`
+	TraceServiceName string `env:"OTEL_SERVICE_NAME" envDefault:"ms-user"`
+
+	MailDriver string `env:"MAIL_DRIVER" envDefault:"log"`
+	MailFrom   string `env:"MAIL_FROM" envDefault:"no-reply@ms-user.local"`
+	SMTPHost   string `env:"SMTP_HOST" envDefault:"localhost"`
+	SMTPPort   int    `env:"SMTP_PORT" envDefault:"25"`
+	SMTPUser   string `env:"SMTP_USER"`
+	SMTPPass   string `env:"SMTP_PASS" redact:"true"`
+
+	SMSDriver string `env:"SMS_DRIVER" envDefault:"log"`
+	// PhoneDefaultRegion is the country of the phone numbers given without a + prefix
+	PhoneDefaultRegion       string `env:"PHONE_DEFAULT_REGION" envDefault:"VN"`
+	OTPTTLSeconds            int    `env:"OTP_TTL_SECONDS" envDefault:"300"`
+	OTPMaxAttempts           int    `env:"OTP_MAX_ATTEMPTS" envDefault:"5"`
+	OTPResendIntervalSeconds int    `env:"OTP_RESEND_INTERVAL_SECONDS" envDefault:"60"`
+	OTPMaxPerHour            int    `env:"OTP_MAX_PER_HOUR" envDefault:"5"`
+
+	// PublicBaseURL is the URL browsers reach ms-user at: emailed sign-in links, OIDC callbacks and SAML endpoints point to it
+	PublicBaseURL string `env:"PUBLIC_BASE_URL" envDefault:"http://localhost:8000"`
+	// CookieSecure sends the sign-in cookies over https only, false allows plain http when running locally
+	CookieSecure bool `env:"COOKIE_SECURE" envDefault:"true"`
+
+	MagicLinkTTLSeconds int `env:"MAGIC_LINK_TTL_SECONDS" envDefault:"600"`
+	MagicLinkMaxPerHour int `env:"MAGIC_LINK_MAX_PER_HOUR" envDefault:"5"`
+
+	// OIDCProviders is a JSON list of the OpenID Connect providers users may sign in with, see OIDCProviderList
+	OIDCProviders       string `env:"OIDC_PROVIDERS" redact:"true"`
+	OIDCStateTTLSeconds int    `env:"OIDC_STATE_TTL_SECONDS" envDefault:"600"`
+
+	// SAMLStateTTLSeconds is how long the identity provider of a business has to answer an AuthnRequest
+	SAMLStateTTLSeconds int `env:"SAML_STATE_TTL_SECONDS" envDefault:"600"`
+
+	// OAuthSigningKey is the PEM RSA private key signing the ID tokens given to OAuth clients.
+	// A key is generated at start when it is empty, every replica then publishes its own.
+	OAuthSigningKey string `env:"OAUTH_SIGNING_KEY" redact:"true"`
+	// OAuthConsentURL is the page signing the user in and asking for consent, /oauth/authorize redirects to it
+	OAuthConsentURL       string `env:"OAUTH_CONSENT_URL" envDefault:"http://localhost:3000/oauth/consent"`
+	OAuthCodeTTLSeconds   int    `env:"OAUTH_CODE_TTL_SECONDS" envDefault:"60"`
+	OAuthAccessTokenHours int    `env:"OAUTH_ACCESS_TOKEN_HOURS" envDefault:"1"`
+
+	// APIKeyMaxTTLDays is the longest lifetime of an API key, and the lifetime of the keys created without expiry
+	APIKeyMaxTTLDays int `env:"API_KEY_MAX_TTL_DAYS" envDefault:"365"`
+
+	// ImpersonationTTLMinutes is the lifetime of the tokens admins impersonate users with, at most 60
+	ImpersonationTTLMinutes int `env:"IMPERSONATION_TTL_MINUTES" envDefault:"15"`
+
+	// InternalServices is a JSON list of the services allowed to call the /internal routes, see InternalServiceList
+	InternalServices string `env:"INTERNAL_SERVICES" redact:"true"`
+
+	OutboxPollIntervalMs int `env:"OUTBOX_POLL_INTERVAL_MS" envDefault:"5000"`
+	OutboxMaxAttempts    int `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	OutboxMaxLagSeconds  int `env:"OUTBOX_MAX_LAG_SECONDS" envDefault:"600"`
+	// AuditChainIntervalMs is how often the events recorded by the requests are appended to the audit chain
+	AuditChainIntervalMs int `env:"AUDIT_CHAIN_INTERVAL_MS" envDefault:"1000"`
+	// PurgeIntervalSeconds is how often the rows expired for more than PurgeRetentionHours are deleted
+	PurgeIntervalSeconds int `env:"PURGE_INTERVAL_SECONDS" envDefault:"3600"`
+	PurgeRetentionHours  int `env:"PURGE_RETENTION_HOURS" envDefault:"24"`
+
 	MSBusinessManagement  string `env:"MS_BUSINESS_MANAGEMENT"  envDefault:"http://localhost:8012"`
-	JWTSecret             string `env:"JWT_SECRET" envDefault:"wjrYwmrct9u78c2j"`
+	JWTSecret             string `env:"JWT_SECRET" envDefault:"wjrYwmrct9u78c2j" redact:"true"`
 	NumHourExpToken       int    `env:"HOUR_EXPIRE_TOKEN" envDefault:"720"`
 	RefreshTokenTTLInDays int    `env:"REFRESH_TOKEN_TTL_IN_DAYS" envDefault:"365"`
+
+	// LoginMaxFailures wrong passwords within LoginLockoutMinutes, with no sign-in since, lock the password login of an account
+	LoginMaxFailures    int `env:"LOGIN_MAX_FAILURES" envDefault:"5"`
+	LoginLockoutMinutes int `env:"LOGIN_LOCKOUT_MINUTES" envDefault:"15"`
 }
 
 var config AppConfig
 
-func SetEnv() {
-	_ = env.Parse(&config)
+// SetEnv loads the configuration with Load, the previous configuration is kept on error
+func SetEnv() error {
+	cfg, err := Load()
+	if err != nil {
+		return err
+	}
+	config = cfg
+	return nil
 }
 
 func LoadEnv() AppConfig {
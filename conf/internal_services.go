@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// InternalService is one item of INTERNAL_SERVICES, a service allowed to call /internal routes, e.g.
+//
+//	[{"name": "ms-order", "secret": "...", "endpoints": ["POST /internal/users/batch-get"]}]
+//
+// The service signs its service tokens with Secret, and may only call the listed Endpoints.
+type InternalService struct {
+	Name      string   `json:"name"`
+	Secret    string   `json:"secret"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// minServiceSecretLen keeps the HS256 secrets of the services out of reach of a brute force
+const minServiceSecretLen = 32
+
+var (
+	serviceNameRe     = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	serviceEndpointRe = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE) /internal/\S+$`)
+)
+
+// Allows tells if the service may call endpoint, the method and route path like "POST /internal/migrate"
+func (s InternalService) Allows(endpoint string) bool {
+	for _, e := range s.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// InternalServiceList parses INTERNAL_SERVICES, an empty setting lets no service call the /internal routes
+func (c AppConfig) InternalServiceList() ([]InternalService, error) {
+	if strings.TrimSpace(c.InternalServices) == "" {
+		return nil, nil
+	}
+	var rs []InternalService
+	if err := json.Unmarshal([]byte(c.InternalServices), &rs); err != nil {
+		return nil, fmt.Errorf("INTERNAL_SERVICES must be a JSON list of services: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, s := range rs {
+		switch {
+		case !serviceNameRe.MatchString(s.Name):
+			return nil, fmt.Errorf("INTERNAL_SERVICES[%d]: name %q must be lowercase letters, digits, - or _", i, s.Name)
+		case seen[s.Name]:
+			return nil, fmt.Errorf("INTERNAL_SERVICES: service %q is listed twice", s.Name)
+		case len(s.Secret) < minServiceSecretLen:
+			return nil, fmt.Errorf("INTERNAL_SERVICES: secret of %q must be at least %d characters", s.Name, minServiceSecretLen)
+		case len(s.Endpoints) == 0:
+			return nil, fmt.Errorf("INTERNAL_SERVICES: %q allows no endpoint", s.Name)
+		}
+		for _, e := range s.Endpoints {
+			if !serviceEndpointRe.MatchString(e) {
+				return nil, fmt.Errorf("INTERNAL_SERVICES: endpoint %q of %q must be a method and an /internal path, e.g. \"POST /internal/migrate\"", e, s.Name)
+			}
+		}
+		seen[s.Name] = true
+	}
+	return rs, nil
+}
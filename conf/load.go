@@ -0,0 +1,343 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v2"
+
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/phone"
+)
+
+const (
+	// ConfigFileEnv names the YAML file read under the environment variables
+	ConfigFileEnv = "CONFIG_FILE"
+	// secretFileSuffix marks a variable holding the path of a file with the value, e.g. JWT_SECRET_FILE
+	secretFileSuffix = "_FILE"
+	redactedValue    = "[REDACTED]"
+)
+
+// Load builds the configuration from, by increasing priority: the envDefault tags,
+// the YAML file of CONFIG_FILE, the environment variables and the files of the *_FILE variables.
+// Unknown keys in the file, unparsable values and invalid settings are errors.
+func Load() (AppConfig, error) {
+	return load(environMap(os.Environ()))
+}
+
+// load reads the configuration from environ, which it modifies
+func load(environ map[string]string) (cfg AppConfig, err error) {
+	merged := map[string]string{}
+	if path := environ[ConfigFileEnv]; path != "" {
+		if merged, err = readConfigFile(path); err != nil {
+			return cfg, err
+		}
+		if err = resolveSecretFiles(merged); err != nil {
+			return cfg, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	if err = resolveSecretFiles(environ); err != nil {
+		return cfg, err
+	}
+	for k, v := range environ {
+		merged[k] = v
+	}
+
+	if err = env.Parse(&cfg, env.Options{Environment: merged}); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// IsProduction is true when ENV is prod or production
+func (c AppConfig) IsProduction() bool {
+	e := strings.ToLower(c.Env)
+	return e == "prod" || e == "production"
+}
+
+// Validate reports every invalid setting at once
+func (c AppConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, "%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT must be a TCP port, got %q", c.Port)
+	_, err = strconv.ParseBool(c.EnableDB)
+	check(err == nil, "ENABLE_DB must be a boolean, got %q", c.EnableDB)
+	oneOf("DB_DRIVER", c.DBDriver, "postgres", "sqlite")
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	oneOf("OTEL_TRACES_EXPORTER", c.TracesExporter, "none", "otlp")
+	oneOf("MAIL_DRIVER", c.MailDriver, "log", "smtp")
+	oneOf("SMS_DRIVER", c.SMSDriver, "log")
+	check(phone.KnownRegion(c.PhoneDefaultRegion), "PHONE_DEFAULT_REGION: unsupported region %q", c.PhoneDefaultRegion)
+	check(c.OTPResendIntervalSeconds >= 0, "OTP_RESEND_INTERVAL_SECONDS must not be negative")
+	check(c.JWTSecret != "", "JWT_SECRET must not be empty")
+	check(strings.HasPrefix(c.PublicBaseURL, "http://") || strings.HasPrefix(c.PublicBaseURL, "https://"),
+		"PUBLIC_BASE_URL must be an http(s) URL, got %q", c.PublicBaseURL)
+	if _, err := c.OIDCProviderList(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := c.InternalServiceList(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.OAuthSigningKey != "" {
+		if _, err := oauth.ParseSigner(c.OAuthSigningKey); err != nil {
+			problems = append(problems, "OAUTH_SIGNING_KEY: "+err.Error())
+		}
+	}
+	check(strings.HasPrefix(c.OAuthConsentURL, "http://") || strings.HasPrefix(c.OAuthConsentURL, "https://"),
+		"OAUTH_CONSENT_URL must be an http(s) URL, got %q", c.OAuthConsentURL)
+	oneOf("FRAME_OPTIONS", c.FrameOptions, "", "DENY", "SAMEORIGIN")
+	check(len(c.CORSAllowOrigins) > 0, "CORS_ALLOW_ORIGINS must not be empty")
+	for _, origin := range c.CORSAllowOrigins {
+		valid := origin == "*" || (strings.Count(origin, "*") <= 1 &&
+			(strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://")))
+		check(valid, "CORS_ALLOW_ORIGINS: %q must be *, or an http(s) origin with at most one *", origin)
+		// any site could then call the API with the cookies of the user
+		check(origin != "*" || !c.CORSAllowCredentials, "CORS_ALLOW_ORIGINS must not be * while CORS_ALLOW_CREDENTIALS is true")
+	}
+	for _, proxy := range c.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES: %q is neither an IP nor a CIDR", proxy)
+	}
+	check(c.GRPCPort >= 0 && c.GRPCPort < 65536, "GRPC_PORT must be a TCP port or 0, got %d", c.GRPCPort)
+	check(c.GRPCPort == 0 || strconv.Itoa(c.GRPCPort) != c.Port, "GRPC_PORT must differ from PORT")
+	check(c.CORSMaxAgeSeconds >= 0, "CORS_MAX_AGE_SECONDS must not be negative")
+	check(c.HSTSMaxAgeSeconds >= 0, "HSTS_MAX_AGE_SECONDS must not be negative")
+	check(c.ImpersonationTTLMinutes <= 60, "IMPERSONATION_TTL_MINUTES must be at most 60, got %d", c.ImpersonationTTLMinutes)
+
+	positive := map[string]int{
+		"READY_CHECK_TIMEOUT_MS":      c.ReadyCheckTimeoutMs,
+		"HTTP_READ_TIMEOUT_MS":        c.HTTPReadTimeoutMs,
+		"HTTP_READ_HEADER_TIMEOUT_MS": c.HTTPReadHeaderTimeoutMs,
+		"HTTP_WRITE_TIMEOUT_MS":       c.HTTPWriteTimeoutMs,
+		"HTTP_IDLE_TIMEOUT_MS":        c.HTTPIdleTimeoutMs,
+		"HTTP_MAX_HEADER_BYTES":       c.HTTPMaxHeaderBytes,
+		"SHUTDOWN_TIMEOUT_MS":         c.ShutdownTimeoutMs,
+		"GRPC_MAX_RECV_MSG_BYTES":     c.GRPCMaxRecvMsgBytes,
+		"SMTP_PORT":                   c.SMTPPort,
+		"OTP_TTL_SECONDS":             c.OTPTTLSeconds,
+		"OTP_MAX_ATTEMPTS":            c.OTPMaxAttempts,
+		"OTP_MAX_PER_HOUR":            c.OTPMaxPerHour,
+		"MAGIC_LINK_TTL_SECONDS":      c.MagicLinkTTLSeconds,
+		"MAGIC_LINK_MAX_PER_HOUR":     c.MagicLinkMaxPerHour,
+		"OIDC_STATE_TTL_SECONDS":      c.OIDCStateTTLSeconds,
+		"SAML_STATE_TTL_SECONDS":      c.SAMLStateTTLSeconds,
+		"OAUTH_CODE_TTL_SECONDS":      c.OAuthCodeTTLSeconds,
+		"OAUTH_ACCESS_TOKEN_HOURS":    c.OAuthAccessTokenHours,
+		"API_KEY_MAX_TTL_DAYS":        c.APIKeyMaxTTLDays,
+		"IMPERSONATION_TTL_MINUTES":   c.ImpersonationTTLMinutes,
+		"OUTBOX_POLL_INTERVAL_MS":     c.OutboxPollIntervalMs,
+		"OUTBOX_MAX_ATTEMPTS":         c.OutboxMaxAttempts,
+		"OUTBOX_MAX_LAG_SECONDS":      c.OutboxMaxLagSeconds,
+		"AUDIT_CHAIN_INTERVAL_MS":     c.AuditChainIntervalMs,
+		"PURGE_INTERVAL_SECONDS":      c.PurgeIntervalSeconds,
+		"PURGE_RETENTION_HOURS":       c.PurgeRetentionHours,
+		"HOUR_EXPIRE_TOKEN":           c.NumHourExpToken,
+		"REFRESH_TOKEN_TTL_IN_DAYS":   c.RefreshTokenTTLInDays,
+		"LOGIN_MAX_FAILURES":          c.LoginMaxFailures,
+		"LOGIN_LOCKOUT_MINUTES":       c.LoginLockoutMinutes,
+	}
+	for _, key := range sortedKeys(positive) {
+		check(positive[key] > 0, "%s must be positive, got %d", key, positive[key])
+	}
+
+	if c.IsProduction() {
+		for _, f := range fields() {
+			if f.redact && f.def != "" && f.value(c) == f.def {
+				problems = append(problems, f.key+" must not keep its default value in production")
+			}
+		}
+		check(len(c.JWTSecret) >= 32, "JWT_SECRET must be at least 32 characters in production")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Print writes c as a YAML config file, secrets are replaced by [REDACTED] when redacted is set
+func Print(w io.Writer, c AppConfig, redacted bool) error {
+	out := yaml.MapSlice{}
+	rv := reflect.ValueOf(c)
+	for _, f := range fields() {
+		var v interface{} = rv.Field(f.index).Interface()
+		if redacted && f.redact && f.value(c) != "" {
+			v = redactedValue
+		}
+		out = append(out, yaml.MapItem{Key: f.key, Value: v})
+	}
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ExportCloud0Env copies the settings read by the cloud0 BaseApp into the environment,
+// cloud0 parses the environment itself and would miss the values of the config file and *_FILE variables
+func ExportCloud0Env() {
+	c := LoadEnv()
+	_ = os.Setenv("ENV", c.Env)
+	_ = os.Setenv("PORT", c.Port)
+	_ = os.Setenv("DB_HOST", c.DBHost)
+	_ = os.Setenv("DB_PORT", c.DBPort)
+	_ = os.Setenv("DB_USER", c.DBUser)
+	_ = os.Setenv("DB_PASS", c.DBPass)
+	_ = os.Setenv("DB_NAME", c.DBName)
+	_ = os.Setenv("ENABLE_DB", c.EnableDB)
+}
+
+type field struct {
+	index  int
+	key    string
+	def    string
+	redact bool
+}
+
+func (f field) value(c AppConfig) string {
+	return fmt.Sprint(reflect.ValueOf(c).Field(f.index).Interface())
+}
+
+// fields lists the settings of AppConfig in declaration order
+func fields() []field {
+	t := reflect.TypeOf(AppConfig{})
+	rs := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		key := strings.Split(tag.Get("env"), ",")[0]
+		if key == "" {
+			continue
+		}
+		rs = append(rs, field{index: i, key: key, def: tag.Get("envDefault"), redact: tag.Get("redact") == "true"})
+	}
+	return rs
+}
+
+// readConfigFile reads a YAML file whose keys are the variable names, in any case.
+// Nested maps are joined with "_" (db: {host: x} is DB_HOST) and lists with ",".
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var doc map[string]interface{}
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	rs := map[string]string{}
+	if err = flatten("", doc, rs); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	known := map[string]bool{}
+	for _, f := range fields() {
+		known[f.key] = true
+	}
+	for key := range rs {
+		if !known[key] && !known[strings.TrimSuffix(key, secretFileSuffix)] {
+			return nil, fmt.Errorf("config file %s: unknown setting %s", path, key)
+		}
+	}
+	return rs, nil
+}
+
+func flatten(prefix string, v interface{}, out map[string]string) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if err := flatten(joinKey(prefix, k), child, out); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, child := range val {
+			if err := flatten(joinKey(prefix, fmt.Sprint(k)), child, out); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			switch item.(type) {
+			case map[interface{}]interface{}, []interface{}:
+				return fmt.Errorf("%s: lists may only hold plain values", prefix)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		out[prefix] = ""
+	default:
+		out[prefix] = fmt.Sprint(val)
+	}
+	return nil
+}
+
+func joinKey(prefix, key string) string {
+	key = strings.ToUpper(key)
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+// resolveSecretFiles replaces every KEY_FILE of a known setting by KEY set to the trimmed content of the file
+func resolveSecretFiles(environ map[string]string) error {
+	for _, f := range fields() {
+		path, ok := environ[f.key+secretFileSuffix]
+		if !ok || path == "" {
+			continue
+		}
+		if _, set := environ[f.key]; set {
+			return fmt.Errorf("both %s and %s%s are set", f.key, f.key, secretFileSuffix)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s%s: %w", f.key, secretFileSuffix, err)
+		}
+		environ[f.key] = strings.TrimRight(string(b), "\r\n")
+	}
+	return nil
+}
+
+func environMap(environ []string) map[string]string {
+	rs := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			rs[kv[:i]] = kv[i+1:]
+		}
+	}
+	return rs
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,188 @@
+package conf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeFile writes content to name in a directory of the test and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOrder(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+port: 8100
+db:
+  host: db.internal
+  name: from_file
+log_format: json
+cors_allow_origins: [https://a.example.com, https://b.example.com]
+`)
+	cfg, err := load(map[string]string{
+		ConfigFileEnv:     file,
+		"DB_NAME":         "from_env",
+		"JWT_SECRET_FILE": writeFile(t, "jwt", testSecret+"\n"),
+	})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, tc := range []struct{ key, got, want string }{
+		{"DB_PORT", cfg.DBPort, "5432"},           // envDefault
+		{"PORT", cfg.Port, "8100"},                // the file over the default
+		{"DB_HOST", cfg.DBHost, "db.internal"},    // nested keys of the file
+		{"DB_NAME", cfg.DBName, "from_env"},       // the environment over the file
+		{"JWT_SECRET", cfg.JWTSecret, testSecret}, // the trimmed content of the _FILE
+		{"LOG_FORMAT", cfg.LogFormat, "json"},
+		{"CORS_ALLOW_ORIGINS", strings.Join(cfg.CORSAllowOrigins, " "), "https://a.example.com https://b.example.com"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.key, tc.got, tc.want)
+		}
+	}
+
+	// a _FILE of the environment wins over the value of the config file
+	file = writeFile(t, "config.yaml", "jwt_secret: from-the-config-file\n")
+	cfg, err = load(map[string]string{ConfigFileEnv: file, "JWT_SECRET_FILE": writeFile(t, "jwt", testSecret)})
+	if err != nil || cfg.JWTSecret != testSecret {
+		t.Errorf("JWT_SECRET = %q, %v, want the content of JWT_SECRET_FILE", cfg.JWTSecret, err)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		environ map[string]string
+		want    string
+	}{
+		{"missing _FILE", map[string]string{"JWT_SECRET_FILE": filepath.Join(t.TempDir(), "missing")}, "read JWT_SECRET_FILE"},
+		{"unreadable _FILE", map[string]string{"JWT_SECRET_FILE": t.TempDir()}, "read JWT_SECRET_FILE"},
+		{"value and _FILE", map[string]string{"JWT_SECRET": testSecret, "JWT_SECRET_FILE": writeFile(t, "jwt", testSecret)}, "both JWT_SECRET and JWT_SECRET_FILE are set"},
+		{"missing config file", map[string]string{ConfigFileEnv: filepath.Join(t.TempDir(), "missing.yaml")}, "read config file"},
+		{"unknown key", map[string]string{ConfigFileEnv: writeFile(t, "config.yaml", "jwt_secrets: x\n")}, "unknown setting JWT_SECRETS"},
+		{"unparsable value", map[string]string{"GRPC_PORT": "ninety"}, `parsing "ninety"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := load(tc.environ); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("load err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	_, err := load(map[string]string{
+		"PORT":                      "0",
+		"DB_DRIVER":                 "mysql",
+		"OTP_MAX_ATTEMPTS":          "0",
+		"PUBLIC_BASE_URL":           "ftp://example.com",
+		"CORS_ALLOW_ORIGINS":        "example.com",
+		"TRUSTED_PROXIES":           "not-an-ip",
+		"IMPERSONATION_TTL_MINUTES": "120",
+	})
+	if err == nil {
+		t.Fatal("load accepts an invalid configuration")
+	}
+	for _, want := range []string{
+		`PORT must be a TCP port, got "0"`,
+		"DB_DRIVER must be one of postgres, sqlite",
+		"OTP_MAX_ATTEMPTS must be positive",
+		"PUBLIC_BASE_URL must be an http(s) URL",
+		`CORS_ALLOW_ORIGINS: "example.com"`,
+		`TRUSTED_PROXIES: "not-an-ip"`,
+		"IMPERSONATION_TTL_MINUTES must be at most 60",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("load err = %v, want it to report %q", err, want)
+		}
+	}
+}
+
+func TestValidateCORSWildcard(t *testing.T) {
+	// the credentials are allowed by default
+	_, err := load(map[string]string{"CORS_ALLOW_ORIGINS": "*"})
+	if err == nil || !strings.Contains(err.Error(), "CORS_ALLOW_ORIGINS must not be * while CORS_ALLOW_CREDENTIALS is true") {
+		t.Errorf("load err = %v, want * with credentials refused", err)
+	}
+	if _, err = load(map[string]string{"CORS_ALLOW_ORIGINS": "*", "CORS_ALLOW_CREDENTIALS": "false"}); err != nil {
+		t.Errorf("load * without credentials: %v", err)
+	}
+	if _, err = load(map[string]string{"CORS_ALLOW_ORIGINS": "https://*.example.com"}); err != nil {
+		t.Errorf("load a wildcard pattern with credentials: %v", err)
+	}
+}
+
+func TestValidateProduction(t *testing.T) {
+	// the defaults are fine out of production
+	if _, err := load(map[string]string{}); err != nil {
+		t.Fatalf("load the defaults: %v", err)
+	}
+
+	_, err := load(map[string]string{"ENV": "production", "JWT_SECRET": "short-secret"})
+	if err == nil {
+		t.Fatal("production accepts the defaults and a short JWT_SECRET")
+	}
+	for _, want := range []string{
+		"DB_PASS must not keep its default value in production",
+		"JWT_SECRET must be at least 32 characters in production",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("load err = %v, want it to report %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "JWT_SECRET must not keep its default") {
+		t.Errorf("load err = %v, JWT_SECRET is set", err)
+	}
+
+	_, err = load(map[string]string{"ENV": "prod", "DB_PASS": "a-real-password"})
+	if err == nil || !strings.Contains(err.Error(), "JWT_SECRET must not keep its default value in production") {
+		t.Errorf("load err = %v, want the default JWT_SECRET refused", err)
+	}
+	if _, err = load(map[string]string{"ENV": "prod", "DB_PASS": "a-real-password", "JWT_SECRET": testSecret}); err != nil {
+		t.Errorf("load a production configuration: %v", err)
+	}
+}
+
+func TestPrintRedacts(t *testing.T) {
+	cfg, err := load(map[string]string{"JWT_SECRET": testSecret, "DB_PASS": "db-password", "DB_HOST": "db.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plain, redacted bytes.Buffer
+	if err = Print(&plain, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = Print(&redacted, cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testSecret, "db-password"} {
+		if !strings.Contains(plain.String(), secret) {
+			t.Errorf("Print does not show %q", secret)
+		}
+		if strings.Contains(redacted.String(), secret) {
+			t.Errorf("Print redacted shows %q", secret)
+		}
+	}
+	for _, want := range []string{"JWT_SECRET: '[REDACTED]'", "DB_PASS: '[REDACTED]'", "DB_HOST: db.internal", "DB_DSN: \"\""} {
+		if !strings.Contains(redacted.String(), want) {
+			t.Errorf("Print redacted lacks %q:\n%s", want, redacted.String())
+		}
+	}
+
+	// the printed file loads back to the same configuration
+	back, err := load(map[string]string{ConfigFileEnv: writeFile(t, "config.yaml", plain.String())})
+	if err != nil || back.JWTSecret != cfg.JWTSecret || back.DBHost != cfg.DBHost || back.DBPass != cfg.DBPass {
+		t.Errorf("load the printed configuration = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OIDCProvider is one item of OIDC_PROVIDERS, e.g.
+//
+//	[{"name": "google", "issuer": "https://accounts.google.com", "client_id": "...", "client_secret": "..."}]
+//
+// Name appears in the sign-in URLs and is stored with the linked identities, it must not change.
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+var providerNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// OIDCProviderList parses OIDC_PROVIDERS, an empty setting configures no provider.
+// Issuers must be https, plain http is accepted outside production for local providers.
+func (c AppConfig) OIDCProviderList() ([]OIDCProvider, error) {
+	if strings.TrimSpace(c.OIDCProviders) == "" {
+		return nil, nil
+	}
+	var rs []OIDCProvider
+	if err := json.Unmarshal([]byte(c.OIDCProviders), &rs); err != nil {
+		return nil, fmt.Errorf("OIDC_PROVIDERS must be a JSON list of providers: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, p := range rs {
+		switch {
+		case !providerNameRe.MatchString(p.Name):
+			return nil, fmt.Errorf("OIDC_PROVIDERS[%d]: name %q must be lowercase letters, digits, - or _", i, p.Name)
+		case seen[p.Name]:
+			return nil, fmt.Errorf("OIDC_PROVIDERS: provider %q is listed twice", p.Name)
+		case !strings.HasPrefix(p.Issuer, "https://") && (c.IsProduction() || !strings.HasPrefix(p.Issuer, "http://")):
+			return nil, fmt.Errorf("OIDC_PROVIDERS: issuer of %q must be an https URL, got %q", p.Name, p.Issuer)
+		case p.ClientID == "":
+			return nil, fmt.Errorf("OIDC_PROVIDERS: client_id of %q is required", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return rs, nil
+}
@@ -0,0 +1,162 @@
+// Package audit holds the hash chain of the audit log and the request
+// information (actor, IP, user agent) that audit events are stamped with.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"ms-user/pkg/model"
+)
+
+type actorKey struct{}
+
+type requestInfoKey struct{}
+
+type impersonationKey struct{}
+
+type RequestInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// WithActor stores the authenticated user making the request
+func WithActor(ctx context.Context, actorID uuid.UUID) context.Context {
+	return context.WithValue(ctx, actorKey{}, actorID)
+}
+
+func ActorFromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(actorKey{}).(uuid.UUID)
+	return id, ok && id != uuid.Nil
+}
+
+// Impersonation is the admin ActorID acting as the user of the request with the impersonation token ID
+type Impersonation struct {
+	ActorID uuid.UUID
+	ID      string
+}
+
+// WithImpersonation marks the request as made by an admin impersonating the actor, the events are tagged with it
+func WithImpersonation(ctx context.Context, imp Impersonation) context.Context {
+	return context.WithValue(ctx, impersonationKey{}, imp)
+}
+
+func ImpersonationFromContext(ctx context.Context) (Impersonation, bool) {
+	imp, ok := ctx.Value(impersonationKey{}).(Impersonation)
+	return imp, ok
+}
+
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// NewEvent makes an event of type typ about subject, stamped with the actor, impersonation and request info of ctx
+func NewEvent(ctx context.Context, typ string, subjectID *uuid.UUID, metadata map[string]interface{}) model.AuditEvent {
+	info := RequestInfoFromContext(ctx)
+	ev := model.AuditEvent{
+		Type:      typ,
+		SubjectID: subjectID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+	}
+	if actorID, ok := ActorFromContext(ctx); ok {
+		ev.ActorID = &actorID
+	}
+	if imp, ok := ImpersonationFromContext(ctx); ok {
+		tagged := map[string]interface{}{"impersonator_id": imp.ActorID.String(), "impersonation_id": imp.ID}
+		for k, v := range metadata {
+			tagged[k] = v
+		}
+		metadata = tagged
+	}
+	if len(metadata) > 0 {
+		if b, err := json.Marshal(metadata); err == nil {
+			ev.Metadata = string(b)
+		}
+	}
+	return ev
+}
+
+// Chain links ev after prev: it sets the ID, sequence, previous hash and hash, ev keeps the time it was recorded at.
+// prev is nil for the first event of the log.
+func Chain(prev *model.AuditEvent, ev *model.AuditEvent) {
+	if ev.ID == uuid.Nil {
+		ev.ID = uuid.New()
+	}
+	// Postgres keeps microseconds, hash what will be read back
+	ev.OccurredAt = ev.OccurredAt.UTC().Truncate(time.Microsecond)
+	ev.Seq = 1
+	ev.PrevHash = ""
+	if prev != nil {
+		ev.Seq = prev.Seq + 1
+		ev.PrevHash = prev.Hash
+	}
+	ev.Hash = Hash(*ev)
+}
+
+// Hash computes the hash of ev over every column except Hash itself
+func Hash(ev model.AuditEvent) string {
+	uuidString := func(id *uuid.UUID) string {
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	}
+	b, _ := json.Marshal([]interface{}{
+		ev.Seq,
+		ev.ID.String(),
+		ev.OccurredAt.UTC().Format(time.RFC3339Nano),
+		ev.Type,
+		uuidString(ev.ActorID),
+		uuidString(ev.SubjectID),
+		ev.IP,
+		ev.UserAgent,
+		ev.Metadata,
+		ev.PrevHash,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that events, ordered by Seq, form an unbroken chain after prev
+func Verify(prev *model.AuditEvent, events []model.AuditEvent) error {
+	for i := range events {
+		ev := events[i]
+		wantSeq, wantPrev := int64(1), ""
+		if prev != nil {
+			wantSeq, wantPrev = prev.Seq+1, prev.Hash
+		}
+		if ev.Seq != wantSeq {
+			return &ChainError{Seq: ev.Seq, Index: i, Reason: fmt.Sprintf("expected seq %d", wantSeq)}
+		}
+		if ev.PrevHash != wantPrev {
+			return &ChainError{Seq: ev.Seq, Index: i, Reason: "prev_hash does not match the previous event"}
+		}
+		if Hash(ev) != ev.Hash {
+			return &ChainError{Seq: ev.Seq, Index: i, Reason: "hash does not match the event content"}
+		}
+		prev = &events[i]
+	}
+	return nil
+}
+
+// ChainError is the first event breaking the chain, Index is its position in the events verified
+type ChainError struct {
+	Seq    int64
+	Index  int
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at seq %d: %s", e.Seq, e.Reason)
+}
@@ -0,0 +1,17 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// GinMiddleware stores the client IP and user agent in the request context for audit events
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := WithRequestInfo(c.Request.Context(), RequestInfo{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
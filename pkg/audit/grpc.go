@@ -0,0 +1,29 @@
+package audit
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// UnaryServerInterceptor is GinMiddleware for gRPC, the IP is the one of the peer
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var ri RequestInfo
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			ri.IP = p.Addr.String()
+			if host, _, err := net.SplitHostPort(ri.IP); err == nil {
+				ri.IP = host
+			}
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ua := md.Get("user-agent"); len(ua) > 0 {
+				ri.UserAgent = ua[0]
+			}
+		}
+		return handler(WithRequestInfo(ctx, ri), req)
+	}
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// CreateAPIKey makes an API key of the signed in user, the key is only returned here.
+// It needs an access token: API keys can not manage API keys.
+func (c *Client) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (rs APIKey, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/me/api-keys", body: req, auth: true}, &rs, nil)
+	return rs, err
+}
+
+// ListAPIKeys lists the keys of the signed in user, the latest created first, without the keys themselves
+func (c *Client) ListAPIKeys(ctx context.Context) (rs []APIKey, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/user/me/api-keys", auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	if id == uuid.Nil {
+		return errEmptyID
+	}
+	path := "/api/v1/user/me/api-keys/" + id.String()
+	return c.call(ctx, request{method: http.MethodDelete, path: path, auth: true, idempotent: true}, nil, nil)
+}
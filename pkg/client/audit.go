@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (f AuditFilter) values() url.Values {
+	v := url.Values{}
+	if f.ActorID != nil {
+		v.Set("actor_id", f.ActorID.String())
+	}
+	if f.SubjectID != nil {
+		v.Set("subject_id", f.SubjectID.String())
+	}
+	if f.Type != "" {
+		v.Set("type", f.Type)
+	}
+	if f.From != nil {
+		v.Set("from", f.From.Format(time.RFC3339))
+	}
+	if f.To != nil {
+		v.Set("to", f.To.Format(time.RFC3339))
+	}
+	if f.Page > 0 {
+		v.Set("page", strconv.Itoa(f.Page))
+	}
+	if f.PageSize > 0 {
+		v.Set("page_size", strconv.Itoa(f.PageSize))
+	}
+	return v
+}
+
+// ListAuditEvents returns a page of the audit log, it needs an admin account
+func (c *Client) ListAuditEvents(ctx context.Context, filter AuditFilter) (rs []AuditEvent, meta Pagination, err error) {
+	req := request{method: http.MethodGet, path: "/api/v1/admin/audit/events", query: filter.values(), auth: true, idempotent: true}
+	err = c.call(ctx, req, &rs, &meta)
+	return rs, meta, err
+}
+
+// ExportAuditEvents calls fn with every event matching filter, Page and PageSize are ignored.
+// The export is streamed, an error of fn stops it and is returned.
+func (c *Client) ExportAuditEvents(ctx context.Context, filter AuditFilter, fn func(AuditEvent) error) error {
+	filter.Page, filter.PageSize = 0, 0
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/api/v1/admin/audit/events/export", query: filter.values(), auth: true, idempotent: true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev AuditEvent
+		if err = json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return fmt.Errorf("ms-user: decode audit export: %w", err)
+		}
+		if err = fn(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyAuditChain checks the hash chain of the whole audit log
+func (c *Client) VerifyAuditChain(ctx context.Context) (rs AuditVerifyResponse, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/admin/audit/verify", auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
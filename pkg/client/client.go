@@ -0,0 +1,368 @@
+// Package client is the Go SDK of ms-user for the other services.
+//
+//	c := client.New("http://ms-user:8000", client.WithCredentials(email, password, ""))
+//	user, err := c.GetUser(ctx, id)
+//
+// Authenticated calls log in when needed, and refresh the access token once on a 401.
+// Idempotent calls are retried with exponential backoff on network errors, 429, 502, 503 and 504.
+// Errors returned by ms-user are *APIError, see IsUnauthorized and the other helpers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy bounds the retries of idempotent calls, the delay doubles after every attempt
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// NoRetry makes every call a single attempt
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	userAgent  string
+
+	// mu guards the tokens, it is held during a login or a refresh so concurrent calls renew only once
+	mu          sync.Mutex
+	tokens      Tokens
+	credentials *LoginRequest
+	onTokens    func(Tokens)
+
+	// service signs the calls of the /internal routes
+	service *serviceCredentials
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout or a transport
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithTokens starts the client with tokens obtained earlier
+func WithTokens(t Tokens) Option {
+	return func(c *Client) { c.tokens = t }
+}
+
+// WithAPIKey authenticates the calls with an API key instead of an access token, e.g. in a script.
+// A rejected key is not renewed.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.tokens = Tokens{AccessToken: key} }
+}
+
+// WithCredentials lets the client log in by itself, at the first authenticated call
+// and whenever the refresh token is rejected
+func WithCredentials(email, password, deviceID string) Option {
+	return func(c *Client) {
+		c.credentials = &LoginRequest{Email: email, Password: password, DeviceID: deviceID}
+	}
+}
+
+// WithServiceCredentials authenticates the calls of the /internal routes (Migrate, BatchGetUsers) as the service
+// name of INTERNAL_SERVICES, with a short-lived service token signed with its secret
+func WithServiceCredentials(name, secret string) Option {
+	return func(c *Client) { c.service = &serviceCredentials{name: name, secret: secret} }
+}
+
+// WithTokenCallback is called with the new tokens after every login and refresh, e.g. to persist them
+func WithTokenCallback(fn func(Tokens)) Option {
+	return func(c *Client) { c.onTokens = fn }
+}
+
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New makes a client of the ms-user instance at baseURL, e.g. http://ms-user:8000
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+		userAgent:  "ms-user-go-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.MaxAttempts < 1 {
+		c.retry.MaxAttempts = 1
+	}
+	return c
+}
+
+// Tokens returns the current tokens
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+type request struct {
+	method     string
+	path       string
+	query      url.Values
+	body       interface{}
+	auth       bool
+	internal   bool
+	idempotent bool
+}
+
+type envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta json.RawMessage `json:"meta"`
+}
+
+// call sends req and decodes the data and meta of the response envelope into data and meta, both may be nil
+func (c *Client) call(ctx context.Context, req request, data, meta interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 || (data == nil && meta == nil) {
+		return nil
+	}
+	var env envelope
+	if err = json.Unmarshal(body, &env); err != nil {
+		return fmt.Errorf("ms-user: decode response of %s %s: %w", req.method, req.path, err)
+	}
+	if data != nil && len(env.Data) > 0 {
+		if err = json.Unmarshal(env.Data, data); err != nil {
+			return fmt.Errorf("ms-user: decode data of %s %s: %w", req.method, req.path, err)
+		}
+	}
+	if meta != nil && len(env.Meta) > 0 {
+		if err = json.Unmarshal(env.Meta, meta); err != nil {
+			return fmt.Errorf("ms-user: decode meta of %s %s: %w", req.method, req.path, err)
+		}
+	}
+	return nil
+}
+
+// send returns the 2xx response of req, the caller closes its body.
+// Other statuses are returned as *APIError.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var payload []byte
+	if req.body != nil {
+		var err error
+		if payload, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := 1
+	if req.idempotent {
+		attempts = c.retry.MaxAttempts
+	}
+	renewed := false
+	for attempt := 1; ; attempt++ {
+		token := ""
+		if req.auth {
+			var err error
+			if token, err = c.accessToken(ctx); err != nil {
+				return nil, err
+			}
+		} else if req.internal && c.service != nil {
+			var err error
+			if token, err = c.service.sign(time.Now()); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.roundTrip(ctx, req, payload, token)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && req.auth && !renewed {
+			apiErr := readError(resp)
+			renewed = true
+			if err = c.renew(ctx, token, apiErr); err != nil {
+				return nil, err
+			}
+			// the retry with the new token does not count as an attempt
+			attempt--
+			continue
+		}
+		if attempt < attempts && retryable(ctx, resp, err) {
+			delay := c.backoff(attempt, resp)
+			if resp != nil {
+				_ = readError(resp)
+			}
+			if err = sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, readError(resp)
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, req request, payload []byte, token string) (*http.Response, error) {
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(httpReq)
+}
+
+// accessToken returns the current access token, it logs in first when there is none and credentials are set
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens.AccessToken == "" && c.credentials != nil {
+		if err := c.loginLocked(ctx, *c.credentials); err != nil {
+			return "", err
+		}
+	}
+	return c.tokens.AccessToken, nil
+}
+
+// renew replaces the rejected access token stale, with the refresh token or else the credentials.
+// rejected is returned when there is no way to get a new token.
+func (c *Client) renew(ctx context.Context, stale string, rejected error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens.AccessToken != stale {
+		// renewed by a concurrent call
+		return nil
+	}
+
+	if c.tokens.RefreshToken != "" {
+		err := c.refreshLocked(ctx)
+		if err == nil || !IsUnauthorized(err) || c.credentials == nil {
+			return err
+		}
+	}
+	if c.credentials != nil {
+		return c.loginLocked(ctx, *c.credentials)
+	}
+	return rejected
+}
+
+func (c *Client) loginLocked(ctx context.Context, req LoginRequest) error {
+	var rs LoginResponse
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/login", body: req}, &rs, nil); err != nil {
+		return err
+	}
+	c.setTokensLocked(rs)
+	return nil
+}
+
+func (c *Client) refreshLocked(ctx context.Context) error {
+	var rs LoginResponse
+	body := map[string]string{"refresh_token": c.tokens.RefreshToken}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/refresh-token", body: body}, &rs, nil); err != nil {
+		return err
+	}
+	c.setTokensLocked(rs)
+	return nil
+}
+
+func (c *Client) setTokensLocked(rs LoginResponse) {
+	c.tokens = Tokens{AccessToken: rs.Token, RefreshToken: rs.RefreshToken}
+	if c.onTokens != nil {
+		c.onTokens(c.tokens)
+	}
+}
+
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is a random delay up to BaseDelay * 2^(attempt-1), or the Retry-After of resp when it is longer
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	d := c.retry.BaseDelay
+	for i := 1; i < attempt && (c.retry.MaxDelay <= 0 || d < c.retry.MaxDelay); i++ {
+		d *= 2
+	}
+	if c.retry.MaxDelay > 0 && d > c.retry.MaxDelay {
+		d = c.retry.MaxDelay
+	}
+	if d > 0 {
+		d = time.Duration(rand.Int63n(int64(d)) + 1)
+	}
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && time.Duration(secs)*time.Second > d {
+			d = time.Duration(secs) * time.Second
+			if c.retry.MaxDelay > 0 && d > c.retry.MaxDelay {
+				d = c.retry.MaxDelay
+			}
+		}
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// readError consumes and closes the body of a failed response
+func readError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return newAPIError(resp.StatusCode, body)
+}
+
+var errEmptyID = errors.New("ms-user: empty id")
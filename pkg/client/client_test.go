@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ms-user/pkg/servicetoken"
+)
+
+// fastRetry keeps the backoff of the tests short
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// newServer serves handler and counts its requests
+func newServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, body)
+}
+
+func TestRetriesIdempotentCalls(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		calls  int32
+	}{
+		{http.StatusTooManyRequests, 3},
+		{http.StatusBadGateway, 3},
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusGatewayTimeout, 3},
+		// a 500 is a bug of ms-user, the same call fails again
+		{http.StatusInternalServerError, 1},
+		{http.StatusBadRequest, 1},
+		{http.StatusForbidden, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusConflict, 1},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv, calls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, tc.status, `{"error":"nope"}`)
+			})
+			c := New(srv.URL, WithRetryPolicy(fastRetry))
+			_, err := c.Test(context.Background())
+			if StatusCode(err) != tc.status {
+				t.Fatalf("err = %v, want status %d", err, tc.status)
+			}
+			if got := atomic.LoadInt32(calls); got != tc.calls {
+				t.Errorf("%d calls, want %d", got, tc.calls)
+			}
+		})
+	}
+}
+
+func TestRetryRecovers(t *testing.T) {
+	var attempts int32
+	srv, _ := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			writeJSON(w, http.StatusServiceUnavailable, `{"error":"warming up"}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"data":"ok"}`)
+	})
+
+	rs, err := New(srv.URL, WithRetryPolicy(fastRetry)).Test(context.Background())
+	if err != nil || rs != "ok" {
+		t.Fatalf("Test() = %q, %v", rs, err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("%d attempts, want 3", got)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	srv, calls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, `{"error":"down"}`)
+	})
+
+	t.Run("MaxAttempts", func(t *testing.T) {
+		atomic.StoreInt32(calls, 0)
+		c := New(srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}))
+		if _, err := c.Test(context.Background()); StatusCode(err) != http.StatusServiceUnavailable {
+			t.Fatalf("err = %v", err)
+		}
+		if got := atomic.LoadInt32(calls); got != 5 {
+			t.Errorf("%d calls, want 5", got)
+		}
+	})
+
+	t.Run("NoRetry", func(t *testing.T) {
+		atomic.StoreInt32(calls, 0)
+		if _, err := New(srv.URL, WithRetryPolicy(NoRetry)).Test(context.Background()); err == nil {
+			t.Fatal("no error")
+		}
+		if got := atomic.LoadInt32(calls); got != 1 {
+			t.Errorf("%d calls, want 1", got)
+		}
+	})
+
+	t.Run("non idempotent", func(t *testing.T) {
+		atomic.StoreInt32(calls, 0)
+		if _, err := New(srv.URL, WithRetryPolicy(fastRetry)).CreateUser(context.Background(), CreateUserRequest{}); err == nil {
+			t.Fatal("no error")
+		}
+		if got := atomic.LoadInt32(calls); got != 1 {
+			t.Errorf("a POST was sent %d times", got)
+		}
+	})
+
+	t.Run("Retry-After is capped by MaxDelay", func(t *testing.T) {
+		slow, slowCalls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			writeJSON(w, http.StatusTooManyRequests, `{"error":"slow down"}`)
+		})
+		start := time.Now()
+		if _, err := New(slow.URL, WithRetryPolicy(fastRetry)).Test(context.Background()); StatusCode(err) != http.StatusTooManyRequests {
+			t.Fatalf("err = %v", err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("the retries took %s", d)
+		}
+		if got := atomic.LoadInt32(slowCalls); got != 3 {
+			t.Errorf("%d calls, want 3", got)
+		}
+	})
+}
+
+func TestRetryStopsWithTheContext(t *testing.T) {
+	srv, calls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusServiceUnavailable, `{"error":"down"}`)
+	})
+
+	t.Run("during the backoff", func(t *testing.T) {
+		atomic.StoreInt32(calls, 0)
+		c := New(srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := c.Test(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want the deadline", err)
+		}
+		if got := atomic.LoadInt32(calls); got != 1 {
+			t.Errorf("%d calls, want 1", got)
+		}
+	})
+
+	t.Run("during the request", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		hanging, hangingCalls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := New(hanging.URL, WithRetryPolicy(fastRetry)).Test(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want canceled", err)
+		}
+		if got := atomic.LoadInt32(hangingCalls); got != 1 {
+			t.Errorf("a canceled call was retried, %d calls", got)
+		}
+	})
+}
+
+func TestAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		is     func(error) bool
+		detail string
+		fields map[string]string
+		msg    string
+	}{
+		{"detail object", http.StatusNotFound, `{"error":{"detail":"user not found"}}`, IsNotFound, "user not found", nil, "ms-user: 404 user not found"},
+		{"string", http.StatusUnauthorized, `{"error":"token expired"}`, IsUnauthorized, "token expired", nil, "ms-user: 401 token expired"},
+		{"fields", http.StatusBadRequest, `{"error":{"email":"invalid","password":"too short"}}`, IsBadRequest, "",
+			map[string]string{"email": "invalid", "password": "too short"}, "ms-user: 400 email: invalid, password: too short"},
+		{"detail and fields", http.StatusConflict, `{"error":{"detail":"taken","email":"bob@example.com"}}`, IsConflict, "taken",
+			map[string]string{"email": "bob@example.com"}, "ms-user: 409 taken"},
+		{"forbidden", http.StatusForbidden, `{"error":{"detail":"admin only"}}`, IsForbidden, "admin only", nil, "ms-user: 403 admin only"},
+		{"not JSON", http.StatusBadGateway, `<html>bad gateway</html>`, func(err error) bool { return StatusCode(err) == http.StatusBadGateway }, "", nil, "ms-user: 502 Bad Gateway"},
+		{"empty", http.StatusServiceUnavailable, ``, func(err error) bool { return StatusCode(err) == http.StatusServiceUnavailable }, "", nil, "ms-user: 503 Service Unavailable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, tc.status, tc.body)
+			})
+			_, err := New(srv.URL, WithRetryPolicy(NoRetry)).Test(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v (%T), want *APIError", err, err)
+			}
+			if !tc.is(err) {
+				t.Errorf("%v has the wrong status", err)
+			}
+			if apiErr.Detail != tc.detail {
+				t.Errorf("Detail = %q, want %q", apiErr.Detail, tc.detail)
+			}
+			if len(apiErr.Fields) != len(tc.fields) {
+				t.Errorf("Fields = %v, want %v", apiErr.Fields, tc.fields)
+			}
+			for k, v := range tc.fields {
+				if apiErr.Fields[k] != v {
+					t.Errorf("Fields[%s] = %q, want %q", k, apiErr.Fields[k], v)
+				}
+			}
+			if string(apiErr.Body) != tc.body {
+				t.Errorf("Body = %q", apiErr.Body)
+			}
+			if err.Error() != tc.msg {
+				t.Errorf("Error() = %q, want %q", err.Error(), tc.msg)
+			}
+		})
+	}
+
+	if StatusCode(errors.New("dial tcp: refused")) != 0 || IsNotFound(nil) {
+		t.Error("a non API error has a status")
+	}
+}
+
+func TestServiceTokens(t *testing.T) {
+	const name, secret = "ms-billing", "billing-secret"
+	secrets := func(service string) (string, bool) { return secret, service == name }
+
+	var mu sync.Mutex
+	var tokens []string
+	srv, _ := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		tokens = append(tokens, token)
+		first := len(tokens) == 1
+		mu.Unlock()
+		if service, err := servicetoken.Parse(token, secrets); err != nil || service != name {
+			writeJSON(w, http.StatusUnauthorized, `{"error":"invalid service token"}`)
+			return
+		}
+		if first {
+			writeJSON(w, http.StatusServiceUnavailable, `{"error":"down"}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"data":{"users":{},"missing_ids":[],"missing_emails":["bob@example.com"]}}`)
+	})
+
+	c := New(srv.URL, WithServiceCredentials(name, secret), WithRetryPolicy(fastRetry))
+	rs, err := c.BatchGetUsers(context.Background(), BatchGetUsersRequest{Emails: []string{"bob@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.MissingEmails) != 1 {
+		t.Errorf("response = %+v", rs)
+	}
+	// the retry is signed again, a token is never kept across calls
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		t.Fatalf("tokens = %q", tokens)
+	}
+	if c.Tokens() != (Tokens{}) {
+		t.Errorf("a service token was kept as an access token: %+v", c.Tokens())
+	}
+
+	t.Run("lifetime", func(t *testing.T) {
+		creds := serviceCredentials{name: name, secret: secret}
+		now := time.Now()
+		first, err := creds.sign(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := decodeClaims(t, first)
+		if claims.Aud != servicetoken.Audience || claims.Iss != name {
+			t.Errorf("claims = %+v", claims)
+		}
+		if lifetime := time.Duration(claims.Exp-claims.Iat) * time.Second; lifetime > servicetoken.MaxTTL {
+			t.Errorf("the token lives %s, more than %s", lifetime, servicetoken.MaxTTL)
+		}
+		if claims.Exp <= now.Unix() || claims.Iat > now.Unix() {
+			t.Errorf("the token is not valid at its signing time: %+v", claims)
+		}
+		// a call made once the first token expired gets a fresh one
+		later, err := creds.sign(time.Unix(claims.Exp, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if later == first || decodeClaims(t, later).Exp <= claims.Exp {
+			t.Error("the token was not renewed")
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		_, err := New(srv.URL, WithServiceCredentials(name, "other"), WithRetryPolicy(NoRetry)).
+			BatchGetUsers(context.Background(), BatchGetUsersRequest{IDs: []uuid.UUID{uuid.New()}})
+		if !IsUnauthorized(err) {
+			t.Errorf("err = %v, want 401", err)
+		}
+	})
+}
+
+type serviceClaims struct {
+	Aud string `json:"aud"`
+	Iss string `json:"iss"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+func decodeClaims(t *testing.T, token string) (claims serviceClaims) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("%q is not a JWT", token)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(raw, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestRefreshesTheAccessTokenOnce(t *testing.T) {
+	var refreshed []Tokens
+	srv, calls := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/user/refresh-token":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["refresh_token"] != "refresh-1" {
+				writeJSON(w, http.StatusUnauthorized, `{"error":"refresh token revoked"}`)
+				return
+			}
+			writeJSON(w, http.StatusOK, `{"data":{"token":"access-2","refresh_token":"refresh-2"}}`)
+		case r.Header.Get("Authorization") == "Bearer access-2":
+			writeJSON(w, http.StatusOK, `{"data":[]}`)
+		default:
+			writeJSON(w, http.StatusUnauthorized, `{"error":"token expired"}`)
+		}
+	})
+
+	c := New(srv.URL,
+		WithTokens(Tokens{AccessToken: "access-1", RefreshToken: "refresh-1"}),
+		WithTokenCallback(func(tk Tokens) { refreshed = append(refreshed, tk) }),
+		WithRetryPolicy(NoRetry))
+	if _, err := c.MyLogins(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	want := Tokens{AccessToken: "access-2", RefreshToken: "refresh-2"}
+	if c.Tokens() != want || len(refreshed) != 1 || refreshed[0] != want {
+		t.Errorf("tokens = %+v, callback got %+v", c.Tokens(), refreshed)
+	}
+	// the rejected call, the refresh and the call with the new token
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("%d calls, want 3", got)
+	}
+
+	t.Run("revoked refresh token", func(t *testing.T) {
+		atomic.StoreInt32(calls, 0)
+		c := New(srv.URL, WithTokens(Tokens{AccessToken: "access-1", RefreshToken: "stolen"}), WithRetryPolicy(NoRetry))
+		if _, err := c.MyLogins(context.Background(), 0); !IsUnauthorized(err) {
+			t.Fatalf("err = %v, want 401", err)
+		}
+		if got := atomic.LoadInt32(calls); got != 2 {
+			t.Errorf("%d calls, want 2", got)
+		}
+	})
+}
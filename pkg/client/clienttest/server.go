@@ -0,0 +1,1216 @@
+// Package clienttest runs a fake ms-user on httptest for the tests of the services using pkg/client.
+//
+//	srv := clienttest.NewServer()
+//	defer srv.Close()
+//	srv.AddUser("admin@example.com", "secret", "admin")
+//	c := client.New(srv.URL, client.WithCredentials("admin@example.com", "secret", ""))
+//
+// It keeps everything in memory and answers with the envelopes and statuses of ms-user.
+// Tokens are opaque strings, they only mean something to the server that issued them.
+// Codes sent by SMS are read with PhoneCode and emailed sign-in links with MagicLinkToken, the fake has no rate limit on them.
+// OpenID Connect providers are declared with SetOIDCUser, their sign-ins are approved without a provider.
+// OAuth clients and consents are kept for the admin and consent page calls, the fake has no token endpoint.
+// SAML connections are kept for the admin calls and not checked, the fake has no SAML sign-in.
+// SCIM tokens are kept for the admin calls, the fake has no SCIM endpoints.
+// API keys authenticate like access tokens, their scopes and expiry are not checked.
+// Impersonation tokens are access tokens refused on the sensitive operations of the account, they are not audited per request.
+// The /internal routes want a bearer token, as sent by client.WithServiceCredentials, which is not verified.
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/client"
+	"ms-user/pkg/phone"
+)
+
+// phoneRegion is the region of the national numbers given to the fake
+const phoneRegion = "VN"
+
+// phoneCode is a code sent by SMS, userID is the account verifying the phone, uuid.Nil for a sign-in
+type phoneCode struct {
+	code   string
+	userID uuid.UUID
+}
+
+// magicLinkCookie carries the nonce a magic link is bound to
+const magicLinkCookie = "ms_user_magic_link"
+
+type magicLink struct {
+	userID uuid.UUID
+	nonce  string
+}
+
+// oidcStateCookie carries the state an OpenID Connect sign-in is bound to
+const oidcStateCookie = "ms_user_oidc_state"
+
+// OIDCUser is the account of a provider that signs in with it
+type OIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type oidcSignIn struct {
+	provider string
+	code     string
+	deviceID string
+}
+
+type account struct {
+	user     client.User
+	password string
+	logins   []client.LoginHistory
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	accounts map[uuid.UUID]*account
+	byEmail  map[string]uuid.UUID
+	byPhone  map[string]uuid.UUID
+	codes    map[string]phoneCode
+	links    map[string]magicLink
+	// oidcUsers are by provider, identities by provider and subject
+	oidcUsers  map[string]OIDCUser
+	identities map[string]uuid.UUID
+	oidcStates map[string]oidcSignIn
+	// oauthClients are in registration order, consents by user id and client_id
+	oauthClients []client.OAuthClient
+	consents     map[string]client.OAuthConsent
+	samlConns    map[uuid.UUID]client.SAMLConnection
+	scimTokens   []client.SCIMToken
+	// apiKeys are by id, with the key of each in apiKeySecrets
+	apiKeys       map[uuid.UUID]client.APIKey
+	apiKeySecrets map[uuid.UUID]string
+	access        map[string]uuid.UUID
+	refresh       map[string]uuid.UUID
+	audit         []client.AuditEvent
+	failures      []int
+	requests      []string
+	seq           int
+	ready         bool
+
+	// impersonations are the access tokens issued to admins, by token
+	impersonations map[string]client.Impersonation
+}
+
+func NewServer() *Server {
+	s := &Server{
+		accounts:      map[uuid.UUID]*account{},
+		byEmail:       map[string]uuid.UUID{},
+		byPhone:       map[string]uuid.UUID{},
+		codes:         map[string]phoneCode{},
+		links:         map[string]magicLink{},
+		oidcUsers:     map[string]OIDCUser{},
+		identities:    map[string]uuid.UUID{},
+		oidcStates:    map[string]oidcSignIn{},
+		consents:      map[string]client.OAuthConsent{},
+		samlConns:     map[uuid.UUID]client.SAMLConnection{},
+		apiKeys:       map[uuid.UUID]client.APIKey{},
+		apiKeySecrets: map[uuid.UUID]string{},
+		access:        map[string]uuid.UUID{},
+		refresh:       map[string]uuid.UUID{},
+		ready:         true,
+
+		impersonations: map[string]client.Impersonation{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddUser creates a user that can log in, accountType "admin" gives access to the admin API
+func (s *Server) AddUser(email, password, accountType string) client.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addUserLocked(email, password, accountType)
+}
+
+// Token returns a valid access token of the user with email, e.g. for client.WithTokens
+func (s *Server) Token(email string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.byEmail[normalizeEmail(email)]
+	if !ok {
+		return ""
+	}
+	return s.issueLocked(id).Token
+}
+
+// PhoneCode returns the pending code last sent to number by SMS, "" when there is none
+func (s *Server) PhoneCode(number string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e164, err := phone.Normalize(number, phoneRegion)
+	if err != nil {
+		return ""
+	}
+	return s.codes[e164].code
+}
+
+// MagicLinkToken returns the token of the unused link last emailed to email, "" when there is none
+func (s *Server) MagicLinkToken(email string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.byEmail[normalizeEmail(email)]
+	if !ok {
+		return ""
+	}
+	last := ""
+	for token, link := range s.links {
+		if link.userID == id && token > last {
+			last = token
+		}
+	}
+	return last
+}
+
+// SetOIDCUser declares the provider, its next sign-ins are made as u
+func (s *Server) SetOIDCUser(provider string, u OIDCUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oidcUsers[provider] = u
+}
+
+// ExpireAccessTokens makes every access token issued so far rejected with 401, refresh tokens stay valid
+func (s *Server) ExpireAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.access = map[string]uuid.UUID{}
+	s.impersonations = map[string]client.Impersonation{}
+}
+
+// RevokeRefreshTokens makes every refresh token issued so far rejected with 401
+func (s *Server) RevokeRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh = map[string]uuid.UUID{}
+}
+
+// FailNext answers the next n requests with status, before any routing
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// SetReady changes the answer of /readyz
+func (s *Server) SetReady(ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = ready
+}
+
+// Requests lists the requests received so far as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// AuditEvents returns the audit log of the fake
+func (s *Server) AuditEvents() []client.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]client.AuditEvent(nil), s.audit...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case s.impersonated(r) && ownerRoute(r.Method, path):
+		writeError(w, http.StatusForbidden, "This operation is not allowed while impersonating a user")
+	case r.Method == http.MethodGet && path == "/api/v1/test":
+		writeData(w, http.StatusOK, "test ms-user success", nil)
+	case r.Method == http.MethodPost && path == "/api/v1/user/create":
+		s.createUser(w, r)
+	case r.Method == http.MethodPost && path == "/api/v1/user/login":
+		s.login(w, r)
+	case r.Method == http.MethodPost && path == "/api/v1/user/refresh-token":
+		s.refreshToken(w, r)
+	case r.Method == http.MethodPost && path == "/api/v1/user/login/phone/otp":
+		s.sendPhoneCode(w, r, uuid.Nil)
+	case r.Method == http.MethodPost && path == "/api/v1/user/login/phone":
+		s.loginWithPhone(w, r)
+	case r.Method == http.MethodPost && path == "/api/v1/user/login/magic-link":
+		s.requestMagicLink(w, r)
+	case r.Method == http.MethodGet && path == "/api/v1/user/login/magic-link/consume":
+		s.consumeMagicLink(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/api/v1/user/login/oidc/"):
+		s.oidcLogin(w, r, strings.TrimPrefix(path, "/api/v1/user/login/oidc/"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/api/v1/user/get-one/"):
+		if acc, ok := s.authenticate(w, r); ok {
+			s.getUser(w, acc, strings.TrimPrefix(path, "/api/v1/user/get-one/"))
+		}
+	case r.Method == http.MethodGet && path == "/api/v1/user/me/logins":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.myLogins(w, r, acc)
+		}
+	case r.Method == http.MethodPost && path == "/api/v1/user/me/phone/otp":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.sendPhoneCode(w, r, acc.user.ID)
+		}
+	case r.Method == http.MethodPost && path == "/api/v1/user/me/phone/verify":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.verifyPhone(w, r, acc)
+		}
+	case r.Method == http.MethodGet && path == "/api/v1/user/me/oauth/consents":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.myConsents(w, acc)
+		}
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/api/v1/user/me/oauth/consents/"):
+		if acc, ok := s.authenticate(w, r); ok {
+			s.revokeConsent(w, acc, strings.TrimPrefix(path, "/api/v1/user/me/oauth/consents/"))
+		}
+	case path == "/api/v1/user/me/api-keys" || strings.HasPrefix(path, "/api/v1/user/me/api-keys/"):
+		if acc, ok := s.authenticate(w, r); ok {
+			s.myAPIKeys(w, r, acc, strings.TrimPrefix(strings.TrimPrefix(path, "/api/v1/user/me/api-keys"), "/"))
+		}
+	case r.Method == http.MethodGet && path == "/api/v1/oauth/authorize":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.authorizeInfo(w, r, acc)
+		}
+	case r.Method == http.MethodPost && path == "/api/v1/oauth/authorize":
+		if acc, ok := s.authenticate(w, r); ok {
+			s.decide(w, r, acc)
+		}
+	case strings.HasPrefix(path, "/api/v1/admin/"):
+		acc, ok := s.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if acc.user.AccountType != "admin" {
+			writeError(w, http.StatusForbidden, "Permission denied")
+			return
+		}
+		s.admin(w, r, acc, strings.TrimPrefix(path, "/api/v1/admin"))
+	case strings.HasPrefix(path, "/internal/") && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+	case r.Method == http.MethodPost && path == "/internal/migrate":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && path == "/internal/users/batch-get":
+		s.batchGetUsers(w, r)
+	case r.Method == http.MethodGet && path == "/healthz":
+		writeJSON(w, http.StatusOK, client.HealthReport{Status: "ok"})
+	case r.Method == http.MethodGet && path == "/readyz":
+		if s.ready {
+			writeJSON(w, http.StatusOK, client.HealthReport{Status: "ok"})
+		} else {
+			writeJSON(w, http.StatusServiceUnavailable, client.HealthReport{Status: "fail"})
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"route": "not found"}})
+	}
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var req client.CreateUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Email == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "Email or password can not be empty")
+		return
+	}
+	if _, ok := s.byEmail[normalizeEmail(req.Email)]; ok {
+		writeError(w, http.StatusConflict, "This account has been existed")
+		return
+	}
+	user := s.addUserLocked(req.Email, req.Password, "")
+	s.recordLocked("user.registered", &user.ID)
+	writeData(w, http.StatusOK, user, nil)
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req client.LoginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	id, ok := s.byEmail[normalizeEmail(req.Email)]
+	if !ok || s.accounts[id].password != req.Password {
+		if ok {
+			s.loginLocked(r, id, req.DeviceID, false)
+		}
+		writeError(w, http.StatusUnauthorized, "account or password incorrect")
+		return
+	}
+	s.loginLocked(r, id, req.DeviceID, true)
+	writeData(w, http.StatusOK, s.issueLocked(id), nil)
+}
+
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	id, ok := s.refresh[req.RefreshToken]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	delete(s.refresh, req.RefreshToken)
+	writeData(w, http.StatusOK, s.issueLocked(id), nil)
+}
+
+// sendPhoneCode keeps a new code for the phone, for a sign-in when userID is uuid.Nil
+func (s *Server) sendPhoneCode(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	var req client.PhoneCodeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	e164, ok := normalizePhone(w, req.PhoneNumber, req.Region)
+	if !ok {
+		return
+	}
+	if owner, ok := s.byPhone[e164]; ok && userID != uuid.Nil && owner != userID {
+		writeError(w, http.StatusConflict, "This phone number is used by another account")
+		return
+	}
+	s.seq++
+	s.codes[e164] = phoneCode{code: fmt.Sprintf("%06d", 100000+s.seq%900000), userID: userID}
+	writeData(w, http.StatusOK, client.PhoneCodeResponse{PhoneNumber: e164, ExpiresAt: time.Now().UTC().Add(5 * time.Minute)}, nil)
+}
+
+// takeCodeLocked consumes the pending code of e164 when it matches, it answers 401 otherwise
+func (s *Server) takeCodeLocked(w http.ResponseWriter, e164, code string, userID uuid.UUID) bool {
+	pending, ok := s.codes[e164]
+	if !ok || pending.code != code || pending.userID != userID {
+		writeError(w, http.StatusUnauthorized, "The code is invalid or expired")
+		return false
+	}
+	delete(s.codes, e164)
+	return true
+}
+
+func (s *Server) loginWithPhone(w http.ResponseWriter, r *http.Request) {
+	var req client.PhoneLoginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	e164, ok := normalizePhone(w, req.PhoneNumber, req.Region)
+	if !ok || !s.takeCodeLocked(w, e164, req.Code, uuid.Nil) {
+		return
+	}
+	id, ok := s.byPhone[e164]
+	if !ok {
+		user := s.addUserLocked("", "", "")
+		s.setPhoneLocked(user.ID, e164)
+		s.recordLocked("user.registered", &user.ID)
+		id = user.ID
+	}
+	s.loginLocked(r, id, req.DeviceID, true)
+	writeData(w, http.StatusOK, s.issueLocked(id), nil)
+}
+
+func (s *Server) verifyPhone(w http.ResponseWriter, r *http.Request, acc *account) {
+	var req client.PhoneVerifyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	e164, ok := normalizePhone(w, req.PhoneNumber, req.Region)
+	if !ok || !s.takeCodeLocked(w, e164, req.Code, acc.user.ID) {
+		return
+	}
+	s.setPhoneLocked(acc.user.ID, e164)
+	s.recordLocked("phone.verified", &acc.user.ID)
+	writeData(w, http.StatusOK, acc.user, nil)
+}
+
+func (s *Server) setPhoneLocked(id uuid.UUID, e164 string) {
+	acc := s.accounts[id]
+	if old := acc.user.PhoneNumber; old != "" && s.byPhone[old] == id {
+		delete(s.byPhone, old)
+	}
+	now := time.Now().UTC()
+	acc.user.PhoneNumber, acc.user.PhoneVerifiedAt, acc.user.UpdatedAt = e164, &now, now
+	s.byPhone[e164] = id
+}
+
+func (s *Server) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Email == "" {
+		writeError(w, http.StatusBadRequest, "Invalid input: email is required")
+		return
+	}
+	s.seq++
+	nonce := fmt.Sprintf("fake-nonce-%d", s.seq)
+	if id, ok := s.byEmail[normalizeEmail(req.Email)]; ok {
+		// zero padded so the last token is the greatest
+		s.links[fmt.Sprintf("fake-link-%010d", s.seq)] = magicLink{userID: id, nonce: nonce}
+	}
+	http.SetCookie(w, &http.Cookie{Name: magicLinkCookie, Value: nonce, Path: "/api/v1/user/login/magic-link", HttpOnly: true})
+	writeData(w, http.StatusOK, client.MagicLinkResponse{ExpiresAt: time.Now().UTC().Add(10 * time.Minute)}, nil)
+}
+
+func (s *Server) consumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	link, ok := s.links[token]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "The link is invalid or expired")
+		return
+	}
+	if cookie, err := r.Cookie(magicLinkCookie); err != nil || cookie.Value != link.nonce {
+		writeError(w, http.StatusUnauthorized, "Open the link in the browser it was requested from")
+		return
+	}
+	delete(s.links, token)
+	s.loginLocked(r, link.userID, r.URL.Query().Get("device_id"), true)
+	writeData(w, http.StatusOK, s.issueLocked(link.userID), nil)
+}
+
+// oidcLogin serves the start of a sign-in, rest is the provider, and its callback, rest is the provider and /callback
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request, rest string) {
+	provider := strings.TrimSuffix(rest, "/callback")
+	u, ok := s.oidcUsers[provider]
+	if !ok || strings.Contains(provider, "/") {
+		writeError(w, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+	if provider == rest {
+		// the provider approves at once, the browser goes straight to the callback
+		s.seq++
+		state, code := fmt.Sprintf("fake-state-%d", s.seq), fmt.Sprintf("fake-code-%d", s.seq)
+		s.oidcStates[state] = oidcSignIn{provider: provider, code: code, deviceID: r.URL.Query().Get("device_id")}
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: state, Path: "/api/v1/user/login/oidc/", HttpOnly: true})
+		http.Redirect(w, r, s.URL+"/api/v1/user/login/oidc/"+provider+"/callback?code="+code+"&state="+state, http.StatusFound)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	signIn, ok := s.oidcStates[state]
+	cookie, err := r.Cookie(oidcStateCookie)
+	if !ok || err != nil || cookie.Value != state || signIn.provider != provider || signIn.code != r.URL.Query().Get("code") {
+		writeError(w, http.StatusUnauthorized, "The sign-in with the provider failed, try again")
+		return
+	}
+	delete(s.oidcStates, state)
+
+	key := provider + "|" + u.Subject
+	id, ok := s.identities[key]
+	if !ok {
+		email := normalizeEmail(u.Email)
+		existing, taken := s.byEmail[email]
+		switch {
+		case email != "" && taken && !u.EmailVerified:
+			writeError(w, http.StatusConflict, "An account uses this email, sign in with its password or verify the email at the provider")
+			return
+		case email != "" && taken:
+			id = existing
+			s.recordLocked("identity.linked", &id)
+		default:
+			if !u.EmailVerified {
+				email = ""
+			}
+			user := s.addUserLocked(email, "", "")
+			s.recordLocked("user.registered", &user.ID)
+			id = user.ID
+		}
+		s.identities[key] = id
+	}
+	s.loginLocked(r, id, signIn.deviceID, true)
+	writeData(w, http.StatusOK, s.issueLocked(id), nil)
+}
+
+func (s *Server) getUser(w http.ResponseWriter, viewer *account, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	acc, ok := s.accounts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if viewer.user.ID == id || viewer.user.AccountType == "admin" {
+		writeData(w, http.StatusOK, acc.user, nil)
+		return
+	}
+	u := acc.user
+	writeData(w, http.StatusOK, client.User{ID: u.ID, FullName: u.FullName, DisplayName: u.DisplayName, Bio: u.Bio, Images: u.Images, Link: u.Link}, nil)
+}
+
+func (s *Server) batchGetUsers(w http.ResponseWriter, r *http.Request) {
+	var req client.BatchGetUsersRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 && len(req.Emails) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid input: ids or emails is required")
+		return
+	}
+	if len(req.IDs)+len(req.Emails) > 100 {
+		writeError(w, http.StatusBadRequest, "At most 100 users can be requested at once")
+		return
+	}
+	rs := client.BatchGetUsersResponse{Users: map[uuid.UUID]client.User{}, MissingIDs: []uuid.UUID{}, MissingEmails: []string{}}
+	for _, id := range req.IDs {
+		if acc, ok := s.accounts[id]; ok {
+			rs.Users[id] = acc.user
+		} else {
+			rs.MissingIDs = append(rs.MissingIDs, id)
+		}
+	}
+	for _, email := range req.Emails {
+		if id, ok := s.byEmail[normalizeEmail(email)]; ok {
+			rs.Users[id] = s.accounts[id].user
+		} else {
+			rs.MissingEmails = append(rs.MissingEmails, normalizeEmail(email))
+		}
+	}
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+func (s *Server) myLogins(w http.ResponseWriter, r *http.Request, acc *account) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if n > 0 && n <= 100 {
+			limit = n
+		} else if n > 100 {
+			limit = 100
+		}
+	}
+	rs := []client.LoginHistory{}
+	for i := len(acc.logins) - 1; i >= 0 && len(rs) < limit; i-- {
+		rs = append(rs, acc.logins[i])
+	}
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+func (s *Server) admin(w http.ResponseWriter, r *http.Request, acc *account, path string) {
+	switch {
+	case r.Method == http.MethodGet && path == "/audit/events":
+		page, pageSize := atoiDefault(r.URL.Query().Get("page"), 1), atoiDefault(r.URL.Query().Get("page_size"), 20)
+		matched := s.filterAudit(r)
+		start, end := (page-1)*pageSize, page*pageSize
+		if start > len(matched) {
+			start = len(matched)
+		}
+		if end > len(matched) {
+			end = len(matched)
+		}
+		meta := ginext.BodyMeta{
+			"page":        page,
+			"page_size":   pageSize,
+			"total_pages": (len(matched) + pageSize - 1) / pageSize,
+			"total_rows":  len(matched),
+		}
+		writeData(w, http.StatusOK, matched[start:end], meta)
+	case r.Method == http.MethodGet && path == "/audit/events/export":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		for _, ev := range s.filterAudit(r) {
+			_ = enc.Encode(ev)
+		}
+	case r.Method == http.MethodGet && path == "/audit/verify":
+		writeData(w, http.StatusOK, client.AuditVerifyResponse{Valid: true, Checked: len(s.audit)}, nil)
+	case r.Method == http.MethodPost && path == "/oauth/clients":
+		s.createOAuthClient(w, r, acc)
+	case r.Method == http.MethodGet && path == "/oauth/clients":
+		writeData(w, http.StatusOK, append([]client.OAuthClient{}, s.oauthClients...), nil)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/oauth/clients/"):
+		s.deleteOAuthClient(w, strings.TrimPrefix(path, "/oauth/clients/"))
+	case r.Method == http.MethodGet && path == "/saml/connections":
+		s.listSAMLConnections(w)
+	case strings.HasPrefix(path, "/saml/connections/"):
+		s.samlConnection(w, r, acc, strings.TrimPrefix(path, "/saml/connections/"))
+	case r.Method == http.MethodPost && path == "/scim/tokens":
+		s.createSCIMToken(w, r, acc)
+	case r.Method == http.MethodGet && path == "/scim/tokens":
+		s.listSCIMTokens(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/scim/tokens/"):
+		s.deleteSCIMToken(w, acc, strings.TrimPrefix(path, "/scim/tokens/"))
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/impersonate"):
+		s.impersonate(w, r, acc, strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/impersonate"))
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"route": "not found"}})
+	}
+}
+
+func (s *Server) impersonate(w http.ResponseWriter, r *http.Request, acc *account, rawID string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil || req.Reason == "" {
+		writeError(w, http.StatusBadRequest, "Invalid input: id must be a UUID and reason is required")
+		return
+	}
+	target, ok := s.accounts[id]
+	switch {
+	case id == acc.user.ID:
+		writeError(w, http.StatusBadRequest, "Admins can not impersonate themselves")
+		return
+	case !ok:
+		writeError(w, http.StatusNotFound, "Unknown user")
+		return
+	case target.user.AccountType == "admin":
+		writeError(w, http.StatusForbidden, "Admins can not be impersonated")
+		return
+	}
+	s.seq++
+	rs := client.Impersonation{
+		ID:        uuid.NewString(),
+		Token:     fmt.Sprintf("fake-impersonation-%d-%s", s.seq, id),
+		UserID:    id,
+		ActorID:   acc.user.ID,
+		ExpiresAt: time.Now().UTC().Add(15 * time.Minute),
+	}
+	s.access[rs.Token] = id
+	s.impersonations[rs.Token] = rs
+	s.recordLocked("impersonation.started", &id)
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+func (s *Server) impersonated(r *http.Request) bool {
+	_, ok := s.impersonations[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	return ok
+}
+
+// ownerRoute tells the routes refused to impersonation tokens
+func ownerRoute(method, path string) bool {
+	switch {
+	case method == http.MethodPost && (path == "/api/v1/user/me/phone/otp" || path == "/api/v1/user/me/phone/verify"):
+		return true
+	case method == http.MethodDelete && strings.HasPrefix(path, "/api/v1/user/me/oauth/consents/"):
+		return true
+	case method == http.MethodPost && path == "/api/v1/oauth/authorize":
+		return true
+	}
+	return path == "/api/v1/user/me/api-keys" || strings.HasPrefix(path, "/api/v1/user/me/api-keys/")
+}
+
+func (s *Server) createOAuthClient(w http.ResponseWriter, r *http.Request, acc *account) {
+	var req client.CreateOAuthClientRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Name == "" || len(req.RedirectURIs) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid input: name and redirect_uris are required")
+		return
+	}
+	c := client.OAuthClient{
+		ClientID:     uuid.NewString(),
+		Name:         req.Name,
+		Public:       req.Public,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		CreatedBy:    &acc.user.ID,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if len(c.GrantTypes) == 0 {
+		c.GrantTypes = []string{"authorization_code", "refresh_token"}
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "profile", "email"}
+	}
+	s.oauthClients = append(s.oauthClients, c)
+	s.recordLocked("oauth_client.created", &acc.user.ID)
+	if !c.Public {
+		s.seq++
+		c.ClientSecret = fmt.Sprintf("fake-secret-%d", s.seq)
+	}
+	writeData(w, http.StatusOK, c, nil)
+}
+
+func (s *Server) deleteOAuthClient(w http.ResponseWriter, clientID string) {
+	for i, c := range s.oauthClients {
+		if c.ClientID != clientID {
+			continue
+		}
+		s.oauthClients = append(s.oauthClients[:i], s.oauthClients[i+1:]...)
+		for key, consent := range s.consents {
+			if consent.ClientID == clientID {
+				delete(s.consents, key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not found")
+}
+
+func (s *Server) listSAMLConnections(w http.ResponseWriter) {
+	rs := make([]client.SAMLConnection, 0, len(s.samlConns))
+	for _, c := range s.samlConns {
+		rs = append(rs, c)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].CreatedAt.Before(rs[j].CreatedAt) })
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+// samlConnection serves the get, save and delete of the connection of the business rawID
+func (s *Server) samlConnection(w http.ResponseWriter, r *http.Request, acc *account, rawID string) {
+	businessID, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid input: business_id must be a UUID")
+		return
+	}
+	existing, ok := s.samlConns[businessID]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			writeError(w, http.StatusNotFound, "The business has no SAML sign-in")
+			return
+		}
+		writeData(w, http.StatusOK, existing, nil)
+	case http.MethodPut:
+		var req client.SaveSAMLConnectionRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		if len(req.Domains) == 0 || (req.MetadataXML == "" && (req.IdPEntityID == "" || req.IdPSSOURL == "" || req.IdPCertificates == "")) {
+			writeError(w, http.StatusBadRequest, "Invalid input: domains and the metadata_xml or the idp_entity_id, idp_sso_url and idp_certificates are required")
+			return
+		}
+		now := time.Now().UTC()
+		c := client.SAMLConnection{
+			ID:              uuid.New(),
+			BusinessID:      businessID,
+			IdPEntityID:     req.IdPEntityID,
+			IdPSSOURL:       req.IdPSSOURL,
+			IdPCertificates: req.IdPCertificates,
+			EmailAttribute:  req.EmailAttribute,
+			NameAttribute:   req.NameAttribute,
+			Domains:         req.Domains,
+			Enabled:         req.Enabled == nil || *req.Enabled,
+			CreatedBy:       &acc.user.ID,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+		if ok {
+			c.ID, c.CreatedBy, c.CreatedAt = existing.ID, existing.CreatedBy, existing.CreatedAt
+		}
+		s.samlConns[businessID] = c
+		s.recordLocked("saml_connection.saved", &acc.user.ID)
+		writeData(w, http.StatusOK, c, nil)
+	case http.MethodDelete:
+		if !ok {
+			writeError(w, http.StatusNotFound, "The business has no SAML sign-in")
+			return
+		}
+		delete(s.samlConns, businessID)
+		s.recordLocked("saml_connection.deleted", &acc.user.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"route": "not found"}})
+	}
+}
+
+func (s *Server) createSCIMToken(w http.ResponseWriter, r *http.Request, acc *account) {
+	var req client.CreateSCIMTokenRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.BusinessID == uuid.Nil || len(req.Description) > 255 {
+		writeError(w, http.StatusBadRequest, "Invalid input: business_id is required")
+		return
+	}
+	t := client.SCIMToken{
+		ID:          uuid.New(),
+		BusinessID:  req.BusinessID,
+		Description: req.Description,
+		CreatedBy:   &acc.user.ID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	s.scimTokens = append(s.scimTokens, t)
+	s.recordLocked("scim_token.created", &acc.user.ID)
+	s.seq++
+	t.Token = fmt.Sprintf("scim_fake-%d", s.seq)
+	writeData(w, http.StatusOK, t, nil)
+}
+
+func (s *Server) listSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	rs := []client.SCIMToken{}
+	raw := r.URL.Query().Get("business_id")
+	businessID, err := uuid.Parse(raw)
+	if raw != "" && err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid input: business_id must be a UUID")
+		return
+	}
+	for _, t := range s.scimTokens {
+		if raw == "" || t.BusinessID == businessID {
+			rs = append(rs, t)
+		}
+	}
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+func (s *Server) deleteSCIMToken(w http.ResponseWriter, acc *account, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid input: id must be a UUID")
+		return
+	}
+	for i, t := range s.scimTokens {
+		if t.ID != id {
+			continue
+		}
+		s.scimTokens = append(s.scimTokens[:i], s.scimTokens[i+1:]...)
+		s.recordLocked("scim_token.deleted", &acc.user.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, "Unknown SCIM token")
+}
+
+func (s *Server) oauthClientLocked(clientID string) (client.OAuthClient, bool) {
+	for _, c := range s.oauthClients {
+		if c.ClientID == clientID {
+			return c, true
+		}
+	}
+	return client.OAuthClient{}, false
+}
+
+// authorizationLocked checks the client, redirect_uri and scope of an authorization request
+func (s *Server) authorizationLocked(w http.ResponseWriter, q url.Values) (client.OAuthClient, []string, bool) {
+	c, ok := s.oauthClientLocked(q.Get("client_id"))
+	if !ok || !contains(c.RedirectURIs, q.Get("redirect_uri")) {
+		writeError(w, http.StatusBadRequest, "Unknown client_id or redirect_uri")
+		return c, nil, false
+	}
+	scope := strings.Fields(q.Get("scope"))
+	for _, v := range scope {
+		if !contains(c.Scopes, v) {
+			writeError(w, http.StatusBadRequest, "The scope is not allowed to the client")
+			return c, nil, false
+		}
+	}
+	return c, scope, true
+}
+
+func (s *Server) authorizeInfo(w http.ResponseWriter, r *http.Request, acc *account) {
+	c, scope, ok := s.authorizationLocked(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	consent, found := s.consents[acc.user.ID.String()+" "+c.ClientID]
+	consented := found
+	for _, v := range scope {
+		consented = consented && contains(consent.Scope, v)
+	}
+	writeData(w, http.StatusOK, client.OAuthAuthorizeInfo{ClientID: c.ClientID, ClientName: c.Name, Scope: scope, Consented: consented}, nil)
+}
+
+// decide grows the consent on approval and answers a redirect with a code the fake can not exchange
+func (s *Server) decide(w http.ResponseWriter, r *http.Request, acc *account) {
+	var req map[string]interface{}
+	if !decode(w, r, &req) {
+		return
+	}
+	q := url.Values{}
+	for k, v := range req {
+		if str, ok := v.(string); ok {
+			q.Set(k, str)
+		}
+	}
+	c, scope, ok := s.authorizationLocked(w, q)
+	if !ok {
+		return
+	}
+	back := url.Values{}
+	if approve, _ := req["approve"].(bool); approve {
+		key := acc.user.ID.String() + " " + c.ClientID
+		now := time.Now().UTC()
+		consent, found := s.consents[key]
+		if !found {
+			consent = client.OAuthConsent{ID: uuid.New(), UserID: acc.user.ID, ClientID: c.ClientID, CreatedAt: now}
+		}
+		for _, v := range scope {
+			if !contains(consent.Scope, v) {
+				consent.Scope = append(consent.Scope, v)
+			}
+		}
+		consent.UpdatedAt = now
+		s.consents[key] = consent
+		s.seq++
+		back.Set("code", fmt.Sprintf("fake-code-%d", s.seq))
+	} else {
+		back.Set("error", "access_denied")
+	}
+	if state := q.Get("state"); state != "" {
+		back.Set("state", state)
+	}
+	writeData(w, http.StatusOK, map[string]string{"redirect_to": q.Get("redirect_uri") + "?" + back.Encode()}, nil)
+}
+
+func (s *Server) myConsents(w http.ResponseWriter, acc *account) {
+	rs := []client.OAuthConsent{}
+	for _, consent := range s.consents {
+		if consent.UserID == acc.user.ID {
+			if c, ok := s.oauthClientLocked(consent.ClientID); ok {
+				consent.ClientName = c.Name
+			}
+			rs = append(rs, consent)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].UpdatedAt.After(rs[j].UpdatedAt) })
+	writeData(w, http.StatusOK, rs, nil)
+}
+
+func (s *Server) revokeConsent(w http.ResponseWriter, acc *account, clientID string) {
+	key := acc.user.ID.String() + " " + clientID
+	if _, ok := s.consents[key]; !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	delete(s.consents, key)
+	s.recordLocked("oauth_consent.revoked", &acc.user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// myAPIKeys serves the create, list and revoke of the API keys of acc, rawID is empty for the first two
+func (s *Server) myAPIKeys(w http.ResponseWriter, r *http.Request, acc *account, rawID string) {
+	if strings.HasPrefix(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), "msu_") {
+		writeError(w, http.StatusForbidden, "API keys can not manage API keys, sign in instead")
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && rawID == "":
+		var req client.CreateAPIKeyRequest
+		if !decode(w, r, &req) {
+			return
+		}
+		if req.Name == "" || len(req.Scopes) == 0 {
+			writeError(w, http.StatusBadRequest, "Invalid input: name and scopes are required")
+			return
+		}
+		now := time.Now().UTC()
+		s.seq++
+		k := client.APIKey{
+			ID:        uuid.New(),
+			UserID:    acc.user.ID,
+			Name:      req.Name,
+			Prefix:    fmt.Sprintf("%08x", s.seq),
+			Scopes:    req.Scopes,
+			ExpiresAt: now.AddDate(1, 0, 0),
+			CreatedAt: now,
+		}
+		if req.ExpiresAt != nil {
+			k.ExpiresAt = *req.ExpiresAt
+		}
+		s.apiKeys[k.ID] = k
+		s.apiKeySecrets[k.ID] = "msu_" + k.Prefix + "_fake"
+		s.access[s.apiKeySecrets[k.ID]] = acc.user.ID
+		s.recordLocked("api_key.created", &acc.user.ID)
+		k.Key = s.apiKeySecrets[k.ID]
+		writeData(w, http.StatusOK, k, nil)
+	case r.Method == http.MethodGet && rawID == "":
+		rs := []client.APIKey{}
+		for _, k := range s.apiKeys {
+			if k.UserID == acc.user.ID {
+				rs = append(rs, k)
+			}
+		}
+		sort.Slice(rs, func(i, j int) bool { return rs[i].CreatedAt.After(rs[j].CreatedAt) })
+		writeData(w, http.StatusOK, rs, nil)
+	case r.Method == http.MethodDelete && rawID != "":
+		id, err := uuid.Parse(rawID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid input: id must be a UUID")
+			return
+		}
+		if k, ok := s.apiKeys[id]; !ok || k.UserID != acc.user.ID {
+			writeError(w, http.StatusNotFound, "Unknown API key")
+			return
+		}
+		delete(s.access, s.apiKeySecrets[id])
+		delete(s.apiKeySecrets, id)
+		delete(s.apiKeys, id)
+		s.recordLocked("api_key.revoked", &acc.user.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"route": "not found"}})
+	}
+}
+
+// filterAudit applies the actor_id, subject_id and type filters, the fake ignores from and to
+func (s *Server) filterAudit(r *http.Request) []client.AuditEvent {
+	q := r.URL.Query()
+	rs := []client.AuditEvent{}
+	for _, ev := range s.audit {
+		if v := q.Get("type"); v != "" && ev.Type != v {
+			continue
+		}
+		if v := q.Get("actor_id"); v != "" && (ev.ActorID == nil || ev.ActorID.String() != v) {
+			continue
+		}
+		if v := q.Get("subject_id"); v != "" && (ev.SubjectID == nil || ev.SubjectID.String() != v) {
+			continue
+		}
+		rs = append(rs, ev)
+	}
+	return rs
+}
+
+// authenticate reads the bearer token, or the Token query parameter, and answers 401 when it is unknown
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*account, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("Token")
+	}
+	id, ok := s.access[token]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	return s.accounts[id], true
+}
+
+func (s *Server) addUserLocked(email, password, accountType string) client.User {
+	now := time.Now().UTC()
+	user := client.User{
+		ID:          uuid.New(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Email:       email,
+		AccountType: accountType,
+	}
+	s.accounts[user.ID] = &account{user: user, password: password}
+	if email != "" {
+		s.byEmail[normalizeEmail(email)] = user.ID
+	}
+	return user
+}
+
+func (s *Server) issueLocked(id uuid.UUID) client.LoginResponse {
+	s.seq++
+	rs := client.LoginResponse{
+		Token:        fmt.Sprintf("fake-access-%d-%s", s.seq, id),
+		RefreshToken: fmt.Sprintf("fake-refresh-%d-%s", s.seq, id),
+	}
+	s.access[rs.Token] = id
+	s.refresh[rs.RefreshToken] = id
+	return rs
+}
+
+func (s *Server) loginLocked(r *http.Request, id uuid.UUID, deviceID string, success bool) {
+	h := client.LoginHistory{
+		ID:         uuid.New(),
+		OccurredAt: time.Now().UTC(),
+		IP:         r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		DeviceID:   deviceID,
+		Success:    success,
+	}
+	evType := "login.succeeded"
+	if !success {
+		h.Reason = "invalid_password"
+		evType = "login.failed"
+	}
+	acc := s.accounts[id]
+	acc.logins = append(acc.logins, h)
+	s.recordLocked(evType, &id)
+}
+
+func (s *Server) recordLocked(evType string, subject *uuid.UUID) {
+	s.audit = append(s.audit, client.AuditEvent{
+		ID:         uuid.New(),
+		Seq:        int64(len(s.audit) + 1),
+		OccurredAt: time.Now().UTC(),
+		Type:       evType,
+		ActorID:    subject,
+		SubjectID:  subject,
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// normalizeEmail is model.NormalizeEmail, copied to keep pkg/model and GORM out of the SDK,
+// TestNormalizeEmailMatchesModel keeps the two in step
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func normalizePhone(w http.ResponseWriter, number, region string) (string, bool) {
+	if region == "" {
+		region = phoneRegion
+	}
+	e164, err := phone.Normalize(number, region)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Phone number invalid: "+err.Error())
+		return "", false
+	}
+	return e164, true
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func atoiDefault(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}, meta ginext.BodyMeta) {
+	writeJSON(w, status, ginext.GeneralBody{Data: data, Meta: meta})
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, ginext.GeneralBody{Error: map[string]string{"detail": detail}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
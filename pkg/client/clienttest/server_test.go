@@ -0,0 +1,22 @@
+package clienttest
+
+import (
+	"testing"
+
+	"ms-user/pkg/model"
+)
+
+func TestNormalizeEmailMatchesModel(t *testing.T) {
+	for _, email := range []string{
+		"bob@example.com",
+		"Bob@Example.COM",
+		"  bob@example.com\t",
+		"\nBOB@EXAMPLE.COM ",
+		"ÉLODIE@example.com",
+		"",
+	} {
+		if got, want := normalizeEmail(email), model.NormalizeEmail(email); got != want {
+			t.Errorf("normalizeEmail(%q) = %q, model.NormalizeEmail = %q", email, got, want)
+		}
+	}
+}
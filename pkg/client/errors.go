@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// APIError is a non 2xx answer of ms-user, decoded from the error of the response envelope
+type APIError struct {
+	StatusCode int
+	// Detail is the message of {"error": {"detail": "..."}} or {"error": "..."}
+	Detail string
+	// Fields holds the other error objects, e.g. validation errors by field
+	Fields map[string]string
+	// Body is the raw response body
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	msg := e.Detail
+	if msg == "" && len(e.Fields) > 0 {
+		keys := make([]string, 0, len(e.Fields))
+		for k := range e.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			parts = append(parts, k+": "+e.Fields[k])
+		}
+		msg = strings.Join(parts, ", ")
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("ms-user: %d %s", e.StatusCode, msg)
+}
+
+func newAPIError(status int, body []byte) *APIError {
+	e := &APIError{StatusCode: status, Body: body}
+	var env struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &env) != nil || len(env.Error) == 0 {
+		return e
+	}
+
+	var detail string
+	if json.Unmarshal(env.Error, &detail) == nil {
+		e.Detail = detail
+		return e
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(env.Error, &fields) == nil {
+		if d, ok := fields["detail"].(string); ok {
+			e.Detail = d
+			delete(fields, "detail")
+		}
+		for k, v := range fields {
+			if e.Fields == nil {
+				e.Fields = map[string]string{}
+			}
+			e.Fields[k] = fmt.Sprint(v)
+		}
+	}
+	return e
+}
+
+// StatusCode returns the HTTP status of an APIError in the chain of err, 0 otherwise
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func IsBadRequest(err error) bool   { return StatusCode(err) == http.StatusBadRequest }
+func IsUnauthorized(err error) bool { return StatusCode(err) == http.StatusUnauthorized }
+func IsForbidden(err error) bool    { return StatusCode(err) == http.StatusForbidden }
+func IsNotFound(err error) bool     { return StatusCode(err) == http.StatusNotFound }
+func IsConflict(err error) bool     { return StatusCode(err) == http.StatusConflict }
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Impersonate issues to the signed in admin a token of the user, reason is kept in the audit log.
+// Use the token with client.New(baseURL, client.WithTokens(client.Tokens{AccessToken: rs.Token})); it can not be refreshed
+// and is refused on the sensitive operations of the account.
+func (c *Client) Impersonate(ctx context.Context, userID uuid.UUID, reason string) (rs Impersonation, err error) {
+	if userID == uuid.Nil {
+		return rs, errEmptyID
+	}
+	path := "/api/v1/admin/users/" + userID.String() + "/impersonate"
+	err = c.call(ctx, request{method: http.MethodPost, path: path, body: map[string]string{"reason": reason}, auth: true}, &rs, nil)
+	return rs, err
+}
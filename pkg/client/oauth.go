@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CreateOAuthClient registers a client of the OAuth 2.0 / OpenID Connect provider, it needs an admin account.
+// The ClientSecret of a confidential client is only returned here.
+func (c *Client) CreateOAuthClient(ctx context.Context, req CreateOAuthClientRequest) (rs OAuthClient, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/admin/oauth/clients", body: req, auth: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) ListOAuthClients(ctx context.Context) (rs []OAuthClient, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/admin/oauth/clients", auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// DeleteOAuthClient deletes a client with the consents given to it and its refresh tokens
+func (c *Client) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	path := "/api/v1/admin/oauth/clients/" + url.PathEscape(clientID)
+	return c.call(ctx, request{method: http.MethodDelete, path: path, auth: true, idempotent: true}, nil, nil)
+}
+
+// OAuthAuthorizeInfo describes the authorization request of query, the query the consent page was opened with
+func (c *Client) OAuthAuthorizeInfo(ctx context.Context, query url.Values) (rs OAuthAuthorizeInfo, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/oauth/authorize", query: query, auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// DecideOAuthAuthorization approves or denies the authorization request of query as the signed in user,
+// it returns where to send the browser
+func (c *Client) DecideOAuthAuthorization(ctx context.Context, query url.Values, approve bool) (redirectTo string, err error) {
+	body := map[string]interface{}{"approve": approve}
+	for k := range query {
+		body[k] = query.Get(k)
+	}
+	var rs struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/oauth/authorize", body: body, auth: true}, &rs, nil)
+	return rs.RedirectTo, err
+}
+
+// MyOAuthConsents lists the clients the signed in user granted access to
+func (c *Client) MyOAuthConsents(ctx context.Context) (rs []OAuthConsent, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/user/me/oauth/consents", auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// RevokeOAuthConsent withdraws the access of a client to the signed in user
+func (c *Client) RevokeOAuthConsent(ctx context.Context, clientID string) error {
+	path := "/api/v1/user/me/oauth/consents/" + url.PathEscape(clientID)
+	return c.call(ctx, request{method: http.MethodDelete, path: path, auth: true, idempotent: true}, nil, nil)
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Migrate runs the database migrations of ms-user, they are idempotent
+func (c *Client) Migrate(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/internal/migrate", internal: true, idempotent: true}, nil, nil)
+}
+
+// Health calls the liveness probe
+func (c *Client) Health(ctx context.Context) (HealthReport, error) {
+	return c.report(ctx, "/healthz")
+}
+
+// Ready calls the readiness probe, an unready service returns the report with an *APIError of status 503
+func (c *Client) Ready(ctx context.Context) (HealthReport, error) {
+	return c.report(ctx, "/readyz")
+}
+
+// report decodes the plain JSON answer of a probe, which is not wrapped in the response envelope
+func (c *Client) report(ctx context.Context, path string) (rs HealthReport, err error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: path})
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok {
+			_ = json.Unmarshal(apiErr.Body, &rs)
+		}
+		return rs, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return rs, err
+	}
+	return rs, json.Unmarshal(body, &rs)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// SaveSAMLConnection configures the SAML identity provider of a business, it needs an admin account.
+// Replacing the identity provider by one with another entity ID unlinks the identities signed in by the former one.
+func (c *Client) SaveSAMLConnection(ctx context.Context, businessID uuid.UUID, req SaveSAMLConnectionRequest) (rs SAMLConnection, err error) {
+	if businessID == uuid.Nil {
+		return rs, errEmptyID
+	}
+	path := "/api/v1/admin/saml/connections/" + businessID.String()
+	err = c.call(ctx, request{method: http.MethodPut, path: path, body: req, auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) GetSAMLConnection(ctx context.Context, businessID uuid.UUID) (rs SAMLConnection, err error) {
+	if businessID == uuid.Nil {
+		return rs, errEmptyID
+	}
+	path := "/api/v1/admin/saml/connections/" + businessID.String()
+	err = c.call(ctx, request{method: http.MethodGet, path: path, auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) ListSAMLConnections(ctx context.Context) (rs []SAMLConnection, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/admin/saml/connections", auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// DeleteSAMLConnection deletes the connection of a business and unlinks the identities it signed in
+func (c *Client) DeleteSAMLConnection(ctx context.Context, businessID uuid.UUID) error {
+	if businessID == uuid.Nil {
+		return errEmptyID
+	}
+	path := "/api/v1/admin/saml/connections/" + businessID.String()
+	return c.call(ctx, request{method: http.MethodDelete, path: path, auth: true, idempotent: true}, nil, nil)
+}
+
+// SAMLMetadataURL is the URL of the SP metadata of a business, the one its identity provider is configured with
+func (c *Client) SAMLMetadataURL(businessID uuid.UUID) string {
+	return c.baseURL + "/api/v1/user/login/saml/" + businessID.String() + "/metadata"
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// CreateSCIMToken makes the token the directory of a business provisions its users with, it needs an admin account.
+// The token is only returned here.
+func (c *Client) CreateSCIMToken(ctx context.Context, req CreateSCIMTokenRequest) (rs SCIMToken, err error) {
+	if req.BusinessID == uuid.Nil {
+		return rs, errEmptyID
+	}
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/admin/scim/tokens", body: req, auth: true}, &rs, nil)
+	return rs, err
+}
+
+// ListSCIMTokens lists the tokens of a business, or of every business when businessID is uuid.Nil
+func (c *Client) ListSCIMTokens(ctx context.Context, businessID uuid.UUID) (rs []SCIMToken, err error) {
+	query := url.Values{}
+	if businessID != uuid.Nil {
+		query.Set("business_id", businessID.String())
+	}
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/admin/scim/tokens", query: query, auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) DeleteSCIMToken(ctx context.Context, id uuid.UUID) error {
+	if id == uuid.Nil {
+		return errEmptyID
+	}
+	path := "/api/v1/admin/scim/tokens/" + id.String()
+	return c.call(ctx, request{method: http.MethodDelete, path: path, auth: true, idempotent: true}, nil, nil)
+}
+
+// SCIMBaseURL is the base URL of the SCIM 2.0 endpoints, the one the directory of a business is configured with
+func (c *Client) SCIMBaseURL() string {
+	return c.baseURL + "/scim/v2"
+}
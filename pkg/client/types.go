@@ -0,0 +1,273 @@
+package client
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User holds every field ms-user may show of an account. GetUser leaves zero the fields the caller may not see:
+// the owner and admins get them all, the others only the public profile (id, names, bio, images and link).
+type User struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatorID   uuid.UUID  `json:"creator_id"`
+	UpdaterID   uuid.UUID  `json:"updater_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	FullName    string     `json:"full_name"`
+	PhoneNumber string     `json:"phone_number"`
+	// PhoneVerifiedAt is set once the phone number can be used to sign in
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+	Email           string     `json:"email"`
+	DisplayName     string     `json:"display_name"`
+	Bio             string     `json:"bio"`
+	AccountType     string     `json:"account_type"`
+	Images          string     `json:"images"`
+	Link            string     `json:"link"`
+}
+
+type BatchGetUsersRequest struct {
+	IDs    []uuid.UUID `json:"ids,omitempty"`
+	Emails []string    `json:"emails,omitempty"`
+}
+
+// BatchGetUsersResponse keys the users found by id, they come without credentials
+type BatchGetUsersResponse struct {
+	Users         map[uuid.UUID]User `json:"users"`
+	MissingIDs    []uuid.UUID        `json:"missing_ids"`
+	MissingEmails []string           `json:"missing_emails"`
+}
+
+type CreateUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+type LoginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PhoneCodeRequest asks for a code by SMS, Region is the country of national numbers and may be empty
+type PhoneCodeRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Region      string `json:"region,omitempty"`
+}
+
+type PhoneCodeResponse struct {
+	// PhoneNumber is the number the code was sent to, in E.164
+	PhoneNumber string    `json:"phone_number"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type PhoneLoginRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Region      string `json:"region,omitempty"`
+	Code        string `json:"code"`
+	DeviceID    string `json:"device_id,omitempty"`
+}
+
+type PhoneVerifyRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Region      string `json:"region,omitempty"`
+	Code        string `json:"code"`
+}
+
+// MagicLinkResponse is the same whether the email has an account or not
+type MagicLinkResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Tokens are the credentials the client sends, they change on every refresh
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+type LoginHistory struct {
+	ID         uuid.UUID `json:"id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Browser    string    `json:"browser"`
+	OS         string    `json:"os"`
+	DeviceID   string    `json:"device_id"`
+	Success    bool      `json:"success"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+type AuditEvent struct {
+	ID         uuid.UUID  `json:"id"`
+	Seq        int64      `json:"seq"`
+	OccurredAt time.Time  `json:"occurred_at"`
+	Type       string     `json:"type"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty"`
+	SubjectID  *uuid.UUID `json:"subject_id,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	Metadata   string     `json:"metadata,omitempty"`
+	PrevHash   string     `json:"prev_hash"`
+	Hash       string     `json:"hash"`
+}
+
+// AuditFilter selects audit events, zero fields are ignored
+type AuditFilter struct {
+	ActorID   *uuid.UUID
+	SubjectID *uuid.UUID
+	Type      string
+	From      *time.Time
+	To        *time.Time
+	Page      int
+	PageSize  int
+}
+
+type Pagination struct {
+	Page       int `json:"page"`
+	PageSize   int `json:"page_size"`
+	TotalPages int `json:"total_pages"`
+	TotalRows  int `json:"total_rows"`
+}
+
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type OAuthClient struct {
+	ClientID     string     `json:"client_id"`
+	Name         string     `json:"name"`
+	Public       bool       `json:"public"`
+	RedirectURIs []string   `json:"redirect_uris"`
+	GrantTypes   []string   `json:"grant_types"`
+	Scopes       []string   `json:"scopes"`
+	CreatedBy    *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	// ClientSecret is only set by CreateOAuthClient, for a confidential client
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// CreateOAuthClientRequest registers a client, empty GrantTypes and Scopes take the defaults of ms-user
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	GrantTypes   []string `json:"grant_types,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+type OAuthAuthorizeInfo struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scope      []string `json:"scope"`
+	// Consented is true when the user already granted these scopes
+	Consented bool `json:"consented"`
+}
+
+type OAuthConsent struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name,omitempty"`
+	Scope      []string  `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type SAMLConnection struct {
+	ID              uuid.UUID  `json:"id"`
+	BusinessID      uuid.UUID  `json:"business_id"`
+	IdPEntityID     string     `json:"idp_entity_id"`
+	IdPSSOURL       string     `json:"idp_sso_url"`
+	IdPCertificates string     `json:"idp_certificates"`
+	EmailAttribute  string     `json:"email_attribute"`
+	NameAttribute   string     `json:"name_attribute"`
+	Domains         []string   `json:"domains"`
+	Enabled         bool       `json:"enabled"`
+	CreatedBy       *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// SaveSAMLConnectionRequest gives the metadata document of the identity provider, or its entity ID, SSO URL and PEM certificates
+type SaveSAMLConnectionRequest struct {
+	MetadataXML     string   `json:"metadata_xml,omitempty"`
+	IdPEntityID     string   `json:"idp_entity_id,omitempty"`
+	IdPSSOURL       string   `json:"idp_sso_url,omitempty"`
+	IdPCertificates string   `json:"idp_certificates,omitempty"`
+	EmailAttribute  string   `json:"email_attribute,omitempty"`
+	NameAttribute   string   `json:"name_attribute,omitempty"`
+	Domains         []string `json:"domains"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type SCIMToken struct {
+	ID          uuid.UUID  `json:"id"`
+	BusinessID  uuid.UUID  `json:"business_id"`
+	Description string     `json:"description"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// Token is only set by CreateSCIMToken
+	Token string `json:"token,omitempty"`
+}
+
+type CreateSCIMTokenRequest struct {
+	BusinessID  uuid.UUID `json:"business_id"`
+	Description string    `json:"description,omitempty"`
+}
+
+// Scopes of the API keys: read opens the GET routes, write every route, admin the admin API of an admin account
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+	APIKeyScopeAdmin = "admin"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Key is only set by CreateAPIKey
+	Key string `json:"key,omitempty"`
+}
+
+// CreateAPIKeyRequest names a key, a nil ExpiresAt gives the longest lifetime ms-user allows
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Impersonation is a short-lived access token of UserID for the admin ActorID, it comes without refresh token
+type Impersonation struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	UserID    uuid.UUID `json:"user_id"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Test calls the /api/v1/test ping endpoint
+func (c *Client) Test(ctx context.Context) (rs string, err error) {
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/test", idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest) (rs User, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/create", body: req}, &rs, nil)
+	return rs, err
+}
+
+// Login signs in with req and makes the client use the returned tokens
+func (c *Client) Login(ctx context.Context, req LoginRequest) (rs LoginResponse, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/login", body: req}, &rs, nil); err != nil {
+		return rs, err
+	}
+	c.setTokensLocked(rs)
+	return rs, nil
+}
+
+// RefreshToken exchanges the current refresh token for new tokens, the old refresh token is revoked
+func (c *Client) RefreshToken(ctx context.Context) (Tokens, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refreshLocked(ctx); err != nil {
+		return Tokens{}, err
+	}
+	return c.tokens, nil
+}
+
+// RequestPhoneLoginCode sends a sign-in code by SMS, see LoginWithPhone
+func (c *Client) RequestPhoneLoginCode(ctx context.Context, req PhoneCodeRequest) (rs PhoneCodeResponse, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/login/phone/otp", body: req}, &rs, nil)
+	return rs, err
+}
+
+// LoginWithPhone signs in with the code sent by SMS and makes the client use the returned tokens.
+// The account is created on the first sign-in of the phone number.
+func (c *Client) LoginWithPhone(ctx context.Context, req PhoneLoginRequest) (rs LoginResponse, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/login/phone", body: req}, &rs, nil); err != nil {
+		return rs, err
+	}
+	c.setTokensLocked(rs)
+	return rs, nil
+}
+
+// RequestMagicLink emails a sign-in link to the account of email, see ConsumeMagicLink.
+// The link is bound to a cookie, so the http.Client given with WithHTTPClient needs a cookie jar.
+func (c *Client) RequestMagicLink(ctx context.Context, email string) (rs MagicLinkResponse, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/login/magic-link", body: map[string]string{"email": email}}, &rs, nil)
+	return rs, err
+}
+
+// ConsumeMagicLink signs in with the token of an emailed link and makes the client use the returned tokens
+func (c *Client) ConsumeMagicLink(ctx context.Context, token, deviceID string) (rs LoginResponse, err error) {
+	query := url.Values{"token": {token}}
+	if deviceID != "" {
+		query.Set("device_id", deviceID)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/user/login/magic-link/consume", query: query}, &rs, nil); err != nil {
+		return rs, err
+	}
+	c.setTokensLocked(rs)
+	return rs, nil
+}
+
+// StartOIDCLogin starts a sign-in with an OpenID Connect provider of ms-user and returns the URL
+// of the provider to open in the browser, see FinishOIDCLogin. ms-user binds the sign-in to a cookie,
+// so the http.Client given with WithHTTPClient needs a cookie jar.
+func (c *Client) StartOIDCLogin(ctx context.Context, provider, deviceID string) (authURL string, err error) {
+	u := c.baseURL + "/api/v1/user/login/oidc/" + url.PathEscape(provider)
+	if deviceID != "" {
+		u += "?" + url.Values{"device_id": {deviceID}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	// the redirect is the answer, it is not followed
+	noRedirect := *c.httpClient
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusFound {
+		return "", readError(resp)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Location"), nil
+}
+
+// FinishOIDCLogin signs in with the query the provider redirected back with, and makes the client use the returned tokens
+func (c *Client) FinishOIDCLogin(ctx context.Context, provider string, callback url.Values) (rs LoginResponse, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := "/api/v1/user/login/oidc/" + url.PathEscape(provider) + "/callback"
+	if err = c.call(ctx, request{method: http.MethodGet, path: path, query: callback}, &rs, nil); err != nil {
+		return rs, err
+	}
+	c.setTokensLocked(rs)
+	return rs, nil
+}
+
+// RequestPhoneVerificationCode sends a code to the phone number the signed in user wants to add, see VerifyPhone
+func (c *Client) RequestPhoneVerificationCode(ctx context.Context, req PhoneCodeRequest) (rs PhoneCodeResponse, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/me/phone/otp", body: req, auth: true}, &rs, nil)
+	return rs, err
+}
+
+// VerifyPhone sets the phone number of the signed in user, it returns the updated account
+func (c *Client) VerifyPhone(ctx context.Context, req PhoneVerifyRequest) (rs User, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/api/v1/user/me/phone/verify", body: req, auth: true}, &rs, nil)
+	return rs, err
+}
+
+func (c *Client) GetUser(ctx context.Context, id uuid.UUID) (rs User, err error) {
+	if id == uuid.Nil {
+		return rs, errEmptyID
+	}
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/user/get-one/" + id.String(), auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// BatchGetUsers looks up at most 100 users by ids and emails in one call
+func (c *Client) BatchGetUsers(ctx context.Context, req BatchGetUsersRequest) (rs BatchGetUsersResponse, err error) {
+	err = c.call(ctx, request{method: http.MethodPost, path: "/internal/users/batch-get", body: req, internal: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
+
+// MyLogins lists the recent sign-ins of the signed in user, limit 0 keeps the server default
+func (c *Client) MyLogins(ctx context.Context, limit int) (rs []LoginHistory, err error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	err = c.call(ctx, request{method: http.MethodGet, path: "/api/v1/user/me/logins", query: query, auth: true, idempotent: true}, &rs, nil)
+	return rs, err
+}
@@ -0,0 +1,45 @@
+package grpcserver
+
+import (
+	"errors"
+	"net/http"
+
+	"gitlab.com/goxp/cloud0/ginext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+
+	"ms-user/pkg/utils"
+)
+
+var httpToCode = map[int]codes.Code{
+	http.StatusBadRequest:         codes.InvalidArgument,
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusNotFound:           codes.NotFound,
+	http.StatusConflict:           codes.AlreadyExists,
+	http.StatusTooManyRequests:    codes.ResourceExhausted,
+	http.StatusServiceUnavailable: codes.Unavailable,
+	http.StatusGatewayTimeout:     codes.DeadlineExceeded,
+}
+
+// toStatus maps the errors of the service layer to gRPC statuses, the ginext.NewError codes
+// are translated and internal details are not sent to the caller
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return status.Error(codes.NotFound, utils.MessageError()[http.StatusNotFound])
+	}
+	var apiErr ginext.ApiError
+	if errors.As(err, &apiErr) {
+		if code, ok := httpToCode[apiErr.Code()]; ok {
+			return status.Error(code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, utils.MessageError()[http.StatusInternalServerError])
+}
@@ -0,0 +1,125 @@
+package grpcserver
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"ms-user/pkg/audit"
+	"ms-user/pkg/pb/userv1"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// publicMethods of the UserService are called without an access token, the other services
+// (health) are public as well
+var publicMethods = map[string]bool{
+	userv1.UserService_CreateUser_FullMethodName:  true,
+	userv1.UserService_Login_FullMethodName:       true,
+	userv1.UserService_VerifyToken_FullMethodName: true,
+}
+
+// internalMethods of the UserService are called by the services of INTERNAL_SERVICES with a service token,
+// each is allowed like the REST route of the same call
+var internalMethods = map[string]string{
+	userv1.UserService_BatchGetUsers_FullMethodName: "POST /internal/users/batch-get",
+}
+
+func requiresAuth(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+userv1.UserService_ServiceDesc.ServiceName+"/") && !publicMethods[fullMethod]
+}
+
+// authInterceptor checks the "authorization: Bearer <token>" metadata like VerifyTokenHandler,
+// the user becomes the actor of the audit events. The internal methods take a service token
+// instead, like RequireService.
+func authInterceptor(users service.UserInterface, internal service.InternalAuthInterface) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !requiresAuth(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		log := tracing.WithCtx(ctx, "grpcserver.authInterceptor")
+		if endpoint, ok := internalMethods[info.FullMethod]; ok {
+			name, err := internal.Authenticate(ctx, bearerToken(ctx), endpoint)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			log.WithField("service", name).Info("internal call")
+			return handler(ctx, req)
+		}
+		unauthenticated := status.Error(codes.Unauthenticated, utils.MessageError()[http.StatusUnauthorized])
+
+		token := bearerToken(ctx)
+		if token == "" {
+			log.Error("error_401: missing token")
+			return nil, unauthenticated
+		}
+		claims, userID, err := users.AuthenticateAccessToken(ctx, token)
+		if err != nil {
+			log.WithError(err).Error("authenticate token error")
+			return nil, unauthenticated
+		}
+		// the services calling the gRPC API act as themselves, an admin impersonates users on the HTTP API only
+		if claims.Act != nil {
+			log.WithField("impersonation_id", claims.Id).Error("error_401: impersonation token")
+			return nil, unauthenticated
+		}
+		return handler(audit.WithActor(ctx, userID), req)
+	}
+}
+
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			return strings.TrimSpace(v[7:])
+		}
+	}
+	return ""
+}
+
+// logInterceptor writes one access log line per call
+func logInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		rs, err := handler(ctx, req)
+
+		l := tracing.WithCtx(ctx, "grpc").
+			WithField("method", info.FullMethod).
+			WithField("code", status.Code(err).String()).
+			WithField("latency", time.Since(start).Milliseconds())
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			l = l.WithField("peer", p.Addr.String())
+		}
+		if err != nil {
+			l = l.WithField("error", status.Convert(err).Message())
+		}
+		l.Info("access log")
+		return rs, err
+	}
+}
+
+// recoveryInterceptor turns a panic into an Internal error, gRPC would crash the process otherwise
+func recoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rs interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				tracing.WithCtx(ctx, "grpcserver.recoveryInterceptor").
+					WithField("panic", r).WithField("stack", string(debug.Stack())).Error("panic in grpc handler")
+				err = status.Error(codes.Internal, utils.MessageError()[http.StatusInternalServerError])
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,71 @@
+// Package grpcserver serves the UserService gRPC API of proto/user/v1 and grpc.health.v1,
+// on its own port and with the same service.UserInterface as the REST API.
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/pb/userv1"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+type Config struct {
+	MaxRecvMsgBytes int
+}
+
+type Server struct {
+	*grpc.Server
+	health *health.Server
+}
+
+// New registers the UserService and the health service, the interceptors run in this order:
+// tracing, metrics, access log, request info for the audit, panic recovery, authentication.
+// internal authenticates the services calling the internal methods.
+func New(users service.UserInterface, internal service.InternalAuthInterface, cfg Config) *Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			tracing.UnaryServerInterceptor(),
+			metrics.UnaryServerInterceptor(),
+			logInterceptor(),
+			audit.UnaryServerInterceptor(),
+			recoveryInterceptor(),
+			authInterceptor(users, internal),
+		),
+	}
+	if cfg.MaxRecvMsgBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgBytes))
+	}
+
+	s := &Server{Server: grpc.NewServer(opts...), health: health.NewServer()}
+	userv1.RegisterUserServiceServer(s.Server, &userServer{users: users})
+	healthpb.RegisterHealthServer(s.Server, s.health)
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus(userv1.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	return s
+}
+
+// Shutdown reports NOT_SERVING to the health checks and waits for the in-flight calls,
+// the remaining ones are cancelled when ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,176 @@
+package grpcserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/pb/userv1"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+type userServer struct {
+	userv1.UnimplementedUserServiceServer
+	users service.UserInterface
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.GetUserResponse, error) {
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "id must be a UUID")
+	}
+	user, err := s.users.GetOneUserByID(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userv1.GetUserResponse{User: s.viewer(ctx).user(user)}, nil
+}
+
+func (s *userServer) BatchGetUsers(ctx context.Context, req *userv1.BatchGetUsersRequest) (*userv1.BatchGetUsersResponse, error) {
+	ids := make([]uuid.UUID, 0, len(req.GetIds()))
+	for _, raw := range req.GetIds() {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "ids: %q is not a UUID", raw)
+		}
+		ids = append(ids, id)
+	}
+
+	found, err := s.users.BatchGetUsers(ctx, model.BatchGetUsersReq{IDs: ids})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	// only services call it, they get the summary of the internal REST route
+	rs := &userv1.BatchGetUsersResponse{Users: make([]*userv1.User, 0, len(found.Users))}
+	for _, u := range found.Users {
+		rs.Users = append(rs.Users, toUserSummary(model.NewUserSummary(u)))
+	}
+	for _, id := range found.MissingIDs {
+		rs.MissingIds = append(rs.MissingIds, id.String())
+	}
+	return rs, nil
+}
+
+func (s *userServer) VerifyToken(ctx context.Context, req *userv1.VerifyTokenRequest) (*userv1.VerifyTokenResponse, error) {
+	log := tracing.WithCtx(ctx, "grpcserver.VerifyToken")
+
+	claims, userID, err := s.users.AuthenticateAccessToken(ctx, req.GetToken())
+	if err != nil {
+		log.WithError(err).Info("invalid token")
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	rs := &userv1.VerifyTokenResponse{
+		UserId:    userID.String(),
+		DeviceId:  claims.DeviceID,
+		ExpiresAt: timestamppb.New(time.Unix(claims.ExpiresAt, 0)),
+	}
+	// the service verifying an impersonation token serves the user, the verification is audited
+	if actorID, ok := claims.Impersonator(); ok {
+		rs.ImpersonatorId, rs.ImpersonationId = actorID.String(), claims.Id
+		ctx = audit.WithImpersonation(ctx, audit.Impersonation{ActorID: actorID, ID: claims.Id})
+		log.WithField("impersonator_id", actorID).WithField("impersonation_id", claims.Id).Info("impersonation token verified")
+		s.users.RecordImpersonatedRequest(ctx, userID, "grpc", userv1.UserService_VerifyToken_FullMethodName, http.StatusOK)
+	}
+	return rs, nil
+}
+
+func (s *userServer) CreateUser(ctx context.Context, req *userv1.CreateUserRequest) (*userv1.CreateUserResponse, error) {
+	email, password := req.GetEmail(), req.GetPassword()
+	user, err := s.users.CreateUser(ctx, model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userv1.CreateUserResponse{User: toUser(user)}, nil
+}
+
+func (s *userServer) Login(ctx context.Context, req *userv1.LoginRequest) (*userv1.LoginResponse, error) {
+	email, password := req.GetEmail(), req.GetPassword()
+	login := model.CreateUserReq{Email: &email, Password: &password}
+	if deviceID := req.GetDeviceId(); deviceID != "" {
+		login.DeviceID = &deviceID
+	}
+	rs, err := s.users.Login(ctx, login)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &userv1.LoginResponse{Token: rs.Token, RefreshToken: rs.RefreshToken}, nil
+}
+
+// userViewer shows the users like the views of the REST API: their owner and the admins see
+// the whole account, the other callers only its public profile
+type userViewer struct {
+	id    uuid.UUID
+	admin bool
+}
+
+// viewer is the signed in user calling GetUser, authInterceptor made it the actor of ctx
+func (s *userServer) viewer(ctx context.Context) userViewer {
+	id, ok := audit.ActorFromContext(ctx)
+	if !ok {
+		return userViewer{}
+	}
+	v := userViewer{id: id}
+	if u, err := s.users.GetOneUserByID(ctx, id); err == nil {
+		v.admin = u.AccountType == model.AccountTypeAdmin
+	}
+	return v
+}
+
+func (v userViewer) user(u model.User) *userv1.User {
+	if v.admin || (v.id != uuid.Nil && v.id == u.ID) {
+		return toUser(u)
+	}
+	return toPublicUser(model.NewPublicProfile(u))
+}
+
+// toPublicUser leaves the email, phone number, account type and dates of the account empty
+func toPublicUser(p model.PublicProfile) *userv1.User {
+	return &userv1.User{
+		Id:          p.ID.String(),
+		FullName:    p.FullName,
+		DisplayName: p.DisplayName,
+		Bio:         p.Bio,
+		Images:      p.Images,
+		Link:        p.Link,
+	}
+}
+
+func toUserSummary(u model.UserSummary) *userv1.User {
+	return &userv1.User{
+		Id:          u.ID.String(),
+		Email:       u.Email,
+		FullName:    u.FullName,
+		DisplayName: u.DisplayName,
+		PhoneNumber: u.PhoneNumber,
+		Bio:         u.Bio,
+		AccountType: u.AccountType,
+		Images:      u.Images,
+		Link:        u.Link,
+		CreatedAt:   timestamppb.New(u.CreatedAt),
+		UpdatedAt:   timestamppb.New(u.UpdatedAt),
+	}
+}
+
+func toUser(u model.User) *userv1.User {
+	return &userv1.User{
+		Id:          u.ID.String(),
+		Email:       u.Email,
+		FullName:    u.FullName,
+		DisplayName: u.DisplayName,
+		PhoneNumber: u.PhoneNumber,
+		Bio:         u.Bio,
+		AccountType: u.AccountType,
+		Images:      u.Images,
+		Link:        u.Link,
+		CreatedAt:   timestamppb.New(u.CreatedAt),
+		UpdatedAt:   timestamppb.New(u.UpdatedAt),
+	}
+}
@@ -0,0 +1,163 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/pb/userv1"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/service"
+	"ms-user/pkg/servicetoken"
+)
+
+func TestUserViews(t *testing.T) {
+	ctx := context.Background()
+	r := repo.NewMemoryRepo()
+	owner := &model.User{Email: "owner@example.com", FullName: "Owner", PhoneNumber: "+84912345678", AccountType: "personal"}
+	other := &model.User{Email: "other@example.com", FullName: "Other", AccountType: "personal"}
+	admin := &model.User{Email: "admin@example.com", FullName: "Admin", AccountType: model.AccountTypeAdmin}
+	for _, u := range []*model.User{owner, other, admin} {
+		if err := r.CreateUser(ctx, u, nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	s := &userServer{users: service.NewUserService(r, nil, nil)}
+
+	for _, tc := range []struct {
+		name   string
+		viewer *model.User
+		full   bool
+	}{
+		{"owner", owner, true},
+		{"other user", other, false},
+		{"admin", admin, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.GetUser(audit.WithActor(ctx, tc.viewer.ID), &userv1.GetUserRequest{Id: owner.ID.String()})
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			if got.User.Id != owner.ID.String() || got.User.FullName != "Owner" {
+				t.Errorf("user = %v, want the profile of the owner", got.User)
+			}
+			shown := got.User.Email != "" || got.User.PhoneNumber != "" || got.User.AccountType != "" || got.User.CreatedAt != nil
+			if shown != tc.full {
+				t.Errorf("user = %v, email, phone, account type and dates shown = %v, want %v", got.User, shown, tc.full)
+			}
+		})
+	}
+}
+
+// TestBatchGetUsersNeedsAServiceToken calls BatchGetUsers through authInterceptor: like the internal REST route,
+// only the services allowed on "POST /internal/users/batch-get" get the users, and they get their summary
+func TestBatchGetUsersNeedsAServiceToken(t *testing.T) {
+	ctx := context.Background()
+	r := repo.NewMemoryRepo()
+	owner := &model.User{Email: "owner@example.com", FullName: "Owner", PhoneNumber: "+84912345678", AccountType: "personal"}
+	if err := r.CreateUser(ctx, owner, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	users := service.NewUserService(r, nil, nil)
+	s := &userServer{users: users}
+	allowed := conf.InternalService{Name: "billing", Secret: strings.Repeat("b", 32), Endpoints: []string{"POST /internal/users/batch-get"}}
+	other := conf.InternalService{Name: "search", Secret: strings.Repeat("s", 32), Endpoints: []string{"POST /internal/migrate"}}
+	interceptor := authInterceptor(users, service.NewInternalAuthService(r, []conf.InternalService{allowed, other}))
+
+	sign := func(svc conf.InternalService) string {
+		token, err := servicetoken.Sign(svc.Name, svc.Secret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: userv1.UserService_BatchGetUsers_FullMethodName}
+	req := &userv1.BatchGetUsersRequest{Ids: []string{owner.ID.String()}}
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"no token", "", codes.Unauthenticated},
+		{"not a service token", "not-a-service-token", codes.Unauthenticated},
+		{"service not allowed", sign(other), codes.PermissionDenied},
+		{"allowed service", sign(allowed), codes.OK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := ctx
+			if tc.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tc.token))
+			}
+			rs, err := interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return s.BatchGetUsers(ctx, req.(*userv1.BatchGetUsersRequest))
+			})
+			if got := status.Code(err); got != tc.want {
+				t.Fatalf("BatchGetUsers: code %v, want %v: %v", got, tc.want, err)
+			}
+			if err != nil {
+				return
+			}
+			batch := rs.(*userv1.BatchGetUsersResponse)
+			if len(batch.Users) != 1 || batch.Users[0].Email != owner.Email || batch.Users[0].PhoneNumber != owner.PhoneNumber || batch.Users[0].CreatedAt == nil {
+				t.Errorf("BatchGetUsers = %v, want the summary of the owner", batch)
+			}
+		})
+	}
+}
+
+// TestVerifyTokenNamesTheImpersonator verifies the token of a user and an impersonation token of that user,
+// only the latter names its admin and impersonation
+func TestVerifyTokenNamesTheImpersonator(t *testing.T) {
+	if err := conf.SetEnv(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r := repo.NewMemoryRepo()
+	users := service.NewUserService(r, nil, nil)
+	s := &userServer{users: users}
+	admin := &model.User{Email: "admin@example.com", FullName: "Admin", AccountType: model.AccountTypeAdmin}
+	if err := r.CreateUser(ctx, admin, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	email, password := "owner@example.com", "Passw0rd!grpc"
+	owner, err := users.CreateUser(ctx, model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	session, err := users.Login(ctx, model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	imp, err := users.Impersonate(ctx, admin.ID, owner.ID, model.ImpersonateReq{Reason: "ticket 42"})
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name                            string
+		token                           string
+		impersonatorID, impersonationID string
+	}{
+		{"token of the user", session.Token, "", ""},
+		{"impersonation token", imp.Token, admin.ID.String(), imp.ID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := s.VerifyToken(ctx, &userv1.VerifyTokenRequest{Token: tc.token})
+			if err != nil {
+				t.Fatalf("VerifyToken: %v", err)
+			}
+			if rs.UserId != owner.ID.String() || rs.ImpersonatorId != tc.impersonatorID || rs.ImpersonationId != tc.impersonationID {
+				t.Errorf("VerifyToken = %v, want the owner with impersonator %q and impersonation %q", rs, tc.impersonatorID, tc.impersonationID)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// Keys of the gin context set by VerifyTokenHandler when the request comes with an API key
+const (
+	apiKeyIDKey     = "x-api-key-id"
+	apiKeyScopesKey = "x-api-key-scopes"
+)
+
+// apiKeyAllows tells whether the scopes of key open a route of method, the admin scope is checked by RequireAdmin
+func apiKeyAllows(key model.APIKey, method string) bool {
+	if key.HasScope(model.APIKeyScopeWrite) {
+		return true
+	}
+	read := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	return read && key.HasScope(model.APIKeyScopeRead)
+}
+
+// keyOwner returns the signed in user managing its API keys; a request made with an API key is refused,
+// so a leaked key can not make others
+func keyOwner(r *ginext.Request, tag string) (uuid.UUID, error) {
+	log := tracing.WithCtx(r.GinCtx, tag)
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return userID, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+	if r.GinCtx.GetString(apiKeyIDKey) != "" {
+		log.Error("error_403: API keys are managed with an access token only")
+		return userID, ginext.NewError(http.StatusForbidden, "API keys can not manage API keys, sign in instead")
+	}
+	return userID, nil
+}
+
+// CreateMyAPIKey makes an API key of the signed in user, the answer holds the key which is not shown again
+func (h *UserHandlers) CreateMyAPIKey(r *ginext.Request) (*ginext.Response, error) {
+	userID, err := keyOwner(r, "UserHandlers.CreateMyAPIKey")
+	if err != nil {
+		return nil, err
+	}
+
+	req := model.CreateAPIKeyReq{}
+	if err = r.GinCtx.ShouldBindJSON(&req); err != nil {
+		tracing.WithCtx(r.GinCtx, "UserHandlers.CreateMyAPIKey").WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.CreateAPIKey(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// ListMyAPIKeys lists the API keys of the signed in user, without the keys themselves
+func (h *UserHandlers) ListMyAPIKeys(r *ginext.Request) (*ginext.Response, error) {
+	userID, err := keyOwner(r, "UserHandlers.ListMyAPIKeys")
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := h.service.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		rs = []model.APIKey{}
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *UserHandlers) RevokeMyAPIKey(r *ginext.Request) (*ginext.Response, error) {
+	userID, err := keyOwner(r, "UserHandlers.RevokeMyAPIKey")
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(r.GinCtx.Param("id"))
+	if err != nil {
+		tracing.WithCtx(r.GinCtx, "UserHandlers.RevokeMyAPIKey").WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: id must be a UUID")
+	}
+	if err = h.service.RevokeAPIKey(r.Context(), userID, id); err != nil {
+		return nil, err
+	}
+	return ginext.NewResponse(http.StatusNoContent), nil
+}
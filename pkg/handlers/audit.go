@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"ms-user/pkg/model"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/ginext"
+)
+
+type AuditHandlers struct {
+	service service.AuditInterface
+}
+
+func NewAuditHandlers(service service.AuditInterface) *AuditHandlers {
+	return &AuditHandlers{service: service}
+}
+
+// ListEvents lists audit events filtered by actor_id, subject_id, type, from and to
+func (h *AuditHandlers) ListEvents(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "AuditHandlers.ListEvents")
+
+	filter := model.AuditFilter{}
+	if err := r.GinCtx.ShouldBindQuery(&filter); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, meta, err := h.service.ListEvents(r.Context(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{
+		Code: http.StatusOK,
+		GeneralBody: &ginext.GeneralBody{
+			Data: rs,
+			Meta: meta,
+		},
+	}, nil
+}
+
+// ExportEvents streams the events matching the ListEvents filters as JSON Lines
+func (h *AuditHandlers) ExportEvents(c *gin.Context) {
+	log := tracing.WithCtx(c, "AuditHandlers.ExportEvents")
+
+	filter := model.AuditFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		_ = c.Error(ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error()))
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit_events.jsonl"`)
+	if err := h.service.ExportEvents(ginext.FromGinRequestContext(c), filter, c.Writer); err != nil {
+		// headers are gone once the first line is written, the error can only be logged
+		log.WithError(err).Error("error_500: failed to export audit events")
+		if !c.Writer.Written() {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// VerifyChain checks the hash chain of the whole audit log
+func (h *AuditHandlers) VerifyChain(r *ginext.Request) (*ginext.Response, error) {
+	rs, err := h.service.VerifyChain(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{
+		Code: http.StatusOK,
+		GeneralBody: &ginext.GeneralBody{
+			Data: rs,
+		},
+	}, nil
+}
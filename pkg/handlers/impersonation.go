@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// Keys of the gin context set by VerifyTokenHandler when the request comes with an impersonation token
+const (
+	impersonatorIDKey  = "x-impersonator-id"
+	impersonationIDKey = "x-impersonation-id"
+)
+
+// Impersonate issues to the signed in admin a short-lived token of the user of the path, it needs an access token
+func (h *UserHandlers) Impersonate(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.Impersonate")
+
+	adminID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+	if r.GinCtx.GetString(apiKeyIDKey) != "" {
+		log.Error("error_403: impersonation with an API key")
+		return nil, ginext.NewError(http.StatusForbidden, "API keys can not impersonate users, sign in instead")
+	}
+	userID, err := uuid.Parse(r.GinCtx.Param("id"))
+	if err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: id must be a UUID")
+	}
+	req := model.ImpersonateReq{}
+	if err = r.GinCtx.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.Impersonate(r.Context(), adminID, userID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// RefuseImpersonation guards the sensitive operations on an account (its sign-in factors, API keys
+// and grants to OAuth clients) from the admins impersonating the user, it runs after VerifyTokenHandler
+func (h *UserHandlers) RefuseImpersonation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetString(impersonatorIDKey) != "" {
+			tracing.WithCtx(ctx, "UserHandlers.RefuseImpersonation").Error("error_403: sensitive operation refused to an impersonation token")
+			_ = ctx.Error(ginext.NewError(http.StatusForbidden, "This operation is not allowed while impersonating a user"))
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// responseStatus is the status of the response of c after the handlers ran, the error handler of ginext
+// writes the errors later
+func responseStatus(c *gin.Context) int {
+	if c.Writer.Written() || len(c.Errors) == 0 {
+		return c.Writer.Status()
+	}
+	if apiErr, ok := c.Errors.Last().Err.(ginext.ApiError); ok {
+		return apiErr.Code()
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+// internalServiceKey is the key of the gin context holding the service calling an /internal route
+const internalServiceKey = "x-internal-service"
+
+type InternalAuthHandlers struct {
+	service service.InternalAuthInterface
+}
+
+func NewInternalAuthHandlers(service service.InternalAuthInterface) *InternalAuthHandlers {
+	return &InternalAuthHandlers{service: service}
+}
+
+// RequireService lets through the services of INTERNAL_SERVICES allowed on the route,
+// they send a service token as bearer token
+func (h *InternalAuthHandlers) RequireService() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, err := h.service.Authenticate(ginext.FromGinRequestContext(c), bearerToken(c), c.Request.Method+" "+c.FullPath())
+		if err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Set(internalServiceKey, name)
+		tracing.WithCtx(c, "InternalAuthHandlers.RequireService").WithField("service", name).Info("internal call")
+		c.Next()
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/praslar/lib/common"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+)
+
+// magicLinkCookie holds the nonce binding a magic link to the browser that asked for it
+const (
+	magicLinkCookie     = "ms_user_magic_link"
+	magicLinkCookiePath = "/api/v1/user/login/magic-link"
+)
+
+// RequestMagicLink emails a sign-in link and sets the nonce cookie the link needs
+func (h *UserHandlers) RequestMagicLink(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.RequestMagicLink")
+
+	req := model.MagicLinkReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, nonce, err := h.service.RequestMagicLink(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+	setSignInCookie(r.GinCtx, magicLinkCookie, magicLinkCookiePath, nonce, time.Until(rs.ExpiresAt))
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// ConsumeMagicLink exchanges the token of an emailed link for the tokens of the account
+func (h *UserHandlers) ConsumeMagicLink(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.ConsumeMagicLink")
+
+	req := model.ConsumeMagicLinkReq{}
+	r.MustBind(&req)
+	if req.Token == "" {
+		log.Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: token is required")
+	}
+	nonce, _ := r.GinCtx.Cookie(magicLinkCookie)
+
+	rs, err := h.service.ConsumeMagicLink(r.Context(), req, nonce)
+	if err != nil {
+		return nil, err
+	}
+	setSignInCookie(r.GinCtx, magicLinkCookie, magicLinkCookiePath, "", -1)
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// setSignInCookie sets a cookie of a sign-in flow for maxAge, a negative maxAge deletes the cookie.
+// SameSite Lax lets the browser send it when a link of a mail client or a redirect of a provider is followed.
+func setSignInCookie(c *gin.Context, name, path, value string, maxAge time.Duration) {
+	seconds := int(maxAge / time.Second)
+	if maxAge < 0 {
+		seconds = -1
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   seconds,
+		Secure:   conf.LoadEnv().CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
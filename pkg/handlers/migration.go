@@ -1,12 +1,24 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/ginext"
 	"gorm.io/gorm"
 )
 
+// emailIndex and phoneIndex make the normalized email and the verified phone of the accounts that are not deleted unique
+const (
+	emailIndex = "idx_users_email_normalized"
+	phoneIndex = "idx_users_phone_e164"
+)
+
 type MigrationHandler struct {
 	db *gorm.DB
 }
@@ -16,18 +28,155 @@ func NewMigrationHandler(db *gorm.DB) *MigrationHandler {
 }
 
 func (h *MigrationHandler) Migrate(ctx *gin.Context) {
+	if err := h.MigrateDB(); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+}
+
+// MigrateDB creates or updates every table, it adapts the schema to the dialect of h.db
+func (h *MigrationHandler) MigrateDB() error {
+	dialect := h.db.Dialector.Name()
+	if dialect == repo.DriverPostgres {
+		_ = h.db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
+	}
+
+	for _, m := range migrationModels() {
+		if dialect == repo.DriverSQLite {
+			if err := h.dropPostgresDefaults(m); err != nil {
+				return err
+			}
+		}
+		if err := h.db.AutoMigrate(m); err != nil {
+			return err
+		}
+	}
+	if err := h.migrateEmailIdentity(); err != nil {
+		return err
+	}
+	return h.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + phoneIndex +
+		" ON users (phone_e164) WHERE deleted_at IS NULL").Error
+}
+
+// migrateEmailIdentity fills users.email_normalized and creates its unique index.
+// It fails with a 409 naming the emails when accounts created before the index share one.
+func (h *MigrationHandler) migrateEmailIdentity() error {
+	// accounts without an email keep a NULL, which the unique index ignores
+	var users []model.User
+	err := h.db.Unscoped().Model(&model.User{}).Select("id", "email").
+		Where("(email_normalized IS NULL AND email <> '') OR email_normalized = ''").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		err = h.db.Unscoped().Model(&model.User{}).Where("id = ?", u.ID).
+			UpdateColumn("email_normalized", model.NormalizedEmailOf(u.Email)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var conflicts []string
+	err = h.db.Raw("SELECT email_normalized FROM users WHERE deleted_at IS NULL AND email_normalized IS NOT NULL " +
+		"GROUP BY email_normalized HAVING COUNT(*) > 1 ORDER BY email_normalized").Scan(&conflicts).Error
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		shown := conflicts
+		if len(shown) > 20 {
+			shown = shown[:20]
+		}
+		return ginext.NewError(http.StatusConflict, fmt.Sprintf(
+			"%d emails are used by several accounts, merge or delete them before migrating: %s",
+			len(conflicts), strings.Join(shown, ", ")))
+	}
 
-	_ = h.db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
+	return h.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + emailIndex +
+		" ON users (email_normalized) WHERE deleted_at IS NULL").Error
+}
 
-	models := []interface{}{
+// PendingMigrations lists the tables and columns that MigrateDB would still have to create
+func (h *MigrationHandler) PendingMigrations(ctx context.Context) ([]string, error) {
+	db := h.db.WithContext(ctx)
+	var pending []string
+	for _, m := range migrationModels() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, err
+		}
+		migrator := db.Migrator()
+		if !migrator.HasTable(m) {
+			pending = append(pending, "table "+stmt.Schema.Table)
+			continue
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName != "" && !migrator.HasColumn(m, f.DBName) {
+				pending = append(pending, "column "+stmt.Schema.Table+"."+f.DBName)
+			}
+		}
+	}
+	if db.Migrator().HasTable(&model.User{}) {
+		for _, index := range []string{emailIndex, phoneIndex} {
+			if !db.Migrator().HasIndex(&model.User{}, index) {
+				pending = append(pending, "index users."+index)
+			}
+		}
+	}
+	return pending, ctx.Err()
+}
+
+// Tables lists the tables that MigrateDB creates
+func (h *MigrationHandler) Tables() ([]string, error) {
+	tables := make([]string, 0, len(migrationModels()))
+	for _, m := range migrationModels() {
+		stmt := &gorm.Statement{DB: h.db}
+		if err := stmt.Parse(m); err != nil {
+			return nil, err
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	return tables, nil
+}
+
+func migrationModels() []interface{} {
+	return []interface{}{
 		&model.User{},
 		&model.RefreshToken{},
+		&model.AuditEvent{},
+		&model.PendingAuditEvent{},
+		&model.LoginHistory{},
+		&model.OutboxMessage{},
+		&model.PhoneOTP{},
+		&model.MagicLink{},
+		&model.UserIdentity{},
+		&model.OIDCAuthRequest{},
+		&model.OAuthClient{},
+		&model.OAuthAuthorizationCode{},
+		&model.OAuthConsent{},
+		&model.SAMLConnection{},
+		&model.SAMLAuthRequest{},
+		&model.SCIMToken{},
+		&model.SCIMUser{},
+		&model.SCIMGroup{},
+		&model.SCIMGroupMember{},
+		&model.APIKey{},
 	}
-	for _, m := range models {
-		err := h.db.AutoMigrate(m)
-		if err != nil {
-			_ = ctx.Error(err)
-			return
+}
+
+// dropPostgresDefaults removes uuid_generate_v4() column defaults from the cached schema of m,
+// IDs are generated by model.BaseModel.BeforeCreate instead
+func (h *MigrationHandler) dropPostgresDefaults(m interface{}) error {
+	stmt := &gorm.Statement{DB: h.db}
+	if err := stmt.Parse(m); err != nil {
+		return err
+	}
+	for _, f := range stmt.Schema.Fields {
+		if strings.Contains(f.DefaultValue, "uuid_generate_v4") {
+			f.HasDefaultValue = false
+			f.DefaultValue = ""
+			f.DefaultValueInterface = nil
 		}
 	}
+	return nil
 }
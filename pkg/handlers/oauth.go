@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// OAuthHandlers serve the authorization server. The protocol endpoints answer the formats of
+// RFC 6749 and OpenID Connect, the endpoints of the consent page and of the admins the ginext body.
+type OAuthHandlers struct {
+	service service.OAuthInterface
+}
+
+func NewOAuthHandlers(service service.OAuthInterface) *OAuthHandlers {
+	return &OAuthHandlers{service: service}
+}
+
+func (h *OAuthHandlers) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.Discovery())
+}
+
+func (h *OAuthHandlers) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.JWKS())
+}
+
+// Authorize sends the browser of an authorization request to the consent page, or back to the client on error
+func (h *OAuthHandlers) Authorize(c *gin.Context) {
+	log := tracing.WithCtx(c, "OAuthHandlers.Authorize")
+
+	req := model.OAuthAuthorizeReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		_ = c.Error(ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error()))
+		return
+	}
+	redirectTo, err := h.service.Authorize(ginext.FromGinRequestContext(c), req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Redirect(http.StatusFound, redirectTo)
+}
+
+// AuthorizeInfo describes an authorization request to the consent page of the signed in user
+func (h *OAuthHandlers) AuthorizeInfo(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "OAuthHandlers.AuthorizeInfo")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+	req := model.OAuthAuthorizeReq{}
+	if err = r.GinCtx.ShouldBindQuery(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.AuthorizeInfo(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// Decide takes the answer of the signed in user, the consent page then sends the browser to redirect_to
+func (h *OAuthHandlers) Decide(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "OAuthHandlers.Decide")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+	req := model.OAuthDecisionReq{}
+	if err = r.GinCtx.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.Decide(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// Token is the token endpoint, clients authenticate with HTTP Basic or client_id and client_secret in the form
+func (h *OAuthHandlers) Token(c *gin.Context) {
+	log := tracing.WithCtx(c, "OAuthHandlers.Token")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	req := model.OAuthTokenReq{}
+	if err := c.ShouldBindWith(&req, binding.FormPost); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		writeOAuthError(c, oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "The body must be a form"), false)
+		return
+	}
+	id, secret, basic := c.Request.BasicAuth()
+	if basic {
+		if req.ClientSecret != "" {
+			writeOAuthError(c, oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "Use a single client authentication method"), false)
+			return
+		}
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic
+		req.ClientID, _ = url.QueryUnescape(id)
+		req.ClientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	rs, err := h.service.Token(ginext.FromGinRequestContext(c), req)
+	if err != nil {
+		writeOAuthError(c, err, basic)
+		return
+	}
+	c.JSON(http.StatusOK, rs)
+}
+
+// UserInfo returns the claims of the user of a bearer access token
+func (h *OAuthHandlers) UserInfo(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	token := bearerToken(c)
+	if token == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="ms-user"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	rs, err := h.service.UserInfo(ginext.FromGinRequestContext(c), token)
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		c.Header("WWW-Authenticate", `Bearer realm="ms-user", error="`+oauthErr.Code+`"`)
+		c.AbortWithStatusJSON(oauthErr.Status, oauthErr)
+		return
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, rs)
+}
+
+// writeOAuthError answers err as an OAuth error, a failure of ms-user itself is a server_error
+func writeOAuthError(c *gin.Context, err error, basic bool) {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		tracing.WithCtx(c, "OAuthHandlers.writeOAuthError").WithError(err).Error("error_500: token endpoint failed")
+		oauthErr = oauth.NewError(http.StatusInternalServerError, oauth.ErrServerError, "")
+	}
+	if oauthErr.Code == oauth.ErrInvalidClient && basic {
+		c.Header("WWW-Authenticate", `Basic realm="ms-user"`)
+	}
+	c.AbortWithStatusJSON(oauthErr.Status, oauthErr)
+}
+
+// bearerToken returns the token of the Authorization header, "" without one
+func bearerToken(c *gin.Context) string {
+	if bearer := c.GetHeader("Authorization"); len(bearer) > 7 && strings.EqualFold(bearer[:7], "Bearer ") {
+		return strings.TrimSpace(bearer[7:])
+	}
+	return ""
+}
+
+// CreateClient registers an OAuth client, the answer holds its secret which is not shown again
+func (h *OAuthHandlers) CreateClient(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "OAuthHandlers.CreateClient")
+
+	req := model.CreateOAuthClientReq{}
+	if err := r.GinCtx.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.CreateClient(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *OAuthHandlers) ListClients(r *ginext.Request) (*ginext.Response, error) {
+	rs, err := h.service.ListClients(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// DeleteClient deletes a client with the consents given to it and its refresh tokens
+func (h *OAuthHandlers) DeleteClient(r *ginext.Request) (*ginext.Response, error) {
+	if err := h.service.DeleteClient(r.Context(), r.GinCtx.Param("client_id")); err != nil {
+		return nil, err
+	}
+	return ginext.NewResponse(http.StatusNoContent), nil
+}
+
+// ListMyConsents lists the clients the signed in user granted access to
+func (h *OAuthHandlers) ListMyConsents(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "OAuthHandlers.ListMyConsents")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+
+	rs, err := h.service.ListConsents(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// RevokeMyConsent withdraws the access of a client to the signed in user
+func (h *OAuthHandlers) RevokeMyConsent(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "OAuthHandlers.RevokeMyConsent")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+
+	if err = h.service.RevokeConsent(r.Context(), userID, r.GinCtx.Param("client_id")); err != nil {
+		return nil, err
+	}
+	return ginext.NewResponse(http.StatusNoContent), nil
+}
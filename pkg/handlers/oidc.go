@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+// oidcStateCookie holds the state binding the callback of a provider to the browser that started the sign-in
+const oidcStateCookie = "ms_user_oidc_state"
+
+// StartOIDCLogin redirects the browser to the provider of the path
+func (h *UserHandlers) StartOIDCLogin(c *gin.Context) {
+	log := tracing.WithCtx(c, "UserHandlers.StartOIDCLogin")
+
+	req := model.StartOIDCLoginReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		_ = c.Error(ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error()))
+		return
+	}
+
+	authURL, state, err := h.service.StartOIDCLogin(ginext.FromGinRequestContext(c), c.Param("provider"), req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	ttl := time.Duration(conf.LoadEnv().OIDCStateTTLSeconds) * time.Second
+	setSignInCookie(c, oidcStateCookie, service.OIDCLoginPath, state, ttl)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback signs in with the answer of the provider and returns the tokens of the account
+func (h *UserHandlers) OIDCCallback(r *ginext.Request) (*ginext.Response, error) {
+	req := model.OIDCCallbackReq{}
+	r.MustBind(&req)
+	state, _ := r.GinCtx.Cookie(oidcStateCookie)
+
+	rs, err := h.service.FinishOIDCLogin(r.Context(), r.GinCtx.Param("provider"), req, state)
+	if err != nil {
+		return nil, err
+	}
+	setSignInCookie(r.GinCtx, oidcStateCookie, service.OIDCLoginPath, "", -1)
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
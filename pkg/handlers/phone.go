@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/praslar/lib/common"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// RequestPhoneLoginCode sends a sign-in code by SMS
+func (h *UserHandlers) RequestPhoneLoginCode(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.RequestPhoneLoginCode")
+
+	req := model.PhoneOTPReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.RequestPhoneLoginCode(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// LoginWithPhone signs in with the code sent by RequestPhoneLoginCode
+func (h *UserHandlers) LoginWithPhone(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.LoginWithPhone")
+
+	req := model.PhoneLoginReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.LoginWithPhone(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// RequestPhoneVerificationCode sends a code to the phone number the current user wants to add
+func (h *UserHandlers) RequestPhoneVerificationCode(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.RequestPhoneVerificationCode")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+
+	req := model.PhoneOTPReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.RequestPhoneVerificationCode(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// VerifyPhone confirms the code of RequestPhoneVerificationCode, the phone number can then be used to sign in
+func (h *UserHandlers) VerifyPhone(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.VerifyPhone")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+
+	req := model.PhoneVerifyReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.VerifyPhone(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: model.NewSelfProfile(rs),
+	}}, nil
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+// samlStateCookie holds the relay state binding the Response of an identity provider to the browser that started the sign-in
+const samlStateCookie = "ms_user_saml_state"
+
+func businessIDParam(c *gin.Context) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Param("business_id"))
+	if err != nil {
+		tracing.WithCtx(c, "handlers.businessIDParam").WithError(err).Error("error_400: Invalid input")
+		return id, ginext.NewError(http.StatusBadRequest, "Invalid input: business_id must be a UUID")
+	}
+	return id, nil
+}
+
+// setSAMLStateCookie sets the relay state cookie, a negative maxAge deletes it.
+// The Response is posted to ms-user from the site of the identity provider, a SameSite Lax cookie would not be sent with it:
+// the cookie is SameSite None, which browsers only accept on a secure cookie. Without COOKIE_SECURE, locally, it stays Lax.
+func setSAMLStateCookie(c *gin.Context, value string, maxAge time.Duration) {
+	seconds := int(maxAge / time.Second)
+	if maxAge < 0 {
+		seconds = -1
+	}
+	secure := conf.LoadEnv().CookieSecure
+	sameSite := http.SameSiteLaxMode
+	if secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     samlStateCookie,
+		Value:    value,
+		Path:     service.SAMLLoginPath,
+		MaxAge:   seconds,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// StartSAMLLogin redirects the browser to the identity provider of the business with an AuthnRequest
+func (h *UserHandlers) StartSAMLLogin(c *gin.Context) {
+	log := tracing.WithCtx(c, "UserHandlers.StartSAMLLogin")
+
+	businessID, err := businessIDParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	req := model.StartSAMLLoginReq{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		_ = c.Error(ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error()))
+		return
+	}
+
+	authURL, relayState, err := h.service.StartSAMLLogin(ginext.FromGinRequestContext(c), businessID, req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	setSAMLStateCookie(c, relayState, time.Duration(conf.LoadEnv().SAMLStateTTLSeconds)*time.Second)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// SAMLACS is the assertion consumer service: it signs in with the Response of the identity provider
+// and returns the tokens of the account
+func (h *UserHandlers) SAMLACS(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.SAMLACS")
+
+	businessID, err := businessIDParam(r.GinCtx)
+	if err != nil {
+		return nil, err
+	}
+	req := model.SAMLACSReq{}
+	if err := r.GinCtx.ShouldBind(&req); err != nil || req.SAMLResponse == "" {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: SAMLResponse is required")
+	}
+	relayState, _ := r.GinCtx.Cookie(samlStateCookie)
+
+	rs, err := h.service.FinishSAMLLogin(r.Context(), businessID, req, relayState)
+	if err != nil {
+		return nil, err
+	}
+	setSAMLStateCookie(r.GinCtx, "", -1)
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// SAMLMetadata serves the SP metadata document of the business, for its identity provider
+func (h *UserHandlers) SAMLMetadata(c *gin.Context) {
+	businessID, err := businessIDParam(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	rs, err := h.service.SAMLMetadata(ginext.FromGinRequestContext(c), businessID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", rs)
+}
+
+// SAMLHandlers serve the admin API configuring the SAML identity providers of the businesses
+type SAMLHandlers struct {
+	service service.SAMLInterface
+}
+
+func NewSAMLHandlers(service service.SAMLInterface) *SAMLHandlers {
+	return &SAMLHandlers{service: service}
+}
+
+// SaveConnection creates or replaces the SAML connection of a business
+func (h *SAMLHandlers) SaveConnection(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "SAMLHandlers.SaveConnection")
+
+	businessID, err := businessIDParam(r.GinCtx)
+	if err != nil {
+		return nil, err
+	}
+	req := model.SaveSAMLConnectionReq{}
+	if err := r.GinCtx.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.SaveConnection(r.Context(), businessID, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *SAMLHandlers) GetConnection(r *ginext.Request) (*ginext.Response, error) {
+	businessID, err := businessIDParam(r.GinCtx)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := h.service.GetConnection(r.Context(), businessID)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *SAMLHandlers) ListConnections(r *ginext.Request) (*ginext.Response, error) {
+	rs, err := h.service.ListConnections(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// DeleteConnection deletes the SAML connection of a business and unlinks the identities it signed in
+func (h *SAMLHandlers) DeleteConnection(r *ginext.Request) (*ginext.Response, error) {
+	businessID, err := businessIDParam(r.GinCtx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.service.DeleteConnection(r.Context(), businessID); err != nil {
+		return nil, err
+	}
+	return ginext.NewResponse(http.StatusNoContent), nil
+}
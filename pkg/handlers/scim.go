@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/scim"
+	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
+)
+
+// maxSCIMBody bounds the body of a SCIM request, a group with thousands of members fits
+const maxSCIMBody = 1 << 20
+
+// scimBusinessKey is the key of the gin context holding the business of the SCIM token
+const scimBusinessKey = "x-scim-business-id"
+
+// SCIMHandlers serve the SCIM endpoints, which answer the SCIM JSON of RFC 7644,
+// and the admin endpoints of the SCIM tokens, which answer the ginext body.
+type SCIMHandlers struct {
+	service service.SCIMInterface
+}
+
+func NewSCIMHandlers(service service.SCIMInterface) *SCIMHandlers {
+	return &SCIMHandlers{service: service}
+}
+
+// CreateToken makes a SCIM token for a business, the answer holds the token which is not shown again
+func (h *SCIMHandlers) CreateToken(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "SCIMHandlers.CreateToken")
+
+	req := model.CreateSCIMTokenReq{}
+	if err := r.GinCtx.ShouldBindJSON(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.CreateToken(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *SCIMHandlers) ListTokens(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "SCIMHandlers.ListTokens")
+
+	req := model.ListSCIMTokensReq{}
+	if err := r.GinCtx.ShouldBindQuery(&req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.ListTokens(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+func (h *SCIMHandlers) DeleteToken(r *ginext.Request) (*ginext.Response, error) {
+	id, err := uuid.Parse(r.GinCtx.Param("id"))
+	if err != nil {
+		tracing.WithCtx(r.GinCtx, "SCIMHandlers.DeleteToken").WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: id must be a UUID")
+	}
+	if err = h.service.DeleteToken(r.Context(), id); err != nil {
+		return nil, err
+	}
+	return ginext.NewResponse(http.StatusNoContent), nil
+}
+
+// Authenticate lets through the requests with the SCIM token of a business, whose id goes in the context
+func (h *SCIMHandlers) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			writeSCIMError(c, scim.NewError(http.StatusUnauthorized, "", "a SCIM bearer token is required"))
+			return
+		}
+		businessID, err := h.service.Authenticate(ginext.FromGinRequestContext(c), token)
+		if err != nil {
+			tracing.WithCtx(c, "SCIMHandlers.Authenticate").WithError(err).Error("error_401: invalid SCIM token")
+			writeSCIMError(c, err)
+			return
+		}
+		c.Set(scimBusinessKey, businessID)
+		c.Next()
+	}
+}
+
+// ServiceProviderConfig describes the SCIM features supported, it needs no token
+func (h *SCIMHandlers) ServiceProviderConfig(c *gin.Context) {
+	writeSCIM(c, http.StatusOK, scim.NewServiceProviderConfig(service.SCIMMaxResults))
+}
+
+func (h *SCIMHandlers) ListUsers(c *gin.Context) {
+	req, ok := scimListReq(c)
+	if !ok {
+		return
+	}
+	rs, err := h.service.ListUsers(ginext.FromGinRequestContext(c), scimBusiness(c), req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) GetUser(c *gin.Context) {
+	id, ok := scimID(c, "User")
+	if !ok {
+		return
+	}
+	rs, err := h.service.GetUser(ginext.FromGinRequestContext(c), scimBusiness(c), id)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) CreateUser(c *gin.Context) {
+	req := scim.User{}
+	if !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.CreateUser(ginext.FromGinRequestContext(c), scimBusiness(c), req)
+	writeSCIMResult(c, http.StatusCreated, rs, err)
+}
+
+func (h *SCIMHandlers) ReplaceUser(c *gin.Context) {
+	id, ok := scimID(c, "User")
+	req := scim.User{}
+	if !ok || !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.ReplaceUser(ginext.FromGinRequestContext(c), scimBusiness(c), id, req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) PatchUser(c *gin.Context) {
+	id, ok := scimID(c, "User")
+	req := scim.PatchRequest{}
+	if !ok || !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.PatchUser(ginext.FromGinRequestContext(c), scimBusiness(c), id, req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+// DeleteUser deprovisions a user: it is deactivated and its sessions are revoked
+func (h *SCIMHandlers) DeleteUser(c *gin.Context) {
+	id, ok := scimID(c, "User")
+	if !ok {
+		return
+	}
+	err := h.service.DeleteUser(ginext.FromGinRequestContext(c), scimBusiness(c), id)
+	writeSCIMResult(c, http.StatusNoContent, nil, err)
+}
+
+func (h *SCIMHandlers) ListGroups(c *gin.Context) {
+	req, ok := scimListReq(c)
+	if !ok {
+		return
+	}
+	rs, err := h.service.ListGroups(ginext.FromGinRequestContext(c), scimBusiness(c), req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) GetGroup(c *gin.Context) {
+	id, ok := scimID(c, "Group")
+	if !ok {
+		return
+	}
+	rs, err := h.service.GetGroup(ginext.FromGinRequestContext(c), scimBusiness(c), id)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) CreateGroup(c *gin.Context) {
+	req := scim.Group{}
+	if !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.CreateGroup(ginext.FromGinRequestContext(c), scimBusiness(c), req)
+	writeSCIMResult(c, http.StatusCreated, rs, err)
+}
+
+func (h *SCIMHandlers) ReplaceGroup(c *gin.Context) {
+	id, ok := scimID(c, "Group")
+	req := scim.Group{}
+	if !ok || !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.ReplaceGroup(ginext.FromGinRequestContext(c), scimBusiness(c), id, req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) PatchGroup(c *gin.Context) {
+	id, ok := scimID(c, "Group")
+	req := scim.PatchRequest{}
+	if !ok || !decodeSCIM(c, &req) {
+		return
+	}
+	rs, err := h.service.PatchGroup(ginext.FromGinRequestContext(c), scimBusiness(c), id, req)
+	writeSCIMResult(c, http.StatusOK, rs, err)
+}
+
+func (h *SCIMHandlers) DeleteGroup(c *gin.Context) {
+	id, ok := scimID(c, "Group")
+	if !ok {
+		return
+	}
+	err := h.service.DeleteGroup(ginext.FromGinRequestContext(c), scimBusiness(c), id)
+	writeSCIMResult(c, http.StatusNoContent, nil, err)
+}
+
+func scimBusiness(c *gin.Context) uuid.UUID {
+	id, _ := c.Get(scimBusinessKey)
+	businessID, _ := id.(uuid.UUID)
+	return businessID
+}
+
+// scimID reads the id of the path, ids are opaque to the clients so a malformed one is an unknown resource
+func scimID(c *gin.Context, resourceType string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		writeSCIMError(c, scim.NewError(http.StatusNotFound, "", resourceType+" "+c.Param("id")+" not found"))
+		return id, false
+	}
+	return id, true
+}
+
+func scimListReq(c *gin.Context) (req model.SCIMListReq, ok bool) {
+	if err := c.ShouldBindQuery(&req); err != nil {
+		tracing.WithCtx(c, "handlers.scimListReq").WithError(err).Error("error_400: Invalid input")
+		writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, "startIndex and count must be integers"))
+		return req, false
+	}
+	return req, true
+}
+
+// decodeSCIM reads the JSON body into v, clients send it as application/scim+json or application/json
+func decodeSCIM(c *gin.Context, v interface{}) bool {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSCIMBody)
+	if err := json.NewDecoder(c.Request.Body).Decode(v); err != nil {
+		tracing.WithCtx(c, "handlers.decodeSCIM").WithError(err).Error("error_400: Invalid input")
+		writeSCIMError(c, scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "the body is not a valid resource: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+func writeSCIM(c *gin.Context, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	c.Data(status, scim.ContentType, b)
+}
+
+// writeSCIMResult answers rs with status, or err as a SCIM error
+func writeSCIMResult(c *gin.Context, status int, rs interface{}, err error) {
+	switch {
+	case err != nil:
+		writeSCIMError(c, err)
+	case status == http.StatusNoContent:
+		c.Status(status)
+	default:
+		writeSCIM(c, status, rs)
+	}
+}
+
+// writeSCIMError answers err as a SCIM error: the errors of the repo keep their status, a 409 is a uniqueness error,
+// a failure of ms-user itself is a 500 without detail
+func writeSCIMError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	var apiErr ginext.ApiError
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.As(err, &apiErr) && apiErr.Code() < http.StatusInternalServerError:
+		scimErr = scim.NewError(apiErr.Code(), "", err.Error())
+		if apiErr.Code() == http.StatusConflict {
+			scimErr.Type = scim.ErrUniqueness
+		}
+	default:
+		tracing.WithCtx(c, "handlers.writeSCIMError").WithError(err).Error("error_500: SCIM request failed")
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "")
+	}
+	if scimErr.Status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Bearer realm="ms-user"`)
+	}
+	b, _ := json.Marshal(scimErr)
+	c.Abort()
+	c.Data(scimErr.Status, scim.ContentType, b)
+}
@@ -2,14 +2,19 @@ package handlers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/praslar/lib/common"
+	"github.com/sirupsen/logrus"
 	"gitlab.com/goxp/cloud0/ginext"
-	"gitlab.com/goxp/cloud0/logger"
+	"go.opentelemetry.io/otel/trace"
+	"ms-user/pkg/audit"
 	"ms-user/pkg/model"
 	"ms-user/pkg/service"
+	"ms-user/pkg/tracing"
 	"ms-user/pkg/utils"
 	"ms-user/pkg/valid"
 	"net/http"
+	"strings"
 )
 
 type UserHandlers struct {
@@ -22,7 +27,7 @@ func NewUserHandlers(service service.UserInterface) *UserHandlers {
 
 // TestMsUser - hieucn - 22/03/2022
 func (h *UserHandlers) TestMsUser(r *ginext.Request) (*ginext.Response, error) {
-	log := logger.WithCtx(r.GinCtx, "UserHandlers.TestMsUser")
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.TestMsUser")
 
 	if err := h.service.TestMsUser(r.Context()); err != nil {
 		return nil, ginext.NewError(http.StatusBadRequest, "Fail to Test ms-user")
@@ -39,7 +44,7 @@ func (h *UserHandlers) TestMsUser(r *ginext.Request) (*ginext.Response, error) {
 
 // 30/3/2022 - hieucn - register user with email, password
 func (h *UserHandlers) CreateUser(r *ginext.Request) (*ginext.Response, error) {
-	log := logger.WithCtx(r.GinCtx, "UserHandlers.CreateUser")
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.CreateUser")
 
 	// Check valid request
 	req := model.CreateUserReq{}
@@ -57,14 +62,14 @@ func (h *UserHandlers) CreateUser(r *ginext.Request) (*ginext.Response, error) {
 	return &ginext.Response{
 		Code: http.StatusOK,
 		GeneralBody: &ginext.GeneralBody{
-			Data: rs,
+			Data: model.NewSelfProfile(rs),
 		},
 	}, nil
 }
 
 // 31/3/2022 - hieucn - login user with email & password
 func (h *UserHandlers) Login(r *ginext.Request) (*ginext.Response, error) {
-	log := logger.WithCtx(r.GinCtx, "UserHandlers.Login")
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.Login")
 
 	// Check valid request
 	req := model.CreateUserReq{}
@@ -87,30 +92,82 @@ func (h *UserHandlers) Login(r *ginext.Request) (*ginext.Response, error) {
 	}, nil
 }
 
+// RefreshToken exchanges a refresh token for a new pair of tokens
+func (h *UserHandlers) RefreshToken(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.RefreshToken")
+
+	req := model.RefreshTokenReq{}
+	r.MustBind(&req)
+	if err := common.CheckRequireValid(req); err != nil {
+		log.WithError(err).Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+	}
+
+	rs, err := h.service.RefreshToken(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ginext.Response{
+		Code: http.StatusOK,
+		GeneralBody: &ginext.GeneralBody{
+			Data: rs,
+		},
+	}, nil
+}
+
 // 05/04/2022 - hieucn - VerifyTokenHandler
 func (h *UserHandlers) VerifyTokenHandler() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		log := logger.WithCtx(ctx, "UserHandlers.VerifyTokenHandler")
+		log := tracing.WithCtx(ctx, "UserHandlers.VerifyTokenHandler")
 
-		req := model.OAuthVerifyRequest{}
-		ctx.ShouldBind(&req)
-		if err := common.CheckRequireValid(req); err != nil {
-			log.WithError(err).Error("error_400: Invalid input")
-			ginext.NewError(http.StatusBadRequest, "Invalid input: "+err.Error())
+		unauthorized := func() {
+			_ = ctx.Error(ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized]))
+			ctx.Abort()
 		}
 
-		// do validate scopes latter
-		claims, err := h.service.ParseAccessToken(req.Token)
-		if err != nil {
-			log.WithField("error", err).Error("parse token error")
-			ginext.NewError(http.StatusBadRequest, utils.MessageError()[http.StatusUnauthorized])
+		// the token comes as a bearer token, or in the Token query parameter for older clients;
+		// only the query is bound, the body is left to the handler
+		req := model.OAuthVerifyRequest{}
+		_ = ctx.ShouldBindQuery(&req)
+		if bearer := ctx.GetHeader("Authorization"); len(bearer) > 7 && strings.EqualFold(bearer[:7], "Bearer ") {
+			req.Token = strings.TrimSpace(bearer[7:])
+		}
+		if req.Token == "" {
+			log.Error("error_401: missing token")
+			unauthorized()
+			return
 		}
 
-		// load user
-		userID, err := utils.ExtractUserID(claims.Subject)
-		if err != nil {
-			log.WithField("error", err).Error("invalid userID in token payload")
-			ginext.NewError(http.StatusBadRequest, utils.MessageError()[http.StatusUnauthorized])
+		// an API key stands for its user within its scopes
+		var userID, impersonator uuid.UUID
+		var impersonationID string
+		if strings.HasPrefix(req.Token, model.APIKeyPrefix) {
+			key, err := h.service.AuthenticateAPIKey(ginext.FromGinRequestContext(ctx), req.Token)
+			if err != nil {
+				log.WithField("error", err).Error("authenticate API key error")
+				unauthorized()
+				return
+			}
+			if !apiKeyAllows(key, ctx.Request.Method) {
+				log.WithField("key_id", key.ID).Error("error_403: the scopes of the API key do not allow the method")
+				_ = ctx.Error(ginext.NewError(http.StatusForbidden, utils.MessageError()[http.StatusForbidden]))
+				ctx.Abort()
+				return
+			}
+			userID = key.UserID
+			ctx.Set(apiKeyIDKey, key.ID.String())
+			ctx.Set(apiKeyScopesKey, key.Scopes)
+		} else {
+			claims, id, err := h.service.AuthenticateAccessToken(ginext.FromGinRequestContext(ctx), req.Token)
+			if err != nil {
+				log.WithField("error", err).Error("authenticate token error")
+				unauthorized()
+				return
+			}
+			userID = id
+			impersonator, _ = claims.Impersonator()
+			impersonationID = claims.Id
 		}
 
 		//if _, err = h.service.GetOneUserByID(r.Context(), userID); err != nil {
@@ -128,13 +185,57 @@ func (h *UserHandlers) VerifyTokenHandler() gin.HandlerFunc {
 
 		//rs := &model.OAuthVerifyResponseData{UserID: claims.Subject}
 		ctx.Set("x-user-id", userID.String())
+		ctx.Request = ctx.Request.WithContext(audit.WithActor(ctx.Request.Context(), userID))
+		if impersonator == uuid.Nil {
+			ctx.Next()
+			return
+		}
+
+		// an admin impersonates the user: the logs and audit events of the request are tagged,
+		// and the request itself is audited with the admin as actor
+		ctx.Set(impersonatorIDKey, impersonator.String())
+		ctx.Set(impersonationIDKey, impersonationID)
+		reqCtx := audit.WithImpersonation(ctx.Request.Context(), audit.Impersonation{ActorID: impersonator, ID: impersonationID})
+		reqCtx = tracing.WithLogFields(reqCtx, logrus.Fields{"impersonator_id": impersonator.String(), "impersonation_id": impersonationID})
+		trace.SpanFromContext(reqCtx).SetAttributes(tracing.Attributes("impersonator.id", impersonator.String(), "impersonation.id", impersonationID)...)
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Next()
+
+		status := responseStatus(ctx)
+		tracing.WithCtx(ctx, "UserHandlers.VerifyTokenHandler").WithField("user_id", userID).WithField("status", status).Info("impersonated request")
+		h.service.RecordImpersonatedRequest(ginext.FromGinRequestContext(ctx), userID, ctx.Request.Method, ctx.FullPath(), status)
+	}
+}
+
+// RequireAdmin lets through the users with the admin account type, not impersonated, it runs after VerifyTokenHandler
+func (h *UserHandlers) RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		log := tracing.WithCtx(ctx, "UserHandlers.RequireAdmin")
+
+		userID, err := uuid.Parse(ctx.GetString("x-user-id"))
+		if err != nil {
+			log.WithError(err).Error("error_401: missing user in context")
+			_ = ctx.Error(ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized]))
+			ctx.Abort()
+			return
+		}
+
+		user, err := h.service.GetOneUserByID(ginext.FromGinRequestContext(ctx), userID)
+		scopes, byKey := ctx.Get(apiKeyScopesKey)
+		impersonated := ctx.GetString(impersonatorIDKey) != ""
+		if err != nil || user.AccountType != model.AccountTypeAdmin || impersonated || (byKey && !scopes.(model.SpaceList).Contains(model.APIKeyScopeAdmin)) {
+			log.WithField("user_id", userID).Error("error_403: user is not admin")
+			_ = ctx.Error(ginext.NewError(http.StatusForbidden, utils.MessageError()[http.StatusForbidden]))
+			ctx.Abort()
+			return
+		}
 		ctx.Next()
 	}
 }
 
 // 05/04/2022 - hieucn - get one user by id
 func (h *UserHandlers) GetOneUserByID(r *ginext.Request) (*ginext.Response, error) {
-	log := logger.WithCtx(r.GinCtx, "UserHandlers.GetOneUserByID")
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.GetOneUserByID")
 
 	// parse ID from URI
 	id := utils.ParseIDFromUri(r.GinCtx)
@@ -148,6 +249,78 @@ func (h *UserHandlers) GetOneUserByID(r *ginext.Request) (*ginext.Response, erro
 		return nil, err
 	}
 
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: h.userView(r, rs),
+	}}, nil
+}
+
+// userView shows user as a self profile to its owner, as an admin view to admins and as a public profile to the others
+func (h *UserHandlers) userView(r *ginext.Request, user model.User) interface{} {
+	viewerID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		return model.NewPublicProfile(user)
+	}
+	if viewerID == user.ID {
+		if user.AccountType == model.AccountTypeAdmin {
+			return model.NewAdminUserView(user)
+		}
+		return model.NewSelfProfile(user)
+	}
+	viewer, err := h.service.GetOneUserByID(r.Context(), viewerID)
+	if err == nil && viewer.AccountType == model.AccountTypeAdmin {
+		return model.NewAdminUserView(user)
+	}
+	return model.NewPublicProfile(user)
+}
+
+// BatchGetUsers looks up users by ids and emails for the other services, the users are keyed by id
+func (h *UserHandlers) BatchGetUsers(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.BatchGetUsers")
+
+	req := model.BatchGetUsersReq{}
+	r.MustBind(&req)
+	if len(req.IDs) == 0 && len(req.Emails) == 0 {
+		log.Error("error_400: Invalid input")
+		return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: ids or emails is required")
+	}
+
+	found, err := h.service.BatchGetUsers(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := model.BatchGetUsersResponse{
+		Users:         make(map[uuid.UUID]model.UserSummary, len(found.Users)),
+		MissingIDs:    append([]uuid.UUID{}, found.MissingIDs...),
+		MissingEmails: append([]string{}, found.MissingEmails...),
+	}
+	for _, u := range found.Users {
+		rs.Users[u.ID] = model.NewUserSummary(u)
+	}
+
+	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
+		Data: rs,
+	}}, nil
+}
+
+// GetMyLogins lists the recent sign-ins of the current user
+func (h *UserHandlers) GetMyLogins(r *ginext.Request) (*ginext.Response, error) {
+	log := tracing.WithCtx(r.GinCtx, "UserHandlers.GetMyLogins")
+
+	userID, err := uuid.Parse(r.GinCtx.GetString("x-user-id"))
+	if err != nil {
+		log.WithError(err).Error("error_401: missing user in context")
+		return nil, ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+	}
+
+	req := model.LoginHistoryRequest{}
+	r.MustBind(&req)
+
+	rs, err := h.service.GetLoginHistory(r.Context(), userID, req)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ginext.Response{Code: http.StatusOK, GeneralBody: &ginext.GeneralBody{
 		Data: rs,
 	}}, nil
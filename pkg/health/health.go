@@ -0,0 +1,171 @@
+// Package health serves the liveness and readiness probes.
+// Subsystems register their own readiness checks on a Registry.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/logger"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckFunc returns nil when the dependency is ready
+type CheckFunc func(ctx context.Context) error
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	checks  map[string]CheckFunc
+	timeout time.Duration
+}
+
+// NewRegistry makes an empty registry, every check gets at most timeout to answer
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		checks:  map[string]CheckFunc{},
+		timeout: timeout,
+	}
+}
+
+// Register adds a readiness check, registering the same name twice replaces the previous check
+func (r *Registry) Register(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Names returns the registered check names in order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run executes every check concurrently
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make(map[string]CheckFunc, len(r.checks))
+	for name, check := range r.checks {
+		checks[name] = check
+	}
+	r.mu.RUnlock()
+
+	rs := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			res := r.runOne(ctx, check)
+			mu.Lock()
+			rs.Checks[name] = res
+			if res.Status != StatusOK {
+				rs.Status = StatusFail
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return rs
+}
+
+func (r *Registry) runOne(ctx context.Context, check CheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if rc := recover(); rc != nil {
+				errCh <- fmt.Errorf("panic: %v", rc)
+			}
+		}()
+		errCh <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", r.timeout)
+	}
+
+	rs := CheckResult{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		rs.Status = StatusFail
+		rs.Error = err.Error()
+	}
+	return rs
+}
+
+// LivenessHandler answers /healthz, it only tells the process is able to serve requests
+func LivenessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, Report{Status: StatusOK})
+	}
+}
+
+// ReadinessHandler answers /readyz with 503 when any registered check fails
+func (r *Registry) ReadinessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rs := r.Run(c.Request.Context())
+		code := http.StatusOK
+		if rs.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+			logger.WithCtx(c, "health.ReadinessHandler").WithField("checks", rs.Checks).Warn("service is not ready")
+		}
+		c.JSON(code, rs)
+	}
+}
+
+// DBCheck pings the database pool
+func DBCheck(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// LagCheck fails when a queue (mailer, outbox, ...) is behind by more than max,
+// lag returns the age of the oldest item still waiting to be processed
+func LagCheck(lag func(ctx context.Context) (time.Duration, error), max time.Duration) CheckFunc {
+	return func(ctx context.Context) error {
+		d, err := lag(ctx)
+		if err != nil {
+			return err
+		}
+		if d > max {
+			return fmt.Errorf("lag %s exceeds %s", d.Round(time.Second), max)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,120 @@
+// Package mailer sends the emails of the service through a pluggable driver.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/goxp/cloud0/logger"
+)
+
+const (
+	DriverLog  = "log"
+	DriverSMTP = "smtp"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	Driver   string
+	From     string
+	SMTPHost string
+	SMTPPort int
+	SMTPUser string
+	SMTPPass string
+}
+
+// New returns the mailer of cfg.Driver, the log driver is used when it is empty
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "", DriverLog:
+		return LogMailer{}, nil
+	case DriverSMTP:
+		return &SMTPMailer{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unsupported mail driver %q", cfg.Driver)
+}
+
+// LogMailer only writes the emails to the log, for local development
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	logger.WithCtx(ctx, "mailer.LogMailer").
+		WithField("to", msg.To).
+		WithField("subject", msg.Subject).
+		Info(msg.Body)
+	return nil
+}
+
+type SMTPMailer struct {
+	cfg Config
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+	var auth smtp.Auth
+	if m.cfg.SMTPUser != "" {
+		auth = smtp.PlainAuth("", m.cfg.SMTPUser, m.cfg.SMTPPass, m.cfg.SMTPHost)
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + m.cfg.From + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(addr, auth, m.cfg.From, []string{msg.To}, []byte(b.String()))
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FakeMailer records the emails instead of sending them, for tests
+type FakeMailer struct {
+	mu   sync.Mutex
+	sent []Message
+	Err  error
+}
+
+func NewFakeMailer() *FakeMailer {
+	return &FakeMailer{}
+}
+
+func (m *FakeMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *FakeMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const gormStartKey = "metrics:start"
+
+// GormPlugin observes the duration of every GORM operation into DBQueryDuration
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "metrics"
+}
+
+func (p GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, h := range hooks {
+		if err := h.before("metrics:before_"+h.operation, before); err != nil {
+			return err
+		}
+		if err := h.after("metrics:after_"+h.operation, after(h.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func before(db *gorm.DB) {
+	db.InstanceSet(gormStartKey, time.Now())
+}
+
+func after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(gormStartKey)
+		if !ok {
+			return
+		}
+		start, ok := v.(time.Time)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		DBQueryDuration.Observe(time.Since(start).Seconds(), operation, table)
+	}
+}
+
+// RegisterDBStats exposes the pool statistics of db, they are read at scrape time
+func RegisterDBStats(r *Registry, db *sql.DB) {
+	NewGaugeFunc(r, "ms_user_db_open_connections", "Established connections, in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	NewGaugeFunc(r, "ms_user_db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	NewGaugeFunc(r, "ms_user_db_idle_connections", "Idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	NewGaugeFunc(r, "ms_user_db_max_open_connections", "Maximum number of open connections.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	NewCounterFunc(r, "ms_user_db_wait_count_total", "Connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	NewCounterFunc(r, "ms_user_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
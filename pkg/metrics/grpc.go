@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records gRPC count and latency by full method and status code
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		rs, err := handler(ctx, req)
+
+		code := status.Code(err).String()
+		GRPCRequests.Inc(info.FullMethod, code)
+		GRPCDuration.Observe(time.Since(start).Seconds(), info.FullMethod, code)
+		return rs, err
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GinMiddleware records HTTP count and latency, the route label is the registered
+// path (/user/get-one/:id) so ids do not explode the cardinality
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		HTTPRequests.Inc(c.Request.Method, route, status)
+		HTTPDuration.Observe(time.Since(start).Seconds(), c.Request.Method, route, status)
+	}
+}
+
+// GinHandler serves the default registry
+func GinHandler() gin.HandlerFunc {
+	return gin.WrapH(DefaultRegistry.Handler())
+}
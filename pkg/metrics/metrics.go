@@ -0,0 +1,288 @@
+// Package metrics keeps the service counters and histograms and exposes them
+// in the Prometheus text format at /metrics. It stands in for prometheus/client_golang
+// and only implements what the service needs: counters, gauges and histograms with labels.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefBuckets fits request and query latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]collector{}}
+}
+
+// DefaultRegistry holds every metric of the service
+var DefaultRegistry = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.name()]; ok {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// Write writes every metric sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.RLock()
+		c := r.collectors[name]
+		r.mu.RUnlock()
+		c.write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	fqName string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, escapeHelp(d.help), d.fqName, d.typ)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.fqName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a monotonic counter partitioned by labels
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+func NewCounterVec(r *Registry, name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{fqName: name, help: help, typ: typeCounter, labels: labels},
+		values: map[string]*series{},
+	}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the current count, it is mostly useful in tests
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.values[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.fqName, c.labelPairs(s.labels), formatFloat(s.value))
+	}
+}
+
+// HistogramVec counts observations in cumulative buckets, partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramVec(r *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{fqName: name, help: help, typ: typeHistogram, labels: labels},
+		buckets: append([]float64(nil), buckets...),
+		values:  map[string]*histogram{},
+	}
+	sort.Float64s(h.buckets)
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, h.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, h.labelPairs(s.labels), s.count)
+	}
+}
+
+// GaugeFunc reads its value when the registry is scraped
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func NewGaugeFunc(r *Registry, name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{fqName: name, help: help, typ: typeGauge}, fn: fn}
+	r.register(g)
+	return g
+}
+
+// NewCounterFunc is a GaugeFunc exposed as a counter, fn must never decrease
+func NewCounterFunc(r *Registry, name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{fqName: name, help: help, typ: typeCounter}, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatFloat(g.fn()))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,56 @@
+package metrics
+
+// Login outcomes
+const (
+	LoginSuccess     = "success"
+	LoginBadPassword = "bad_password"
+	LoginLocked      = "locked"
+	LoginUnknownUser = "unknown_user"
+	LoginBadCode     = "bad_code"
+	LoginExpiredCode = "expired_code"
+	LoginBadLink     = "bad_link"
+	LoginExpiredLink = "expired_link"
+	// LoginOtherBrowser is a magic link opened without the nonce cookie of the browser that asked for it
+	LoginOtherBrowser = "other_browser"
+	LoginBadState     = "bad_state"
+	LoginExpiredState = "expired_state"
+	// LoginOIDCRejected is a provider that answered an error, or a token that failed the checks
+	LoginOIDCRejected = "oidc_rejected"
+	// LoginSAMLRejected is a Response that failed the checks, or an email outside the domains of the business
+	LoginSAMLRejected = "saml_rejected"
+)
+
+// Token types
+const (
+	TokenAccess  = "access"
+	TokenRefresh = "refresh"
+	TokenID      = "id"
+)
+
+var (
+	HTTPRequests = NewCounterVec(DefaultRegistry, "ms_user_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "status")
+	HTTPDuration = NewHistogramVec(DefaultRegistry, "ms_user_http_request_duration_seconds",
+		"HTTP request latency by method, route and status code.", DefBuckets, "method", "route", "status")
+
+	GRPCRequests = NewCounterVec(DefaultRegistry, "ms_user_grpc_requests_total",
+		"gRPC calls by method and status code.", "method", "code")
+	GRPCDuration = NewHistogramVec(DefaultRegistry, "ms_user_grpc_request_duration_seconds",
+		"gRPC call latency by method and status code.", DefBuckets, "method", "code")
+
+	Logins = NewCounterVec(DefaultRegistry, "ms_user_logins_total",
+		"Login attempts by outcome.", "outcome")
+	TokensIssued = NewCounterVec(DefaultRegistry, "ms_user_tokens_issued_total",
+		"Tokens issued by type.", "type")
+	TokensRefreshed = NewCounterVec(DefaultRegistry, "ms_user_tokens_refreshed_total",
+		"Access tokens issued in exchange of a refresh token.")
+	OAuthTokenRequests = NewCounterVec(DefaultRegistry, "ms_user_oauth_token_requests_total",
+		"Requests to the OAuth token endpoint by grant type and outcome, ok or the OAuth error code.", "grant_type", "outcome")
+	Registrations = NewCounterVec(DefaultRegistry, "ms_user_registrations_total",
+		"Users registered.")
+	OTPsSent = NewCounterVec(DefaultRegistry, "ms_user_otps_sent_total",
+		"One-time codes sent by SMS by purpose.", "purpose")
+
+	DBQueryDuration = NewHistogramVec(DefaultRegistry, "ms_user_db_query_duration_seconds",
+		"GORM query latency by operation and table.", DefBuckets, "operation", "table")
+)
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyPrefix starts every API key, it tells VerifyTokenHandler an API key from a JWT
+const APIKeyPrefix = "msu_"
+
+// Scopes of the API keys: read opens the GET routes, write every route, admin the admin API of an admin account
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+	APIKeyScopeAdmin = "admin"
+)
+
+// APIKey lets the scripts of a user call the API as the user. The key is APIKeyPrefix, Prefix and a secret;
+// Prefix finds the row and only the hash of the whole key is stored.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;index;not null"`
+	Name       string     `json:"name" gorm:"type:varchar(255);not null"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(16);uniqueIndex;not null"`
+	KeyHash    string     `json:"-" gorm:"type:varchar(64);not null"`
+	Scopes     SpaceList  `json:"scopes" gorm:"type:text"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// HasScope tells whether the key was given scope
+func (k APIKey) HasScope(scope string) bool {
+	return k.Scopes.Contains(scope)
+}
+
+// CreateAPIKeyReq names a key, ExpiresAt defaults to the longest lifetime allowed
+type CreateAPIKeyReq struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse carries the key, it is only returned on creation
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit event types
+const (
+	AuditUserRegistered  = "user.registered"
+	AuditLoginSucceeded  = "login.succeeded"
+	AuditLoginFailed     = "login.failed"
+	AuditPasswordChanged = "password.changed"
+	AuditPhoneVerified   = "phone.verified"
+	AuditIdentityLinked  = "identity.linked"
+	// the admins register OAuth clients, users grant them access
+	AuditOAuthClientCreated  = "oauth_client.created"
+	AuditOAuthClientDeleted  = "oauth_client.deleted"
+	AuditOAuthConsentGranted = "oauth_consent.granted"
+	AuditOAuthConsentRevoked = "oauth_consent.revoked"
+	AuditRoleGranted         = "role.granted"
+	AuditSessionRevoked      = "session.revoked"
+	AuditUserRead            = "user.read"
+	AuditLogQueried          = "audit.queried"
+	AuditLogExported         = "audit.exported"
+	// the admins configure the SAML identity provider of a business
+	AuditSAMLConnectionSaved   = "saml_connection.saved"
+	AuditSAMLConnectionDeleted = "saml_connection.deleted"
+	// the directories of the businesses provision their users over SCIM
+	AuditSCIMTokenCreated  = "scim_token.created"
+	AuditSCIMTokenDeleted  = "scim_token.deleted"
+	AuditUserUpdated       = "user.updated"
+	AuditUserDeactivated   = "user.deactivated"
+	AuditUserReactivated   = "user.reactivated"
+	AuditUserDeprovisioned = "user.deprovisioned"
+	// the users manage the API keys of their scripts
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+	// the other services call the /internal routes with service tokens
+	AuditInternalCallRejected = "internal_call.rejected"
+	// the admins impersonate users, every request made with the token is audited
+	AuditImpersonationStarted = "impersonation.started"
+	AuditImpersonatedRequest  = "impersonation.request"
+)
+
+// AuditEvent is one row of the append-only audit log.
+// Hash covers every other column and PrevHash, so rows are chained by Seq.
+type AuditEvent struct {
+	ID         uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	Seq        int64      `json:"seq" gorm:"uniqueIndex;not null"`
+	OccurredAt time.Time  `json:"occurred_at" gorm:"index;not null"`
+	Type       string     `json:"type" gorm:"type:varchar(100);index;not null"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid;index"`
+	SubjectID  *uuid.UUID `json:"subject_id,omitempty" gorm:"type:uuid;index"`
+	IP         string     `json:"ip,omitempty" gorm:"type:varchar(100)"`
+	UserAgent  string     `json:"user_agent,omitempty" gorm:"type:varchar(500)"`
+	Metadata   string     `json:"metadata,omitempty" gorm:"type:text"`
+	PrevHash   string     `json:"prev_hash" gorm:"type:varchar(64)"`
+	Hash       string     `json:"hash" gorm:"type:varchar(64);not null"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// PendingAuditEvent is an event recorded by a request and not chained yet: requests only insert them,
+// the audit chain worker appends them to audit_events by (occurred_at, id) so no request waits on the chain lock.
+type PendingAuditEvent struct {
+	ID         uuid.UUID  `gorm:"primary_key;type:uuid"`
+	OccurredAt time.Time  `gorm:"index;not null"`
+	Type       string     `gorm:"type:varchar(100);not null"`
+	ActorID    *uuid.UUID `gorm:"type:uuid"`
+	SubjectID  *uuid.UUID `gorm:"type:uuid"`
+	IP         string     `gorm:"type:varchar(100)"`
+	UserAgent  string     `gorm:"type:varchar(500)"`
+	Metadata   string     `gorm:"type:text"`
+}
+
+func (PendingAuditEvent) TableName() string {
+	return "audit_pending_events"
+}
+
+// NewPendingAuditEvent queues ev as it happened at now
+func NewPendingAuditEvent(ev AuditEvent, now time.Time) PendingAuditEvent {
+	id := ev.ID
+	if id == uuid.Nil {
+		id = uuid.New()
+	}
+	return PendingAuditEvent{
+		ID:         id,
+		OccurredAt: now.UTC().Truncate(time.Microsecond),
+		Type:       ev.Type,
+		ActorID:    ev.ActorID,
+		SubjectID:  ev.SubjectID,
+		IP:         ev.IP,
+		UserAgent:  ev.UserAgent,
+		Metadata:   ev.Metadata,
+	}
+}
+
+// Event is the unchained audit event of p
+func (p PendingAuditEvent) Event() AuditEvent {
+	return AuditEvent{
+		ID:         p.ID,
+		OccurredAt: p.OccurredAt,
+		Type:       p.Type,
+		ActorID:    p.ActorID,
+		SubjectID:  p.SubjectID,
+		IP:         p.IP,
+		UserAgent:  p.UserAgent,
+		Metadata:   p.Metadata,
+	}
+}
+
+type AuditFilter struct {
+	ActorID   *uuid.UUID `json:"actor_id" form:"actor_id"`
+	SubjectID *uuid.UUID `json:"subject_id" form:"subject_id"`
+	Type      string     `json:"type" form:"type"`
+	From      *time.Time `json:"from" form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        *time.Time `json:"to" form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page      int        `json:"page" form:"page"`
+	PageSize  int        `json:"page_size" form:"page_size"`
+}
+
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
@@ -8,9 +8,11 @@ import (
 )
 
 type CreateTokenRequest struct {
-	UserID  string `json:"user_id"`
-	NumHour int    `json:"num_hour"`
-	Extra   string `json:"extra"`
+	UserID   string `json:"user_id"`
+	NumHour  int    `json:"num_hour"`
+	Extra    string `json:"extra"`
+	DeviceID string `json:"device_id"`
+	Scope    string `json:"scope"`
 }
 
 type TokenRepository struct {
@@ -27,16 +29,37 @@ type RefreshToken struct {
 	DeviceID   string    `gorm:"index"`
 	Sign       string    `gorm:"index"`
 	BusinessID uuid.UUID `json:"business_id" gorm:"index;type:uuid"`
-	ExpiredAt  time.Time
+	// ClientID is the OAuth client the token was issued to, empty for the sign-in of ms-user itself
+	ClientID  string    `json:"client_id" gorm:"type:varchar(64);not null;default:'';index"`
+	Scope     SpaceList `json:"scope" gorm:"type:text"`
+	ExpiredAt time.Time
 }
 
 type AccessTokenClaims struct {
 	DeviceID       string `json:"device_id"`
 	BusinessID     string `json:"business_id"`
 	PermissionKeys string `json:"permission_keys"`
+	// Scope is set on the tokens of OAuth clients, their audience is the client
+	Scope string `json:"scope,omitempty"`
+	// Act is set on the tokens an admin impersonates the subject with
+	Act *TokenActor `json:"act,omitempty"`
 	jwt.StandardClaims
 }
 
+// TokenActor is the act claim of RFC 8693: the admin acting as the subject of the token
+type TokenActor struct {
+	Subject string `json:"sub"`
+}
+
+// Impersonator returns the admin of the act claim, false for the tokens without one
+func (c *AccessTokenClaims) Impersonator() (uuid.UUID, bool) {
+	if c.Act == nil {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(c.Act.Subject)
+	return id, err == nil
+}
+
 type OAuthVerifyRequest struct {
 	Token               string `json:"token"`
 	Method              string `json:"method"`
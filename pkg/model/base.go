@@ -16,6 +16,14 @@ type BaseModel struct {
 	DeletedAt *gorm.DeletedAt `json:"deleted_at,omitempty" sql:"index"`
 }
 
+// BeforeCreate generates the ID in Go so it does not depend on uuid_generate_v4(), which only exists on Postgres
+func (m *BaseModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
 type UriParse struct {
 	ID []string `json:"id" uri:"id"`
 }
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImpersonationDeviceID is the device of the impersonation tokens, the login history of the user does not show them
+const ImpersonationDeviceID = "impersonation"
+
+type ImpersonateReq struct {
+	// Reason is kept in the audit log, e.g. the support ticket
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// ImpersonationResponse holds an access token of the user carrying the admin in its act claim.
+// It comes without refresh token and can not be renewed.
+type ImpersonationResponse struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	UserID    uuid.UUID `json:"user_id"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginHistory is one sign-in attempt on a known account
+type LoginHistory struct {
+	ID          uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	UserID      uuid.UUID `json:"-" gorm:"type:uuid;index;not null"`
+	OccurredAt  time.Time `json:"occurred_at" gorm:"index;not null"`
+	IP          string    `json:"ip" gorm:"type:varchar(100)"`
+	UserAgent   string    `json:"user_agent" gorm:"type:varchar(500)"`
+	Browser     string    `json:"browser" gorm:"type:varchar(100)"`
+	OS          string    `json:"os" gorm:"type:varchar(100)"`
+	DeviceID    string    `json:"device_id" gorm:"type:varchar(255)"`
+	Fingerprint string    `json:"-" gorm:"type:varchar(64);index"`
+	Success     bool      `json:"success"`
+	Reason      string    `json:"reason,omitempty" gorm:"type:varchar(50)"`
+}
+
+func (LoginHistory) TableName() string {
+	return "login_history"
+}
+
+type LoginHistoryRequest struct {
+	Limit int `json:"limit" form:"limit"`
+}
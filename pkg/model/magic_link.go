@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MagicLink is a single-use sign-in link sent by email. The link carries the ID and its signature,
+// NonceHash binds it to the browser that asked for it through a cookie.
+type MagicLink struct {
+	ID         uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;index;not null"`
+	NonceHash  string     `json:"-" gorm:"type:varchar(64);not null"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+}
+
+func (MagicLink) TableName() string {
+	return "magic_links"
+}
+
+type MagicLinkReq struct {
+	Email *string `json:"email" valid:"Required"`
+}
+
+// MagicLinkResponse is the same whether the email has an account or not
+type MagicLinkResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ConsumeMagicLinkReq struct {
+	Token    string `json:"token" form:"token"`
+	DeviceID string `json:"device_id" form:"device_id"`
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Grant types of the OAuth token endpoint
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// SpaceList is stored as a space separated string, the way OAuth writes scopes
+type SpaceList []string
+
+func (l SpaceList) String() string {
+	return strings.Join(l, " ")
+}
+
+func (l SpaceList) Contains(v string) bool {
+	for _, item := range l {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (l SpaceList) Value() (driver.Value, error) {
+	return l.String(), nil
+}
+
+func (l *SpaceList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*l = strings.Fields(v)
+	case []byte:
+		*l = strings.Fields(string(v))
+	case nil:
+		*l = nil
+	default:
+		return fmt.Errorf("cannot scan %T into a SpaceList", src)
+	}
+	return nil
+}
+
+// OAuthClient is an application signing users in with ms-user, or calling APIs as itself.
+// A public client, e.g. a mobile or single page app, has no secret and must use PKCE.
+type OAuthClient struct {
+	ID           uuid.UUID  `json:"-" gorm:"primary_key;type:uuid"`
+	ClientID     string     `json:"client_id" gorm:"type:varchar(64);uniqueIndex;not null"`
+	SecretHash   string     `json:"-" gorm:"type:varchar(64)"`
+	Name         string     `json:"name" gorm:"type:varchar(255);not null"`
+	Public       bool       `json:"public" gorm:"not null"`
+	RedirectURIs SpaceList  `json:"redirect_uris" gorm:"type:text"`
+	GrantTypes   SpaceList  `json:"grant_types" gorm:"type:text"`
+	Scopes       SpaceList  `json:"scopes" gorm:"type:text"`
+	CreatedBy    *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"not null"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// OAuthAuthorizationCode is a code given to a client after the user consented, it is exchanged once.
+// Only the hash of the code is stored.
+type OAuthAuthorizationCode struct {
+	ID            uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	CodeHash      string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	ClientID      string     `json:"client_id" gorm:"type:varchar(64);not null"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
+	RedirectURI   string     `json:"redirect_uri" gorm:"type:text;not null"`
+	Scope         SpaceList  `json:"scope" gorm:"type:text"`
+	Nonce         string     `json:"-" gorm:"type:varchar(255)"`
+	CodeChallenge string     `json:"-" gorm:"type:varchar(128)"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"not null"`
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt    *time.Time `json:"consumed_at,omitempty"`
+}
+
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// OAuthConsent records the scopes a user granted to a client, a later request within them is not asked again
+type OAuthConsent struct {
+	ID        uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_oauth_consents_user_client"`
+	ClientID  string    `json:"client_id" gorm:"type:varchar(64);not null;uniqueIndex:idx_oauth_consents_user_client"`
+	Scope     SpaceList `json:"scope" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"not null"`
+	// ClientName is filled when the consents are listed to the user
+	ClientName string `json:"client_name,omitempty" gorm:"-"`
+}
+
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
+
+type CreateOAuthClientReq struct {
+	Name         string   `json:"name" validate:"required,max=255"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	// GrantTypes defaults to authorization_code and refresh_token
+	GrantTypes []string `json:"grant_types"`
+	// Scopes defaults to every supported scope
+	Scopes []string `json:"scopes"`
+}
+
+// CreateOAuthClientResponse carries the secret of a confidential client, it is shown only once
+type CreateOAuthClientResponse struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// OAuthAuthorizeReq is the query of an authorization request, RFC 6749 section 4.1.1
+type OAuthAuthorizeReq struct {
+	ResponseType        string `json:"response_type" form:"response_type"`
+	ClientID            string `json:"client_id" form:"client_id"`
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri"`
+	Scope               string `json:"scope" form:"scope"`
+	State               string `json:"state" form:"state"`
+	Nonce               string `json:"nonce" form:"nonce"`
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method"`
+}
+
+// OAuthAuthorizeInfo is what the consent page shows of an authorization request
+type OAuthAuthorizeInfo struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scope      SpaceList `json:"scope"`
+	// Consented is true when the user already granted these scopes, the page may approve without asking
+	Consented bool `json:"consented"`
+}
+
+// OAuthDecisionReq is the answer of the user to an authorization request
+type OAuthDecisionReq struct {
+	OAuthAuthorizeReq
+	Approve bool `json:"approve"`
+}
+
+// OAuthDecisionResponse is where the consent page sends the browser, back to the client
+type OAuthDecisionResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthTokenReq is the form posted to the token endpoint
+type OAuthTokenReq struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// OAuthTokenResponse is the answer of the token endpoint, RFC 6749 section 5.1
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthUserInfo holds the claims of /oauth/userinfo, the scopes of the token decide which are set
+type OAuthUserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Profile           string `json:"profile,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox message kinds
+const (
+	OutboxKindEmail = "email"
+)
+
+// OutboxMessage is a side effect (an email, ...) written in the transaction of the action
+// that caused it and delivered later by the outbox worker
+type OutboxMessage struct {
+	ID            uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	Kind          string     `json:"kind" gorm:"type:varchar(50);not null"`
+	Payload       string     `json:"payload" gorm:"type:text;not null"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"not null"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index;not null"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty" gorm:"type:text"`
+	SentAt        *time.Time `json:"sent_at,omitempty" gorm:"index"`
+	FailedAt      *time.Time `json:"failed_at,omitempty"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+// EmailPayload is the payload of OutboxKindEmail messages
+type EmailPayload struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
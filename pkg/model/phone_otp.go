@@ -0,0 +1,55 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of a PhoneOTP
+const (
+	OTPPurposeLogin  = "login"
+	OTPPurposeVerify = "verify"
+)
+
+// PhoneOTP is a one-time code sent by SMS, only the HMAC of the code is stored
+type PhoneOTP struct {
+	ID      uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	Phone   string    `json:"phone" gorm:"type:varchar(20);index;not null"`
+	Purpose string    `json:"purpose" gorm:"type:varchar(20);not null"`
+	// UserID is the signed in user verifying the phone, nil for a login
+	UserID     *uuid.UUID `json:"user_id,omitempty" gorm:"type:uuid"`
+	CodeHash   string     `json:"-" gorm:"type:varchar(64);not null"`
+	Attempts   int        `json:"attempts" gorm:"not null"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index;not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+}
+
+func (PhoneOTP) TableName() string {
+	return "phone_otps"
+}
+
+type PhoneOTPReq struct {
+	PhoneNumber *string `json:"phone_number" valid:"Required"`
+	// Region is the ISO 3166 country of national numbers, PHONE_DEFAULT_REGION when empty
+	Region string `json:"region"`
+}
+
+type PhoneLoginReq struct {
+	PhoneNumber *string `json:"phone_number" valid:"Required"`
+	Region      string  `json:"region"`
+	Code        *string `json:"code" valid:"Required"`
+	DeviceID    *string `json:"device_id"`
+}
+
+type PhoneVerifyReq struct {
+	PhoneNumber *string `json:"phone_number" valid:"Required"`
+	Region      string  `json:"region"`
+	Code        *string `json:"code" valid:"Required"`
+}
+
+type PhoneOTPResponse struct {
+	PhoneNumber string    `json:"phone_number"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
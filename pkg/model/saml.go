@@ -0,0 +1,70 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SAMLConnection is the SAML identity provider of a business, its users sign in with it.
+// Only emails of its domains are trusted to it: they are the ones it can sign in, or link to an existing account.
+type SAMLConnection struct {
+	ID              uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	BusinessID      uuid.UUID `json:"business_id" gorm:"type:uuid;uniqueIndex;not null"`
+	IdPEntityID     string    `json:"idp_entity_id" gorm:"column:idp_entity_id;type:varchar(1024);not null"`
+	IdPSSOURL       string    `json:"idp_sso_url" gorm:"column:idp_sso_url;type:text;not null"`
+	IdPCertificates string    `json:"idp_certificates" gorm:"column:idp_certificates;type:text;not null"`
+	// EmailAttribute names the attribute holding the email, the NameID is the email when it is empty
+	EmailAttribute string     `json:"email_attribute" gorm:"type:varchar(255)"`
+	NameAttribute  string     `json:"name_attribute" gorm:"type:varchar(255)"`
+	Domains        SpaceList  `json:"domains" gorm:"type:text"`
+	Enabled        bool       `json:"enabled" gorm:"not null"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"not null"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"not null"`
+}
+
+func (SAMLConnection) TableName() string {
+	return "saml_connections"
+}
+
+// SAMLAuthRequest is an AuthnRequest sent to the identity provider of a business and waiting for its Response.
+// The relay state is sent in a cookie too, only its hash is stored.
+type SAMLAuthRequest struct {
+	ID             uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	RequestID      string     `json:"request_id" gorm:"type:varchar(64);not null"`
+	BusinessID     uuid.UUID  `json:"business_id" gorm:"type:uuid;not null"`
+	RelayStateHash string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	DeviceID       string     `json:"device_id" gorm:"type:varchar(255)"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"not null"`
+	ExpiresAt      time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt     *time.Time `json:"consumed_at,omitempty"`
+}
+
+func (SAMLAuthRequest) TableName() string {
+	return "saml_auth_requests"
+}
+
+// SaveSAMLConnectionReq configures the identity provider of a business, from its metadata document
+// or from the entity ID, SSO URL and PEM certificates given one by one
+type SaveSAMLConnectionReq struct {
+	MetadataXML     string   `json:"metadata_xml"`
+	IdPEntityID     string   `json:"idp_entity_id" validate:"max=1024"`
+	IdPSSOURL       string   `json:"idp_sso_url"`
+	IdPCertificates string   `json:"idp_certificates"`
+	EmailAttribute  string   `json:"email_attribute" validate:"max=255"`
+	NameAttribute   string   `json:"name_attribute" validate:"max=255"`
+	Domains         []string `json:"domains" validate:"required,min=1"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+type StartSAMLLoginReq struct {
+	DeviceID string `form:"device_id"`
+}
+
+// SAMLACSReq is the form the identity provider makes the browser post to the assertion consumer service
+type SAMLACSReq struct {
+	SAMLResponse string `form:"SAMLResponse"`
+	RelayState   string `form:"RelayState"`
+}
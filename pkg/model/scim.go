@@ -0,0 +1,100 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SCIMToken is a bearer token the directory of a business provisions its users with, only its hash is stored
+type SCIMToken struct {
+	ID          uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	BusinessID  uuid.UUID  `json:"business_id" gorm:"type:uuid;index;not null"`
+	Description string     `json:"description" gorm:"type:varchar(255)"`
+	TokenHash   string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"not null"`
+}
+
+func (SCIMToken) TableName() string {
+	return "scim_tokens"
+}
+
+// SCIMUser records that a business provisioned a user: the user is a SCIM resource of that business only.
+// DeprovisionedAt is set when the directory deleted the user, the row is kept so a new provisioning of the
+// same userName revives the account.
+type SCIMUser struct {
+	UserID     uuid.UUID `json:"user_id" gorm:"primary_key;type:uuid"`
+	BusinessID uuid.UUID `json:"business_id" gorm:"type:uuid;index;not null"`
+	ExternalID string    `json:"external_id" gorm:"type:varchar(255)"`
+	// GivenName and FamilyName keep the parts of the name the directory sent, the user only has a full name
+	GivenName       string     `json:"given_name" gorm:"type:varchar(255)"`
+	FamilyName      string     `json:"family_name" gorm:"type:varchar(255)"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"not null"`
+	UpdatedAt       time.Time  `json:"updated_at" gorm:"not null"`
+	DeprovisionedAt *time.Time `json:"deprovisioned_at,omitempty"`
+	// User is loaded with the row, soft-deleted or not
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+func (SCIMUser) TableName() string {
+	return "scim_users"
+}
+
+// SCIMUserFilter narrows a listing, an empty field does not filter
+type SCIMUserFilter struct {
+	Email      string
+	ExternalID string
+}
+
+type SCIMGroup struct {
+	ID          uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	BusinessID  uuid.UUID `json:"business_id" gorm:"type:uuid;index;not null"`
+	DisplayName string    `json:"display_name" gorm:"type:varchar(255);not null"`
+	ExternalID  string    `json:"external_id" gorm:"type:varchar(255)"`
+	CreatedAt   time.Time `json:"created_at" gorm:"not null"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"not null"`
+}
+
+func (SCIMGroup) TableName() string {
+	return "scim_groups"
+}
+
+// SCIMGroupFilter narrows a listing, an empty field does not filter
+type SCIMGroupFilter struct {
+	DisplayName string
+	ExternalID  string
+}
+
+type SCIMGroupMember struct {
+	GroupID    uuid.UUID `json:"group_id" gorm:"primary_key;type:uuid"`
+	UserID     uuid.UUID `json:"user_id" gorm:"primary_key;type:uuid;index"`
+	BusinessID uuid.UUID `json:"business_id" gorm:"type:uuid;index;not null"`
+}
+
+func (SCIMGroupMember) TableName() string {
+	return "scim_group_members"
+}
+
+type CreateSCIMTokenReq struct {
+	BusinessID  uuid.UUID `json:"business_id" validate:"required"`
+	Description string    `json:"description" validate:"max=255"`
+}
+
+// CreateSCIMTokenResponse carries the token, it is only returned on creation
+type CreateSCIMTokenResponse struct {
+	SCIMToken
+	Token string `json:"token"`
+}
+
+// ListSCIMTokensReq filters the tokens on a business, the form binding of gin does not read a uuid.UUID
+type ListSCIMTokensReq struct {
+	BusinessID string `form:"business_id" validate:"omitempty,uuid"`
+}
+
+// SCIMListReq is the query of a listing: a filter expression and a page starting at StartIndex, counted from 1
+type SCIMListReq struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"`
+}
@@ -1,5 +1,15 @@
 package model
 
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// AccountTypeAdmin marks the accounts allowed on the admin API
+const AccountTypeAdmin = "admin"
+
 type User struct {
 	BaseModel
 	FullName    string `json:"full_name" gorm:"column:full_name; type:varchar(255)"`
@@ -10,19 +20,69 @@ type User struct {
 	AccountType string `json:"account_type" gorm:"type:varchar(50);"`
 	Images      string `json:"images" gorm:"column:images; type:varchar(255);"`
 	Link        string `json:"link" gorm:"type:varchar(500)"`
-	Password    string `json:"password,omitempty" gorm:"type:varchar(255); not null;" sql:"-"`
+	// EmailNormalized identifies the account, see NormalizeEmail, it is nil without an email.
+	// Its unique index is created by the migration, like the one of PhoneE164.
+	EmailNormalized *string `json:"-" gorm:"column:email_normalized; type:varchar(500)"`
+	// PhoneE164 is the verified phone number the account signs in with, nil when there is none
+	PhoneE164       *string    `json:"-" gorm:"column:phone_e164; type:varchar(20)"`
+	PhoneVerifiedAt *time.Time `json:"-"`
+	// Password is the bcrypt hash, it is never serialized; handlers answer with the views of user_view.go
+	Password string `json:"-" gorm:"type:varchar(255); not null;" sql:"-"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
 
+// BeforeSave keeps EmailNormalized in line with Email
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.EmailNormalized = NormalizedEmailOf(u.Email)
+	return nil
+}
+
+// NormalizedEmailOf is the value of User.EmailNormalized for email
+func NormalizedEmailOf(email string) *string {
+	if e := NormalizeEmail(email); e != "" {
+		return &e
+	}
+	return nil
+}
+
+// NormalizeEmail returns the canonical form of an email address, addresses with the same form are one account
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 type CreateUserReq struct {
 	Email    *string `json:"email" valid:"Required"`
 	Password *string `json:"password" valid:"Required"`
+	DeviceID *string `json:"device_id"`
+}
+
+type RefreshTokenReq struct {
+	RefreshToken *string `json:"refresh_token" valid:"Required"`
 }
 
 type ConfirmLoginResponse struct {
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 }
+
+// BatchGetUsersReq looks users up by id and by email, both lists together are bounded by the service
+type BatchGetUsersReq struct {
+	IDs    []uuid.UUID `json:"ids"`
+	Emails []string    `json:"emails"`
+}
+
+// BatchGetUsersResult holds the users found in the order of the request, without duplicates
+type BatchGetUsersResult struct {
+	Users         []User
+	MissingIDs    []uuid.UUID
+	MissingEmails []string
+}
+
+type BatchGetUsersResponse struct {
+	Users         map[uuid.UUID]UserSummary `json:"users"`
+	MissingIDs    []uuid.UUID               `json:"missing_ids"`
+	MissingEmails []string                  `json:"missing_emails"`
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links an account of an OpenID Connect provider, its subject, to a user.
+// The provider of a SAML identity is "saml:" followed by the business ID.
+type UserIdentity struct {
+	ID       uuid.UUID `json:"id" gorm:"primary_key;type:uuid"`
+	UserID   uuid.UUID `json:"user_id" gorm:"type:uuid;index;not null"`
+	Provider string    `json:"provider" gorm:"type:varchar(64);not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject  string    `json:"subject" gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_provider_subject"`
+	// Email is the one given by the provider when the identity was linked
+	Email     string    `json:"email" gorm:"type:varchar(500)"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OIDCAuthRequest is a sign-in started at a provider and waiting for its callback.
+// The state is sent in a cookie too, only its hash is stored.
+type OIDCAuthRequest struct {
+	ID           uuid.UUID  `json:"id" gorm:"primary_key;type:uuid"`
+	Provider     string     `json:"provider" gorm:"type:varchar(32);not null"`
+	StateHash    string     `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	Nonce        string     `json:"-" gorm:"type:varchar(64);not null"`
+	CodeVerifier string     `json:"-" gorm:"type:varchar(128);not null"`
+	DeviceID     string     `json:"device_id" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"not null"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
+	ConsumedAt   *time.Time `json:"consumed_at,omitempty"`
+}
+
+func (OIDCAuthRequest) TableName() string {
+	return "oidc_auth_requests"
+}
+
+type StartOIDCLoginReq struct {
+	DeviceID string `form:"device_id"`
+}
+
+// OIDCCallbackReq is the query the provider redirects the browser back with
+type OIDCCallbackReq struct {
+	Code             string `form:"code"`
+	State            string `form:"state"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+}
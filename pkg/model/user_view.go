@@ -0,0 +1,104 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// The views below are what the API shows of a User, they never carry the password hash
+
+// PublicProfile is what any signed in user sees of another one
+type PublicProfile struct {
+	ID          uuid.UUID `json:"id"`
+	FullName    string    `json:"full_name"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	Images      string    `json:"images"`
+	Link        string    `json:"link"`
+}
+
+// SelfProfile is what users see of their own account
+type SelfProfile struct {
+	PublicProfile
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phone_number"`
+	// PhoneVerifiedAt is set once the phone number has been verified by SMS, it can then be used to sign in
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+	AccountType     string     `json:"account_type"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AdminUserView is what admins see of any account
+type AdminUserView struct {
+	SelfProfile
+	CreatorID uuid.UUID  `json:"creator_id"`
+	UpdaterID uuid.UUID  `json:"updater_id"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func NewPublicProfile(u User) PublicProfile {
+	return PublicProfile{
+		ID:          u.ID,
+		FullName:    u.FullName,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		Images:      u.Images,
+		Link:        u.Link,
+	}
+}
+
+func NewSelfProfile(u User) SelfProfile {
+	return SelfProfile{
+		PublicProfile:   NewPublicProfile(u),
+		Email:           u.Email,
+		PhoneNumber:     u.PhoneNumber,
+		PhoneVerifiedAt: u.PhoneVerifiedAt,
+		AccountType:     u.AccountType,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+	}
+}
+
+func NewAdminUserView(u User) AdminUserView {
+	rs := AdminUserView{
+		SelfProfile: NewSelfProfile(u),
+		CreatorID:   u.CreatorID,
+		UpdaterID:   u.UpdaterID,
+	}
+	if u.DeletedAt != nil && u.DeletedAt.Valid {
+		rs.DeletedAt = &u.DeletedAt.Time
+	}
+	return rs
+}
+
+// UserSummary is a user without its credentials, as shown to the other services
+type UserSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	FullName    string    `json:"full_name"`
+	DisplayName string    `json:"display_name"`
+	PhoneNumber string    `json:"phone_number"`
+	Bio         string    `json:"bio"`
+	AccountType string    `json:"account_type"`
+	Images      string    `json:"images"`
+	Link        string    `json:"link"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func NewUserSummary(u User) UserSummary {
+	return UserSummary{
+		ID:          u.ID,
+		Email:       u.Email,
+		FullName:    u.FullName,
+		DisplayName: u.DisplayName,
+		PhoneNumber: u.PhoneNumber,
+		Bio:         u.Bio,
+		AccountType: u.AccountType,
+		Images:      u.Images,
+		Link:        u.Link,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+	}
+}
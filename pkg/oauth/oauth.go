@@ -0,0 +1,92 @@
+// Package oauth holds the protocol pieces of the OAuth 2.0 / OpenID Connect authorization server:
+// its error codes, the scopes it knows and the RS256 key signing its ID tokens.
+package oauth
+
+import (
+	"strings"
+
+	"ms-user/pkg/oidc"
+)
+
+// Error codes of RFC 6749 section 5.2 and 4.1.2.1, and RFC 6750 section 3.1
+const (
+	ErrInvalidRequest          = "invalid_request"
+	ErrInvalidClient           = "invalid_client"
+	ErrInvalidGrant            = "invalid_grant"
+	ErrUnauthorizedClient      = "unauthorized_client"
+	ErrUnsupportedGrantType    = "unsupported_grant_type"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	ErrInvalidScope            = "invalid_scope"
+	ErrAccessDenied            = "access_denied"
+	ErrServerError             = "server_error"
+	ErrInvalidToken            = "invalid_token"
+	ErrInsufficientScope       = "insufficient_scope"
+)
+
+// Scopes known to the server, they open the claims of the user in the ID token and at the userinfo endpoint
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedScopes are the scopes a client can be registered for
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// Error is an OAuth error answer, written as {"error": Code, "error_description": Description}
+type Error struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewError(status int, code, description string) *Error {
+	return &Error{Status: status, Code: code, Description: description}
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// ParseScope splits a scope parameter, duplicates are dropped and the order is kept
+func ParseScope(scope string) []string {
+	var rs []string
+	seen := map[string]bool{}
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			rs = append(rs, s)
+		}
+	}
+	return rs
+}
+
+// Subset tells whether every scope of want is in have
+func Subset(want, have []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if w == h {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Discovery is the document of /.well-known/openid-configuration
+type Discovery struct {
+	oidc.Metadata
+	ResponseTypesSupported []string `json:"response_types_supported"`
+	SubjectTypesSupported  []string `json:"subject_types_supported"`
+	GrantTypesSupported    []string `json:"grant_types_supported"`
+	ScopesSupported        []string `json:"scopes_supported"`
+	ClaimsSupported        []string `json:"claims_supported"`
+}
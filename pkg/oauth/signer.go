@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"ms-user/pkg/oidc"
+)
+
+// Signer signs the ID tokens with an RSA key, relying parties check them against JWKS
+type Signer struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// ParseSigner reads a PEM encoded RSA private key, PKCS #1 or PKCS #8, of at least 2048 bits
+func ParseSigner(pemKey string) (*Signer, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(pemKey))
+	if err != nil {
+		return nil, fmt.Errorf("oauth: signing key: %w", err)
+	}
+	return newSigner(key)
+}
+
+// GenerateSigner makes a signer with a new key, the ID tokens it signs can not be checked after a restart
+func GenerateSigner() (*Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(key)
+}
+
+func newSigner(key *rsa.PrivateKey) (*Signer, error) {
+	if key.N.BitLen() < 2048 {
+		return nil, errors.New("oauth: signing key must have at least 2048 bits")
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	// the key id is derived from the key, a new key gets a new id without any setting
+	sum := sha256.Sum256(der)
+	return &Signer{key: key, kid: base64.RawURLEncoding.EncodeToString(sum[:12])}, nil
+}
+
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+// Sign returns the RS256 JWT of claims, its header names the key
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
+
+// JWKS is the key set published at jwks_uri
+func (s *Signer) JWKS() oidc.JSONWebKeySet {
+	return oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{oidc.NewRSAJSONWebKey(s.kid, &s.key.PublicKey)}}
+}
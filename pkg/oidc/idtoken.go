@@ -0,0 +1,113 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// clockSkew is tolerated on the exp and iat claims
+const clockSkew = time.Minute
+
+// signingMethods are the algorithms accepted on ID tokens, never none or a shared secret one
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// IDToken holds the claims of a verified ID token
+type IDToken struct {
+	Issuer        string        `json:"iss"`
+	Subject       string        `json:"sub"`
+	Audience      audience      `json:"aud"`
+	AuthorizedBy  string        `json:"azp,omitempty"`
+	Expiry        int64         `json:"exp"`
+	IssuedAt      int64         `json:"iat"`
+	Nonce         string        `json:"nonce,omitempty"`
+	Email         string        `json:"email,omitempty"`
+	EmailVerified looseBool     `json:"email_verified,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	Picture       string        `json:"picture,omitempty"`
+	Raw           jwt.MapClaims `json:"-"`
+}
+
+// VerifyIDToken checks the signature of raw against the JWKS of the provider, then its issuer,
+// audience, expiry and nonce. nonce is the value sent in the authorization request.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (rs IDToken, err error) {
+	if _, err = p.Metadata(ctx); err != nil {
+		return rs, err
+	}
+
+	parser := jwt.Parser{ValidMethods: signingMethods, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return rs, fmt.Errorf("oidc: id token: %w", err)
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return rs, err
+	}
+	if err = json.Unmarshal(b, &rs); err != nil {
+		return rs, fmt.Errorf("oidc: id token claims: %w", err)
+	}
+	rs.Raw = claims
+
+	now := time.Now()
+	switch {
+	case rs.Issuer != p.cfg.Issuer:
+		return rs, fmt.Errorf("oidc: id token issued by %q, want %q", rs.Issuer, p.cfg.Issuer)
+	case !rs.Audience.contains(p.cfg.ClientID):
+		return rs, errors.New("oidc: id token is not meant for this client")
+	case rs.AuthorizedBy != "" && rs.AuthorizedBy != p.cfg.ClientID:
+		return rs, errors.New("oidc: id token is authorized for another client")
+	case rs.Subject == "":
+		return rs, errors.New("oidc: id token has no subject")
+	case rs.Expiry == 0 || now.After(time.Unix(rs.Expiry, 0).Add(clockSkew)):
+		return rs, errors.New("oidc: id token is expired")
+	case time.Unix(rs.IssuedAt, 0).After(now.Add(clockSkew)):
+		return rs, errors.New("oidc: id token is issued in the future")
+	case subtle.ConstantTimeCompare([]byte(rs.Nonce), []byte(nonce)) != 1:
+		return rs, errors.New("oidc: id token nonce does not match")
+	}
+	return rs, nil
+}
+
+// audience is the aud claim, a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	return contains(a, v)
+}
+
+// looseBool accepts true and "true", some providers send email_verified as a string
+type looseBool bool
+
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often an unknown key id makes the key set be fetched again
+const jwksRefreshInterval = time.Minute
+
+// JSONWebKey is a public key of a JWKS, RSA or EC
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// keySet caches the keys of jwks_uri, it is fetched again when a token is signed by an unknown key,
+// which is how providers rotate their keys
+type keySet struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	uri       string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func (s *keySet) setURI(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uri = uri
+}
+
+// key returns the key kid, a token without kid is accepted when the set has a single key
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(s.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	var set JSONWebKeySet
+	if err := getJSON(ctx, s.client, s.uri, &set); err != nil {
+		return nil, fmt.Errorf("oidc: jwks: %w", err)
+	}
+	s.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.PublicKey(); err == nil {
+			s.keys[jwk.Kid] = k
+		}
+	}
+	s.fetchedAt = time.Now()
+
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// PublicKey decodes the key, *rsa.PublicKey or *ecdsa.PublicKey
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("oidc: RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("oidc: EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+}
+
+// NewRSAJSONWebKey encodes an RSA public key for a JWKS
+func NewRSAJSONWebKey(kid string, pub *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("oidc: invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,216 @@
+// Package oidc is an OpenID Connect relying party: discovery, authorization code flow with PKCE
+// and ID token validation against the JWKS of the provider.
+//
+//	p := oidc.NewProvider(oidc.Config{Issuer: "https://accounts.google.com", ClientID: id, ClientSecret: secret})
+//	url, err := p.AuthCodeURL(ctx, state, nonce, oidc.Challenge(verifier), redirectURI)
+//	// ... the browser comes back to redirectURI with code and state
+//	tokens, err := p.Exchange(ctx, code, verifier, redirectURI)
+//	idToken, err := p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultScopes are requested when Config.Scopes is empty
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// HTTPClient calls the provider, a client with a 10s timeout is used when nil
+	HTTPClient *http.Client
+}
+
+// Metadata is the part of the discovery document the relying party uses
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Tokens is the answer of the token endpoint
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Provider is one identity provider. The discovery document is fetched on first use and kept,
+// a failed discovery is retried on the next call.
+type Provider struct {
+	cfg  Config
+	keys *keySet
+
+	mu   sync.Mutex
+	meta *Metadata
+}
+
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, keys: &keySet{client: cfg.HTTPClient}}
+}
+
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// Metadata returns the discovery document of the issuer, fetching it when it is not known yet
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return *p.meta, nil
+	}
+
+	var meta Metadata
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &meta); err != nil {
+		return meta, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	// the document must be the one of the configured issuer, see OpenID Connect Discovery 4.3
+	if meta.Issuer != p.cfg.Issuer {
+		return meta, fmt.Errorf("oidc: discovery: issuer %q does not match %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return meta, errors.New("oidc: discovery: the authorization, token and jwks endpoints are required")
+	}
+	if len(meta.CodeChallengeMethodsSupported) > 0 && !contains(meta.CodeChallengeMethodsSupported, "S256") {
+		return meta, errors.New("oidc: discovery: the provider does not support PKCE with S256")
+	}
+	p.keys.setURI(meta.JWKSURI)
+	p.meta = &meta
+	return meta, nil
+}
+
+// AuthCodeURL is the authorization endpoint URL the browser is sent to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge, redirectURI string) (string, error) {
+	meta, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the authorization code for tokens, verifier is the PKCE code verifier of the request
+func (p *Provider) Exchange(ctx context.Context, code, verifier, redirectURI string) (rs Tokens, err error) {
+	meta, err := p.Metadata(ctx)
+	if err != nil {
+		return rs, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic is the default of the spec, client_secret_post is used when it is the only one offered
+	basic := p.cfg.ClientSecret != "" && (len(meta.TokenEndpointAuthMethodsSupported) == 0 ||
+		contains(meta.TokenEndpointAuthMethodsSupported, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return rs, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return rs, fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return rs, fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &oauthErr)
+		return rs, fmt.Errorf("oidc: token endpoint: status %d: %s %s", resp.StatusCode, oauthErr.Error, oauthErr.ErrorDescription)
+	}
+	if err = json.Unmarshal(body, &rs); err != nil {
+		return rs, fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+	if rs.IDToken == "" {
+		return rs, errors.New("oidc: token endpoint: no id_token in the answer")
+	}
+	return rs, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	return getJSON(ctx, p.cfg.HTTPClient, u, v)
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
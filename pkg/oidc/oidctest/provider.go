@@ -0,0 +1,233 @@
+// Package oidctest runs a fake OpenID Connect provider on httptest, for the tests of relying parties.
+//
+//	idp := oidctest.NewProvider("client-id", "client-secret")
+//	defer idp.Close()
+//	idp.SetUser(oidctest.User{Subject: "123", Email: "bob@example.com", EmailVerified: true})
+//	p := oidc.NewProvider(oidc.Config{Issuer: idp.Issuer(), ClientID: "client-id", ClientSecret: "client-secret"})
+//
+// The authorization endpoint signs in the current user without asking and redirects to redirect_uri
+// with a code; the token endpoint checks the client, the redirect URI and the PKCE verifier.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"ms-user/pkg/oidc"
+)
+
+// User is the account signed in at the fake provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+type Provider struct {
+	*httptest.Server
+
+	clientID     string
+	clientSecret string
+
+	mu     sync.Mutex
+	user   User
+	key    *rsa.PrivateKey
+	kid    string
+	grants map[string]grant
+	seq    int
+	// claimsHook edits the claims of the next ID tokens, e.g. to test the checks of the relying party
+	claimsHook func(jwt.MapClaims)
+}
+
+// NewProvider starts a provider knowing one client; clientSecret "" makes it a public client
+func NewProvider(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		user:         User{Subject: "fake-user", Email: "fake-user@example.com", EmailVerified: true, Name: "Fake User"},
+		grants:       map[string]grant{},
+	}
+	p.RotateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// SetUser changes the account the next authorizations are made for
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = u
+}
+
+// RotateKey signs the next ID tokens with a new key, the JWKS then only lists the new key
+func (p *Provider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.seq++
+	p.kid = fmt.Sprintf("fake-key-%d", p.seq)
+}
+
+// SetClaimsHook lets hook edit the claims of the next ID tokens, nil restores valid tokens
+func (p *Provider) SetClaimsHook(hook func(jwt.MapClaims)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claimsHook = hook
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Metadata{
+		Issuer:                            p.URL,
+		AuthorizationEndpoint:             p.URL + "/authorize",
+		TokenEndpoint:                     p.URL + "/token",
+		JWKSURI:                           p.URL + "/jwks",
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writeJSON(w, http.StatusOK, oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{oidc.NewRSAJSONWebKey(p.kid, &p.key.PublicKey)}})
+}
+
+// authorize signs the current user in and redirects to redirect_uri with a code
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	p.seq++
+	code := fmt.Sprintf("fake-code-%d", p.seq)
+	p.grants[code] = grant{
+		user:          p.user,
+		clientID:      p.clientID,
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || secret != p.clientSecret {
+		oauthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := r.PostForm.Get("code")
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || time.Now().After(g.expiresAt) || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		oauthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.URL,
+		"sub":   g.user.Subject,
+		"aud":   g.clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	if g.user.Email != "" {
+		claims["email"] = g.user.Email
+		claims["email_verified"] = g.user.EmailVerified
+	}
+	if g.user.Name != "" {
+		claims["name"] = g.user.Name
+	}
+	if p.claimsHook != nil {
+		p.claimsHook(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, oidc.Tokens{AccessToken: "fake-access-" + code, TokenType: "Bearer", IDToken: signed, ExpiresIn: 3600})
+}
+
+func oauthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
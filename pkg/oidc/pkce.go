@@ -0,0 +1,27 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// NewVerifier returns a PKCE code verifier, 43 characters of base64url (RFC 7636)
+func NewVerifier() (string, error) {
+	return RandomString(32)
+}
+
+// Challenge is the S256 code challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns n random bytes in base64url, for states and nonces
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
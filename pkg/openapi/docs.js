@@ -0,0 +1,118 @@
+(function () {
+  "use strict";
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) { node.setAttribute(k, attrs[k]); });
+    (children || []).forEach(function (c) {
+      node.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+    });
+    return node;
+  }
+
+  function resolve(spec, obj) {
+    var seen = 0;
+    while (obj && obj.$ref && seen++ < 10) {
+      obj = obj.$ref.replace(/^#\//, "").split("/").reduce(function (o, k) { return o && o[k]; }, spec);
+    }
+    return obj || {};
+  }
+
+  // example builds a sample value of a schema, refs are followed up to depth levels
+  function example(spec, schema, depth) {
+    schema = resolve(spec, schema);
+    if (depth > 6) { return null; }
+    if (schema.example !== undefined) { return schema.example; }
+    if (schema.allOf) {
+      return schema.allOf.reduce(function (acc, s) {
+        var v = example(spec, s, depth + 1);
+        return v && typeof v === "object" ? Object.assign(acc, v) : acc;
+      }, {});
+    }
+    if (schema.oneOf) { return example(spec, schema.oneOf[0], depth + 1); }
+    if (schema.type === "array") { return [example(spec, schema.items, depth + 1)]; }
+    if (schema.type === "object" || schema.properties) {
+      var out = {};
+      Object.keys(schema.properties || {}).forEach(function (k) {
+        out[k] = example(spec, schema.properties[k], depth + 1);
+      });
+      return out;
+    }
+    if (schema.enum) { return schema.enum[0]; }
+    if (schema.format === "uuid") { return "00000000-0000-0000-0000-000000000000"; }
+    if (schema.format === "date-time") { return "2024-01-01T00:00:00Z"; }
+    return { string: "string", integer: 0, number: 0, boolean: true }[schema.type] || null;
+  }
+
+  function content(spec, c) {
+    var nodes = [];
+    Object.keys(c || {}).forEach(function (type) {
+      nodes.push(el("div", {}, [type]));
+      if (type.indexOf("json") >= 0 && c[type].schema) {
+        nodes.push(el("pre", {}, [JSON.stringify(example(spec, c[type].schema, 0), null, 2)]));
+      }
+    });
+    return nodes;
+  }
+
+  function operation(spec, path, method, op) {
+    var body = el("div", { "class": "body" }, []);
+    if (op.description) { body.appendChild(el("p", {}, [op.description])); }
+
+    var params = (op.parameters || []).map(function (p) { return resolve(spec, p); });
+    if (params.length) {
+      body.appendChild(el("h4", {}, ["Parameters"]));
+      body.appendChild(el("table", {}, [el("tr", {}, [el("th", {}, ["name"]), el("th", {}, ["in"]), el("th", {}, ["type"]), el("th", {}, ["description"])])]
+        .concat(params.map(function (p) {
+          var s = resolve(spec, p.schema);
+          return el("tr", {}, [el("td", {}, [p.name + (p.required ? " *" : "")]), el("td", {}, [p.in]),
+            el("td", {}, [(s.type || "") + (s.format ? " (" + s.format + ")" : "")]), el("td", {}, [p.description || ""])]);
+        }))));
+    }
+    if (op.requestBody) {
+      body.appendChild(el("h4", {}, ["Request body"]));
+      content(spec, resolve(spec, op.requestBody).content).forEach(function (n) { body.appendChild(n); });
+    }
+    body.appendChild(el("h4", {}, ["Responses"]));
+    Object.keys(op.responses || {}).forEach(function (code) {
+      var r = resolve(spec, op.responses[code]);
+      body.appendChild(el("div", {}, [el("strong", {}, [code]), " " + (r.description || "")]));
+      content(spec, r.content).forEach(function (n) { body.appendChild(n); });
+    });
+
+    var secured = (op.security || spec.security || []).length > 0;
+    return el("details", {}, [
+      el("summary", {}, [el("span", { "class": "method " + method }, [method.toUpperCase()]), path,
+        secured ? el("span", { "class": "lock", title: "requires an access token" }, ["🔒"]) : "",
+        el("span", { "class": "summary" }, [op.summary || ""])]),
+      body
+    ]);
+  }
+
+  function render(spec) {
+    document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+    document.getElementById("description").textContent = spec.info.description || "";
+    var byTag = {};
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      Object.keys(spec.paths[path]).forEach(function (method) {
+        var op = spec.paths[path][method];
+        var tag = (op.tags || ["default"])[0];
+        (byTag[tag] = byTag[tag] || []).push(operation(spec, path, method, op));
+      });
+    });
+    var main = document.getElementById("operations");
+    main.textContent = "";
+    var tags = (spec.tags || []).map(function (t) { return t.name; });
+    Object.keys(byTag).forEach(function (t) { if (tags.indexOf(t) < 0) { tags.push(t); } });
+    tags.forEach(function (tag) {
+      if (!byTag[tag]) { return; }
+      main.appendChild(el("h2", {}, [tag]));
+      byTag[tag].forEach(function (n) { main.appendChild(n); });
+    });
+  }
+
+  fetch("/openapi.json")
+    .then(function (r) { return r.json(); })
+    .then(render)
+    .catch(function (e) { document.getElementById("operations").textContent = "Cannot load /openapi.json: " + e; });
+})();
@@ -0,0 +1,79 @@
+// Package openapi serves the OpenAPI 3 document of the HTTP API and a page to browse it.
+// openapi.json is written by hand, MissingRoutes tells which registered routes it does not describe.
+package openapi
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	//go:embed openapi.json
+	spec []byte
+	//go:embed docs.html
+	docsHTML string
+	//go:embed docs.js
+	docsJS string
+	//go:embed docs.css
+	docsCSS string
+)
+
+// Spec returns the OpenAPI document
+func Spec() []byte {
+	return spec
+}
+
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	}
+}
+
+// DocsHandler serves a page rendering /openapi.json, its script and style are inline
+// and allowed by hash so the page works with a strict Content-Security-Policy
+func DocsHandler() gin.HandlerFunc {
+	page := strings.NewReplacer("{{style}}", docsCSS, "{{script}}", docsJS).Replace(docsHTML)
+	csp := fmt.Sprintf("default-src 'none'; connect-src 'self'; script-src '%s'; style-src '%s'; frame-ancestors 'none'",
+		cspHash(docsJS), cspHash(docsCSS))
+
+	return func(c *gin.Context) {
+		c.Header("Content-Security-Policy", csp)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	}
+}
+
+func cspHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var ginParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+// MissingRoutes lists the routes, as "METHOD /path", that are not described by the document
+func MissingRoutes(routes gin.RoutesInfo) ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("parse openapi.json: %w", err)
+	}
+
+	var missing []string
+	for _, r := range routes {
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		if _, ok := doc.Paths[path][strings.ToLower(r.Method)]; !ok {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
@@ -0,0 +1,4 @@
+// Package userv1 holds the code generated from proto/user/v1/user.proto, run go generate after editing it
+package userv1
+
+//go:generate protoc -I ../../../proto --go_out=../../.. --go_opt=module=ms-user --go-grpc_out=../../.. --go-grpc_opt=module=ms-user user/v1/user.proto
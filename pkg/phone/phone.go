@@ -0,0 +1,99 @@
+// Package phone normalizes phone numbers to E.164, e.g. "0912 345 678" in VN is "+84912345678".
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidNumber = errors.New("invalid phone number")
+	ErrUnknownRegion = errors.New("unknown phone region")
+)
+
+// region describes how the numbers of a country are dialed nationally
+type region struct {
+	code string
+	// trunk is the prefix dialed before national numbers, dropped in E.164
+	trunk string
+	// minLen and maxLen bound the national significant number
+	minLen, maxLen int
+}
+
+var regions = map[string]region{
+	"VN": {code: "84", trunk: "0", minLen: 9, maxLen: 10},
+	"US": {code: "1", trunk: "1", minLen: 10, maxLen: 10},
+	"CA": {code: "1", trunk: "1", minLen: 10, maxLen: 10},
+	"GB": {code: "44", trunk: "0", minLen: 9, maxLen: 10},
+	"SG": {code: "65", minLen: 8, maxLen: 8},
+	"TH": {code: "66", trunk: "0", minLen: 8, maxLen: 9},
+	"MY": {code: "60", trunk: "0", minLen: 8, maxLen: 10},
+	"ID": {code: "62", trunk: "0", minLen: 8, maxLen: 12},
+	"PH": {code: "63", trunk: "0", minLen: 8, maxLen: 10},
+	"KH": {code: "855", trunk: "0", minLen: 8, maxLen: 9},
+	"LA": {code: "856", trunk: "0", minLen: 8, maxLen: 10},
+	"JP": {code: "81", trunk: "0", minLen: 9, maxLen: 10},
+	"KR": {code: "82", trunk: "0", minLen: 8, maxLen: 10},
+	"CN": {code: "86", trunk: "0", minLen: 10, maxLen: 11},
+	"AU": {code: "61", trunk: "0", minLen: 9, maxLen: 9},
+	"FR": {code: "33", trunk: "0", minLen: 9, maxLen: 9},
+}
+
+// KnownRegion tells whether Normalize accepts region, an ISO 3166 alpha-2 code
+func KnownRegion(r string) bool {
+	_, ok := regions[strings.ToUpper(r)]
+	return ok
+}
+
+// Normalize returns raw in E.164. Numbers without a + or 00 prefix are read as national numbers of defaultRegion.
+// Spaces, dashes, dots and parentheses are ignored.
+func Normalize(raw, defaultRegion string) (string, error) {
+	s := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '\t':
+			return -1
+		}
+		return r
+	}, raw)
+
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s, international = s[1:], true
+	case strings.HasPrefix(s, "00"):
+		s, international = s[2:], true
+	}
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return "", ErrInvalidNumber
+	}
+
+	if international {
+		if s[0] == '0' || len(s) < 8 || len(s) > 15 {
+			return "", ErrInvalidNumber
+		}
+		// "+84 0912..." is a common way to write "+84 912..."
+		for _, reg := range regions {
+			if reg.trunk == "0" && strings.HasPrefix(s, reg.code+"0") {
+				return check(reg, s[len(reg.code)+1:])
+			}
+		}
+		return "+" + s, nil
+	}
+
+	reg, ok := regions[strings.ToUpper(defaultRegion)]
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	// national significant numbers never start with 0, the 1 of NANP is a trunk prefix only on 11 digits
+	if reg.trunk != "" && strings.HasPrefix(s, reg.trunk) && (reg.trunk == "0" || len(s) > reg.maxLen) {
+		s = s[len(reg.trunk):]
+	}
+	return check(reg, s)
+}
+
+func check(reg region, national string) (string, error) {
+	if len(national) < reg.minLen || len(national) > reg.maxLen || national[0] == '0' {
+		return "", ErrInvalidNumber
+	}
+	return "+" + reg.code + national, nil
+}
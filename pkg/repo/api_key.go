@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+func (r *RepoPG) CreateAPIKey(ctx context.Context, req *model.APIKey, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateAPIKey")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		if isUniqueViolation(err) {
+			log.WithError(err).Error("error_409: prefix taken in CreateAPIKey - RepoPG")
+			return ginext.NewError(http.StatusConflict, APIKeyPrefixTakenMessage)
+		}
+		// the error of the database is logged only, the answer does not show the schema
+		log.WithError(err).Error("error_500: error CreateAPIKey - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, "Cannot create the API key")
+	}
+	return nil
+}
+
+func (r *RepoPG) GetAPIKeyByPrefix(ctx context.Context, prefix string, tx *gorm.DB) (rs model.APIKey, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetAPIKeyByPrefix")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("prefix = ?", prefix).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetAPIKeyByPrefix - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListAPIKeys returns the keys of the user, the latest created first
+func (r *RepoPG) ListAPIKeys(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.APIKey, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListAPIKeys")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("user_id = ?", userID).Order("created_at DESC, id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListAPIKeys - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteAPIKey deletes a key of the user, ok is false when the user has no such key
+func (r *RepoPG) DeleteAPIKey(ctx context.Context, userID, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteAPIKey")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&model.APIKey{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteAPIKey - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// TouchAPIKey records that the key was used at a time
+func (r *RepoPG) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.TouchAPIKey")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Model(&model.APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error; err != nil {
+		log.WithError(err).Error("error_500: error TouchAPIKey - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+)
+
+// auditChainLockKey identifies the advisory lock serializing audit chain writers
+const auditChainLockKey = 7_310_001
+
+// LockAuditChain makes concurrent writers of the audit chain, the chain workers of every instance,
+// wait for each other until tx ends; sqlite already serializes writers
+func (r *RepoPG) LockAuditChain(ctx context.Context, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.LockAuditChain")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+	if tx.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockKey).Error; err != nil {
+		log.WithError(err).Error("error_500: error LockAuditChain - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) CreatePendingAuditEvent(ctx context.Context, req *model.PendingAuditEvent, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreatePendingAuditEvent")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreatePendingAuditEvent - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// GetPendingAuditEvents returns the first limit events waiting to be chained, in chain order
+func (r *RepoPG) GetPendingAuditEvents(ctx context.Context, limit int, tx *gorm.DB) (rs []model.PendingAuditEvent, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetPendingAuditEvents")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	rs = []model.PendingAuditEvent{}
+	if err = tx.Order("occurred_at ASC, id ASC").Limit(limit).Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error GetPendingAuditEvents - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+func (r *RepoPG) DeletePendingAuditEvents(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.DeletePendingAuditEvents")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := tx.Where("id IN ?", ids).Delete(&model.PendingAuditEvent{}).Error; err != nil {
+		log.WithError(err).Error("error_500: error DeletePendingAuditEvents - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetLastAuditEvent(ctx context.Context, tx *gorm.DB) (rs model.AuditEvent, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetLastAuditEvent")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Model(&model.AuditEvent{}).Order("seq DESC").First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetLastAuditEvent - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+func (r *RepoPG) CreateAuditEvent(ctx context.Context, req *model.AuditEvent, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateAuditEvent")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateAuditEvent - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) ListAuditEvents(ctx context.Context, filter model.AuditFilter, tx *gorm.DB) (rs []model.AuditEvent, meta ginext.BodyMeta, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListAuditEvents")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	page := r.GetPage(filter.Page)
+	pageSize := r.GetPageSize(filter.PageSize)
+	tx = tx.Model(&model.AuditEvent{})
+	if filter.ActorID != nil {
+		tx = tx.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.SubjectID != nil {
+		tx = tx.Where("subject_id = ?", *filter.SubjectID)
+	}
+	if filter.Type != "" {
+		tx = tx.Where("type = ?", filter.Type)
+	}
+	if filter.From != nil {
+		tx = tx.Where("occurred_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		tx = tx.Where("occurred_at < ?", *filter.To)
+	}
+	// share the conditions between count and find
+	tx = tx.Session(&gorm.Session{})
+
+	var total int64
+	if err = tx.Count(&total).Error; err != nil {
+		log.WithError(err).Error("error_500: error count ListAuditEvents - RepoPG")
+		return nil, nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	if err = tx.Order("seq ASC").Offset(r.GetOffset(page, pageSize)).Limit(pageSize).Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListAuditEvents - RepoPG")
+		return nil, nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+
+	meta, err = r.GetPaginationInfo("", nil, int(total), page, pageSize)
+	return rs, meta, err
+}
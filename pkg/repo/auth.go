@@ -4,22 +4,23 @@ import (
 	"context"
 	"github.com/google/uuid"
 	"gitlab.com/goxp/cloud0/ginext"
-	"gitlab.com/goxp/cloud0/logger"
 	"gorm.io/gorm"
 	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
 	"ms-user/pkg/utils"
 	"net/http"
 )
 
+// DeleteRefreshToken revokes the refresh tokens of the sign-in of userID, those of OAuth clients are kept
 func (r *RepoPG) DeleteRefreshToken(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
-	log := logger.WithCtx(ctx, "RepoPG.DeleteListBusiness")
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteListBusiness")
 	var cancel context.CancelFunc
 	if tx == nil {
 		tx, cancel = r.DBWithTimeout(ctx)
 		defer cancel()
 	}
 
-	if err := tx.Debug().Where("user_id = ?", userID).Delete(&model.RefreshToken{}).Error; err != nil {
+	if err := tx.Debug().Where("user_id = ? AND client_id = ''", userID).Delete(&model.RefreshToken{}).Error; err != nil {
 		log.WithError(err).Error("error_500 when call func DeleteRefreshToken")
 		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
 	}
@@ -28,7 +29,7 @@ func (r *RepoPG) DeleteRefreshToken(ctx context.Context, userID uuid.UUID, tx *g
 }
 
 func (r *RepoPG) CreateRefreshToken(ctx context.Context, req *model.RefreshToken, tx *gorm.DB) error {
-	log := logger.WithCtx(ctx, "RepoPG.CreateRefreshToken")
+	log := tracing.WithCtx(ctx, "RepoPG.CreateRefreshToken")
 	var cancel context.CancelFunc
 	if tx == nil {
 		tx, cancel = r.DBWithTimeout(ctx)
@@ -42,3 +43,73 @@ func (r *RepoPG) CreateRefreshToken(ctx context.Context, req *model.RefreshToken
 
 	return nil
 }
+
+// GetRefreshTokenBySign returns gorm.ErrRecordNotFound when the token was revoked or rotated
+func (r *RepoPG) GetRefreshTokenBySign(ctx context.Context, sign string, tx *gorm.DB) (rs model.RefreshToken, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetRefreshTokenBySign")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	err = tx.Where("sign = ?", sign).First(&rs).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.WithError(err).Error("error_500 when call func GetRefreshTokenBySign")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, err
+}
+
+// RevokeRefreshToken deletes the token, ok is false when it was already revoked
+func (r *RepoPG) RevokeRefreshToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.RevokeRefreshToken")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("id = ?", id).Delete(&model.RefreshToken{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500 when call func RevokeRefreshToken")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// DeleteOAuthRefreshTokens revokes the refresh tokens issued to clientID, of every user when userID is nil
+func (r *RepoPG) DeleteOAuthRefreshTokens(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteOAuthRefreshTokens")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Where("client_id = ?", clientID)
+	if userID != nil {
+		tx = tx.Where("user_id = ?", *userID)
+	}
+	if err := tx.Delete(&model.RefreshToken{}).Error; err != nil {
+		log.WithError(err).Error("error_500 when call func DeleteOAuthRefreshTokens")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// DeleteUserRefreshTokens revokes every refresh token of the user, those of OAuth clients included
+func (r *RepoPG) DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteUserRefreshTokens")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RefreshToken{}).Error; err != nil {
+		log.WithError(err).Error("error_500 when call func DeleteUserRefreshTokens")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
@@ -3,9 +3,9 @@ package repo
 import (
 	"context"
 	"fmt"
-	"gitlab.com/goxp/cloud0/logger"
 	"math"
 	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
 	"runtime/debug"
 	"time"
 
@@ -36,12 +36,116 @@ type PGInterface interface {
 
 	TestMsUser(ctx context.Context) (err error)
 	GetOneUserByEmail(ctx context.Context, email string, tx *gorm.DB) (rs model.User, err error)
+	GetOneUserByPhone(ctx context.Context, phone string, tx *gorm.DB) (rs model.User, err error)
 	CreateUser(ctx context.Context, req *model.User, tx *gorm.DB) error
+	UpdateUserPhone(ctx context.Context, userID uuid.UUID, phone string, verifiedAt time.Time, tx *gorm.DB) error
+	UpdateUserProfile(ctx context.Context, req *model.User, tx *gorm.DB) error
+	SoftDeleteUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error
+	RestoreUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error
 
 	// refresh token
 	DeleteRefreshToken(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error
 	CreateRefreshToken(ctx context.Context, req *model.RefreshToken, tx *gorm.DB) error
+	GetRefreshTokenBySign(ctx context.Context, sign string, tx *gorm.DB) (rs model.RefreshToken, err error)
+	RevokeRefreshToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error)
+	DeleteOAuthRefreshTokens(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) error
+	DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error
 	GetOneUserByID(ctx context.Context, ID uuid.UUID, tx *gorm.DB) (res model.User, err error)
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) (rs []model.User, err error)
+	GetUsersByEmails(ctx context.Context, emails []string, tx *gorm.DB) (rs []model.User, err error)
+
+	// audit
+	LockAuditChain(ctx context.Context, tx *gorm.DB) error
+	CreatePendingAuditEvent(ctx context.Context, req *model.PendingAuditEvent, tx *gorm.DB) error
+	GetPendingAuditEvents(ctx context.Context, limit int, tx *gorm.DB) (rs []model.PendingAuditEvent, err error)
+	DeletePendingAuditEvents(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) error
+	GetLastAuditEvent(ctx context.Context, tx *gorm.DB) (rs model.AuditEvent, err error)
+	CreateAuditEvent(ctx context.Context, req *model.AuditEvent, tx *gorm.DB) error
+	ListAuditEvents(ctx context.Context, filter model.AuditFilter, tx *gorm.DB) (rs []model.AuditEvent, meta ginext.BodyMeta, err error)
+
+	// login history
+	CreateLoginHistory(ctx context.Context, req *model.LoginHistory, tx *gorm.DB) error
+	ListLoginHistory(ctx context.Context, userID uuid.UUID, limit int, tx *gorm.DB) (rs []model.LoginHistory, err error)
+	CountSuccessfulLogins(ctx context.Context, userID uuid.UUID, fingerprint string, tx *gorm.DB) (count int64, err error)
+	CountLoginFailures(ctx context.Context, userID uuid.UUID, reason string, since time.Time, tx *gorm.DB) (count int64, err error)
+
+	// phone otp
+	CreatePhoneOTP(ctx context.Context, req *model.PhoneOTP, tx *gorm.DB) error
+	GetLatestPhoneOTP(ctx context.Context, phone, purpose string, tx *gorm.DB) (rs model.PhoneOTP, err error)
+	CountPhoneOTPsSince(ctx context.Context, phone string, since time.Time, tx *gorm.DB) (count int64, err error)
+	IncrementPhoneOTPAttempts(ctx context.Context, id uuid.UUID, tx *gorm.DB) (attempts int, err error)
+	ConsumePhoneOTP(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error)
+
+	// magic link
+	CreateMagicLink(ctx context.Context, req *model.MagicLink, tx *gorm.DB) error
+	GetMagicLink(ctx context.Context, id uuid.UUID, tx *gorm.DB) (rs model.MagicLink, err error)
+	CountMagicLinksSince(ctx context.Context, userID uuid.UUID, since time.Time, tx *gorm.DB) (count int64, err error)
+	ConsumeMagicLink(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error)
+
+	// oidc
+	CreateUserIdentity(ctx context.Context, req *model.UserIdentity, tx *gorm.DB) error
+	GetUserIdentity(ctx context.Context, provider, subject string, tx *gorm.DB) (rs model.UserIdentity, err error)
+	DeleteUserIdentities(ctx context.Context, provider string, tx *gorm.DB) (count int64, err error)
+	CreateOIDCAuthRequest(ctx context.Context, req *model.OIDCAuthRequest, tx *gorm.DB) error
+	GetOIDCAuthRequestByState(ctx context.Context, stateHash string, tx *gorm.DB) (rs model.OIDCAuthRequest, err error)
+	ConsumeOIDCAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error)
+
+	// oauth
+	CreateOAuthClient(ctx context.Context, req *model.OAuthClient, tx *gorm.DB) error
+	GetOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (rs model.OAuthClient, err error)
+	ListOAuthClients(ctx context.Context, tx *gorm.DB) (rs []model.OAuthClient, err error)
+	DeleteOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (ok bool, err error)
+	CreateOAuthAuthorizationCode(ctx context.Context, req *model.OAuthAuthorizationCode, tx *gorm.DB) error
+	GetOAuthAuthorizationCode(ctx context.Context, codeHash string, tx *gorm.DB) (rs model.OAuthAuthorizationCode, err error)
+	ConsumeOAuthAuthorizationCode(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error)
+	SaveOAuthConsent(ctx context.Context, req *model.OAuthConsent, tx *gorm.DB) error
+	GetOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string, tx *gorm.DB) (rs model.OAuthConsent, err error)
+	ListOAuthConsents(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.OAuthConsent, err error)
+	DeleteOAuthConsents(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) (count int64, err error)
+
+	// saml
+	SaveSAMLConnection(ctx context.Context, req *model.SAMLConnection, tx *gorm.DB) error
+	GetSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (rs model.SAMLConnection, err error)
+	ListSAMLConnections(ctx context.Context, tx *gorm.DB) (rs []model.SAMLConnection, err error)
+	DeleteSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (ok bool, err error)
+	CreateSAMLAuthRequest(ctx context.Context, req *model.SAMLAuthRequest, tx *gorm.DB) error
+	GetSAMLAuthRequestByRelayState(ctx context.Context, relayStateHash string, tx *gorm.DB) (rs model.SAMLAuthRequest, err error)
+	ConsumeSAMLAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error)
+
+	// scim
+	CreateSCIMToken(ctx context.Context, req *model.SCIMToken, tx *gorm.DB) error
+	GetSCIMTokenByHash(ctx context.Context, tokenHash string, tx *gorm.DB) (rs model.SCIMToken, err error)
+	ListSCIMTokens(ctx context.Context, businessID *uuid.UUID, tx *gorm.DB) (rs []model.SCIMToken, err error)
+	DeleteSCIMToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error)
+	CreateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error
+	GetSCIMUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs model.SCIMUser, err error)
+	GetSCIMUserByEmail(ctx context.Context, businessID uuid.UUID, email string, tx *gorm.DB) (rs model.SCIMUser, err error)
+	ListSCIMUsers(ctx context.Context, businessID uuid.UUID, filter model.SCIMUserFilter, tx *gorm.DB) (rs []model.SCIMUser, err error)
+	UpdateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error
+	CreateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error
+	GetSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (rs model.SCIMGroup, err error)
+	ListSCIMGroups(ctx context.Context, businessID uuid.UUID, filter model.SCIMGroupFilter, tx *gorm.DB) (rs []model.SCIMGroup, err error)
+	UpdateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error
+	DeleteSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (ok bool, err error)
+	SetSCIMGroupMembers(ctx context.Context, groupID, businessID uuid.UUID, userIDs []uuid.UUID, tx *gorm.DB) error
+	ListSCIMGroupMembers(ctx context.Context, businessID uuid.UUID, groupIDs, userIDs []uuid.UUID, tx *gorm.DB) (rs []model.SCIMGroupMember, err error)
+	DeleteSCIMGroupMembersOfUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error
+
+	// api keys
+	CreateAPIKey(ctx context.Context, req *model.APIKey, tx *gorm.DB) error
+	GetAPIKeyByPrefix(ctx context.Context, prefix string, tx *gorm.DB) (rs model.APIKey, err error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.APIKey, err error)
+	DeleteAPIKey(ctx context.Context, userID, id uuid.UUID, tx *gorm.DB) (ok bool, err error)
+	TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) error
+
+	// outbox
+	CreateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error
+	GetDueOutboxMessages(ctx context.Context, now time.Time, limit int, tx *gorm.DB) (rs []model.OutboxMessage, err error)
+	UpdateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error
+	GetOldestPendingOutboxMessage(ctx context.Context, tx *gorm.DB) (rs model.OutboxMessage, err error)
+
+	// purge
+	PurgeExpired(ctx context.Context, before time.Time, tx *gorm.DB) (rs map[string]int64, err error)
 }
 
 type BaseModel struct {
@@ -112,7 +216,7 @@ func (r *RepoPG) GetPaginationInfo(query string, tx *gorm.DB, totalRow, page, pa
 }
 
 func (r *RepoPG) Transaction(ctx context.Context, f func(rp PGInterface) error) (err error) {
-	log := logger.WithCtx(ctx, "RepoPG.Transaction")
+	log := tracing.WithCtx(ctx, "RepoPG.Transaction")
 	tx, cancel := r.DBWithTimeout(ctx)
 	defer cancel()
 	// create new instance to run the transaction
@@ -0,0 +1,85 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/pkg/handlers"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/repo/repotest"
+	"ms-user/pkg/utils"
+)
+
+// TestPGRepoConformance runs against the database of TEST_DB_DSN, it is skipped without one
+func TestPGRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, repotest.OpenPostgres)
+}
+
+// TestSQLiteRepoConformance runs the Postgres repository on the DB_DRIVER=sqlite database
+func TestSQLiteRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, func(t *testing.T) repo.PGInterface {
+		db, err := repo.OpenSQLite(filepath.Join(t.TempDir(), "ms_user.db"))
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		if err = handlers.NewMigrationHandler(db).MigrateDB(); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		t.Cleanup(func() {
+			if sqlDB, err := db.DB(); err == nil {
+				_ = sqlDB.Close()
+			}
+		})
+		return repo.NewPGRepo(db)
+	})
+}
+
+// TestSQLiteRepoHidesDatabaseErrors calls the repository on a database without tables:
+// the answers are 500s which do not show the error of the database
+func TestSQLiteRepoHidesDatabaseErrors(t *testing.T) {
+	utils.LoadMessageError()
+	db, err := repo.OpenSQLite(filepath.Join(t.TempDir(), "ms_user.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	r := repo.NewPGRepo(db)
+	ctx := context.Background()
+
+	_, errPrefix := r.GetAPIKeyByPrefix(ctx, "0123456789abcdef", nil)
+	_, errList := r.ListAPIKeys(ctx, uuid.New(), nil)
+	_, errDelete := r.DeleteAPIKey(ctx, uuid.New(), uuid.New(), nil)
+	_, errSCIM := r.GetSCIMUser(ctx, uuid.New(), nil)
+	_, errSAML := r.GetSAMLConnection(ctx, uuid.New(), nil)
+	_, errAudit := r.GetLastAuditEvent(ctx, nil)
+	for name, err := range map[string]error{
+		"GetAPIKeyByPrefix": errPrefix,
+		"ListAPIKeys":       errList,
+		"DeleteAPIKey":      errDelete,
+		"TouchAPIKey":       r.TouchAPIKey(ctx, uuid.New(), time.Now(), nil),
+		"GetSCIMUser":       errSCIM,
+		"GetSAMLConnection": errSAML,
+		"GetLastAuditEvent": errAudit,
+	} {
+		var apiErr ginext.ApiError
+		if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusInternalServerError {
+			t.Errorf("%s err = %v, want a 500", name, err)
+			continue
+		}
+		if msg := err.Error(); strings.Contains(msg, "no such table") || msg != utils.MessageError()[http.StatusInternalServerError] {
+			t.Errorf("%s answers %q, want %q", name, msg, utils.MessageError()[http.StatusInternalServerError])
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package repo
+
+import (
+	"errors"
+	"strings"
+)
+
+// AccountExistsMessage is the message of the 409 answered when an email is already used by another account
+const AccountExistsMessage = "This account has been existed"
+
+// PhoneTakenMessage is the message of the 409 answered when a phone number is verified by another account
+const PhoneTakenMessage = "This phone number is used by another account"
+
+// IdentityLinkedMessage is the message of the 409 answered when an account of a provider is linked to another user
+const IdentityLinkedMessage = "This account of the provider is linked to another user"
+
+// ClientExistsMessage is the message of the 409 answered when an OAuth client_id is already registered
+const ClientExistsMessage = "This client_id is already registered"
+
+// APIKeyPrefixTakenMessage is the message of the 409 answered when the lookup prefix of a new API key is used by another key
+const APIKeyPrefixTakenMessage = "This API key prefix is used by another key"
+
+// isUniqueViolation tells whether err was raised by a unique index, of Postgres (SQLSTATE 23505) or SQLite
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
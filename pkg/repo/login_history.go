@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+func (r *RepoPG) CreateLoginHistory(ctx context.Context, req *model.LoginHistory, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateLoginHistory")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateLoginHistory - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// ListLoginHistory returns the last limit sign-ins of the user, most recent first
+func (r *RepoPG) ListLoginHistory(ctx context.Context, userID uuid.UUID, limit int, tx *gorm.DB) (rs []model.LoginHistory, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListLoginHistory")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	rs = []model.LoginHistory{}
+	if err = tx.Where("user_id = ?", userID).Order("occurred_at DESC").Limit(limit).Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListLoginHistory - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// CountSuccessfulLogins counts the successful sign-ins of the user, only from fingerprint when it is not empty
+func (r *RepoPG) CountSuccessfulLogins(ctx context.Context, userID uuid.UUID, fingerprint string, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.CountSuccessfulLogins")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Model(&model.LoginHistory{}).Where("user_id = ? AND success = ?", userID, true)
+	if fingerprint != "" {
+		tx = tx.Where("fingerprint = ?", fingerprint)
+	}
+	if err = tx.Count(&count).Error; err != nil {
+		log.WithError(err).Error("error_500: error CountSuccessfulLogins - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return count, nil
+}
+
+// CountLoginFailures counts the failed sign-ins of the user for reason after since and after its last successful sign-in
+func (r *RepoPG) CountLoginFailures(ctx context.Context, userID uuid.UUID, reason string, since time.Time, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.CountLoginFailures")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	lastSuccess := r.DB.Model(&model.LoginHistory{}).Select("MAX(occurred_at)").Where("user_id = ? AND success = ?", userID, true)
+	err = tx.Model(&model.LoginHistory{}).
+		Where("user_id = ? AND success = ? AND reason = ? AND occurred_at > ?", userID, false, reason, since).
+		Where("occurred_at > COALESCE((?), ?)", lastSuccess, since).
+		Count(&count).Error
+	if err != nil {
+		log.WithError(err).Error("error_500: error CountLoginFailures - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return count, nil
+}
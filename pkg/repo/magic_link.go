@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+func (r *RepoPG) CreateMagicLink(ctx context.Context, req *model.MagicLink, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateMagicLink")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateMagicLink - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetMagicLink(ctx context.Context, id uuid.UUID, tx *gorm.DB) (rs model.MagicLink, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetMagicLink")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("id = ?", id).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetMagicLink - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// CountMagicLinksSince counts the links sent to the user since a time
+func (r *RepoPG) CountMagicLinksSince(ctx context.Context, userID uuid.UUID, since time.Time, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.CountMagicLinksSince")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Model(&model.MagicLink{}).Where("user_id = ? AND created_at >= ?", userID, since).Count(&count).Error; err != nil {
+		log.WithError(err).Error("error_500: error CountMagicLinksSince - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return count, nil
+}
+
+// ConsumeMagicLink marks the link used, ok is false when it was already used
+func (r *RepoPG) ConsumeMagicLink(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ConsumeMagicLink")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.MagicLink{}).Where("id = ? AND consumed_at IS NULL", id).UpdateColumn("consumed_at", at)
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error ConsumeMagicLink - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
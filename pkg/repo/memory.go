@@ -0,0 +1,1479 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+)
+
+// NewMemoryRepo returns a PGInterface that keeps everything in process memory.
+// It is meant for tests and local runs where no Postgres is available.
+func NewMemoryRepo() PGInterface {
+	return &RepoMemory{
+		mu:    &sync.RWMutex{},
+		store: newMemoryStore(),
+	}
+}
+
+type memoryStore struct {
+	users         map[uuid.UUID]model.User
+	refreshTokens map[uuid.UUID]model.RefreshToken
+	auditEvents   []model.AuditEvent
+	auditPending  map[uuid.UUID]model.PendingAuditEvent
+	loginHistory  []model.LoginHistory
+	outbox        map[uuid.UUID]model.OutboxMessage
+	phoneOTPs     map[uuid.UUID]model.PhoneOTP
+	magicLinks    map[uuid.UUID]model.MagicLink
+	identities    map[uuid.UUID]model.UserIdentity
+	oidcRequests  map[uuid.UUID]model.OIDCAuthRequest
+	oauthClients  map[uuid.UUID]model.OAuthClient
+	oauthCodes    map[uuid.UUID]model.OAuthAuthorizationCode
+	oauthConsents map[uuid.UUID]model.OAuthConsent
+	samlConns     map[uuid.UUID]model.SAMLConnection
+	samlRequests  map[uuid.UUID]model.SAMLAuthRequest
+	scimTokens    map[uuid.UUID]model.SCIMToken
+	scimUsers     map[uuid.UUID]model.SCIMUser
+	scimGroups    map[uuid.UUID]model.SCIMGroup
+	scimMembers   []model.SCIMGroupMember
+	apiKeys       map[uuid.UUID]model.APIKey
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         map[uuid.UUID]model.User{},
+		refreshTokens: map[uuid.UUID]model.RefreshToken{},
+		auditPending:  map[uuid.UUID]model.PendingAuditEvent{},
+		outbox:        map[uuid.UUID]model.OutboxMessage{},
+		phoneOTPs:     map[uuid.UUID]model.PhoneOTP{},
+		magicLinks:    map[uuid.UUID]model.MagicLink{},
+		identities:    map[uuid.UUID]model.UserIdentity{},
+		oidcRequests:  map[uuid.UUID]model.OIDCAuthRequest{},
+		oauthClients:  map[uuid.UUID]model.OAuthClient{},
+		oauthCodes:    map[uuid.UUID]model.OAuthAuthorizationCode{},
+		oauthConsents: map[uuid.UUID]model.OAuthConsent{},
+		samlConns:     map[uuid.UUID]model.SAMLConnection{},
+		samlRequests:  map[uuid.UUID]model.SAMLAuthRequest{},
+		scimTokens:    map[uuid.UUID]model.SCIMToken{},
+		scimUsers:     map[uuid.UUID]model.SCIMUser{},
+		scimGroups:    map[uuid.UUID]model.SCIMGroup{},
+		apiKeys:       map[uuid.UUID]model.APIKey{},
+	}
+}
+
+func (s *memoryStore) clone() *memoryStore {
+	c := newMemoryStore()
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.refreshTokens {
+		c.refreshTokens[k] = v
+	}
+	c.auditEvents = append(c.auditEvents, s.auditEvents...)
+	for k, v := range s.auditPending {
+		c.auditPending[k] = v
+	}
+	c.loginHistory = append(c.loginHistory, s.loginHistory...)
+	for k, v := range s.outbox {
+		c.outbox[k] = v
+	}
+	for k, v := range s.phoneOTPs {
+		c.phoneOTPs[k] = v
+	}
+	for k, v := range s.magicLinks {
+		c.magicLinks[k] = v
+	}
+	for k, v := range s.identities {
+		c.identities[k] = v
+	}
+	for k, v := range s.oidcRequests {
+		c.oidcRequests[k] = v
+	}
+	for k, v := range s.oauthClients {
+		c.oauthClients[k] = v
+	}
+	for k, v := range s.oauthCodes {
+		c.oauthCodes[k] = v
+	}
+	for k, v := range s.oauthConsents {
+		c.oauthConsents[k] = v
+	}
+	for k, v := range s.samlConns {
+		c.samlConns[k] = v
+	}
+	for k, v := range s.samlRequests {
+		c.samlRequests[k] = v
+	}
+	for k, v := range s.scimTokens {
+		c.scimTokens[k] = v
+	}
+	for k, v := range s.scimUsers {
+		c.scimUsers[k] = v
+	}
+	for k, v := range s.scimGroups {
+		c.scimGroups[k] = v
+	}
+	c.scimMembers = append(c.scimMembers, s.scimMembers...)
+	for k, v := range s.apiKeys {
+		c.apiKeys[k] = v
+	}
+	return c
+}
+
+// RepoMemory is the in-memory counterpart of RepoPG.
+// The tx arguments are ignored: a transaction works on its own copy of the store,
+// which replaces the parent store only when the transaction succeeds.
+type RepoMemory struct {
+	mu    *sync.RWMutex
+	store *memoryStore
+}
+
+func (r *RepoMemory) DBWithTimeout(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	return nil, func() {}
+}
+
+func (r *RepoMemory) Transaction(ctx context.Context, f func(rp PGInterface) error) (err error) {
+	log := tracing.WithCtx(ctx, "RepoMemory.Transaction")
+	// transactions are serialized, the parent store is locked until commit or rollback
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo := &RepoMemory{
+		mu:    &sync.RWMutex{},
+		store: r.store.clone(),
+	}
+	defer func() {
+		if rc := recover(); rc != nil {
+			err = errors.New(fmt.Sprint(rc))
+			log.WithError(err).Error("error_500: Panic when run Transaction")
+			return
+		}
+		if err != nil {
+			return
+		}
+		r.store = repo.store
+	}()
+	err = f(repo)
+	if err != nil {
+		log.WithError(err).Error("error_500: Error when run Transaction")
+		return err
+	}
+	return nil
+}
+
+func (r *RepoMemory) TestMsUser(ctx context.Context) (err error) {
+	log := tracing.WithCtx(ctx, "RepoMemory.TestMsUser")
+
+	log.Info("RepoMemory: Test ms-user success")
+
+	return nil
+}
+
+func (r *RepoMemory) GetOneUserByEmail(ctx context.Context, email string, tx *gorm.DB) (rs model.User, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := model.NormalizedEmailOf(email)
+	for _, u := range r.store.users {
+		if u.DeletedAt == nil && sameKey(u.EmailNormalized, key) {
+			return u, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) GetOneUserByPhone(ctx context.Context, phone string, tx *gorm.DB) (rs model.User, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.store.users {
+		if u.DeletedAt == nil && sameKey(u.PhoneE164, &phone) {
+			return u, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) GetOneUserByID(ctx context.Context, ID uuid.UUID, tx *gorm.DB) (rs model.User, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.store.users[ID]
+	if !ok || u.DeletedAt != nil {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return u, nil
+}
+
+func (r *RepoMemory) GetUsersByIDs(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) (rs []model.User, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		if u, ok := r.store.users[id]; ok && u.DeletedAt == nil && !seen[id] {
+			seen[id] = true
+			rs = append(rs, u)
+		}
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) GetUsersByEmails(ctx context.Context, emails []string, tx *gorm.DB) (rs []model.User, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		wanted[model.NormalizeEmail(e)] = true
+	}
+	for _, u := range r.store.users {
+		if u.DeletedAt == nil && u.EmailNormalized != nil && wanted[*u.EmailNormalized] {
+			rs = append(rs, u)
+		}
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) CreateUser(ctx context.Context, req *model.User, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req.EmailNormalized = model.NormalizedEmailOf(req.Email)
+	if r.takenLocked(req.ID, req.EmailNormalized, req.PhoneE164) {
+		return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+	}
+	if err := r.initBaseModel(&req.BaseModel, r.store.users[req.ID].ID); err != nil {
+		return err
+	}
+	r.store.users[req.ID] = *req
+	req.Password = ""
+
+	return nil
+}
+
+func (r *RepoMemory) UpdateUserPhone(ctx context.Context, userID uuid.UUID, phone string, verifiedAt time.Time, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.store.users[userID]
+	if !ok || u.DeletedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	if r.takenLocked(userID, nil, &phone) {
+		return ginext.NewError(http.StatusConflict, PhoneTakenMessage)
+	}
+	u.PhoneNumber, u.PhoneE164, u.PhoneVerifiedAt, u.UpdatedAt = phone, &phone, &verifiedAt, time.Now()
+	r.store.users[userID] = u
+	return nil
+}
+
+func (r *RepoMemory) UpdateUserProfile(ctx context.Context, req *model.User, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.store.users[req.ID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	email := model.NormalizedEmailOf(req.Email)
+	if u.DeletedAt == nil && r.takenLocked(req.ID, email, nil) {
+		return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+	}
+	req.UpdatedAt = time.Now()
+	u.Email, u.EmailNormalized, u.FullName, u.DisplayName = req.Email, email, req.FullName, req.DisplayName
+	u.PhoneNumber, u.Images, u.Link, u.UpdatedAt = req.PhoneNumber, req.Images, req.Link, req.UpdatedAt
+	r.store.users[req.ID] = u
+	return nil
+}
+
+func (r *RepoMemory) SoftDeleteUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.store.users[userID]; ok && u.DeletedAt == nil {
+		u.DeletedAt = &gorm.DeletedAt{Time: time.Now(), Valid: true}
+		r.store.users[userID] = u
+	}
+	return nil
+}
+
+func (r *RepoMemory) RestoreUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.store.users[userID]
+	if !ok || u.DeletedAt == nil {
+		return nil
+	}
+	if r.takenLocked(userID, u.EmailNormalized, u.PhoneE164) {
+		return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+	}
+	u.DeletedAt = nil
+	r.store.users[userID] = u
+	return nil
+}
+
+// takenLocked plays the unique indexes of email_normalized and phone_e164 for the user id
+func (r *RepoMemory) takenLocked(id uuid.UUID, email, phone *string) bool {
+	for _, u := range r.store.users {
+		if u.ID != id && u.DeletedAt == nil && (sameKey(u.EmailNormalized, email) || sameKey(u.PhoneE164, phone)) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameKey(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+func (r *RepoMemory) DeleteRefreshToken(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.store.refreshTokens {
+		if t.UserID == userID && t.ClientID == "" {
+			delete(r.store.refreshTokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *RepoMemory) CreateRefreshToken(ctx context.Context, req *model.RefreshToken, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.initBaseModel(&req.BaseModel, r.store.refreshTokens[req.ID].ID); err != nil {
+		return err
+	}
+	r.store.refreshTokens[req.ID] = *req
+
+	return nil
+}
+
+func (r *RepoMemory) GetRefreshTokenBySign(ctx context.Context, sign string, tx *gorm.DB) (rs model.RefreshToken, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.store.refreshTokens {
+		if t.Sign == sign {
+			return t, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) RevokeRefreshToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.store.refreshTokens[id]; !found {
+		return false, nil
+	}
+	delete(r.store.refreshTokens, id)
+	return true, nil
+}
+
+func (r *RepoMemory) DeleteOAuthRefreshTokens(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.store.refreshTokens {
+		if t.ClientID == clientID && (userID == nil || t.UserID == *userID) {
+			delete(r.store.refreshTokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *RepoMemory) DeleteUserRefreshTokens(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.store.refreshTokens {
+		if t.UserID == userID {
+			delete(r.store.refreshTokens, id)
+		}
+	}
+	return nil
+}
+
+// LockAuditChain is a no-op, transactions on the memory store are already serialized
+func (r *RepoMemory) LockAuditChain(ctx context.Context, tx *gorm.DB) error {
+	return nil
+}
+
+func (r *RepoMemory) CreatePendingAuditEvent(ctx context.Context, req *model.PendingAuditEvent, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if _, ok := r.store.auditPending[req.ID]; ok {
+		return ginext.NewError(http.StatusInternalServerError, "duplicate key value violates unique constraint: audit_pending_events")
+	}
+	r.store.auditPending[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetPendingAuditEvents(ctx context.Context, limit int, tx *gorm.DB) (rs []model.PendingAuditEvent, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs = []model.PendingAuditEvent{}
+	for _, ev := range r.store.auditPending {
+		rs = append(rs, ev)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].OccurredAt.Equal(rs[j].OccurredAt) {
+			return rs[i].OccurredAt.Before(rs[j].OccurredAt)
+		}
+		return rs[i].ID.String() < rs[j].ID.String()
+	})
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) DeletePendingAuditEvents(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		delete(r.store.auditPending, id)
+	}
+	return nil
+}
+
+func (r *RepoMemory) GetLastAuditEvent(ctx context.Context, tx *gorm.DB) (rs model.AuditEvent, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.store.auditEvents) == 0 {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return r.store.auditEvents[len(r.store.auditEvents)-1], nil
+}
+
+func (r *RepoMemory) CreateAuditEvent(ctx context.Context, req *model.AuditEvent, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ev := range r.store.auditEvents {
+		if ev.ID == req.ID || ev.Seq == req.Seq {
+			return ginext.NewError(http.StatusInternalServerError, "duplicate key value violates unique constraint: audit_events")
+		}
+	}
+	// keep the slice ordered by seq like the ORDER BY of RepoPG
+	idx := sort.Search(len(r.store.auditEvents), func(i int) bool { return r.store.auditEvents[i].Seq > req.Seq })
+	r.store.auditEvents = append(r.store.auditEvents, model.AuditEvent{})
+	copy(r.store.auditEvents[idx+1:], r.store.auditEvents[idx:])
+	r.store.auditEvents[idx] = *req
+	return nil
+}
+
+func (r *RepoMemory) ListAuditEvents(ctx context.Context, filter model.AuditFilter, tx *gorm.DB) (rs []model.AuditEvent, meta ginext.BodyMeta, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs = []model.AuditEvent{}
+	matched := make([]model.AuditEvent, 0)
+	for _, ev := range r.store.auditEvents {
+		switch {
+		case filter.ActorID != nil && (ev.ActorID == nil || *ev.ActorID != *filter.ActorID):
+		case filter.SubjectID != nil && (ev.SubjectID == nil || *ev.SubjectID != *filter.SubjectID):
+		case filter.Type != "" && ev.Type != filter.Type:
+		case filter.From != nil && ev.OccurredAt.Before(*filter.From):
+		case filter.To != nil && !ev.OccurredAt.Before(*filter.To):
+		default:
+			matched = append(matched, ev)
+		}
+	}
+
+	// reuse the pagination rules of RepoPG, they do not touch the DB
+	pg := &RepoPG{}
+	page := pg.GetPage(filter.Page)
+	pageSize := pg.GetPageSize(filter.PageSize)
+	offset := pg.GetOffset(page, pageSize)
+	if offset < len(matched) {
+		end := offset + pageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		rs = matched[offset:end]
+	}
+	meta, err = pg.GetPaginationInfo("", nil, len(matched), page, pageSize)
+	return rs, meta, err
+}
+
+func (r *RepoMemory) CreateLoginHistory(ctx context.Context, req *model.LoginHistory, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.loginHistory = append(r.store.loginHistory, *req)
+	return nil
+}
+
+func (r *RepoMemory) ListLoginHistory(ctx context.Context, userID uuid.UUID, limit int, tx *gorm.DB) (rs []model.LoginHistory, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs = []model.LoginHistory{}
+	for _, h := range r.store.loginHistory {
+		if h.UserID == userID {
+			rs = append(rs, h)
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].OccurredAt.After(rs[j].OccurredAt) })
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) CountSuccessfulLogins(ctx context.Context, userID uuid.UUID, fingerprint string, tx *gorm.DB) (count int64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, h := range r.store.loginHistory {
+		if h.UserID == userID && h.Success && (fingerprint == "" || h.Fingerprint == fingerprint) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) CountLoginFailures(ctx context.Context, userID uuid.UUID, reason string, since time.Time, tx *gorm.DB) (count int64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, h := range r.store.loginHistory {
+		if h.UserID == userID && h.Success && h.OccurredAt.After(since) {
+			since = h.OccurredAt
+		}
+	}
+	for _, h := range r.store.loginHistory {
+		if h.UserID == userID && !h.Success && h.Reason == reason && h.OccurredAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) CreateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.outbox[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetDueOutboxMessages(ctx context.Context, now time.Time, limit int, tx *gorm.DB) (rs []model.OutboxMessage, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs = []model.OutboxMessage{}
+	for _, m := range r.store.outbox {
+		if m.SentAt == nil && m.FailedAt == nil && !m.NextAttemptAt.After(now) {
+			rs = append(rs, m)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].NextAttemptAt.Before(rs[j].NextAttemptAt) })
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) UpdateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store.outbox[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetOldestPendingOutboxMessage(ctx context.Context, tx *gorm.DB) (rs model.OutboxMessage, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := false
+	for _, m := range r.store.outbox {
+		if m.SentAt == nil && m.FailedAt == nil && (!found || m.CreatedAt.Before(rs.CreatedAt)) {
+			rs, found = m, true
+		}
+	}
+	if !found {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) PurgeExpired(ctx context.Context, before time.Time, tx *gorm.DB) (rs map[string]int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rs = map[string]int64{}
+	for id, o := range r.store.phoneOTPs {
+		if o.ExpiresAt.Before(before) {
+			delete(r.store.phoneOTPs, id)
+			rs[model.PhoneOTP{}.TableName()]++
+		}
+	}
+	for id, l := range r.store.magicLinks {
+		if l.ExpiresAt.Before(before) {
+			delete(r.store.magicLinks, id)
+			rs[model.MagicLink{}.TableName()]++
+		}
+	}
+	for id, a := range r.store.oidcRequests {
+		if a.ExpiresAt.Before(before) {
+			delete(r.store.oidcRequests, id)
+			rs[model.OIDCAuthRequest{}.TableName()]++
+		}
+	}
+	for id, a := range r.store.samlRequests {
+		if a.ExpiresAt.Before(before) {
+			delete(r.store.samlRequests, id)
+			rs[model.SAMLAuthRequest{}.TableName()]++
+		}
+	}
+	for id, c := range r.store.oauthCodes {
+		if c.ExpiresAt.Before(before) {
+			delete(r.store.oauthCodes, id)
+			rs[model.OAuthAuthorizationCode{}.TableName()]++
+		}
+	}
+	// revoked tokens are deleted from the store right away
+	for id, t := range r.store.refreshTokens {
+		if t.ExpiredAt.Before(before) {
+			delete(r.store.refreshTokens, id)
+			rs["refresh_tokens"]++
+		}
+	}
+	return rs, nil
+}
+
+// initBaseModel fills the columns Postgres would default, existing is the ID already stored under req.ID
+func (r *RepoMemory) initBaseModel(m *model.BaseModel, existing uuid.UUID) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	} else if existing != uuid.Nil {
+		return ginext.NewError(http.StatusInternalServerError, "duplicate key value violates unique constraint: "+m.ID.String())
+	}
+	now := time.Now()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	if m.UpdatedAt.IsZero() {
+		m.UpdatedAt = now
+	}
+	return nil
+}
+
+func (r *RepoMemory) CreatePhoneOTP(ctx context.Context, req *model.PhoneOTP, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.phoneOTPs[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetLatestPhoneOTP(ctx context.Context, phone, purpose string, tx *gorm.DB) (rs model.PhoneOTP, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := false
+	for _, o := range r.store.phoneOTPs {
+		if o.Phone == phone && o.Purpose == purpose && (!found || o.CreatedAt.After(rs.CreatedAt)) {
+			rs, found = o, true
+		}
+	}
+	if !found {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) CountPhoneOTPsSince(ctx context.Context, phone string, since time.Time, tx *gorm.DB) (count int64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, o := range r.store.phoneOTPs {
+		if o.Phone == phone && !o.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) IncrementPhoneOTPAttempts(ctx context.Context, id uuid.UUID, tx *gorm.DB) (attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.store.phoneOTPs[id]
+	if !ok {
+		return 0, nil
+	}
+	o.Attempts++
+	r.store.phoneOTPs[id] = o
+	return o.Attempts, nil
+}
+
+func (r *RepoMemory) ConsumePhoneOTP(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, found := r.store.phoneOTPs[id]
+	if !found || o.ConsumedAt != nil {
+		return false, nil
+	}
+	o.ConsumedAt = &at
+	r.store.phoneOTPs[id] = o
+	return true, nil
+}
+
+func (r *RepoMemory) CreateMagicLink(ctx context.Context, req *model.MagicLink, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.magicLinks[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetMagicLink(ctx context.Context, id uuid.UUID, tx *gorm.DB) (rs model.MagicLink, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs, ok := r.store.magicLinks[id]
+	if !ok {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return rs, nil
+}
+
+func (r *RepoMemory) CountMagicLinksSince(ctx context.Context, userID uuid.UUID, since time.Time, tx *gorm.DB) (count int64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, l := range r.store.magicLinks {
+		if l.UserID == userID && !l.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) ConsumeMagicLink(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, found := r.store.magicLinks[id]
+	if !found || l.ConsumedAt != nil {
+		return false, nil
+	}
+	l.ConsumedAt = &at
+	r.store.magicLinks[id] = l
+	return true, nil
+}
+
+func (r *RepoMemory) CreateUserIdentity(ctx context.Context, req *model.UserIdentity, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, i := range r.store.identities {
+		if i.Provider == req.Provider && i.Subject == req.Subject {
+			return ginext.NewError(http.StatusConflict, IdentityLinkedMessage)
+		}
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.identities[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetUserIdentity(ctx context.Context, provider, subject string, tx *gorm.DB) (rs model.UserIdentity, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, i := range r.store.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) DeleteUserIdentities(ctx context.Context, provider string, tx *gorm.DB) (count int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, i := range r.store.identities {
+		if i.Provider == provider {
+			delete(r.store.identities, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) CreateOIDCAuthRequest(ctx context.Context, req *model.OIDCAuthRequest, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.oidcRequests[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetOIDCAuthRequestByState(ctx context.Context, stateHash string, tx *gorm.DB) (rs model.OIDCAuthRequest, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, a := range r.store.oidcRequests {
+		if a.StateHash == stateHash {
+			return a, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ConsumeOIDCAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, found := r.store.oidcRequests[id]
+	if !found || a.ConsumedAt != nil {
+		return false, nil
+	}
+	a.ConsumedAt = &at
+	r.store.oidcRequests[id] = a
+	return true, nil
+}
+
+func (r *RepoMemory) CreateOAuthClient(ctx context.Context, req *model.OAuthClient, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.store.oauthClients {
+		if c.ClientID == req.ClientID {
+			return ginext.NewError(http.StatusConflict, ClientExistsMessage)
+		}
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.oauthClients[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (rs model.OAuthClient, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.oauthClients {
+		if c.ClientID == clientID {
+			return c, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ListOAuthClients(ctx context.Context, tx *gorm.DB) (rs []model.OAuthClient, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.oauthClients {
+		rs = append(rs, c)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+		}
+		return rs[i].ClientID < rs[j].ClientID
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.store.oauthClients {
+		if c.ClientID == clientID {
+			delete(r.store.oauthClients, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *RepoMemory) CreateOAuthAuthorizationCode(ctx context.Context, req *model.OAuthAuthorizationCode, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.oauthCodes[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetOAuthAuthorizationCode(ctx context.Context, codeHash string, tx *gorm.DB) (rs model.OAuthAuthorizationCode, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.oauthCodes {
+		if c.CodeHash == codeHash {
+			return c, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ConsumeOAuthAuthorizationCode(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, found := r.store.oauthCodes[id]
+	if !found || c.ConsumedAt != nil {
+		return false, nil
+	}
+	c.ConsumedAt = &at
+	r.store.oauthCodes[id] = c
+	return true, nil
+}
+
+func (r *RepoMemory) SaveOAuthConsent(ctx context.Context, req *model.OAuthConsent, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.store.oauthConsents {
+		if c.UserID == req.UserID && c.ClientID == req.ClientID {
+			c.Scope = req.Scope
+			c.UpdatedAt = req.UpdatedAt
+			r.store.oauthConsents[id] = c
+			return nil
+		}
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.oauthConsents[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string, tx *gorm.DB) (rs model.OAuthConsent, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.oauthConsents {
+		if c.UserID == userID && c.ClientID == clientID {
+			return c, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ListOAuthConsents(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.OAuthConsent, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.oauthConsents {
+		if c.UserID == userID {
+			rs = append(rs, c)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].UpdatedAt.Equal(rs[j].UpdatedAt) {
+			return rs[i].UpdatedAt.After(rs[j].UpdatedAt)
+		}
+		return rs[i].ClientID < rs[j].ClientID
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteOAuthConsents(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) (count int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.store.oauthConsents {
+		if c.ClientID == clientID && (userID == nil || c.UserID == *userID) {
+			delete(r.store.oauthConsents, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *RepoMemory) SaveSAMLConnection(ctx context.Context, req *model.SAMLConnection, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.store.samlConns {
+		if c.BusinessID == req.BusinessID {
+			saved := *req
+			saved.ID, saved.CreatedBy, saved.CreatedAt = c.ID, c.CreatedBy, c.CreatedAt
+			r.store.samlConns[id] = saved
+			return nil
+		}
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.samlConns[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (rs model.SAMLConnection, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.samlConns {
+		if c.BusinessID == businessID {
+			return c, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ListSAMLConnections(ctx context.Context, tx *gorm.DB) (rs []model.SAMLConnection, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.store.samlConns {
+		rs = append(rs, c)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+		}
+		return rs[i].BusinessID.String() < rs[j].BusinessID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, c := range r.store.samlConns {
+		if c.BusinessID == businessID {
+			delete(r.store.samlConns, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *RepoMemory) CreateSAMLAuthRequest(ctx context.Context, req *model.SAMLAuthRequest, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.samlRequests[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetSAMLAuthRequestByRelayState(ctx context.Context, relayStateHash string, tx *gorm.DB) (rs model.SAMLAuthRequest, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, a := range r.store.samlRequests {
+		if a.RelayStateHash == relayStateHash {
+			return a, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ConsumeSAMLAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, found := r.store.samlRequests[id]
+	if !found || a.ConsumedAt != nil {
+		return false, nil
+	}
+	a.ConsumedAt = &at
+	r.store.samlRequests[id] = a
+	return true, nil
+}
+
+func (r *RepoMemory) CreateSCIMToken(ctx context.Context, req *model.SCIMToken, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.scimTokens[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetSCIMTokenByHash(ctx context.Context, tokenHash string, tx *gorm.DB) (rs model.SCIMToken, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.store.scimTokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ListSCIMTokens(ctx context.Context, businessID *uuid.UUID, tx *gorm.DB) (rs []model.SCIMToken, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.store.scimTokens {
+		if businessID == nil || t.BusinessID == *businessID {
+			rs = append(rs, t)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+		}
+		return rs[i].ID.String() < rs[j].ID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteSCIMToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.store.scimTokens[id]; !found {
+		return false, nil
+	}
+	delete(r.store.scimTokens, id)
+	return true, nil
+}
+
+func (r *RepoMemory) CreateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.store.scimUsers[req.UserID]; found {
+		return ginext.NewError(http.StatusInternalServerError, "duplicate key value violates unique constraint: "+req.UserID.String())
+	}
+	saved := *req
+	saved.User = model.User{}
+	r.store.scimUsers[req.UserID] = saved
+	return nil
+}
+
+// withUserLocked plays the preload of the user of a membership, soft-deleted or not
+func (r *RepoMemory) withUserLocked(m model.SCIMUser) model.SCIMUser {
+	m.User = r.store.users[m.UserID]
+	return m
+}
+
+func (r *RepoMemory) GetSCIMUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs model.SCIMUser, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.store.scimUsers[userID]
+	if !ok {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return r.withUserLocked(m), nil
+}
+
+func (r *RepoMemory) GetSCIMUserByEmail(ctx context.Context, businessID uuid.UUID, email string, tx *gorm.DB) (rs model.SCIMUser, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key := model.NormalizedEmailOf(email)
+	found := false
+	for _, m := range r.store.scimUsers {
+		if m.BusinessID != businessID || !sameKey(r.store.users[m.UserID].EmailNormalized, key) {
+			continue
+		}
+		// the membership still provisioned first, then the latest one
+		active, bestActive := m.DeprovisionedAt == nil, rs.DeprovisionedAt == nil
+		if !found || active && !bestActive || active == bestActive && m.UpdatedAt.After(rs.UpdatedAt) {
+			rs, found = m, true
+		}
+	}
+	if !found {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return r.withUserLocked(rs), nil
+}
+
+func (r *RepoMemory) ListSCIMUsers(ctx context.Context, businessID uuid.UUID, filter model.SCIMUserFilter, tx *gorm.DB) (rs []model.SCIMUser, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	email := model.NormalizedEmailOf(filter.Email)
+	for _, m := range r.store.scimUsers {
+		if m.BusinessID != businessID || m.DeprovisionedAt != nil {
+			continue
+		}
+		if filter.Email != "" && !sameKey(r.store.users[m.UserID].EmailNormalized, email) {
+			continue
+		}
+		if filter.ExternalID != "" && m.ExternalID != filter.ExternalID {
+			continue
+		}
+		rs = append(rs, r.withUserLocked(m))
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+		}
+		return rs[i].UserID.String() < rs[j].UserID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) UpdateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.store.scimUsers[req.UserID]
+	if !ok {
+		return nil
+	}
+	req.UpdatedAt = time.Now()
+	m.BusinessID, m.ExternalID, m.GivenName, m.FamilyName = req.BusinessID, req.ExternalID, req.GivenName, req.FamilyName
+	m.DeprovisionedAt, m.UpdatedAt = req.DeprovisionedAt, req.UpdatedAt
+	r.store.scimUsers[req.UserID] = m
+	return nil
+}
+
+func (r *RepoMemory) CreateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.scimGroups[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (rs model.SCIMGroup, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	g, ok := r.store.scimGroups[id]
+	if !ok || g.BusinessID != businessID {
+		return rs, gorm.ErrRecordNotFound
+	}
+	return g, nil
+}
+
+func (r *RepoMemory) ListSCIMGroups(ctx context.Context, businessID uuid.UUID, filter model.SCIMGroupFilter, tx *gorm.DB) (rs []model.SCIMGroup, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, g := range r.store.scimGroups {
+		if g.BusinessID != businessID {
+			continue
+		}
+		if filter.DisplayName != "" && !strings.EqualFold(g.DisplayName, filter.DisplayName) {
+			continue
+		}
+		if filter.ExternalID != "" && g.ExternalID != filter.ExternalID {
+			continue
+		}
+		rs = append(rs, g)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.Before(rs[j].CreatedAt)
+		}
+		return rs[i].ID.String() < rs[j].ID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) UpdateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.store.scimGroups[req.ID]
+	if !ok {
+		return nil
+	}
+	req.UpdatedAt = time.Now()
+	g.DisplayName, g.ExternalID, g.UpdatedAt = req.DisplayName, req.ExternalID, req.UpdatedAt
+	r.store.scimGroups[req.ID] = g
+	return nil
+}
+
+func (r *RepoMemory) DeleteSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g, found := r.store.scimGroups[id]; !found || g.BusinessID != businessID {
+		return false, nil
+	}
+	delete(r.store.scimGroups, id)
+	r.store.scimMembers = r.keepMembersLocked(func(m model.SCIMGroupMember) bool { return m.GroupID != id })
+	return true, nil
+}
+
+func (r *RepoMemory) SetSCIMGroupMembers(ctx context.Context, groupID, businessID uuid.UUID, userIDs []uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store.scimMembers = r.keepMembersLocked(func(m model.SCIMGroupMember) bool { return m.GroupID != groupID })
+	seen := map[uuid.UUID]bool{}
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			r.store.scimMembers = append(r.store.scimMembers, model.SCIMGroupMember{GroupID: groupID, UserID: id, BusinessID: businessID})
+		}
+	}
+	return nil
+}
+
+func (r *RepoMemory) ListSCIMGroupMembers(ctx context.Context, businessID uuid.UUID, groupIDs, userIDs []uuid.UUID, tx *gorm.DB) (rs []model.SCIMGroupMember, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, m := range r.store.scimMembers {
+		if m.BusinessID == businessID && (groupIDs == nil || containsUUID(groupIDs, m.GroupID)) &&
+			(userIDs == nil || containsUUID(userIDs, m.UserID)) {
+			rs = append(rs, m)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].GroupID != rs[j].GroupID {
+			return rs[i].GroupID.String() < rs[j].GroupID.String()
+		}
+		return rs[i].UserID.String() < rs[j].UserID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteSCIMGroupMembersOfUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store.scimMembers = r.keepMembersLocked(func(m model.SCIMGroupMember) bool { return m.UserID != userID })
+	return nil
+}
+
+// keepMembersLocked returns a copy of the group members that keep returns true for
+func (r *RepoMemory) keepMembersLocked(keep func(m model.SCIMGroupMember) bool) []model.SCIMGroupMember {
+	var kept []model.SCIMGroupMember
+	for _, m := range r.store.scimMembers {
+		if keep(m) {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func containsUUID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RepoMemory) CreateAPIKey(ctx context.Context, req *model.APIKey, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.store.apiKeys {
+		if k.Prefix == req.Prefix {
+			return ginext.NewError(http.StatusConflict, APIKeyPrefixTakenMessage)
+		}
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	r.store.apiKeys[req.ID] = *req
+	return nil
+}
+
+func (r *RepoMemory) GetAPIKeyByPrefix(ctx context.Context, prefix string, tx *gorm.DB) (rs model.APIKey, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.store.apiKeys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return rs, gorm.ErrRecordNotFound
+}
+
+func (r *RepoMemory) ListAPIKeys(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.APIKey, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.store.apiKeys {
+		if k.UserID == userID {
+			rs = append(rs, k)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if !rs[i].CreatedAt.Equal(rs[j].CreatedAt) {
+			return rs[i].CreatedAt.After(rs[j].CreatedAt)
+		}
+		return rs[i].ID.String() < rs[j].ID.String()
+	})
+	return rs, nil
+}
+
+func (r *RepoMemory) DeleteAPIKey(ctx context.Context, userID, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, found := r.store.apiKeys[id]; !found || k.UserID != userID {
+		return false, nil
+	}
+	delete(r.store.apiKeys, id)
+	return true, nil
+}
+
+func (r *RepoMemory) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, found := r.store.apiKeys[id]; found {
+		k.LastUsedAt = &at
+		r.store.apiKeys[id] = k
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package repo_test
+
+import (
+	"testing"
+
+	"ms-user/pkg/repo"
+	"ms-user/pkg/repo/repotest"
+)
+
+func TestMemoryRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, func(*testing.T) repo.PGInterface { return repo.NewMemoryRepo() })
+}
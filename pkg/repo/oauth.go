@@ -0,0 +1,218 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+func (r *RepoPG) CreateOAuthClient(ctx context.Context, req *model.OAuthClient, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateOAuthClient")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		if isUniqueViolation(err) {
+			log.WithError(err).Error("error_409: client_id taken in CreateOAuthClient - RepoPG")
+			return ginext.NewError(http.StatusConflict, ClientExistsMessage)
+		}
+		log.WithError(err).Error("error_500: error CreateOAuthClient - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (rs model.OAuthClient, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOAuthClient")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("client_id = ?", clientID).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetOAuthClient - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListOAuthClients returns every client, the oldest first
+func (r *RepoPG) ListOAuthClients(ctx context.Context, tx *gorm.DB) (rs []model.OAuthClient, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListOAuthClients")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Order("created_at, client_id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListOAuthClients - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteOAuthClient deletes the client, ok is false when it does not exist
+func (r *RepoPG) DeleteOAuthClient(ctx context.Context, clientID string, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteOAuthClient")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("client_id = ?", clientID).Delete(&model.OAuthClient{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteOAuthClient - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *RepoPG) CreateOAuthAuthorizationCode(ctx context.Context, req *model.OAuthAuthorizationCode, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateOAuthAuthorizationCode")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateOAuthAuthorizationCode - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetOAuthAuthorizationCode(ctx context.Context, codeHash string, tx *gorm.DB) (rs model.OAuthAuthorizationCode, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOAuthAuthorizationCode")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("code_hash = ?", codeHash).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetOAuthAuthorizationCode - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ConsumeOAuthAuthorizationCode marks the code used, ok is false when it was already used
+func (r *RepoPG) ConsumeOAuthAuthorizationCode(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ConsumeOAuthAuthorizationCode")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.OAuthAuthorizationCode{}).Where("id = ? AND consumed_at IS NULL", id).UpdateColumn("consumed_at", at)
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error ConsumeOAuthAuthorizationCode - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// SaveOAuthConsent creates the consent of the user to the client or replaces its scope
+func (r *RepoPG) SaveOAuthConsent(ctx context.Context, req *model.OAuthConsent, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.SaveOAuthConsent")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+	}).Create(req).Error
+	if err != nil {
+		log.WithError(err).Error("error_500: error SaveOAuthConsent - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetOAuthConsent(ctx context.Context, userID uuid.UUID, clientID string, tx *gorm.DB) (rs model.OAuthConsent, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOAuthConsent")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("user_id = ? AND client_id = ?", userID, clientID).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetOAuthConsent - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListOAuthConsents returns the consents of the user, the latest granted first
+func (r *RepoPG) ListOAuthConsents(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs []model.OAuthConsent, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListOAuthConsents")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("user_id = ?", userID).Order("updated_at DESC, client_id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListOAuthConsents - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteOAuthConsents deletes the consents given to clientID, of every user when userID is nil
+func (r *RepoPG) DeleteOAuthConsents(ctx context.Context, clientID string, userID *uuid.UUID, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteOAuthConsents")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Where("client_id = ?", clientID)
+	if userID != nil {
+		tx = tx.Where("user_id = ?", *userID)
+	}
+	res := tx.Delete(&model.OAuthConsent{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteOAuthConsents - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+func (r *RepoPG) CreateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateOutboxMessage")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateOutboxMessage - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// GetDueOutboxMessages returns the pending messages to deliver at now, oldest first.
+// On Postgres the rows stay locked until tx ends and rows locked by another worker are skipped.
+func (r *RepoPG) GetDueOutboxMessages(ctx context.Context, now time.Time, limit int, tx *gorm.DB) (rs []model.OutboxMessage, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetDueOutboxMessages")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Where("sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+		Order("next_attempt_at ASC").
+		Limit(limit)
+	if tx.Dialector.Name() == DriverPostgres {
+		tx = tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
+	rs = []model.OutboxMessage{}
+	if err = tx.Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error GetDueOutboxMessages - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+func (r *RepoPG) UpdateOutboxMessage(ctx context.Context, req *model.OutboxMessage, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.UpdateOutboxMessage")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Save(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error UpdateOutboxMessage - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// GetOldestPendingOutboxMessage returns gorm.ErrRecordNotFound when nothing is waiting
+func (r *RepoPG) GetOldestPendingOutboxMessage(ctx context.Context, tx *gorm.DB) (rs model.OutboxMessage, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOldestPendingOutboxMessage")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	err = tx.Where("sent_at IS NULL AND failed_at IS NULL").Order("created_at ASC").First(&rs).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.WithError(err).Error("error_500: error GetOldestPendingOutboxMessage - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, err
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+func (r *RepoPG) CreatePhoneOTP(ctx context.Context, req *model.PhoneOTP, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreatePhoneOTP")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreatePhoneOTP - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// GetLatestPhoneOTP returns the last code sent to phone for purpose, consumed or not
+func (r *RepoPG) GetLatestPhoneOTP(ctx context.Context, phone, purpose string, tx *gorm.DB) (rs model.PhoneOTP, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetLatestPhoneOTP")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("phone = ? AND purpose = ?", phone, purpose).Order("created_at DESC").First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetLatestPhoneOTP - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// CountPhoneOTPsSince counts the codes sent to phone since a time, whatever their purpose
+func (r *RepoPG) CountPhoneOTPsSince(ctx context.Context, phone string, since time.Time, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.CountPhoneOTPsSince")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Model(&model.PhoneOTP{}).Where("phone = ? AND created_at >= ?", phone, since).Count(&count).Error; err != nil {
+		log.WithError(err).Error("error_500: error CountPhoneOTPsSince - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return count, nil
+}
+
+// IncrementPhoneOTPAttempts counts one more guess of the code and returns the new count,
+// the increment is done by the database so concurrent guesses are all counted
+func (r *RepoPG) IncrementPhoneOTPAttempts(ctx context.Context, id uuid.UUID, tx *gorm.DB) (attempts int, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.IncrementPhoneOTPAttempts")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	err = tx.Model(&model.PhoneOTP{}).Where("id = ?", id).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+	if err == nil {
+		err = tx.Model(&model.PhoneOTP{}).Where("id = ?", id).Pluck("attempts", &attempts).Error
+	}
+	if err != nil {
+		log.WithError(err).Error("error_500: error IncrementPhoneOTPAttempts - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return attempts, nil
+}
+
+// ConsumePhoneOTP marks the code used, ok is false when it was already used
+func (r *RepoPG) ConsumePhoneOTP(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ConsumePhoneOTP")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.PhoneOTP{}).Where("id = ? AND consumed_at IS NULL", id).UpdateColumn("consumed_at", at)
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error ConsumePhoneOTP - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"context"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// PurgeExpired deletes the one-time codes, sign-in requests and refresh tokens expired before a time,
+// refresh tokens revoked before it too. It returns the number of rows deleted by table.
+func (r *RepoPG) PurgeExpired(ctx context.Context, before time.Time, tx *gorm.DB) (rs map[string]int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.PurgeExpired")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	rs = map[string]int64{}
+	for _, m := range []interface{ TableName() string }{
+		&model.PhoneOTP{}, &model.MagicLink{}, &model.OIDCAuthRequest{},
+		&model.SAMLAuthRequest{}, &model.OAuthAuthorizationCode{},
+	} {
+		res := tx.Where("expires_at < ?", before).Delete(m)
+		if res.Error != nil {
+			log.WithError(res.Error).Error("error_500: error PurgeExpired - RepoPG")
+			return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+		}
+		rs[m.TableName()] = res.RowsAffected
+	}
+
+	// revoked refresh tokens are soft deleted, Unscoped removes them for good
+	res := tx.Unscoped().Where("expired_at < ? OR deleted_at < ?", before, before).Delete(&model.RefreshToken{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error PurgeExpired - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	rs["refresh_tokens"] = res.RowsAffected
+	return rs, nil
+}
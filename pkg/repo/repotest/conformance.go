@@ -0,0 +1,1370 @@
+// Package repotest holds the behaviour every repo.PGInterface implementation must share.
+// Call RunConformance from a test with a constructor for the implementation under test,
+// OpenPostgres gives the Postgres one when TEST_DB_DSN points at a database.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"ms-user/pkg/handlers"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+)
+
+var errRollback = errors.New("rollback")
+
+// RunConformance runs the shared suite, newRepo must return an empty repository on every call
+func RunConformance(t *testing.T, newRepo func(t *testing.T) repo.PGInterface) {
+	t.Run("CreateAndGetUser", func(t *testing.T) { testCreateAndGetUser(t, newRepo(t)) })
+	t.Run("UserNotFound", func(t *testing.T) { testUserNotFound(t, newRepo(t)) })
+	t.Run("GetUsersByIDs", func(t *testing.T) { testGetUsersByIDs(t, newRepo(t)) })
+	t.Run("GetUsersByEmails", func(t *testing.T) { testGetUsersByEmails(t, newRepo(t)) })
+	t.Run("EmailIdentity", func(t *testing.T) { testEmailIdentity(t, newRepo(t)) })
+	t.Run("UserPhone", func(t *testing.T) { testUserPhone(t, newRepo(t)) })
+	t.Run("PhoneOTP", func(t *testing.T) { testPhoneOTP(t, newRepo(t)) })
+	t.Run("MagicLink", func(t *testing.T) { testMagicLink(t, newRepo(t)) })
+	t.Run("UserIdentity", func(t *testing.T) { testUserIdentity(t, newRepo(t)) })
+	t.Run("OIDCAuthRequest", func(t *testing.T) { testOIDCAuthRequest(t, newRepo(t)) })
+	t.Run("TransactionCommit", func(t *testing.T) { testTransactionCommit(t, newRepo(t)) })
+	t.Run("TransactionRollbackOnError", func(t *testing.T) { testTransactionRollbackOnError(t, newRepo(t)) })
+	t.Run("TransactionRollbackOnPanic", func(t *testing.T) { testTransactionRollbackOnPanic(t, newRepo(t)) })
+	t.Run("RefreshToken", func(t *testing.T) { testRefreshToken(t, newRepo(t)) })
+	t.Run("OAuthRefreshToken", func(t *testing.T) { testOAuthRefreshToken(t, newRepo(t)) })
+	t.Run("OAuthClient", func(t *testing.T) { testOAuthClient(t, newRepo(t)) })
+	t.Run("OAuthAuthorizationCode", func(t *testing.T) { testOAuthAuthorizationCode(t, newRepo(t)) })
+	t.Run("OAuthConsent", func(t *testing.T) { testOAuthConsent(t, newRepo(t)) })
+	t.Run("SAMLConnection", func(t *testing.T) { testSAMLConnection(t, newRepo(t)) })
+	t.Run("SAMLAuthRequest", func(t *testing.T) { testSAMLAuthRequest(t, newRepo(t)) })
+	t.Run("UserLifecycle", func(t *testing.T) { testUserLifecycle(t, newRepo(t)) })
+	t.Run("SCIMToken", func(t *testing.T) { testSCIMToken(t, newRepo(t)) })
+	t.Run("SCIMUser", func(t *testing.T) { testSCIMUser(t, newRepo(t)) })
+	t.Run("SCIMGroup", func(t *testing.T) { testSCIMGroup(t, newRepo(t)) })
+	t.Run("APIKey", func(t *testing.T) { testAPIKey(t, newRepo(t)) })
+	t.Run("AuditEvents", func(t *testing.T) { testAuditEvents(t, newRepo(t)) })
+	t.Run("PendingAuditEvents", func(t *testing.T) { testPendingAuditEvents(t, newRepo(t)) })
+	t.Run("LoginHistory", func(t *testing.T) { testLoginHistory(t, newRepo(t)) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, newRepo(t)) })
+	t.Run("PurgeExpired", func(t *testing.T) { testPurgeExpired(t, newRepo(t)) })
+}
+
+// OpenPostgres connects to TEST_DB_DSN, migrates and truncates the tables, the test is skipped when it is unset
+func OpenPostgres(t *testing.T) repo.PGInterface {
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	migration := handlers.NewMigrationHandler(db)
+	if err = migration.MigrateDB(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// every migrated table, CASCADE follows the foreign keys between them
+	tables, err := migration.Tables()
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	if err = db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return repo.NewPGRepo(db)
+}
+
+func newUser(email string) *model.User {
+	return &model.User{
+		Email:    email,
+		FullName: "Conformance",
+		Password: "hashed",
+	}
+}
+
+func testCreateAndGetUser(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	u := newUser("create@example.com")
+	if err := r.CreateUser(ctx, u, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if u.ID == uuid.Nil {
+		t.Fatal("CreateUser did not assign an ID")
+	}
+	if u.Password != "" {
+		t.Error("CreateUser must clear the password of the request")
+	}
+
+	byEmail, err := r.GetOneUserByEmail(ctx, "create@example.com", nil)
+	if err != nil {
+		t.Fatalf("GetOneUserByEmail: %v", err)
+	}
+	if byEmail.ID != u.ID || byEmail.Password != "hashed" {
+		t.Errorf("GetOneUserByEmail = %+v, want id %s with stored password", byEmail, u.ID)
+	}
+
+	byID, err := r.GetOneUserByID(ctx, u.ID, nil)
+	if err != nil {
+		t.Fatalf("GetOneUserByID: %v", err)
+	}
+	if byID.Email != u.Email || byID.CreatedAt.IsZero() {
+		t.Errorf("GetOneUserByID = %+v", byID)
+	}
+}
+
+func testUserNotFound(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	if _, err := r.GetOneUserByEmail(ctx, "missing@example.com", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOneUserByEmail err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := r.GetOneUserByID(ctx, uuid.New(), nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOneUserByID err = %v, want gorm.ErrRecordNotFound", err)
+	}
+}
+
+func testGetUsersByIDs(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	a, b := newUser("batch-a@example.com"), newUser("batch-b@example.com")
+	for _, u := range []*model.User{a, b} {
+		if err := r.CreateUser(ctx, u, nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	rs, err := r.GetUsersByIDs(ctx, []uuid.UUID{a.ID, uuid.New(), b.ID, a.ID}, nil)
+	if err != nil {
+		t.Fatalf("GetUsersByIDs: %v", err)
+	}
+	found := map[uuid.UUID]string{}
+	for _, u := range rs {
+		found[u.ID] = u.Email
+	}
+	if len(rs) != 2 || found[a.ID] != a.Email || found[b.ID] != b.Email {
+		t.Errorf("GetUsersByIDs = %+v, want %s and %s once", rs, a.ID, b.ID)
+	}
+
+	if rs, err = r.GetUsersByIDs(ctx, nil, nil); err != nil || len(rs) != 0 {
+		t.Errorf("GetUsersByIDs(nil) = %v, %v", rs, err)
+	}
+}
+
+func testGetUsersByEmails(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	a, b := newUser("emails-a@example.com"), newUser("emails-b@example.com")
+	for _, u := range []*model.User{a, b} {
+		if err := r.CreateUser(ctx, u, nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	rs, err := r.GetUsersByEmails(ctx, []string{b.Email, "emails-missing@example.com", a.Email, b.Email}, nil)
+	if err != nil {
+		t.Fatalf("GetUsersByEmails: %v", err)
+	}
+	found := map[string]uuid.UUID{}
+	for _, u := range rs {
+		found[u.Email] = u.ID
+	}
+	if len(rs) != 2 || found[a.Email] != a.ID || found[b.Email] != b.ID {
+		t.Errorf("GetUsersByEmails = %+v, want %s and %s once", rs, a.Email, b.Email)
+	}
+
+	if rs, err = r.GetUsersByEmails(ctx, nil, nil); err != nil || len(rs) != 0 {
+		t.Errorf("GetUsersByEmails(nil) = %v, %v", rs, err)
+	}
+}
+
+func testEmailIdentity(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	u := newUser("Mixed.Case@Example.com")
+	if err := r.CreateUser(ctx, u, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	got, err := r.GetOneUserByEmail(ctx, "  mixed.case@EXAMPLE.com ", nil)
+	if err != nil || got.ID != u.ID || got.Email != "Mixed.Case@Example.com" {
+		t.Errorf("GetOneUserByEmail = %+v, %v, want %s with the email as typed", got, err, u.ID)
+	}
+	if rs, err := r.GetUsersByEmails(ctx, []string{"MIXED.case@example.com"}, nil); err != nil || len(rs) != 1 {
+		t.Errorf("GetUsersByEmails = %+v, %v, want 1 user", rs, err)
+	}
+
+	err = r.CreateUser(ctx, newUser("mixed.case@example.com"), nil)
+	var apiErr ginext.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("CreateUser of the same normalized email err = %v, want a 409", err)
+	}
+}
+
+func testUserPhone(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	a, b := newUser("phone-a@example.com"), newUser("phone-b@example.com")
+	for _, u := range []*model.User{a, b} {
+		if err := r.CreateUser(ctx, u, nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	if _, err := r.GetOneUserByPhone(ctx, "+84912345678", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOneUserByPhone of an unverified number err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	if err := r.UpdateUserPhone(ctx, a.ID, "+84912345678", time.Now(), nil); err != nil {
+		t.Fatalf("UpdateUserPhone: %v", err)
+	}
+	got, err := r.GetOneUserByPhone(ctx, "+84912345678", nil)
+	if err != nil || got.ID != a.ID || got.PhoneNumber != "+84912345678" || got.PhoneVerifiedAt == nil {
+		t.Errorf("GetOneUserByPhone = %+v, %v, want %s with a verified phone", got, err, a.ID)
+	}
+
+	err = r.UpdateUserPhone(ctx, b.ID, "+84912345678", time.Now(), nil)
+	var apiErr ginext.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("UpdateUserPhone of a taken number err = %v, want a 409", err)
+	}
+
+	// accounts created by a phone sign-in have no email
+	phone := "+84987654321"
+	if err = r.CreateUser(ctx, &model.User{PhoneNumber: phone, PhoneE164: &phone}, nil); err != nil {
+		t.Fatalf("CreateUser without email: %v", err)
+	}
+	other := "+84987654322"
+	if err = r.CreateUser(ctx, &model.User{PhoneNumber: other, PhoneE164: &other}, nil); err != nil {
+		t.Errorf("second CreateUser without email: %v", err)
+	}
+}
+
+func testPhoneOTP(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	phone := "+84912345678"
+	if _, err := r.GetLatestPhoneOTP(ctx, phone, model.OTPPurposeLogin, nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetLatestPhoneOTP without codes err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	older := &model.PhoneOTP{Phone: phone, Purpose: model.OTPPurposeLogin, CodeHash: "old", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now}
+	latest := &model.PhoneOTP{Phone: phone, Purpose: model.OTPPurposeLogin, CodeHash: "new", CreatedAt: now, ExpiresAt: now.Add(5 * time.Minute)}
+	verify := &model.PhoneOTP{Phone: phone, Purpose: model.OTPPurposeVerify, CodeHash: "verify", CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(5 * time.Minute)}
+	for _, otp := range []*model.PhoneOTP{older, latest, verify} {
+		if err := r.CreatePhoneOTP(ctx, otp, nil); err != nil {
+			t.Fatalf("CreatePhoneOTP: %v", err)
+		}
+	}
+
+	got, err := r.GetLatestPhoneOTP(ctx, phone, model.OTPPurposeLogin, nil)
+	if err != nil || got.ID != latest.ID || got.CodeHash != "new" {
+		t.Errorf("GetLatestPhoneOTP = %+v, %v, want %s", got, err, latest.ID)
+	}
+	if count, err := r.CountPhoneOTPsSince(ctx, phone, now.Add(-time.Hour), nil); err != nil || count != 2 {
+		t.Errorf("CountPhoneOTPsSince = %d, %v, want 2", count, err)
+	}
+
+	for want := 1; want <= 2; want++ {
+		if attempts, err := r.IncrementPhoneOTPAttempts(ctx, latest.ID, nil); err != nil || attempts != want {
+			t.Errorf("IncrementPhoneOTPAttempts = %d, %v, want %d", attempts, err, want)
+		}
+	}
+
+	if ok, err := r.ConsumePhoneOTP(ctx, latest.ID, now, nil); err != nil || !ok {
+		t.Errorf("ConsumePhoneOTP = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.ConsumePhoneOTP(ctx, latest.ID, now, nil); err != nil || ok {
+		t.Errorf("second ConsumePhoneOTP = %v, %v, want false", ok, err)
+	}
+	got, err = r.GetLatestPhoneOTP(ctx, phone, model.OTPPurposeLogin, nil)
+	if err != nil || got.ConsumedAt == nil || got.Attempts != 2 {
+		t.Errorf("GetLatestPhoneOTP after consume = %+v, %v, want consumed after 2 attempts", got, err)
+	}
+}
+
+func testMagicLink(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	userID := uuid.New()
+	if _, err := r.GetMagicLink(ctx, uuid.New(), nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetMagicLink of an unknown id err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	old := &model.MagicLink{UserID: userID, NonceHash: "old", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now}
+	link := &model.MagicLink{UserID: userID, NonceHash: "nonce", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)}
+	other := &model.MagicLink{UserID: uuid.New(), NonceHash: "other", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)}
+	for _, l := range []*model.MagicLink{old, link, other} {
+		if err := r.CreateMagicLink(ctx, l, nil); err != nil {
+			t.Fatalf("CreateMagicLink: %v", err)
+		}
+	}
+
+	got, err := r.GetMagicLink(ctx, link.ID, nil)
+	if err != nil || got.UserID != userID || got.NonceHash != "nonce" || got.ConsumedAt != nil {
+		t.Errorf("GetMagicLink = %+v, %v, want the unused link of %s", got, err, userID)
+	}
+	if count, err := r.CountMagicLinksSince(ctx, userID, now.Add(-time.Hour), nil); err != nil || count != 1 {
+		t.Errorf("CountMagicLinksSince = %d, %v, want 1", count, err)
+	}
+
+	if ok, err := r.ConsumeMagicLink(ctx, link.ID, now, nil); err != nil || !ok {
+		t.Errorf("ConsumeMagicLink = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.ConsumeMagicLink(ctx, link.ID, now, nil); err != nil || ok {
+		t.Errorf("second ConsumeMagicLink = %v, %v, want false", ok, err)
+	}
+	if got, err = r.GetMagicLink(ctx, link.ID, nil); err != nil || got.ConsumedAt == nil {
+		t.Errorf("GetMagicLink after consume = %+v, %v, want it consumed", got, err)
+	}
+}
+
+func testUserIdentity(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	if _, err := r.GetUserIdentity(ctx, "google", "123", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetUserIdentity of an unknown subject err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	userID := uuid.New()
+	identity := &model.UserIdentity{UserID: userID, Provider: "google", Subject: "123", Email: "bob@example.com", CreatedAt: time.Now()}
+	if err := r.CreateUserIdentity(ctx, identity, nil); err != nil {
+		t.Fatalf("CreateUserIdentity: %v", err)
+	}
+	// the same subject at another provider is another identity
+	if err := r.CreateUserIdentity(ctx, &model.UserIdentity{UserID: uuid.New(), Provider: "microsoft", Subject: "123", CreatedAt: time.Now()}, nil); err != nil {
+		t.Fatalf("CreateUserIdentity of another provider: %v", err)
+	}
+	err := r.CreateUserIdentity(ctx, &model.UserIdentity{UserID: uuid.New(), Provider: "google", Subject: "123", CreatedAt: time.Now()}, nil)
+	var apiErr ginext.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("CreateUserIdentity of a linked subject err = %v, want a 409", err)
+	}
+
+	got, err := r.GetUserIdentity(ctx, "google", "123", nil)
+	if err != nil || got.ID != identity.ID || got.UserID != userID || got.Email != "bob@example.com" {
+		t.Errorf("GetUserIdentity = %+v, %v, want %+v", got, err, identity)
+	}
+
+	if n, err := r.DeleteUserIdentities(ctx, "google", nil); err != nil || n != 1 {
+		t.Errorf("DeleteUserIdentities = %d, %v, want 1", n, err)
+	}
+	if _, err = r.GetUserIdentity(ctx, "google", "123", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetUserIdentity after delete err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if _, err = r.GetUserIdentity(ctx, "microsoft", "123", nil); err != nil {
+		t.Errorf("DeleteUserIdentities deleted the identity of another provider: %v", err)
+	}
+}
+
+func testOIDCAuthRequest(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	if _, err := r.GetOIDCAuthRequestByState(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOIDCAuthRequestByState of an unknown state err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	req := &model.OIDCAuthRequest{Provider: "google", StateHash: "state", Nonce: "nonce", CodeVerifier: "verifier",
+		DeviceID: "device", CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)}
+	other := &model.OIDCAuthRequest{Provider: "google", StateHash: "other", Nonce: "n", CodeVerifier: "v",
+		CreatedAt: now, ExpiresAt: now.Add(10 * time.Minute)}
+	for _, a := range []*model.OIDCAuthRequest{req, other} {
+		if err := r.CreateOIDCAuthRequest(ctx, a, nil); err != nil {
+			t.Fatalf("CreateOIDCAuthRequest: %v", err)
+		}
+	}
+
+	got, err := r.GetOIDCAuthRequestByState(ctx, "state", nil)
+	if err != nil || got.ID != req.ID || got.Nonce != "nonce" || got.CodeVerifier != "verifier" || got.ConsumedAt != nil {
+		t.Errorf("GetOIDCAuthRequestByState = %+v, %v, want the unused request %s", got, err, req.ID)
+	}
+	if ok, err := r.ConsumeOIDCAuthRequest(ctx, req.ID, now, nil); err != nil || !ok {
+		t.Errorf("ConsumeOIDCAuthRequest = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.ConsumeOIDCAuthRequest(ctx, req.ID, now, nil); err != nil || ok {
+		t.Errorf("second ConsumeOIDCAuthRequest = %v, %v, want false", ok, err)
+	}
+	if got, err = r.GetOIDCAuthRequestByState(ctx, "state", nil); err != nil || got.ConsumedAt == nil {
+		t.Errorf("GetOIDCAuthRequestByState after consume = %+v, %v, want it consumed", got, err)
+	}
+}
+
+func testTransactionCommit(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	err := r.Transaction(ctx, func(rp repo.PGInterface) error {
+		return rp.CreateUser(ctx, newUser("commit@example.com"), nil)
+	})
+	if err != nil {
+		t.Fatalf("Transaction: %v", err)
+	}
+	if _, err = r.GetOneUserByEmail(ctx, "commit@example.com", nil); err != nil {
+		t.Errorf("user not visible after commit: %v", err)
+	}
+}
+
+func testTransactionRollbackOnError(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	err := r.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, newUser("rollback@example.com"), nil); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Transaction err = %v, want %v", err, errRollback)
+	}
+	if _, err = r.GetOneUserByEmail(ctx, "rollback@example.com", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("user visible after rollback, err = %v", err)
+	}
+}
+
+func testTransactionRollbackOnPanic(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	err := r.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, newUser("panic@example.com"), nil); err != nil {
+			return err
+		}
+		panic("boom")
+	})
+	if err == nil {
+		t.Fatal("Transaction must return an error when f panics")
+	}
+	if _, err = r.GetOneUserByEmail(ctx, "panic@example.com", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("user visible after panic, err = %v", err)
+	}
+}
+
+func testRefreshToken(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	userID := uuid.New()
+	token := &model.RefreshToken{UserID: userID, Sign: "sign"}
+	if err := r.CreateRefreshToken(ctx, token, nil); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if token.ID == uuid.Nil {
+		t.Error("CreateRefreshToken did not assign an ID")
+	}
+	if got, err := r.GetRefreshTokenBySign(ctx, "sign", nil); err != nil || got.UserID != userID {
+		t.Fatalf("GetRefreshTokenBySign = %v, %v, want the token of %v", got.UserID, err, userID)
+	}
+	if err := r.DeleteRefreshToken(ctx, userID, nil); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	if _, err := r.GetRefreshTokenBySign(ctx, "sign", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetRefreshTokenBySign after delete err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	// deleting again is not an error
+	if err := r.DeleteRefreshToken(ctx, userID, nil); err != nil {
+		t.Fatalf("DeleteRefreshToken twice: %v", err)
+	}
+}
+
+func testOAuthRefreshToken(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	userID, otherID := uuid.New(), uuid.New()
+	tokens := []*model.RefreshToken{
+		{UserID: userID, Sign: "first-party"},
+		{UserID: userID, Sign: "app", ClientID: "app", Scope: model.SpaceList{"openid", "email"}},
+		{UserID: userID, Sign: "other-app", ClientID: "other-app"},
+		{UserID: otherID, Sign: "app-of-other", ClientID: "app"},
+		{UserID: otherID, Sign: "app-of-other-2", ClientID: "app"},
+	}
+	for _, token := range tokens {
+		if err := r.CreateRefreshToken(ctx, token, nil); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+	exists := func(sign string) bool {
+		_, err := r.GetRefreshTokenBySign(ctx, sign, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			t.Fatalf("GetRefreshTokenBySign: %v", err)
+		}
+		return err == nil
+	}
+
+	got, err := r.GetRefreshTokenBySign(ctx, "app", nil)
+	if err != nil || got.ClientID != "app" || got.Scope.String() != "openid email" {
+		t.Errorf("GetRefreshTokenBySign = %+v, %v, want the token of the client with its scope", got, err)
+	}
+
+	// the sign-in of ms-user does not revoke the tokens of the clients
+	if err = r.DeleteRefreshToken(ctx, userID, nil); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	if exists("first-party") || !exists("app") || !exists("other-app") {
+		t.Error("DeleteRefreshToken must only revoke the token of the first party sign-in")
+	}
+
+	if ok, err := r.RevokeRefreshToken(ctx, tokens[1].ID, nil); err != nil || !ok {
+		t.Errorf("RevokeRefreshToken = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.RevokeRefreshToken(ctx, tokens[1].ID, nil); err != nil || ok {
+		t.Errorf("second RevokeRefreshToken = %v, %v, want false", ok, err)
+	}
+	if exists("app") {
+		t.Error("a revoked token is still found")
+	}
+
+	if err = r.DeleteOAuthRefreshTokens(ctx, "app", &otherID, nil); err != nil {
+		t.Fatalf("DeleteOAuthRefreshTokens of a user: %v", err)
+	}
+	if exists("app-of-other") || exists("app-of-other-2") || !exists("other-app") {
+		t.Error("DeleteOAuthRefreshTokens of a user must only revoke the tokens of that user and client")
+	}
+	if err = r.DeleteOAuthRefreshTokens(ctx, "other-app", nil, nil); err != nil {
+		t.Fatalf("DeleteOAuthRefreshTokens: %v", err)
+	}
+	if exists("other-app") {
+		t.Error("DeleteOAuthRefreshTokens must revoke the tokens of every user")
+	}
+}
+
+func testOAuthClient(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	if _, err := r.GetOAuthClient(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOAuthClient of an unknown client err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	client := &model.OAuthClient{ClientID: "web", SecretHash: "hash", Name: "Web", CreatedAt: now,
+		RedirectURIs: model.SpaceList{"https://app.example.com/cb", "https://app.example.com/cb2"},
+		GrantTypes:   model.SpaceList{model.GrantAuthorizationCode}, Scopes: model.SpaceList{"openid"}}
+	mobile := &model.OAuthClient{ClientID: "mobile", Name: "Mobile", Public: true, CreatedAt: now.Add(time.Second)}
+	for _, c := range []*model.OAuthClient{client, mobile} {
+		if err := r.CreateOAuthClient(ctx, c, nil); err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+	}
+	err := r.CreateOAuthClient(ctx, &model.OAuthClient{ClientID: "web", Name: "Again", CreatedAt: now}, nil)
+	var apiErr ginext.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("CreateOAuthClient of a taken client_id err = %v, want a 409", err)
+	}
+
+	got, err := r.GetOAuthClient(ctx, "web", nil)
+	if err != nil || got.ID != client.ID || got.SecretHash != "hash" || got.Public ||
+		got.RedirectURIs.String() != "https://app.example.com/cb https://app.example.com/cb2" ||
+		!got.GrantTypes.Contains(model.GrantAuthorizationCode) || got.Scopes.String() != "openid" {
+		t.Errorf("GetOAuthClient = %+v, %v, want %+v", got, err, client)
+	}
+	list, err := r.ListOAuthClients(ctx, nil)
+	if err != nil || len(list) != 2 || list[0].ClientID != "web" || list[1].ClientID != "mobile" || !list[1].Public {
+		t.Errorf("ListOAuthClients = %+v, %v, want web then mobile", list, err)
+	}
+
+	if ok, err := r.DeleteOAuthClient(ctx, "web", nil); err != nil || !ok {
+		t.Errorf("DeleteOAuthClient = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.DeleteOAuthClient(ctx, "web", nil); err != nil || ok {
+		t.Errorf("second DeleteOAuthClient = %v, %v, want false", ok, err)
+	}
+	if _, err = r.GetOAuthClient(ctx, "web", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOAuthClient after delete err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func testOAuthAuthorizationCode(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	if _, err := r.GetOAuthAuthorizationCode(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOAuthAuthorizationCode of an unknown code err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	code := &model.OAuthAuthorizationCode{CodeHash: "code", ClientID: "web", UserID: uuid.New(),
+		RedirectURI: "https://app.example.com/cb", Scope: model.SpaceList{"openid", "profile"}, Nonce: "nonce",
+		CodeChallenge: "challenge", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	if err := r.CreateOAuthAuthorizationCode(ctx, code, nil); err != nil {
+		t.Fatalf("CreateOAuthAuthorizationCode: %v", err)
+	}
+
+	got, err := r.GetOAuthAuthorizationCode(ctx, "code", nil)
+	if err != nil || got.ID != code.ID || got.UserID != code.UserID || got.Scope.String() != "openid profile" ||
+		got.Nonce != "nonce" || got.CodeChallenge != "challenge" || got.ConsumedAt != nil {
+		t.Errorf("GetOAuthAuthorizationCode = %+v, %v, want the unused code %+v", got, err, code)
+	}
+	if ok, err := r.ConsumeOAuthAuthorizationCode(ctx, code.ID, now, nil); err != nil || !ok {
+		t.Errorf("ConsumeOAuthAuthorizationCode = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.ConsumeOAuthAuthorizationCode(ctx, code.ID, now, nil); err != nil || ok {
+		t.Errorf("second ConsumeOAuthAuthorizationCode = %v, %v, want false", ok, err)
+	}
+	if got, err = r.GetOAuthAuthorizationCode(ctx, "code", nil); err != nil || got.ConsumedAt == nil {
+		t.Errorf("GetOAuthAuthorizationCode after consume = %+v, %v, want it consumed", got, err)
+	}
+}
+
+func testOAuthConsent(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	userID, otherID := uuid.New(), uuid.New()
+	if _, err := r.GetOAuthConsent(ctx, userID, "web", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOAuthConsent of an unknown consent err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	consents := []*model.OAuthConsent{
+		{UserID: userID, ClientID: "web", Scope: model.SpaceList{"openid"}, CreatedAt: now, UpdatedAt: now},
+		{UserID: userID, ClientID: "mobile", Scope: model.SpaceList{"openid"}, CreatedAt: now, UpdatedAt: now.Add(time.Second)},
+		{UserID: otherID, ClientID: "web", Scope: model.SpaceList{"openid"}, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, c := range consents {
+		if err := r.SaveOAuthConsent(ctx, c, nil); err != nil {
+			t.Fatalf("SaveOAuthConsent: %v", err)
+		}
+	}
+	// saving again replaces the scope of the consent
+	later := now.Add(2 * time.Second)
+	err := r.SaveOAuthConsent(ctx, &model.OAuthConsent{UserID: userID, ClientID: "web",
+		Scope: model.SpaceList{"openid", "email"}, CreatedAt: later, UpdatedAt: later}, nil)
+	if err != nil {
+		t.Fatalf("SaveOAuthConsent of a known consent: %v", err)
+	}
+	got, err := r.GetOAuthConsent(ctx, userID, "web", nil)
+	if err != nil || got.ID != consents[0].ID || got.Scope.String() != "openid email" || !got.UpdatedAt.Equal(later) {
+		t.Errorf("GetOAuthConsent = %+v, %v, want consent %s with the new scope", got, err, consents[0].ID)
+	}
+
+	list, err := r.ListOAuthConsents(ctx, userID, nil)
+	if err != nil || len(list) != 2 || list[0].ClientID != "web" || list[1].ClientID != "mobile" {
+		t.Errorf("ListOAuthConsents = %+v, %v, want web then mobile", list, err)
+	}
+
+	if n, err := r.DeleteOAuthConsents(ctx, "web", &userID, nil); err != nil || n != 1 {
+		t.Errorf("DeleteOAuthConsents of a user = %d, %v, want 1", n, err)
+	}
+	if _, err = r.GetOAuthConsent(ctx, otherID, "web", nil); err != nil {
+		t.Errorf("DeleteOAuthConsents of a user deleted the consent of another one: %v", err)
+	}
+	if n, err := r.DeleteOAuthConsents(ctx, "web", nil, nil); err != nil || n != 1 {
+		t.Errorf("DeleteOAuthConsents = %d, %v, want 1", n, err)
+	}
+	if list, err = r.ListOAuthConsents(ctx, otherID, nil); err != nil || len(list) != 0 {
+		t.Errorf("ListOAuthConsents after delete = %+v, %v, want none", list, err)
+	}
+}
+
+func testSAMLConnection(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	acme, globex := uuid.New(), uuid.New()
+	if _, err := r.GetSAMLConnection(ctx, acme, nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetSAMLConnection of an unknown business err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	conns := []*model.SAMLConnection{
+		{BusinessID: acme, IdPEntityID: "https://idp.acme.example", IdPSSOURL: "https://idp.acme.example/sso",
+			IdPCertificates: "pem", Domains: model.SpaceList{"acme.example"}, Enabled: true, CreatedAt: now, UpdatedAt: now},
+		{BusinessID: globex, IdPEntityID: "https://idp.globex.example", IdPSSOURL: "https://idp.globex.example/sso",
+			IdPCertificates: "pem", Domains: model.SpaceList{"globex.example"}, CreatedAt: now.Add(time.Second), UpdatedAt: now},
+	}
+	for _, c := range conns {
+		if err := r.SaveSAMLConnection(ctx, c, nil); err != nil {
+			t.Fatalf("SaveSAMLConnection: %v", err)
+		}
+	}
+	// saving again replaces the configuration of the business
+	later := now.Add(2 * time.Second)
+	err := r.SaveSAMLConnection(ctx, &model.SAMLConnection{BusinessID: acme, IdPEntityID: "https://idp2.acme.example",
+		IdPSSOURL: "https://idp2.acme.example/sso", IdPCertificates: "pem2", EmailAttribute: "mail",
+		Domains: model.SpaceList{"acme.example", "acme.test"}, CreatedAt: later, UpdatedAt: later}, nil)
+	if err != nil {
+		t.Fatalf("SaveSAMLConnection of a known business: %v", err)
+	}
+	got, err := r.GetSAMLConnection(ctx, acme, nil)
+	if err != nil || got.ID != conns[0].ID || got.IdPEntityID != "https://idp2.acme.example" || got.IdPCertificates != "pem2" ||
+		got.EmailAttribute != "mail" || got.Domains.String() != "acme.example acme.test" || got.Enabled ||
+		!got.CreatedAt.Equal(now) || !got.UpdatedAt.Equal(later) {
+		t.Errorf("GetSAMLConnection = %+v, %v, want connection %s with the new configuration", got, err, conns[0].ID)
+	}
+
+	list, err := r.ListSAMLConnections(ctx, nil)
+	if err != nil || len(list) != 2 || list[0].BusinessID != acme || list[1].BusinessID != globex {
+		t.Errorf("ListSAMLConnections = %+v, %v, want acme then globex", list, err)
+	}
+
+	if ok, err := r.DeleteSAMLConnection(ctx, acme, nil); err != nil || !ok {
+		t.Errorf("DeleteSAMLConnection = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.DeleteSAMLConnection(ctx, acme, nil); err != nil || ok {
+		t.Errorf("second DeleteSAMLConnection = %v, %v, want false", ok, err)
+	}
+	if _, err = r.GetSAMLConnection(ctx, globex, nil); err != nil {
+		t.Errorf("DeleteSAMLConnection deleted the connection of another business: %v", err)
+	}
+}
+
+func testSAMLAuthRequest(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	if _, err := r.GetSAMLAuthRequestByRelayState(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetSAMLAuthRequestByRelayState of an unknown relay state err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	req := &model.SAMLAuthRequest{RequestID: "id-1", BusinessID: uuid.New(), RelayStateHash: "relay", DeviceID: "device",
+		CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	other := &model.SAMLAuthRequest{RequestID: "id-2", BusinessID: req.BusinessID, RelayStateHash: "other",
+		CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	for _, a := range []*model.SAMLAuthRequest{req, other} {
+		if err := r.CreateSAMLAuthRequest(ctx, a, nil); err != nil {
+			t.Fatalf("CreateSAMLAuthRequest: %v", err)
+		}
+	}
+
+	got, err := r.GetSAMLAuthRequestByRelayState(ctx, "relay", nil)
+	if err != nil || got.ID != req.ID || got.RequestID != "id-1" || got.BusinessID != req.BusinessID ||
+		got.DeviceID != "device" || got.ConsumedAt != nil {
+		t.Errorf("GetSAMLAuthRequestByRelayState = %+v, %v, want the unused request %s", got, err, req.ID)
+	}
+	if ok, err := r.ConsumeSAMLAuthRequest(ctx, req.ID, now, nil); err != nil || !ok {
+		t.Errorf("ConsumeSAMLAuthRequest = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.ConsumeSAMLAuthRequest(ctx, req.ID, now, nil); err != nil || ok {
+		t.Errorf("second ConsumeSAMLAuthRequest = %v, %v, want false", ok, err)
+	}
+	if got, err = r.GetSAMLAuthRequestByRelayState(ctx, "relay", nil); err != nil || got.ConsumedAt == nil {
+		t.Errorf("GetSAMLAuthRequestByRelayState after consume = %+v, %v, want it consumed", got, err)
+	}
+	if got, err = r.GetSAMLAuthRequestByRelayState(ctx, "other", nil); err != nil || got.ConsumedAt != nil {
+		t.Errorf("ConsumeSAMLAuthRequest consumed another request: %+v, %v", got, err)
+	}
+}
+
+func testUserLifecycle(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	u := newUser("lifecycle@example.com")
+	if err := r.CreateUser(ctx, u, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	other := newUser("other@example.com")
+	if err := r.CreateUser(ctx, other, nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for _, clientID := range []string{"", "client"} {
+		err := r.CreateRefreshToken(ctx, &model.RefreshToken{UserID: u.ID, ClientID: clientID, Sign: "sign-" + clientID}, nil)
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+
+	update := &model.User{BaseModel: model.BaseModel{ID: u.ID}, Email: "Renamed@Example.com", FullName: "Babs Jensen",
+		DisplayName: "Babs", PhoneNumber: "+1 555 0100", Images: "https://example.com/babs.png", Link: "https://example.com/babs"}
+	if err := r.UpdateUserProfile(ctx, update, nil); err != nil {
+		t.Fatalf("UpdateUserProfile: %v", err)
+	}
+	got, err := r.GetOneUserByEmail(ctx, "renamed@example.com", nil)
+	if err != nil || got.ID != u.ID || got.FullName != "Babs Jensen" || got.DisplayName != "Babs" ||
+		got.PhoneNumber != "+1 555 0100" || got.Images != update.Images || got.Link != update.Link || got.Password != "hashed" {
+		t.Errorf("GetOneUserByEmail after UpdateUserProfile = %+v, %v", got, err)
+	}
+	err = r.UpdateUserProfile(ctx, &model.User{BaseModel: model.BaseModel{ID: u.ID}, Email: "other@example.com"}, nil)
+	var apiErr ginext.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("UpdateUserProfile to the email of another account err = %v, want a 409", err)
+	}
+	err = r.UpdateUserProfile(ctx, &model.User{BaseModel: model.BaseModel{ID: uuid.New()}, Email: "ghost@example.com"}, nil)
+	if err != gorm.ErrRecordNotFound {
+		t.Errorf("UpdateUserProfile of an unknown user err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	if err = r.SoftDeleteUser(ctx, u.ID, nil); err != nil {
+		t.Fatalf("SoftDeleteUser: %v", err)
+	}
+	if _, err = r.GetOneUserByID(ctx, u.ID, nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOneUserByID of a soft-deleted user err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if _, err = r.GetOneUserByEmail(ctx, "renamed@example.com", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOneUserByEmail of a soft-deleted user err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	// a soft-deleted user keeps its profile up to date
+	update.FullName = "Barbara Jensen"
+	if err = r.UpdateUserProfile(ctx, update, nil); err != nil {
+		t.Errorf("UpdateUserProfile of a soft-deleted user: %v", err)
+	}
+	if err = r.RestoreUser(ctx, u.ID, nil); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if got, err = r.GetOneUserByID(ctx, u.ID, nil); err != nil || got.FullName != "Barbara Jensen" {
+		t.Errorf("GetOneUserByID of a restored user = %+v, %v", got, err)
+	}
+
+	// the email of a soft-deleted user can be taken, restoring it is then a conflict
+	if err = r.SoftDeleteUser(ctx, u.ID, nil); err != nil {
+		t.Fatalf("SoftDeleteUser: %v", err)
+	}
+	if err = r.CreateUser(ctx, newUser("renamed@example.com"), nil); err != nil {
+		t.Fatalf("CreateUser with the email of a soft-deleted user: %v", err)
+	}
+	if err = r.RestoreUser(ctx, u.ID, nil); !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Errorf("RestoreUser of a user whose email was taken err = %v, want a 409", err)
+	}
+
+	if err = r.DeleteUserRefreshTokens(ctx, u.ID, nil); err != nil {
+		t.Fatalf("DeleteUserRefreshTokens: %v", err)
+	}
+	for _, sign := range []string{"sign-", "sign-client"} {
+		if _, err = r.GetRefreshTokenBySign(ctx, sign, nil); err != gorm.ErrRecordNotFound {
+			t.Errorf("GetRefreshTokenBySign(%s) after DeleteUserRefreshTokens err = %v, want %v", sign, err, gorm.ErrRecordNotFound)
+		}
+	}
+}
+
+func testSCIMToken(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	acme, globex := uuid.New(), uuid.New()
+	if _, err := r.GetSCIMTokenByHash(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetSCIMTokenByHash of an unknown hash err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	tokens := []*model.SCIMToken{
+		{BusinessID: acme, Description: "okta", TokenHash: "hash-1", CreatedAt: now},
+		{BusinessID: globex, Description: "azure", TokenHash: "hash-2", CreatedAt: now.Add(time.Second)},
+		{BusinessID: acme, Description: "rotated", TokenHash: "hash-3", CreatedAt: now.Add(2 * time.Second)},
+	}
+	for _, tok := range tokens {
+		if err := r.CreateSCIMToken(ctx, tok, nil); err != nil {
+			t.Fatalf("CreateSCIMToken: %v", err)
+		}
+	}
+	got, err := r.GetSCIMTokenByHash(ctx, "hash-2", nil)
+	if err != nil || got.ID != tokens[1].ID || got.BusinessID != globex || got.Description != "azure" {
+		t.Errorf("GetSCIMTokenByHash = %+v, %v, want token %s", got, err, tokens[1].ID)
+	}
+
+	all, err := r.ListSCIMTokens(ctx, nil, nil)
+	if err != nil || len(all) != 3 || all[0].ID != tokens[0].ID || all[2].ID != tokens[2].ID {
+		t.Errorf("ListSCIMTokens = %+v, %v, want the three tokens, the oldest first", all, err)
+	}
+	ofAcme, err := r.ListSCIMTokens(ctx, &acme, nil)
+	if err != nil || len(ofAcme) != 2 || ofAcme[0].ID != tokens[0].ID || ofAcme[1].ID != tokens[2].ID {
+		t.Errorf("ListSCIMTokens of a business = %+v, %v, want its two tokens", ofAcme, err)
+	}
+
+	if ok, err := r.DeleteSCIMToken(ctx, tokens[0].ID, nil); err != nil || !ok {
+		t.Errorf("DeleteSCIMToken = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.DeleteSCIMToken(ctx, tokens[0].ID, nil); err != nil || ok {
+		t.Errorf("second DeleteSCIMToken = %v, %v, want false", ok, err)
+	}
+	if _, err = r.GetSCIMTokenByHash(ctx, "hash-1", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetSCIMTokenByHash of a deleted token err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func testSCIMUser(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	acme, globex := uuid.New(), uuid.New()
+
+	users := make([]*model.User, 3)
+	for i, email := range []string{"bjensen@acme.example", "jsmith@acme.example", "bjensen@globex.example"} {
+		users[i] = newUser(email)
+		if err := r.CreateUser(ctx, users[i], nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	memberships := []*model.SCIMUser{
+		{UserID: users[0].ID, BusinessID: acme, ExternalID: "ext-1", CreatedAt: now, UpdatedAt: now},
+		{UserID: users[1].ID, BusinessID: acme, ExternalID: "ext-2", CreatedAt: now.Add(time.Second), UpdatedAt: now},
+		{UserID: users[2].ID, BusinessID: globex, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, m := range memberships {
+		if err := r.CreateSCIMUser(ctx, m, nil); err != nil {
+			t.Fatalf("CreateSCIMUser: %v", err)
+		}
+	}
+	if _, err := r.GetSCIMUser(ctx, uuid.New(), nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetSCIMUser of an unknown user err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	// the user is loaded with the membership, even once deactivated
+	if err := r.SoftDeleteUser(ctx, users[1].ID, nil); err != nil {
+		t.Fatalf("SoftDeleteUser: %v", err)
+	}
+	got, err := r.GetSCIMUser(ctx, users[1].ID, nil)
+	if err != nil || got.BusinessID != acme || got.ExternalID != "ext-2" || got.User.Email != "jsmith@acme.example" ||
+		got.User.DeletedAt == nil {
+		t.Errorf("GetSCIMUser of a deactivated user = %+v, %v", got, err)
+	}
+
+	got, err = r.GetSCIMUserByEmail(ctx, acme, "BJensen@acme.example", nil)
+	if err != nil || got.UserID != users[0].ID || got.User.ID != users[0].ID {
+		t.Errorf("GetSCIMUserByEmail = %+v, %v, want the membership of %s", got, err, users[0].ID)
+	}
+	if _, err = r.GetSCIMUserByEmail(ctx, globex, "bjensen@acme.example", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetSCIMUserByEmail in another business err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	list, err := r.ListSCIMUsers(ctx, acme, model.SCIMUserFilter{}, nil)
+	if err != nil || len(list) != 2 || list[0].UserID != users[0].ID || list[1].UserID != users[1].ID ||
+		list[1].User.Email != "jsmith@acme.example" {
+		t.Errorf("ListSCIMUsers = %+v, %v, want the two users of acme, the oldest first", list, err)
+	}
+	list, err = r.ListSCIMUsers(ctx, acme, model.SCIMUserFilter{Email: "JSMITH@acme.example"}, nil)
+	if err != nil || len(list) != 1 || list[0].UserID != users[1].ID {
+		t.Errorf("ListSCIMUsers by email = %+v, %v, want %s", list, err, users[1].ID)
+	}
+	list, err = r.ListSCIMUsers(ctx, acme, model.SCIMUserFilter{ExternalID: "ext-1"}, nil)
+	if err != nil || len(list) != 1 || list[0].UserID != users[0].ID {
+		t.Errorf("ListSCIMUsers by external id = %+v, %v, want %s", list, err, users[0].ID)
+	}
+
+	// a deprovisioned membership leaves the listings and is still found by email
+	deprovisioned := memberships[0]
+	deprovisioned.ExternalID, deprovisioned.GivenName, deprovisioned.DeprovisionedAt = "ext-1b", "Barbara", &now
+	if err = r.UpdateSCIMUser(ctx, deprovisioned, nil); err != nil {
+		t.Fatalf("UpdateSCIMUser: %v", err)
+	}
+	list, err = r.ListSCIMUsers(ctx, acme, model.SCIMUserFilter{}, nil)
+	if err != nil || len(list) != 1 || list[0].UserID != users[1].ID {
+		t.Errorf("ListSCIMUsers after a deprovisioning = %+v, %v, want %s only", list, err, users[1].ID)
+	}
+	got, err = r.GetSCIMUserByEmail(ctx, acme, "bjensen@acme.example", nil)
+	if err != nil || got.DeprovisionedAt == nil || got.ExternalID != "ext-1b" || got.GivenName != "Barbara" {
+		t.Errorf("GetSCIMUserByEmail of a deprovisioned user = %+v, %v", got, err)
+	}
+}
+
+func testSCIMGroup(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	acme, globex := uuid.New(), uuid.New()
+	alice, bob := uuid.New(), uuid.New()
+
+	groups := []*model.SCIMGroup{
+		{BusinessID: acme, DisplayName: "Engineering", ExternalID: "eng", CreatedAt: now, UpdatedAt: now},
+		{BusinessID: acme, DisplayName: "Sales", CreatedAt: now.Add(time.Second), UpdatedAt: now},
+		{BusinessID: globex, DisplayName: "Engineering", CreatedAt: now, UpdatedAt: now},
+	}
+	for _, g := range groups {
+		if err := r.CreateSCIMGroup(ctx, g, nil); err != nil {
+			t.Fatalf("CreateSCIMGroup: %v", err)
+		}
+	}
+	if _, err := r.GetSCIMGroup(ctx, globex, groups[0].ID, nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetSCIMGroup of another business err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	groups[0].DisplayName, groups[0].ExternalID = "Platform", "platform"
+	if err := r.UpdateSCIMGroup(ctx, groups[0], nil); err != nil {
+		t.Fatalf("UpdateSCIMGroup: %v", err)
+	}
+	got, err := r.GetSCIMGroup(ctx, acme, groups[0].ID, nil)
+	if err != nil || got.DisplayName != "Platform" || got.ExternalID != "platform" || !got.CreatedAt.Equal(now) {
+		t.Errorf("GetSCIMGroup after UpdateSCIMGroup = %+v, %v", got, err)
+	}
+
+	list, err := r.ListSCIMGroups(ctx, acme, model.SCIMGroupFilter{}, nil)
+	if err != nil || len(list) != 2 || list[0].ID != groups[0].ID || list[1].ID != groups[1].ID {
+		t.Errorf("ListSCIMGroups = %+v, %v, want the two groups of acme, the oldest first", list, err)
+	}
+	list, err = r.ListSCIMGroups(ctx, acme, model.SCIMGroupFilter{DisplayName: "sales"}, nil)
+	if err != nil || len(list) != 1 || list[0].ID != groups[1].ID {
+		t.Errorf("ListSCIMGroups by display name = %+v, %v, want %s", list, err, groups[1].ID)
+	}
+	list, err = r.ListSCIMGroups(ctx, acme, model.SCIMGroupFilter{ExternalID: "platform"}, nil)
+	if err != nil || len(list) != 1 || list[0].ID != groups[0].ID {
+		t.Errorf("ListSCIMGroups by external id = %+v, %v, want %s", list, err, groups[0].ID)
+	}
+
+	if err = r.SetSCIMGroupMembers(ctx, groups[0].ID, acme, []uuid.UUID{alice, bob, alice}, nil); err != nil {
+		t.Fatalf("SetSCIMGroupMembers: %v", err)
+	}
+	if err = r.SetSCIMGroupMembers(ctx, groups[1].ID, acme, []uuid.UUID{alice}, nil); err != nil {
+		t.Fatalf("SetSCIMGroupMembers: %v", err)
+	}
+	members, err := r.ListSCIMGroupMembers(ctx, acme, []uuid.UUID{groups[0].ID}, nil, nil)
+	if err != nil || len(members) != 2 {
+		t.Errorf("ListSCIMGroupMembers of a group = %+v, %v, want alice and bob", members, err)
+	}
+	members, err = r.ListSCIMGroupMembers(ctx, acme, nil, []uuid.UUID{alice}, nil)
+	if err != nil || len(members) != 2 {
+		t.Errorf("ListSCIMGroupMembers of a user = %+v, %v, want its two groups", members, err)
+	}
+	if members, err = r.ListSCIMGroupMembers(ctx, globex, nil, nil, nil); err != nil || len(members) != 0 {
+		t.Errorf("ListSCIMGroupMembers of another business = %+v, %v, want none", members, err)
+	}
+
+	// setting the members replaces them
+	if err = r.SetSCIMGroupMembers(ctx, groups[0].ID, acme, []uuid.UUID{bob}, nil); err != nil {
+		t.Fatalf("SetSCIMGroupMembers: %v", err)
+	}
+	members, err = r.ListSCIMGroupMembers(ctx, acme, []uuid.UUID{groups[0].ID}, nil, nil)
+	if err != nil || len(members) != 1 || members[0].UserID != bob {
+		t.Errorf("ListSCIMGroupMembers after a replacement = %+v, %v, want bob only", members, err)
+	}
+
+	if err = r.DeleteSCIMGroupMembersOfUser(ctx, alice, nil); err != nil {
+		t.Fatalf("DeleteSCIMGroupMembersOfUser: %v", err)
+	}
+	if members, err = r.ListSCIMGroupMembers(ctx, acme, nil, []uuid.UUID{alice}, nil); err != nil || len(members) != 0 {
+		t.Errorf("ListSCIMGroupMembers of a removed user = %+v, %v, want none", members, err)
+	}
+
+	if ok, err := r.DeleteSCIMGroup(ctx, globex, groups[0].ID, nil); err != nil || ok {
+		t.Errorf("DeleteSCIMGroup of another business = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.DeleteSCIMGroup(ctx, acme, groups[0].ID, nil); err != nil || !ok {
+		t.Errorf("DeleteSCIMGroup = %v, %v, want true", ok, err)
+	}
+	if members, err = r.ListSCIMGroupMembers(ctx, acme, nil, []uuid.UUID{bob}, nil); err != nil || len(members) != 0 {
+		t.Errorf("ListSCIMGroupMembers after DeleteSCIMGroup = %+v, %v, want none", members, err)
+	}
+}
+
+func testAPIKey(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	alice, bob := uuid.New(), uuid.New()
+	if _, err := r.GetAPIKeyByPrefix(ctx, "unknown", nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetAPIKeyByPrefix of an unknown prefix err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	keys := []*model.APIKey{
+		{UserID: alice, Name: "etl", Prefix: "aaaa0001", KeyHash: "hash-1", Scopes: model.SpaceList{"read"}, ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		{UserID: bob, Name: "report", Prefix: "bbbb0001", KeyHash: "hash-2", Scopes: model.SpaceList{"read", "write"}, ExpiresAt: now.Add(time.Hour), CreatedAt: now},
+		{UserID: alice, Name: "backfill", Prefix: "aaaa0002", KeyHash: "hash-3", Scopes: model.SpaceList{"write"}, ExpiresAt: now.Add(time.Hour), CreatedAt: now.Add(time.Second)},
+	}
+	for _, k := range keys {
+		if err := r.CreateAPIKey(ctx, k, nil); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+	}
+	taken := &model.APIKey{UserID: bob, Name: "again", Prefix: "aaaa0001", KeyHash: "hash-4", ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	var apiErr ginext.ApiError
+	if err := r.CreateAPIKey(ctx, taken, nil); !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+		t.Fatalf("CreateAPIKey with a taken prefix err = %v, want a 409", err)
+	}
+	got, err := r.GetAPIKeyByPrefix(ctx, "bbbb0001", nil)
+	if err != nil || got.ID != keys[1].ID || got.KeyHash != "hash-2" || got.Scopes.String() != "read write" || got.LastUsedAt != nil {
+		t.Errorf("GetAPIKeyByPrefix = %+v, %v, want key %s", got, err, keys[1].ID)
+	}
+
+	ofAlice, err := r.ListAPIKeys(ctx, alice, nil)
+	if err != nil || len(ofAlice) != 2 || ofAlice[0].ID != keys[2].ID || ofAlice[1].ID != keys[0].ID {
+		t.Errorf("ListAPIKeys = %+v, %v, want the two keys of the user, the latest first", ofAlice, err)
+	}
+
+	usedAt := now.Add(time.Minute)
+	if err = r.TouchAPIKey(ctx, keys[0].ID, usedAt, nil); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	if got, err = r.GetAPIKeyByPrefix(ctx, "aaaa0001", nil); err != nil || got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) {
+		t.Errorf("GetAPIKeyByPrefix after TouchAPIKey = %+v, %v, want last used at %v", got, err, usedAt)
+	}
+
+	if ok, err := r.DeleteAPIKey(ctx, bob, keys[0].ID, nil); err != nil || ok {
+		t.Errorf("DeleteAPIKey of another user = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.DeleteAPIKey(ctx, alice, keys[0].ID, nil); err != nil || !ok {
+		t.Errorf("DeleteAPIKey = %v, %v, want true", ok, err)
+	}
+	if _, err = r.GetAPIKeyByPrefix(ctx, "aaaa0001", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetAPIKeyByPrefix of a deleted key err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func testAuditEvents(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	if _, err := r.GetLastAuditEvent(ctx, nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetLastAuditEvent on empty log err = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	subject := uuid.New()
+	start := time.Now().UTC().Truncate(time.Microsecond)
+	for i, typ := range []string{model.AuditUserRegistered, model.AuditLoginFailed, model.AuditLoginSucceeded} {
+		err := r.Transaction(ctx, func(rp repo.PGInterface) error {
+			if err := rp.LockAuditChain(ctx, nil); err != nil {
+				return err
+			}
+			return rp.CreateAuditEvent(ctx, &model.AuditEvent{
+				ID:         uuid.New(),
+				Seq:        int64(i + 1),
+				OccurredAt: start.Add(time.Duration(i) * time.Second),
+				Type:       typ,
+				SubjectID:  &subject,
+				Hash:       typ,
+			}, nil)
+		})
+		if err != nil {
+			t.Fatalf("CreateAuditEvent %s: %v", typ, err)
+		}
+	}
+
+	last, err := r.GetLastAuditEvent(ctx, nil)
+	if err != nil || last.Seq != 3 {
+		t.Fatalf("GetLastAuditEvent = %d, %v, want seq 3", last.Seq, err)
+	}
+	if err = r.CreateAuditEvent(ctx, &model.AuditEvent{ID: uuid.New(), Seq: 3, OccurredAt: start, Type: "dup", Hash: "dup"}, nil); err == nil {
+		t.Error("CreateAuditEvent must reject a duplicated seq")
+	}
+
+	rs, _, err := r.ListAuditEvents(ctx, model.AuditFilter{SubjectID: &subject}, nil)
+	if err != nil || len(rs) != 3 || rs[0].Seq != 1 || rs[2].Seq != 3 {
+		t.Fatalf("ListAuditEvents by subject = %+v, %v", rs, err)
+	}
+	rs, _, _ = r.ListAuditEvents(ctx, model.AuditFilter{Type: model.AuditLoginFailed}, nil)
+	if len(rs) != 1 || rs[0].Type != model.AuditLoginFailed {
+		t.Errorf("ListAuditEvents by type = %+v", rs)
+	}
+	from, to := start.Add(time.Second), start.Add(2*time.Second)
+	rs, _, _ = r.ListAuditEvents(ctx, model.AuditFilter{From: &from, To: &to}, nil)
+	if len(rs) != 1 || rs[0].Seq != 2 {
+		t.Errorf("ListAuditEvents by time = %+v", rs)
+	}
+	rs, meta, _ := r.ListAuditEvents(ctx, model.AuditFilter{Page: 2, PageSize: 2}, nil)
+	if len(rs) != 1 || rs[0].Seq != 3 || meta["total_rows"] != 3 {
+		t.Errorf("ListAuditEvents page 2 = %+v, meta %v", rs, meta)
+	}
+	other := uuid.New()
+	rs, _, _ = r.ListAuditEvents(ctx, model.AuditFilter{ActorID: &other}, nil)
+	if len(rs) != 0 {
+		t.Errorf("ListAuditEvents by unknown actor = %+v", rs)
+	}
+}
+
+func testPendingAuditEvents(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	start := time.Now()
+	subject := uuid.New()
+	var ids []uuid.UUID
+	// recorded out of order, read back by occurred_at
+	for _, i := range []int{2, 0, 1} {
+		ev := model.NewPendingAuditEvent(model.AuditEvent{Type: fmt.Sprint("event-", i), SubjectID: &subject, Metadata: `{"n":1}`}, start.Add(time.Duration(i)*time.Second))
+		if err := r.CreatePendingAuditEvent(ctx, &ev, nil); err != nil {
+			t.Fatalf("CreatePendingAuditEvent: %v", err)
+		}
+		ids = append(ids, ev.ID)
+	}
+
+	rs, err := r.GetPendingAuditEvents(ctx, 2, nil)
+	if err != nil || len(rs) != 2 || rs[0].Type != "event-0" || rs[1].Type != "event-1" {
+		t.Fatalf("GetPendingAuditEvents = %+v, %v, want event-0 then event-1", rs, err)
+	}
+	if ev := rs[0].Event(); ev.SubjectID == nil || *ev.SubjectID != subject || ev.Metadata != `{"n":1}` || ev.Seq != 0 || ev.Hash != "" {
+		t.Errorf("Event of a pending event = %+v", ev)
+	}
+
+	if err = r.DeletePendingAuditEvents(ctx, []uuid.UUID{rs[0].ID, rs[1].ID}, nil); err != nil {
+		t.Fatalf("DeletePendingAuditEvents: %v", err)
+	}
+	if rs, _ = r.GetPendingAuditEvents(ctx, 10, nil); len(rs) != 1 || rs[0].ID != ids[0] {
+		t.Errorf("GetPendingAuditEvents after delete = %+v, want event-2 only", rs)
+	}
+	if err = r.DeletePendingAuditEvents(ctx, nil, nil); err != nil {
+		t.Errorf("DeletePendingAuditEvents of no id: %v", err)
+	}
+}
+
+func testLoginHistory(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	userID := uuid.New()
+	start := time.Now().UTC().Truncate(time.Microsecond)
+	for i, fp := range []string{"a", "b", "a"} {
+		h := &model.LoginHistory{
+			UserID:      userID,
+			OccurredAt:  start.Add(time.Duration(i) * time.Second),
+			Fingerprint: fp,
+			Success:     fp == "a",
+		}
+		if err := r.CreateLoginHistory(ctx, h, nil); err != nil {
+			t.Fatalf("CreateLoginHistory: %v", err)
+		}
+		if h.ID == uuid.Nil {
+			t.Error("CreateLoginHistory did not assign an ID")
+		}
+	}
+
+	rs, err := r.ListLoginHistory(ctx, userID, 2, nil)
+	if err != nil || len(rs) != 2 || !rs[0].OccurredAt.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("ListLoginHistory = %+v, %v, want the 2 most recent first", rs, err)
+	}
+	if rs, _ = r.ListLoginHistory(ctx, uuid.New(), 10, nil); len(rs) != 0 {
+		t.Errorf("ListLoginHistory of another user = %+v", rs)
+	}
+
+	for fp, want := range map[string]int64{"": 2, "a": 2, "b": 0, "c": 0} {
+		if n, err := r.CountSuccessfulLogins(ctx, userID, fp, nil); err != nil || n != want {
+			t.Errorf("CountSuccessfulLogins(%q) = %d, %v, want %d", fp, n, err, want)
+		}
+	}
+
+	// failures after the last success at start+2s count, the success resets them
+	other := uuid.New()
+	for i, reason := range []string{"bad_password", "bad_password", "", "bad_password", "bad_code", "bad_password"} {
+		h := &model.LoginHistory{UserID: other, OccurredAt: start.Add(time.Duration(i) * time.Second), Success: reason == "", Reason: reason}
+		if err := r.CreateLoginHistory(ctx, h, nil); err != nil {
+			t.Fatalf("CreateLoginHistory: %v", err)
+		}
+	}
+	for since, want := range map[time.Duration]int64{-time.Second: 2, 3 * time.Second: 1, 5 * time.Second: 0} {
+		if n, err := r.CountLoginFailures(ctx, other, "bad_password", start.Add(since), nil); err != nil || n != want {
+			t.Errorf("CountLoginFailures(since %s) = %d, %v, want %d", since, n, err, want)
+		}
+	}
+	if n, err := r.CountLoginFailures(ctx, uuid.New(), "bad_password", start.Add(-time.Hour), nil); err != nil || n != 0 {
+		t.Errorf("CountLoginFailures of a user without history = %d, %v", n, err)
+	}
+}
+
+func testOutbox(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	if _, err := r.GetOldestPendingOutboxMessage(ctx, nil); err != gorm.ErrRecordNotFound {
+		t.Fatalf("GetOldestPendingOutboxMessage on empty outbox err = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	due := &model.OutboxMessage{Kind: model.OutboxKindEmail, Payload: "{}", CreatedAt: now.Add(-time.Minute), NextAttemptAt: now}
+	later := &model.OutboxMessage{Kind: model.OutboxKindEmail, Payload: "{}", CreatedAt: now, NextAttemptAt: now.Add(time.Hour)}
+	for _, m := range []*model.OutboxMessage{due, later} {
+		if err := r.CreateOutboxMessage(ctx, m, nil); err != nil {
+			t.Fatalf("CreateOutboxMessage: %v", err)
+		}
+	}
+
+	rs, err := r.GetDueOutboxMessages(ctx, now, 10, nil)
+	if err != nil || len(rs) != 1 || rs[0].ID != due.ID {
+		t.Fatalf("GetDueOutboxMessages = %+v, %v, want only the due message", rs, err)
+	}
+	oldest, err := r.GetOldestPendingOutboxMessage(ctx, nil)
+	if err != nil || oldest.ID != due.ID {
+		t.Fatalf("GetOldestPendingOutboxMessage = %v, %v, want %v", oldest.ID, err, due.ID)
+	}
+
+	sentAt := now
+	due.SentAt = &sentAt
+	due.Attempts = 1
+	if err = r.UpdateOutboxMessage(ctx, due, nil); err != nil {
+		t.Fatalf("UpdateOutboxMessage: %v", err)
+	}
+	if rs, _ = r.GetDueOutboxMessages(ctx, now.Add(2*time.Hour), 10, nil); len(rs) != 1 || rs[0].ID != later.ID {
+		t.Errorf("GetDueOutboxMessages after send = %+v, want only the later message", rs)
+	}
+	if oldest, _ = r.GetOldestPendingOutboxMessage(ctx, nil); oldest.ID != later.ID {
+		t.Errorf("GetOldestPendingOutboxMessage after send = %v, want %v", oldest.ID, later.ID)
+	}
+}
+
+func testPurgeExpired(t *testing.T, r repo.PGInterface) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	expired, live := now.Add(-time.Hour), now.Add(time.Hour)
+	phone := "+84912345678"
+	userID := uuid.New()
+
+	otps := []*model.PhoneOTP{
+		{Phone: phone, Purpose: model.OTPPurposeLogin, CodeHash: "expired", CreatedAt: expired.Add(-time.Minute), ExpiresAt: expired},
+		{Phone: phone, Purpose: model.OTPPurposeLogin, CodeHash: "live", CreatedAt: now, ExpiresAt: live},
+	}
+	links := []*model.MagicLink{
+		{UserID: userID, NonceHash: "expired", CreatedAt: expired, ExpiresAt: expired},
+		{UserID: userID, NonceHash: "live", CreatedAt: now, ExpiresAt: live},
+	}
+	oidcRequests := []*model.OIDCAuthRequest{
+		{Provider: "google", StateHash: "expired", Nonce: "n", CodeVerifier: "v", CreatedAt: expired, ExpiresAt: expired},
+		{Provider: "google", StateHash: "live", Nonce: "n", CodeVerifier: "v", CreatedAt: now, ExpiresAt: live},
+	}
+	samlRequests := []*model.SAMLAuthRequest{
+		{RequestID: "id-1", BusinessID: uuid.New(), RelayStateHash: "expired", CreatedAt: expired, ExpiresAt: expired},
+		{RequestID: "id-2", BusinessID: uuid.New(), RelayStateHash: "live", CreatedAt: now, ExpiresAt: live},
+	}
+	codes := []*model.OAuthAuthorizationCode{
+		{CodeHash: "expired", ClientID: "web", UserID: userID, RedirectURI: "https://app.example.com/cb", CreatedAt: expired, ExpiresAt: expired},
+		{CodeHash: "live", ClientID: "web", UserID: userID, RedirectURI: "https://app.example.com/cb", CreatedAt: now, ExpiresAt: live},
+	}
+	tokens := []*model.RefreshToken{
+		{UserID: userID, Sign: "expired", ExpiredAt: expired},
+		{UserID: userID, Sign: "live", ExpiredAt: live},
+		{UserID: userID, Sign: "revoked", ExpiredAt: live},
+	}
+	for i := range otps {
+		if err := r.CreatePhoneOTP(ctx, otps[i], nil); err != nil {
+			t.Fatalf("CreatePhoneOTP: %v", err)
+		}
+		if err := r.CreateMagicLink(ctx, links[i], nil); err != nil {
+			t.Fatalf("CreateMagicLink: %v", err)
+		}
+		if err := r.CreateOIDCAuthRequest(ctx, oidcRequests[i], nil); err != nil {
+			t.Fatalf("CreateOIDCAuthRequest: %v", err)
+		}
+		if err := r.CreateSAMLAuthRequest(ctx, samlRequests[i], nil); err != nil {
+			t.Fatalf("CreateSAMLAuthRequest: %v", err)
+		}
+		if err := r.CreateOAuthAuthorizationCode(ctx, codes[i], nil); err != nil {
+			t.Fatalf("CreateOAuthAuthorizationCode: %v", err)
+		}
+	}
+	for _, token := range tokens {
+		if err := r.CreateRefreshToken(ctx, token, nil); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+	if ok, err := r.RevokeRefreshToken(ctx, tokens[2].ID, nil); err != nil || !ok {
+		t.Fatalf("RevokeRefreshToken = %v, %v, want true", ok, err)
+	}
+
+	rs, err := r.PurgeExpired(ctx, now.Add(time.Minute), nil)
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	for _, table := range []string{"phone_otps", "magic_links", "oidc_auth_requests", "saml_auth_requests", "oauth_authorization_codes"} {
+		if rs[table] != 1 {
+			t.Errorf("PurgeExpired deleted %d rows of %s, want 1", rs[table], table)
+		}
+	}
+	// the revoked token may already be gone, depending on how the implementation revokes
+	if rs["refresh_tokens"] < 1 {
+		t.Errorf("PurgeExpired deleted %d refresh tokens, want the expired one at least", rs["refresh_tokens"])
+	}
+
+	if got, err := r.GetLatestPhoneOTP(ctx, phone, model.OTPPurposeLogin, nil); err != nil || got.ID != otps[1].ID {
+		t.Errorf("GetLatestPhoneOTP after purge = %v, %v, want the live code %v", got.ID, err, otps[1].ID)
+	}
+	if count, err := r.CountPhoneOTPsSince(ctx, phone, expired.Add(-time.Hour), nil); err != nil || count != 1 {
+		t.Errorf("CountPhoneOTPsSince after purge = %d, %v, want 1", count, err)
+	}
+	if _, err := r.GetMagicLink(ctx, links[0].ID, nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetMagicLink of the expired link err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := r.GetMagicLink(ctx, links[1].ID, nil); err != nil {
+		t.Errorf("GetMagicLink of the live link: %v", err)
+	}
+	if _, err := r.GetOIDCAuthRequestByState(ctx, "expired", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOIDCAuthRequestByState of the expired request err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := r.GetOIDCAuthRequestByState(ctx, "live", nil); err != nil {
+		t.Errorf("GetOIDCAuthRequestByState of the live request: %v", err)
+	}
+	if _, err := r.GetSAMLAuthRequestByRelayState(ctx, "expired", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetSAMLAuthRequestByRelayState of the expired request err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := r.GetSAMLAuthRequestByRelayState(ctx, "live", nil); err != nil {
+		t.Errorf("GetSAMLAuthRequestByRelayState of the live request: %v", err)
+	}
+	if _, err := r.GetOAuthAuthorizationCode(ctx, "expired", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetOAuthAuthorizationCode of the expired code err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := r.GetOAuthAuthorizationCode(ctx, "live", nil); err != nil {
+		t.Errorf("GetOAuthAuthorizationCode of the live code: %v", err)
+	}
+	if _, err := r.GetRefreshTokenBySign(ctx, "expired", nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetRefreshTokenBySign of the expired token err = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if got, err := r.GetRefreshTokenBySign(ctx, "live", nil); err != nil || got.ID != tokens[1].ID {
+		t.Errorf("GetRefreshTokenBySign of the live token = %v, %v, want %v", got.ID, err, tokens[1].ID)
+	}
+
+	if rs, err = r.PurgeExpired(ctx, now.Add(time.Minute), nil); err != nil {
+		t.Fatalf("second PurgeExpired: %v", err)
+	}
+	for table, n := range rs {
+		if n != 0 {
+			t.Errorf("second PurgeExpired deleted %d rows of %s, want 0", n, table)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// SaveSAMLConnection creates the connection of the business or replaces its configuration
+func (r *RepoPG) SaveSAMLConnection(ctx context.Context, req *model.SAMLConnection, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.SaveSAMLConnection")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "business_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"idp_entity_id", "idp_sso_url", "idp_certificates",
+			"email_attribute", "name_attribute", "domains", "enabled", "updated_at"}),
+	}).Create(req).Error
+	if err != nil {
+		log.WithError(err).Error("error_500: error SaveSAMLConnection - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (rs model.SAMLConnection, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSAMLConnection")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("business_id = ?", businessID).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSAMLConnection - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListSAMLConnections returns every connection, the oldest first
+func (r *RepoPG) ListSAMLConnections(ctx context.Context, tx *gorm.DB) (rs []model.SAMLConnection, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListSAMLConnections")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Order("created_at, business_id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListSAMLConnections - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteSAMLConnection deletes the connection of the business, ok is false when it has none
+func (r *RepoPG) DeleteSAMLConnection(ctx context.Context, businessID uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteSAMLConnection")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("business_id = ?", businessID).Delete(&model.SAMLConnection{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteSAMLConnection - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *RepoPG) CreateSAMLAuthRequest(ctx context.Context, req *model.SAMLAuthRequest, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateSAMLAuthRequest")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateSAMLAuthRequest - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetSAMLAuthRequestByRelayState(ctx context.Context, relayStateHash string, tx *gorm.DB) (rs model.SAMLAuthRequest, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSAMLAuthRequestByRelayState")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("relay_state_hash = ?", relayStateHash).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSAMLAuthRequestByRelayState - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ConsumeSAMLAuthRequest marks the request used, ok is false when it was already used
+func (r *RepoPG) ConsumeSAMLAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ConsumeSAMLAuthRequest")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.SAMLAuthRequest{}).Where("id = ? AND consumed_at IS NULL", id).UpdateColumn("consumed_at", at)
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error ConsumeSAMLAuthRequest - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
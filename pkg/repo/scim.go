@@ -0,0 +1,363 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// withUser preloads the user of SCIM memberships, soft-deleted or not
+func withUser(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("User", func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+}
+
+func (r *RepoPG) CreateSCIMToken(ctx context.Context, req *model.SCIMToken, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateSCIMToken")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateSCIMToken - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetSCIMTokenByHash(ctx context.Context, tokenHash string, tx *gorm.DB) (rs model.SCIMToken, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSCIMTokenByHash")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("token_hash = ?", tokenHash).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSCIMTokenByHash - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListSCIMTokens returns the tokens of the business, of every business when businessID is nil, the oldest first
+func (r *RepoPG) ListSCIMTokens(ctx context.Context, businessID *uuid.UUID, tx *gorm.DB) (rs []model.SCIMToken, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListSCIMTokens")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if businessID != nil {
+		tx = tx.Where("business_id = ?", *businessID)
+	}
+	if err = tx.Order("created_at, id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListSCIMTokens - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteSCIMToken deletes the token, ok is false when there is none
+func (r *RepoPG) DeleteSCIMToken(ctx context.Context, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteSCIMToken")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("id = ?", id).Delete(&model.SCIMToken{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteSCIMToken - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *RepoPG) CreateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateSCIMUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Omit("User").Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateSCIMUser - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// GetSCIMUser returns the membership of the user, whatever business provisioned it
+func (r *RepoPG) GetSCIMUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) (rs model.SCIMUser, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSCIMUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = withUser(tx).Where("user_id = ?", userID).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSCIMUser - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// GetSCIMUserByEmail returns the membership in the business of the user with the email, deprovisioned or not
+func (r *RepoPG) GetSCIMUserByEmail(ctx context.Context, businessID uuid.UUID, email string, tx *gorm.DB) (rs model.SCIMUser, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSCIMUserByEmail")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	err = withUser(tx).Joins("JOIN users ON users.id = scim_users.user_id").
+		Where("scim_users.business_id = ? AND users.email_normalized = ?", businessID, model.NormalizeEmail(email)).
+		Order("scim_users.deprovisioned_at IS NULL DESC, scim_users.updated_at DESC").First(&rs).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSCIMUserByEmail - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListSCIMUsers returns the users the business provisioned and did not deprovision, the oldest first
+func (r *RepoPG) ListSCIMUsers(ctx context.Context, businessID uuid.UUID, filter model.SCIMUserFilter, tx *gorm.DB) (rs []model.SCIMUser, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListSCIMUsers")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = withUser(tx).Where("scim_users.business_id = ? AND scim_users.deprovisioned_at IS NULL", businessID)
+	if filter.Email != "" {
+		tx = tx.Joins("JOIN users ON users.id = scim_users.user_id").
+			Where("users.email_normalized = ?", model.NormalizeEmail(filter.Email))
+	}
+	if filter.ExternalID != "" {
+		tx = tx.Where("scim_users.external_id = ?", filter.ExternalID)
+	}
+	if err = tx.Order("scim_users.created_at, scim_users.user_id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListSCIMUsers - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// UpdateSCIMUser saves the business, external id, name parts and deprovisioning of the membership
+func (r *RepoPG) UpdateSCIMUser(ctx context.Context, req *model.SCIMUser, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.UpdateSCIMUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	req.UpdatedAt = time.Now()
+	err := tx.Model(&model.SCIMUser{}).Where("user_id = ?", req.UserID).UpdateColumns(map[string]interface{}{
+		"business_id": req.BusinessID, "external_id": req.ExternalID, "given_name": req.GivenName,
+		"family_name": req.FamilyName, "deprovisioned_at": req.DeprovisionedAt, "updated_at": req.UpdatedAt,
+	}).Error
+	if err != nil {
+		log.WithError(err).Error("error_500: error UpdateSCIMUser - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) CreateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateSCIMGroup")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateSCIMGroup - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (rs model.SCIMGroup, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetSCIMGroup")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("business_id = ? AND id = ?", businessID, id).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetSCIMGroup - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ListSCIMGroups returns the groups of the business, the oldest first
+func (r *RepoPG) ListSCIMGroups(ctx context.Context, businessID uuid.UUID, filter model.SCIMGroupFilter, tx *gorm.DB) (rs []model.SCIMGroup, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListSCIMGroups")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Where("business_id = ?", businessID)
+	if filter.DisplayName != "" {
+		tx = tx.Where("LOWER(display_name) = LOWER(?)", filter.DisplayName)
+	}
+	if filter.ExternalID != "" {
+		tx = tx.Where("external_id = ?", filter.ExternalID)
+	}
+	if err = tx.Order("created_at, id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListSCIMGroups - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+func (r *RepoPG) UpdateSCIMGroup(ctx context.Context, req *model.SCIMGroup, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.UpdateSCIMGroup")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	req.UpdatedAt = time.Now()
+	err := tx.Model(&model.SCIMGroup{}).Where("id = ?", req.ID).UpdateColumns(map[string]interface{}{
+		"display_name": req.DisplayName, "external_id": req.ExternalID, "updated_at": req.UpdatedAt,
+	}).Error
+	if err != nil {
+		log.WithError(err).Error("error_500: error UpdateSCIMGroup - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// DeleteSCIMGroup deletes the group of the business with its members, ok is false when there is none
+func (r *RepoPG) DeleteSCIMGroup(ctx context.Context, businessID, id uuid.UUID, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteSCIMGroup")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("business_id = ? AND id = ?", businessID, id).Delete(&model.SCIMGroup{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteSCIMGroup - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	if err = tx.Where("group_id = ?", id).Delete(&model.SCIMGroupMember{}).Error; err != nil {
+		log.WithError(err).Error("error_500: error DeleteSCIMGroup - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return true, nil
+}
+
+// SetSCIMGroupMembers replaces the members of the group by the users
+func (r *RepoPG) SetSCIMGroupMembers(ctx context.Context, groupID, businessID uuid.UUID, userIDs []uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.SetSCIMGroupMembers")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Where("group_id = ?", groupID).Delete(&model.SCIMGroupMember{}).Error; err != nil {
+		log.WithError(err).Error("error_500: error SetSCIMGroupMembers - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	members := make([]model.SCIMGroupMember, 0, len(userIDs))
+	seen := map[uuid.UUID]bool{}
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, model.SCIMGroupMember{GroupID: groupID, UserID: id, BusinessID: businessID})
+		}
+	}
+	if err := tx.Create(&members).Error; err != nil {
+		log.WithError(err).Error("error_500: error SetSCIMGroupMembers - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// ListSCIMGroupMembers returns the memberships of the business in the groups or of the users, nil matches any
+func (r *RepoPG) ListSCIMGroupMembers(ctx context.Context, businessID uuid.UUID, groupIDs, userIDs []uuid.UUID, tx *gorm.DB) (rs []model.SCIMGroupMember, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ListSCIMGroupMembers")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	tx = tx.Where("business_id = ?", businessID)
+	if groupIDs != nil {
+		tx = tx.Where("group_id IN ?", groupIDs)
+	}
+	if userIDs != nil {
+		tx = tx.Where("user_id IN ?", userIDs)
+	}
+	if err = tx.Order("group_id, user_id").Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error ListSCIMGroupMembers - RepoPG")
+		return nil, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteSCIMGroupMembersOfUser removes the user from every group
+func (r *RepoPG) DeleteSCIMGroupMembersOfUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteSCIMGroupMembersOfUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&model.SCIMGroupMember{}).Error; err != nil {
+		log.WithError(err).Error("error_500: error DeleteSCIMGroupMembersOfUser - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+
+	defaultSQLiteDSN = "ms_user.db"
+)
+
+// OpenSQLite opens the single file database used when DB_DRIVER=sqlite,
+// naming follows the one cloud0 uses for Postgres so both drivers share table names
+func OpenSQLite(dsn string) (*gorm.DB, error) {
+	if dsn == "" {
+		dsn = defaultSQLiteDSN
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// sqlite does not support concurrent writers
+	sqlDB.SetMaxOpenConns(1)
+
+	return db, nil
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"github.com/google/uuid"
 	"gitlab.com/goxp/cloud0/ginext"
-	"gitlab.com/goxp/cloud0/logger"
 	"gorm.io/gorm"
 	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
 	"net/http"
+	"time"
 )
 
 func (r *RepoPG) TestMsUser(ctx context.Context) (err error) {
-	log := logger.WithCtx(ctx, "RepoPG.TestMsUser")
+	log := tracing.WithCtx(ctx, "RepoPG.TestMsUser")
 
 	log.Info("RepoPG: Test ms-user success")
 
@@ -19,26 +21,71 @@ func (r *RepoPG) TestMsUser(ctx context.Context) (err error) {
 }
 
 func (r *RepoPG) GetOneUserByEmail(ctx context.Context, email string, tx *gorm.DB) (rs model.User, err error) {
-	log := logger.WithCtx(ctx, "RepoPG.GetOneUserByEmail")
+	log := tracing.WithCtx(ctx, "RepoPG.GetOneUserByEmail")
 	var cancel context.CancelFunc
 	if tx == nil {
 		tx, cancel = r.DBWithTimeout(ctx)
 		defer cancel()
 	}
 
-	if err = tx.Model(&model.User{}).Where("email = ?", email).First(&rs).Error; err != nil {
+	if err = tx.Model(&model.User{}).Where("email_normalized = ?", model.NormalizeEmail(email)).First(&rs).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			log.WithError(err).Error("error_404: record not found in GetOneUserByEmail - RepoPG")
 			return rs, err
 		}
 		log.WithError(err).Error("error_500: error GetOneUserByEmail - RepoPG")
-		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
 	}
 	return rs, nil
 }
 
+// GetOneUserByPhone returns the user that verified phone, an E.164 number
+func (r *RepoPG) GetOneUserByPhone(ctx context.Context, phone string, tx *gorm.DB) (rs model.User, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOneUserByPhone")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Model(&model.User{}).Where("phone_e164 = ?", phone).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetOneUserByPhone - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// UpdateUserPhone sets the verified phone of the user, it is also its contact phone number
+func (r *RepoPG) UpdateUserPhone(ctx context.Context, userID uuid.UUID, phone string, verifiedAt time.Time, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.UpdateUserPhone")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+		"phone_number": phone, "phone_e164": phone, "phone_verified_at": verifiedAt, "updated_at": time.Now(),
+	})
+	if res.Error != nil {
+		if isUniqueViolation(res.Error) {
+			log.WithError(res.Error).Error("error_409: phone already used in UpdateUserPhone - RepoPG")
+			return ginext.NewError(http.StatusConflict, PhoneTakenMessage)
+		}
+		log.WithError(res.Error).Error("error_500: error UpdateUserPhone - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *RepoPG) GetOneUserByID(ctx context.Context, ID uuid.UUID, tx *gorm.DB) (rs model.User, err error) {
-	log := logger.WithCtx(ctx, "RepoPG.GetOneUserByEmail")
+	log := tracing.WithCtx(ctx, "RepoPG.GetOneUserByEmail")
 	var cancel context.CancelFunc
 	if tx == nil {
 		tx, cancel = r.DBWithTimeout(ctx)
@@ -51,13 +98,55 @@ func (r *RepoPG) GetOneUserByID(ctx context.Context, ID uuid.UUID, tx *gorm.DB)
 			return rs, err
 		}
 		log.WithError(err).Error("error_500: error GetOneUserByEmail - RepoPG")
-		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// GetUsersByIDs loads the users of ids in one query, unknown ids are skipped
+func (r *RepoPG) GetUsersByIDs(ctx context.Context, ids []uuid.UUID, tx *gorm.DB) (rs []model.User, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetUsersByIDs")
+	if len(ids) == 0 {
+		return rs, nil
+	}
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Model(&model.User{}).Where("id IN ?", ids).Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error GetUsersByIDs - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// GetUsersByEmails loads the users of emails in one query, unknown emails are skipped
+func (r *RepoPG) GetUsersByEmails(ctx context.Context, emails []string, tx *gorm.DB) (rs []model.User, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetUsersByEmails")
+	if len(emails) == 0 {
+		return rs, nil
+	}
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	normalized := make([]string, 0, len(emails))
+	for _, e := range emails {
+		normalized = append(normalized, model.NormalizeEmail(e))
+	}
+	if err = tx.Model(&model.User{}).Where("email_normalized IN ?", normalized).Find(&rs).Error; err != nil {
+		log.WithError(err).Error("error_500: error GetUsersByEmails - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
 	}
 	return rs, nil
 }
 
 func (r *RepoPG) CreateUser(ctx context.Context, req *model.User, tx *gorm.DB) error {
-	log := logger.WithCtx(ctx, "RepoPG.CreateUser")
+	log := tracing.WithCtx(ctx, "RepoPG.CreateUser")
 	var cancel context.CancelFunc
 	if tx == nil {
 		tx, cancel = r.DBWithTimeout(ctx)
@@ -65,10 +154,79 @@ func (r *RepoPG) CreateUser(ctx context.Context, req *model.User, tx *gorm.DB) e
 	}
 
 	if err := tx.Model(&model.User{}).Create(&req).Error; err != nil {
+		if isUniqueViolation(err) {
+			log.WithError(err).Error("error_409: email already used in CreateUser - RepoPG")
+			return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+		}
 		log.WithError(err).Error("error_500: error CreateUser - RepoPG")
-		return ginext.NewError(http.StatusInternalServerError, err.Error())
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
 	}
 	req.Password = ""
 
 	return nil
 }
+
+// UpdateUserProfile saves the email and the profile of the user, soft-deleted or not
+func (r *RepoPG) UpdateUserProfile(ctx context.Context, req *model.User, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.UpdateUserProfile")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	req.UpdatedAt = time.Now()
+	res := tx.Unscoped().Model(&model.User{}).Where("id = ?", req.ID).UpdateColumns(map[string]interface{}{
+		"email": req.Email, "email_normalized": model.NormalizedEmailOf(req.Email), "full_name": req.FullName,
+		"display_name": req.DisplayName, "phone_number": req.PhoneNumber, "images": req.Images, "link": req.Link,
+		"updated_at": req.UpdatedAt,
+	})
+	if res.Error != nil {
+		if isUniqueViolation(res.Error) {
+			log.WithError(res.Error).Error("error_409: email already used in UpdateUserProfile - RepoPG")
+			return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+		}
+		log.WithError(res.Error).Error("error_500: error UpdateUserProfile - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SoftDeleteUser deactivates the user: it can not sign in anymore and its access tokens are refused
+func (r *RepoPG) SoftDeleteUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.SoftDeleteUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Where("id = ?", userID).Delete(&model.User{}).Error; err != nil {
+		log.WithError(err).Error("error_500: error SoftDeleteUser - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+// RestoreUser reactivates a soft-deleted user
+func (r *RepoPG) RestoreUser(ctx context.Context, userID uuid.UUID, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.RestoreUser")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err := tx.Unscoped().Model(&model.User{}).Where("id = ?", userID).UpdateColumn("deleted_at", nil).Error; err != nil {
+		if isUniqueViolation(err) {
+			log.WithError(err).Error("error_409: email already used in RestoreUser - RepoPG")
+			return ginext.NewError(http.StatusConflict, AccountExistsMessage)
+		}
+		log.WithError(err).Error("error_500: error RestoreUser - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
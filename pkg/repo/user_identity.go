@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+	"ms-user/pkg/model"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// CreateUserIdentity answers a 409 when the subject of the provider is already linked
+func (r *RepoPG) CreateUserIdentity(ctx context.Context, req *model.UserIdentity, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateUserIdentity")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		if isUniqueViolation(err) {
+			log.WithError(err).Error("error_409: identity already linked in CreateUserIdentity - RepoPG")
+			return ginext.NewError(http.StatusConflict, IdentityLinkedMessage)
+		}
+		log.WithError(err).Error("error_500: error CreateUserIdentity - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetUserIdentity(ctx context.Context, provider, subject string, tx *gorm.DB) (rs model.UserIdentity, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetUserIdentity")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("provider = ? AND subject = ?", provider, subject).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetUserIdentity - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// DeleteUserIdentities unlinks every identity of the provider, the accounts are kept
+func (r *RepoPG) DeleteUserIdentities(ctx context.Context, provider string, tx *gorm.DB) (count int64, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.DeleteUserIdentities")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Where("provider = ?", provider).Delete(&model.UserIdentity{})
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error DeleteUserIdentities - RepoPG")
+		return 0, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected, nil
+}
+
+func (r *RepoPG) CreateOIDCAuthRequest(ctx context.Context, req *model.OIDCAuthRequest, tx *gorm.DB) error {
+	log := tracing.WithCtx(ctx, "RepoPG.CreateOIDCAuthRequest")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	if err := tx.Create(req).Error; err != nil {
+		log.WithError(err).Error("error_500: error CreateOIDCAuthRequest - RepoPG")
+		return ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return nil
+}
+
+func (r *RepoPG) GetOIDCAuthRequestByState(ctx context.Context, stateHash string, tx *gorm.DB) (rs model.OIDCAuthRequest, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.GetOIDCAuthRequestByState")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	if err = tx.Where("state_hash = ?", stateHash).First(&rs).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		log.WithError(err).Error("error_500: error GetOIDCAuthRequestByState - RepoPG")
+		return rs, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return rs, nil
+}
+
+// ConsumeOIDCAuthRequest marks the request used, ok is false when it was already used
+func (r *RepoPG) ConsumeOIDCAuthRequest(ctx context.Context, id uuid.UUID, at time.Time, tx *gorm.DB) (ok bool, err error) {
+	log := tracing.WithCtx(ctx, "RepoPG.ConsumeOIDCAuthRequest")
+	var cancel context.CancelFunc
+	if tx == nil {
+		tx, cancel = r.DBWithTimeout(ctx)
+		defer cancel()
+	}
+
+	res := tx.Model(&model.OIDCAuthRequest{}).Where("id = ? AND consumed_at IS NULL", id).UpdateColumn("consumed_at", at)
+	if res.Error != nil {
+		log.WithError(res.Error).Error("error_500: error ConsumeOIDCAuthRequest - RepoPG")
+		return false, ginext.NewError(http.StatusInternalServerError, utils.MessageError()[http.StatusInternalServerError])
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,71 @@
+package route_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"ms-user/pkg/model"
+)
+
+// TestImpersonationTokenLimits impersonates a user: the token opens the routes of the user,
+// not its sensitive operations nor the admin API, and dies with the admin rights of its admin
+func TestImpersonationTokenLimits(t *testing.T) {
+	adminToken, adminID := signUp(t, "impersonation-admin@example.com")
+	userToken, userID := signUp(t, "impersonation-user@example.com")
+	setAccountType(t, "impersonation-admin@example.com", model.AccountTypeAdmin)
+	reason := map[string]string{"reason": "ticket 42"}
+
+	if status, body := call(t, http.MethodPost, "/api/v1/admin/users/"+adminID+"/impersonate", userToken, reason); status != http.StatusForbidden {
+		t.Errorf("impersonate as a user: status %d, want 403: %s", status, body)
+	}
+	if status, body := call(t, http.MethodPost, "/api/v1/admin/users/"+userID+"/impersonate", adminToken, map[string]string{}); status != http.StatusBadRequest {
+		t.Errorf("impersonate without reason: status %d, want 400: %s", status, body)
+	}
+	var imp struct {
+		Data model.ImpersonationResponse `json:"data"`
+	}
+	status, body := call(t, http.MethodPost, "/api/v1/admin/users/"+userID+"/impersonate", adminToken, reason)
+	if err := json.Unmarshal(body, &imp); err != nil || status != http.StatusOK || imp.Data.Token == "" {
+		t.Fatalf("impersonate a user: status %d: %s", status, body)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		status       int
+	}{
+		{http.MethodGet, "/api/v1/user/me/logins", nil, http.StatusOK},
+		{http.MethodGet, "/api/v1/user/get-one/" + adminID, nil, http.StatusOK},
+		// the routes of ownerApi
+		{http.MethodPost, "/api/v1/user/me/api-keys", map[string]interface{}{"name": "k", "scopes": []string{model.APIKeyScopeRead}}, http.StatusForbidden},
+		{http.MethodPost, "/api/v1/user/me/phone/otp", map[string]string{"phone": "+84912345678"}, http.StatusForbidden},
+		{http.MethodPost, "/api/v1/oauth/authorize", map[string]interface{}{"approve": true}, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/admin/audit/events", nil, http.StatusForbidden},
+	} {
+		if status, body := call(t, tc.method, tc.path, imp.Data.Token, tc.body); status != tc.status {
+			t.Errorf("%s %s while impersonating: status %d, want %d: %s", tc.method, tc.path, status, tc.status, body)
+		}
+	}
+	// RequireAdmin refuses an impersonation token even when the user it names is an admin
+	setAccountType(t, "impersonation-user@example.com", model.AccountTypeAdmin)
+	for _, path := range []string{"/api/v1/admin/audit/events", "/api/v1/admin/scim/tokens"} {
+		if status, body := call(t, http.MethodGet, path, adminToken, nil); status != http.StatusOK {
+			t.Errorf("GET %s as the admin: status %d, want 200: %s", path, status, body)
+		}
+		if status, body := call(t, http.MethodGet, path, imp.Data.Token, nil); status != http.StatusForbidden {
+			t.Errorf("GET %s while impersonating an admin: status %d, want 403: %s", path, status, body)
+		}
+	}
+	setAccountType(t, "impersonation-user@example.com", "")
+
+	if status, body := call(t, http.MethodPost, "/api/v1/user/refresh-token", "", map[string]string{"refresh_token": imp.Data.Token}); status != http.StatusUnauthorized {
+		t.Errorf("refresh an impersonation token: status %d, want 401: %s", status, body)
+	}
+
+	// the token dies with the admin rights of its admin
+	setAccountType(t, "impersonation-admin@example.com", "")
+	if status, body := call(t, http.MethodGet, "/api/v1/user/me/logins", imp.Data.Token, nil); status != http.StatusUnauthorized {
+		t.Errorf("impersonation token of a former admin: status %d, want 401: %s", status, body)
+	}
+}
@@ -0,0 +1,74 @@
+package route_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ms-user/pkg/client"
+	"ms-user/pkg/model"
+	"ms-user/pkg/servicetoken"
+)
+
+// TestInternalRoutesNeedAServiceToken calls the /internal routes as testService, a user and nobody
+func TestInternalRoutesNeedAServiceToken(t *testing.T) {
+	userToken, userID := signUp(t, "internal-user@example.com")
+	serviceToken, err := servicetoken.Sign(testService.Name, testService.Secret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key struct {
+		Data model.CreateAPIKeyResponse `json:"data"`
+	}
+	status, body := call(t, http.MethodPost, "/api/v1/user/me/api-keys", userToken, map[string]interface{}{"name": "etl", "scopes": []string{model.APIKeyScopeRead}})
+	if err = json.Unmarshal(body, &key); err != nil || status != http.StatusOK {
+		t.Fatalf("create an API key: status %d: %s", status, body)
+	}
+	batch := map[string]interface{}{"ids": []string{userID}}
+
+	for _, tc := range []struct {
+		name, path, token string
+		status            int
+	}{
+		{"without token", "/internal/users/batch-get", "", http.StatusUnauthorized},
+		{"with an access token", "/internal/users/batch-get", userToken, http.StatusUnauthorized},
+		{"with an API key", "/internal/users/batch-get", key.Data.Key, http.StatusUnauthorized},
+		{"on a route the service is not allowed on", "/internal/migrate", serviceToken, http.StatusForbidden},
+	} {
+		if status, body := call(t, http.MethodPost, tc.path, tc.token, batch); status != tc.status {
+			t.Errorf("call %s %s: status %d, want %d: %s", tc.path, tc.name, status, tc.status, body)
+		}
+	}
+	if status, body := call(t, http.MethodGet, "/api/v1/user/me/logins", serviceToken, nil); status != http.StatusUnauthorized {
+		t.Errorf("call a user route with a service token: status %d, want 401: %s", status, body)
+	}
+
+	var found struct {
+		Data model.BatchGetUsersResponse `json:"data"`
+	}
+	status, body = call(t, http.MethodPost, "/internal/users/batch-get", serviceToken, batch)
+	if err = json.Unmarshal(body, &found); err != nil || status != http.StatusOK || len(found.Data.Users) != 1 {
+		t.Errorf("look up a user as %s: status %d: %s", testService.Name, status, body)
+	}
+}
+
+// TestClientSignsServiceTokens calls the /internal routes with the Go client, it signs its own tokens
+func TestClientSignsServiceTokens(t *testing.T) {
+	_, userID := signUp(t, "internal-client@example.com")
+	srv := httptest.NewServer(testApp.Router)
+	defer srv.Close()
+
+	ctx := context.Background()
+	sdk := client.New(srv.URL, client.WithServiceCredentials(testService.Name, testService.Secret), client.WithRetryPolicy(client.NoRetry))
+	if rs, err := sdk.BatchGetUsers(ctx, client.BatchGetUsersRequest{IDs: []uuid.UUID{uuid.MustParse(userID)}}); err != nil || len(rs.Users) != 1 {
+		t.Errorf("BatchGetUsers = %+v, %v, want the user", rs, err)
+	}
+	if err := sdk.Migrate(ctx); !client.IsForbidden(err) {
+		t.Errorf("Migrate as %s err = %v, want 403", testService.Name, err)
+	}
+}
@@ -0,0 +1,17 @@
+package route_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestOAuthClientsNeedAnAdmin registers a client as a user, only admins manage the clients
+func TestOAuthClientsNeedAnAdmin(t *testing.T) {
+	token, _ := signUp(t, "oauth-user@example.com")
+	status, body := call(t, http.MethodPost, "/api/v1/admin/oauth/clients", token, map[string]interface{}{
+		"name": "Not an admin", "redirect_uris": []string{"http://127.0.0.1/callback"},
+	})
+	if status != http.StatusForbidden {
+		t.Errorf("register a client as a user: status %d, want 403: %s", status, body)
+	}
+}
@@ -0,0 +1,84 @@
+package route_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ms-user/pkg/servicetoken"
+)
+
+// TestResponsesHidePasswords signs up two users and calls the routes answering with users,
+// none may show a password field or a bcrypt hash
+func TestResponsesHidePasswords(t *testing.T) {
+	router := testApp.Router
+	call := func(method, path, token string, body interface{}) map[string]interface{} {
+		t.Helper()
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var rs map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+		}
+		if where := findPassword(rs, "$"); where != "" {
+			t.Errorf("%s %s: the response shows a password at %s", method, path, where)
+		}
+		data, _ := rs["data"].(map[string]interface{})
+		return data
+	}
+
+	credentials := map[string]string{"email": "password-check@example.com", "password": "Passw0rd!check", "device_id": "password-check"}
+	self := call(http.MethodPost, "/api/v1/user/create", "", credentials)
+	other := call(http.MethodPost, "/api/v1/user/create", "", map[string]string{"email": "password-check-other@example.com", "password": "Passw0rd!check"})
+	tokens := call(http.MethodPost, "/api/v1/user/login", "", credentials)
+	tokens = call(http.MethodPost, "/api/v1/user/refresh-token", "", map[string]interface{}{"refresh_token": tokens["refresh_token"]})
+	token, _ := tokens["token"].(string)
+
+	for _, id := range []interface{}{self["id"], other["id"]} {
+		call(http.MethodGet, fmt.Sprintf("/api/v1/user/get-one/%v", id), token, nil)
+	}
+	call(http.MethodGet, "/api/v1/user/me/logins", token, nil)
+	serviceToken, err := servicetoken.Sign(testService.Name, testService.Secret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(http.MethodPost, "/internal/users/batch-get", serviceToken, map[string]interface{}{"ids": []interface{}{self["id"], other["id"]}})
+}
+
+// findPassword returns the JSON path of the first password field or bcrypt hash in v
+func findPassword(v interface{}, path string) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if strings.Contains(strings.ToLower(k), "password") {
+				return path + "." + k
+			}
+			if where := findPassword(child, path+"."+k); where != "" {
+				return where
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if where := findPassword(child, fmt.Sprintf("%s[%d]", path, i)); where != "" {
+				return where
+			}
+		}
+	case string:
+		if strings.HasPrefix(v, "$2a$") || strings.HasPrefix(v, "$2b$") || strings.HasPrefix(v, "$2y$") {
+			return path
+		}
+	}
+	return ""
+}
@@ -1,13 +1,33 @@
 package route
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/caarlos0/env/v6"
-	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
 	"gitlab.com/goxp/cloud0/ginext"
+	"gitlab.com/goxp/cloud0/logger"
 	"gitlab.com/goxp/cloud0/service"
+	"gorm.io/gorm"
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/grpcserver"
 	"ms-user/pkg/handlers"
+	"ms-user/pkg/health"
+	"ms-user/pkg/mailer"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/oidc"
+	"ms-user/pkg/openapi"
 	"ms-user/pkg/repo"
+	"ms-user/pkg/security"
 	service2 "ms-user/pkg/service"
+	"ms-user/pkg/sms"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/worker"
 )
 
 type extraSetting struct {
@@ -17,30 +37,92 @@ type extraSetting struct {
 type Service struct {
 	*service.BaseApp
 	setting *extraSetting
+
+	db       *gorm.DB
+	grpc     *grpcserver.Server
+	workers  *worker.Group
+	draining int32
 }
 
 func NewService() *Service {
 	s := &Service{
-		service.NewApp("MS User Tutorial", "v1.0"),
-		&extraSetting{},
+		BaseApp: service.NewApp("MS User Tutorial", "v1.0"),
+		setting: &extraSetting{},
+		workers: worker.NewGroup(),
 	}
 
+	cfg := conf.LoadEnv()
+
 	// repo
 	_ = env.Parse(s.setting)
-	db := s.GetDB()
+	db := s.openDB()
 	if s.setting.DbDebugEnable {
 		db = db.Debug()
 	}
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		logger.Tag("NewService").WithError(err).Error("failed to register gorm metrics")
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		logger.Tag("NewService").WithError(err).Error("failed to register gorm tracing")
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		metrics.RegisterDBStats(metrics.DefaultRegistry, sqlDB)
+	}
+	s.db = db
 	repoPG := repo.NewPGRepo(db)
-	s.Router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"PUT", "PATCH", "GET", "DELETE", "POST"},
-		AllowHeaders:     []string{"Origin"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
+	// the client IP is resolved first for the access log, tracing and audit,
+	// tracing & metrics go before the cloud0 error handler to see the status code it writes
+	proxies, err := security.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	s.Router.Handlers = append(gin.HandlersChain{
+		security.ProxyMiddleware(proxies), tracing.GinMiddleware(), metrics.GinMiddleware(),
+	}, s.Router.Handlers...)
+	s.Router.Use(security.CORS(security.CORSConfig{
+		AllowOrigins:     cfg.CORSAllowOrigins,
+		AllowMethods:     cfg.CORSAllowMethods,
+		AllowHeaders:     cfg.CORSAllowHeaders,
+		ExposeHeaders:    cfg.CORSExposeHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           time.Duration(cfg.CORSMaxAgeSeconds) * time.Second,
+		Skip:             func(c *gin.Context) bool { return service2.IsSAMLACSPath(c.Request.URL.Path) },
 	}))
-	userService := service2.NewUserService(repoPG)
+	s.Router.Use(security.HeadersMiddleware(security.HeadersConfig{
+		HSTSMaxAge:            time.Duration(cfg.HSTSMaxAgeSeconds) * time.Second,
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          cfg.FrameOptions,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		ReferrerPolicy:        "no-referrer",
+	}))
+	s.Router.Use(audit.GinMiddleware())
+	userService := service2.NewUserService(repoPG, s.newSMSSender(), s.newOIDCProviders())
+	outboxWorker := service2.NewOutboxWorker(repoPG, s.newMailer(), service2.OutboxConfig{
+		PollInterval: time.Duration(conf.LoadEnv().OutboxPollIntervalMs) * time.Millisecond,
+		MaxAttempts:  conf.LoadEnv().OutboxMaxAttempts,
+	})
+	s.workers.Go("outbox", outboxWorker.Run)
+	auditChainWorker := service2.NewAuditChainWorker(repoPG, time.Duration(cfg.AuditChainIntervalMs)*time.Millisecond)
+	s.workers.Go("audit-chain", auditChainWorker.Run)
+	purgeWorker := service2.NewPurgeWorker(repoPG, service2.PurgeConfig{
+		Interval:  time.Duration(cfg.PurgeIntervalSeconds) * time.Second,
+		Retention: time.Duration(cfg.PurgeRetentionHours) * time.Hour,
+	})
+	s.workers.Go("purge", purgeWorker.Run)
 	userHandle := handlers.NewUserHandlers(userService)
+	internalServices, err := cfg.InternalServiceList()
+	if err != nil {
+		panic(err)
+	}
+	internalAuthService := service2.NewInternalAuthService(repoPG, internalServices)
+	if cfg.GRPCPort > 0 {
+		s.grpc = grpcserver.New(userService, internalAuthService, grpcserver.Config{MaxRecvMsgBytes: cfg.GRPCMaxRecvMsgBytes})
+	}
+	auditHandle := handlers.NewAuditHandlers(service2.NewAuditService(repoPG))
+	oauthHandle := handlers.NewOAuthHandlers(service2.NewOAuthService(repoPG, s.newOAuthSigner()))
+	samlHandle := handlers.NewSAMLHandlers(service2.NewSAMLService(repoPG))
+	scimHandle := handlers.NewSCIMHandlers(service2.NewSCIMService(repoPG))
+	internalAuthHandle := handlers.NewInternalAuthHandlers(internalAuthService)
 
 	v1Api := s.Router.Group("/api/v1")
 
@@ -49,16 +131,211 @@ func NewService() *Service {
 	// user
 	v1Api.POST("user/create", ginext.WrapHandler(userHandle.CreateUser))
 	v1Api.POST("user/login", ginext.WrapHandler(userHandle.Login))
+	v1Api.POST("user/refresh-token", ginext.WrapHandler(userHandle.RefreshToken))
+	v1Api.POST("user/login/phone/otp", ginext.WrapHandler(userHandle.RequestPhoneLoginCode))
+	v1Api.POST("user/login/phone", ginext.WrapHandler(userHandle.LoginWithPhone))
+	v1Api.POST("user/login/magic-link", ginext.WrapHandler(userHandle.RequestMagicLink))
+	v1Api.GET("user/login/magic-link/consume", ginext.WrapHandler(userHandle.ConsumeMagicLink))
+	v1Api.GET("user/login/oidc/:provider", userHandle.StartOIDCLogin)
+	v1Api.GET("user/login/oidc/:provider/callback", ginext.WrapHandler(userHandle.OIDCCallback))
+	v1Api.GET("user/login/saml/:business_id", userHandle.StartSAMLLogin)
+	v1Api.POST("user/login/saml/:business_id/acs", ginext.WrapHandler(userHandle.SAMLACS))
+	v1Api.GET("user/login/saml/:business_id/metadata", userHandle.SAMLMetadata)
+
+	// oauth 2.0 / openid connect provider, the protocol endpoints live at the root like their issuer
+	s.Router.GET("/.well-known/openid-configuration", oauthHandle.Discovery)
+	s.Router.GET(service2.OAuthJWKSPath, oauthHandle.JWKS)
+	s.Router.GET(service2.OAuthAuthorizePath, oauthHandle.Authorize)
+	s.Router.POST(service2.OAuthTokenPath, oauthHandle.Token)
+	s.Router.GET(service2.OAuthUserInfoPath, oauthHandle.UserInfo)
+	s.Router.POST(service2.OAuthUserInfoPath, oauthHandle.UserInfo)
+
+	// scim 2.0, the directories of the businesses provision their users with a SCIM token
+	s.Router.GET(service2.SCIMPath+"/ServiceProviderConfig", scimHandle.ServiceProviderConfig)
+	scimApi := s.Router.Group(service2.SCIMPath, scimHandle.Authenticate())
+	{
+		scimApi.GET("/Users", scimHandle.ListUsers)
+		scimApi.POST("/Users", scimHandle.CreateUser)
+		scimApi.GET("/Users/:id", scimHandle.GetUser)
+		scimApi.PUT("/Users/:id", scimHandle.ReplaceUser)
+		scimApi.PATCH("/Users/:id", scimHandle.PatchUser)
+		scimApi.DELETE("/Users/:id", scimHandle.DeleteUser)
+		scimApi.GET("/Groups", scimHandle.ListGroups)
+		scimApi.POST("/Groups", scimHandle.CreateGroup)
+		scimApi.GET("/Groups/:id", scimHandle.GetGroup)
+		scimApi.PUT("/Groups/:id", scimHandle.ReplaceGroup)
+		scimApi.PATCH("/Groups/:id", scimHandle.PatchGroup)
+		scimApi.DELETE("/Groups/:id", scimHandle.DeleteGroup)
+	}
 
 	// Migrate
 	migrateHandler := handlers.NewMigrationHandler(db)
-	s.Router.POST("/internal/migrate", migrateHandler.Migrate)
+	if conf.LoadEnv().DBDriver == repo.DriverSQLite {
+		// a local sqlite file has nobody to call /internal/migrate
+		if err := migrateHandler.MigrateDB(); err != nil {
+			logger.Tag("NewService").WithError(err).Error("failed to migrate sqlite database")
+		}
+	}
+	// the other services call /internal with a service token, each on the routes INTERNAL_SERVICES allows it
+	internalApi := s.Router.Group("/internal", internalAuthHandle.RequireService())
+	{
+		internalApi.POST("/migrate", migrateHandler.Migrate)
+		internalApi.POST("/users/batch-get", ginext.WrapHandler(userHandle.BatchGetUsers))
+	}
+
+	// probes & metrics
+	s.Router.GET("/metrics", metrics.GinHandler())
+	s.Router.GET("/healthz", health.LivenessHandler())
+	s.Router.GET("/readyz", s.readiness(db, migrateHandler, outboxWorker).ReadinessHandler())
+
+	// docs
+	s.Router.GET("/openapi.json", openapi.Handler())
+	s.Router.GET("/docs", openapi.DocsHandler())
 
 	// middleware
 	v1Api.Use(userHandle.VerifyTokenHandler())
 	{
 		v1Api.GET("/user/get-one/:id", ginext.WrapHandler(userHandle.GetOneUserByID))
+		v1Api.GET("/user/me/logins", ginext.WrapHandler(userHandle.GetMyLogins))
+		v1Api.GET("/user/me/oauth/consents", ginext.WrapHandler(oauthHandle.ListMyConsents))
+		v1Api.GET("/oauth/authorize", ginext.WrapHandler(oauthHandle.AuthorizeInfo))
+	}
+
+	// sensitive operations on the account, refused to the admins impersonating the user
+	ownerApi := v1Api.Group("", userHandle.RefuseImpersonation())
+	{
+		ownerApi.POST("/user/me/phone/otp", ginext.WrapHandler(userHandle.RequestPhoneVerificationCode))
+		ownerApi.POST("/user/me/phone/verify", ginext.WrapHandler(userHandle.VerifyPhone))
+		ownerApi.DELETE("/user/me/oauth/consents/:client_id", ginext.WrapHandler(oauthHandle.RevokeMyConsent))
+		ownerApi.POST("/user/me/api-keys", ginext.WrapHandler(userHandle.CreateMyAPIKey))
+		ownerApi.GET("/user/me/api-keys", ginext.WrapHandler(userHandle.ListMyAPIKeys))
+		ownerApi.DELETE("/user/me/api-keys/:id", ginext.WrapHandler(userHandle.RevokeMyAPIKey))
+		ownerApi.POST("/oauth/authorize", ginext.WrapHandler(oauthHandle.Decide))
+	}
+
+	// admin
+	adminApi := v1Api.Group("/admin", userHandle.RequireAdmin())
+	{
+		adminApi.GET("/audit/events", ginext.WrapHandler(auditHandle.ListEvents))
+		adminApi.GET("/audit/events/export", auditHandle.ExportEvents)
+		adminApi.GET("/audit/verify", ginext.WrapHandler(auditHandle.VerifyChain))
+		adminApi.POST("/oauth/clients", ginext.WrapHandler(oauthHandle.CreateClient))
+		adminApi.GET("/oauth/clients", ginext.WrapHandler(oauthHandle.ListClients))
+		adminApi.DELETE("/oauth/clients/:client_id", ginext.WrapHandler(oauthHandle.DeleteClient))
+		adminApi.GET("/saml/connections", ginext.WrapHandler(samlHandle.ListConnections))
+		adminApi.GET("/saml/connections/:business_id", ginext.WrapHandler(samlHandle.GetConnection))
+		adminApi.PUT("/saml/connections/:business_id", ginext.WrapHandler(samlHandle.SaveConnection))
+		adminApi.DELETE("/saml/connections/:business_id", ginext.WrapHandler(samlHandle.DeleteConnection))
+		adminApi.POST("/scim/tokens", ginext.WrapHandler(scimHandle.CreateToken))
+		adminApi.GET("/scim/tokens", ginext.WrapHandler(scimHandle.ListTokens))
+		adminApi.DELETE("/scim/tokens/:id", ginext.WrapHandler(scimHandle.DeleteToken))
+		adminApi.POST("/users/:id/impersonate", ginext.WrapHandler(userHandle.Impersonate))
+	}
+
+	if missing, err := openapi.MissingRoutes(s.Router.Routes()); err != nil || len(missing) > 0 {
+		logger.Tag("NewService").WithError(err).WithField("routes", missing).Error("routes missing from openapi.json")
 	}
 
 	return s
 }
+
+// readiness registers the checks of the subsystems owned by ms-user
+func (s *Service) readiness(db *gorm.DB, migrateHandler *handlers.MigrationHandler, outboxWorker *service2.OutboxWorker) *health.Registry {
+	registry := health.NewRegistry(time.Duration(conf.LoadEnv().ReadyCheckTimeoutMs) * time.Millisecond)
+	registry.Register("shutdown", s.readyCheck)
+	if sqlDB, err := db.DB(); err == nil {
+		registry.Register("db", health.DBCheck(sqlDB))
+	}
+	registry.Register("migrations", func(ctx context.Context) error {
+		pending, err := migrateHandler.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+		}
+		return nil
+	})
+	registry.Register("outbox", health.LagCheck(outboxWorker.Lag, time.Duration(conf.LoadEnv().OutboxMaxLagSeconds)*time.Second))
+	return registry
+}
+
+// newMailer returns the mailer of MAIL_DRIVER, it falls back to the log driver on a bad setting
+func (s *Service) newMailer() mailer.Mailer {
+	m, err := mailer.New(mailer.Config{
+		Driver:   conf.LoadEnv().MailDriver,
+		From:     conf.LoadEnv().MailFrom,
+		SMTPHost: conf.LoadEnv().SMTPHost,
+		SMTPPort: conf.LoadEnv().SMTPPort,
+		SMTPUser: conf.LoadEnv().SMTPUser,
+		SMTPPass: conf.LoadEnv().SMTPPass,
+	})
+	if err != nil {
+		logger.Tag("NewService").WithError(err).Error("invalid mail settings, emails are only logged")
+		return mailer.LogMailer{}
+	}
+	return m
+}
+
+// newSMSSender returns the sender of SMS_DRIVER, it falls back to the log driver on a bad setting
+func (s *Service) newSMSSender() sms.SMSSender {
+	sender, err := sms.New(sms.Config{Driver: conf.LoadEnv().SMSDriver})
+	if err != nil {
+		logger.Tag("NewService").WithError(err).Error("invalid sms settings, text messages are only logged")
+		return sms.LogSender{}
+	}
+	return sender
+}
+
+// newOIDCProviders returns the providers of OIDC_PROVIDERS, their discovery documents are fetched on first use
+func (s *Service) newOIDCProviders() map[string]*oidc.Provider {
+	list, err := conf.LoadEnv().OIDCProviderList()
+	if err != nil {
+		logger.Tag("NewService").WithError(err).Error("invalid oidc settings, no provider is enabled")
+		return nil
+	}
+	providers := make(map[string]*oidc.Provider, len(list))
+	for _, p := range list {
+		providers[p.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+		})
+	}
+	return providers
+}
+
+// newOAuthSigner returns the key of OAUTH_SIGNING_KEY, without one ID tokens are signed with a key generated at startup
+func (s *Service) newOAuthSigner() *oauth.Signer {
+	if key := conf.LoadEnv().OAuthSigningKey; key != "" {
+		signer, err := oauth.ParseSigner(key)
+		if err != nil {
+			panic(err)
+		}
+		return signer
+	}
+	logger.Tag("NewService").Warn("OAUTH_SIGNING_KEY is not set, ID tokens are signed with a key that changes on every restart")
+	signer, err := oauth.GenerateSigner()
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+// openDB returns the Postgres connection opened by cloud0, or a sqlite file when DB_DRIVER=sqlite
+func (s *Service) openDB() *gorm.DB {
+	if conf.LoadEnv().DBDriver != repo.DriverSQLite {
+		return s.GetDB()
+	}
+
+	// ENABLE_DB is off in sqlite mode, cloud0 only sets up the router here
+	if err := s.Initialize(); err != nil {
+		panic(err)
+	}
+	db, err := repo.OpenSQLite(conf.LoadEnv().DBDSN)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
@@ -0,0 +1,133 @@
+package route_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gitlab.com/goxp/cloud0/logger"
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/openapi"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/route"
+)
+
+// testService may call the /internal routes of testApp
+var testService = conf.InternalService{
+	Name:      "ms-user-test",
+	Secret:    "0123456789abcdef0123456789abcdef",
+	Endpoints: []string{"POST /internal/users/batch-get"},
+}
+
+// testApp is shared by the tests, the metrics of a service are registered once per process
+var testApp *route.Service
+
+// testDSN is the sqlite database of testApp, the tests open it for what the API can not do
+var testDSN string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ms-user-route-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testDSN = filepath.Join(dir, "ms_user.db")
+	app, err := newTestService(testDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testApp = app
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestService builds the service on the sqlite database of dsn, the settings of the environment apply on top
+func newTestService(dsn string) (*route.Service, error) {
+	_ = os.Setenv("DB_DRIVER", repo.DriverSQLite)
+	_ = os.Setenv("DB_DSN", dsn)
+	_ = os.Setenv("DB_DEBUG_ENABLE", "false")
+	services, err := json.Marshal([]conf.InternalService{testService})
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Setenv("INTERNAL_SERVICES", string(services))
+	if err = conf.SetEnv(); err != nil {
+		return nil, err
+	}
+	logger.Init("ms-user-test")
+	gin.SetMode(gin.TestMode)
+	conf.ExportCloud0Env()
+	_ = os.Setenv("ENABLE_DB", "false")
+
+	return route.NewService(), nil
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	missing, err := openapi.MissingRoutes(testApp.Router.Routes())
+	if err != nil {
+		t.Fatalf("MissingRoutes: %v", err)
+	}
+	for _, r := range missing {
+		t.Errorf("route missing from openapi.json: %s", r)
+	}
+}
+
+// call sends body as JSON to the router of testApp, with token as bearer token when set
+func call(t *testing.T, method, path, token string, body interface{}) (int, []byte) {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	testApp.Router.ServeHTTP(w, req)
+	return w.Code, w.Body.Bytes()
+}
+
+// signUp creates a password account and returns its access token and id
+func signUp(t *testing.T, email string) (token, userID string) {
+	t.Helper()
+	credentials := map[string]string{"email": email, "password": "Passw0rd!check", "device_id": "route-test"}
+	var rs struct {
+		Data struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	status, body := call(t, http.MethodPost, "/api/v1/user/create", "", credentials)
+	if err := json.Unmarshal(body, &rs); err != nil || status != http.StatusOK {
+		t.Fatalf("sign up %s: status %d: %s", email, status, body)
+	}
+	userID = rs.Data.ID
+	status, body = call(t, http.MethodPost, "/api/v1/user/login", "", credentials)
+	if err := json.Unmarshal(body, &rs); err != nil || status != http.StatusOK {
+		t.Fatalf("login %s: status %d: %s", email, status, body)
+	}
+	return rs.Data.Token, userID
+}
+
+// setAccountType changes the account type of the user of email, the API has no way to do it
+func setAccountType(t *testing.T, email, accountType string) {
+	t.Helper()
+	db, err := repo.OpenSQLite(testDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	if err = db.Model(&model.User{}).Where("email = ?", email).Update("account_type", accountType).Error; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+package route_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestSAMLConnectionsNeedAnAdmin saves a connection as a user, only admins manage connections
+func TestSAMLConnectionsNeedAnAdmin(t *testing.T) {
+	token, _ := signUp(t, "saml-user@example.com")
+
+	save := map[string]interface{}{"metadata_xml": "<EntityDescriptor/>", "domains": []string{"acme.example"}}
+	if status, body := call(t, http.MethodPut, "/api/v1/admin/saml/connections/"+uuid.New().String(), token, save); status != http.StatusForbidden {
+		t.Errorf("save a connection as a user: status %d, want 403: %s", status, body)
+	}
+}
+
+// TestSAMLACSAcceptsCrossSitePosts posts to the assertion consumer service from another site,
+// the identity provider makes the browser post its Response so CORS must let it through
+func TestSAMLACSAcceptsCrossSitePosts(t *testing.T) {
+	form := url.Values{"SAMLResponse": {"x"}, "RelayState": {"x"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/login/saml/"+uuid.New().String()+"/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://idp.test")
+	w := httptest.NewRecorder()
+	testApp.Router.ServeHTTP(w, req)
+
+	// the business has no connection, the post reached the assertion consumer service
+	if w.Code != http.StatusNotFound {
+		t.Errorf("cross-site post to the assertion consumer service: status %d, want 404: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,25 @@
+package route_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestSCIMNeedsATokenOfTheBusiness calls the SCIM endpoints without a SCIM token, only admins make the tokens
+func TestSCIMNeedsATokenOfTheBusiness(t *testing.T) {
+	token, _ := signUp(t, "scim-user@example.com")
+	if status, body := call(t, http.MethodPost, "/api/v1/admin/scim/tokens", token, map[string]interface{}{"business_id": uuid.New()}); status != http.StatusForbidden {
+		t.Errorf("create a SCIM token as a user: status %d, want 403: %s", status, body)
+	}
+
+	if status, body := call(t, http.MethodGet, "/scim/v2/ServiceProviderConfig", "", nil); status != http.StatusOK {
+		t.Errorf("service provider config: status %d, want 200: %s", status, body)
+	}
+	for _, token := range []string{"", token} {
+		if status, body := call(t, http.MethodGet, "/scim/v2/Users", token, nil); status != http.StatusUnauthorized {
+			t.Errorf("list the users without a SCIM token: status %d, want 401: %s", status, body)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/goxp/cloud0/logger"
+	"ms-user/conf"
+)
+
+// errShuttingDown fails the readiness probe while the server drains
+var errShuttingDown = errors.New("shutting down")
+
+// Start serves HTTP and gRPC until ctx is done, then shuts down in order:
+// stop accepting and drain in-flight requests, stop the background workers, close the DB pool.
+// It replaces BaseApp.Start, which returns before the in-flight requests are drained.
+// The caller cancels ctx on SIGTERM/SIGINT, see signal.NotifyContext.
+func (s *Service) Start(ctx context.Context) error {
+	l := logger.Tag("Service.Start")
+
+	s.configureHTTPServer()
+	listener, err := net.Listen("tcp4", fmt.Sprintf("0.0.0.0:%d", s.Config.Port))
+	if err != nil {
+		return errors.New("failed to listen: " + err.Error())
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		l.Printf("start listening on %s", listener.Addr().String())
+		if err := s.HttpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+
+	if s.grpc != nil {
+		grpcListener, err := net.Listen("tcp4", fmt.Sprintf("0.0.0.0:%d", conf.LoadEnv().GRPCPort))
+		if err != nil {
+			_ = listener.Close()
+			return errors.New("failed to listen grpc: " + err.Error())
+		}
+		go func() {
+			l.Printf("start listening grpc on %s", grpcListener.Addr().String())
+			if err := s.grpc.Serve(grpcListener); err != nil {
+				errCh <- err
+			}
+		}()
+	}
+
+	debugServer := &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(s.Config.DebugPort), Handler: http.DefaultServeMux}
+	go func() {
+		l.Printf("start listening debug server on port %d", s.Config.DebugPort)
+		_ = debugServer.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errCh:
+		l.WithError(err).Error("http server failed")
+	case <-ctx.Done():
+		l.Info("shutdown requested")
+	}
+
+	if shutErr := s.shutdown(debugServer); err == nil {
+		err = shutErr
+	}
+	return err
+}
+
+func (s *Service) configureHTTPServer() {
+	cfg := conf.LoadEnv()
+	s.HttpServer.ReadTimeout = time.Duration(cfg.HTTPReadTimeoutMs) * time.Millisecond
+	s.HttpServer.ReadHeaderTimeout = time.Duration(cfg.HTTPReadHeaderTimeoutMs) * time.Millisecond
+	s.HttpServer.WriteTimeout = time.Duration(cfg.HTTPWriteTimeoutMs) * time.Millisecond
+	s.HttpServer.IdleTimeout = time.Duration(cfg.HTTPIdleTimeoutMs) * time.Millisecond
+	s.HttpServer.MaxHeaderBytes = cfg.HTTPMaxHeaderBytes
+}
+
+// shutdown gives the drain and the workers SHUTDOWN_TIMEOUT_MS each, the DB is closed last
+func (s *Service) shutdown(debugServer *http.Server) error {
+	l := logger.Tag("Service.shutdown")
+	timeout := time.Duration(conf.LoadEnv().ShutdownTimeoutMs) * time.Millisecond
+	atomic.StoreInt32(&s.draining, 1)
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	l.Info("draining http and grpc requests ...")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	grpcDone := make(chan error, 1)
+	if s.grpc != nil {
+		go func() { grpcDone <- s.grpc.Shutdown(ctx) }()
+	} else {
+		grpcDone <- nil
+	}
+	if err := s.HttpServer.Shutdown(ctx); err != nil {
+		l.WithError(err).Error("http server did not drain in time")
+		keep(err)
+		_ = s.HttpServer.Close()
+	}
+	if err := <-grpcDone; err != nil {
+		l.WithError(err).Error("grpc server did not drain in time")
+		keep(err)
+	}
+	_ = debugServer.Shutdown(ctx)
+	cancel()
+
+	l.Info("stopping background workers ...")
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	if err := s.workers.Stop(ctx); err != nil {
+		l.WithError(err).Error("background workers did not stop in time")
+		keep(err)
+	}
+	cancel()
+
+	l.Info("closing database ...")
+	if sqlDB, err := s.db.DB(); err == nil {
+		keep(sqlDB.Close())
+	}
+
+	l.Info("shutdown complete")
+	return firstErr
+}
+
+// readyCheck fails once the shutdown has started, so load balancers stop sending traffic
+func (s *Service) readyCheck(context.Context) error {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		return errShuttingDown
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package route_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a TracerProvider keeping the ended spans, until the end of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+// spansOf returns the ended spans of the trace, by name
+func spansOf(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string][]sdktrace.ReadOnlySpan {
+	rs := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		if s.SpanContext().TraceID() == traceID {
+			rs[s.Name()] = append(rs[s.Name()], s)
+		}
+	}
+	return rs
+}
+
+func TestRequestIsOneTrace(t *testing.T) {
+	signUp(t, "tracing@example.com")
+	recorder := recordSpans(t)
+
+	status, body := call(t, http.MethodPost, "/api/v1/user/login", "", map[string]string{
+		"email": "tracing@example.com", "password": "Passw0rd!check", "device_id": "route-test",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d: %s", status, body)
+	}
+
+	var handler sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "POST /api/v1/user/login" {
+			handler = s
+		}
+	}
+	if handler == nil {
+		t.Fatal("no span for the handler")
+	}
+	if handler.SpanKind() != trace.SpanKindServer || handler.Parent().IsValid() {
+		t.Errorf("the handler span is not the root server span: kind %s, parent %v", handler.SpanKind(), handler.Parent())
+	}
+
+	spans := spansOf(recorder, handler.SpanContext().TraceID())
+	service := spans["UserService.Login"]
+	if len(service) != 1 {
+		t.Fatalf("%d UserService.Login spans in the trace of the handler", len(service))
+	}
+	if service[0].Parent().SpanID() != handler.SpanContext().SpanID() {
+		t.Error("the service span is not a child of the handler span")
+	}
+	queries := spans["gorm.query"]
+	if len(queries) == 0 {
+		t.Fatal("no repository span in the trace of the handler")
+	}
+	for _, q := range queries {
+		if q.SpanKind() != trace.SpanKindClient {
+			t.Errorf("repository span of kind %s", q.SpanKind())
+		}
+	}
+	if q := queries[0]; q.Parent().SpanID() != service[0].SpanContext().SpanID() {
+		t.Error("the first query is not a child of the service span")
+	}
+	if service[0].Status().Code == codes.Error || handler.Status().Code == codes.Error {
+		t.Error("a successful login is marked as failed")
+	}
+}
+
+func TestRequestContinuesTheCallerTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/test", nil)
+	req.Header.Set("traceparent", traceparent)
+	w := httptest.NewRecorder()
+	testApp.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spans := spansOf(recorder, traceID)["GET /api/v1/test"]
+	if len(spans) != 1 {
+		t.Fatalf("%d spans continue the trace of the caller", len(spans))
+	}
+	if got := spans[0].Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span %s", got)
+	}
+	if got := w.Header().Get("traceparent"); !strings.Contains(got, "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("traceparent of the response %q", got)
+	}
+}
+
+func TestFailedRequestMarksItsSpans(t *testing.T) {
+	signUp(t, "tracing-fail@example.com")
+	recorder := recordSpans(t)
+
+	status, _ := call(t, http.MethodPost, "/api/v1/user/login", "", map[string]string{
+		"email": "tracing-fail@example.com", "password": "wrong-password", "device_id": "route-test",
+	})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("login with a wrong password: status %d", status)
+	}
+
+	var service sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "UserService.Login" {
+			service = s
+		}
+	}
+	if service == nil {
+		t.Fatal("no span for the service")
+	}
+	if st := service.Status(); st.Code != codes.Error || st.Description != "account or password incorrect" {
+		t.Errorf("status of the service span = %+v", st)
+	}
+	recorded := false
+	for _, e := range service.Events() {
+		recorded = recorded || e.Name == "exception"
+	}
+	if !recorded {
+		t.Error("the error is not recorded as an event of the span")
+	}
+	// a 4xx is the caller's fault, the server span stays unset
+	for _, s := range spansOf(recorder, service.SpanContext().TraceID())["POST /api/v1/user/login"] {
+		if s.Status().Code == codes.Error {
+			t.Errorf("the handler span of a 401 is marked as failed: %+v", s.Status())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package saml is a SAML 2.0 service provider: it reads the metadata of identity providers, writes its own,
+// sends AuthnRequests with the HTTP-Redirect binding and checks the signed Responses posted back to its
+// assertion consumer service. Only sign-ins started by the service provider are accepted, every Response
+// must answer a request it sent. Encrypted assertions are not supported.
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Namespaces, bindings and formats of SAML 2.0
+const (
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	StatusSuccess           = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	ConfirmationBearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	NameIDFormatEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatTransient   = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+	NameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// IdPMetadata is what the service provider needs of an identity provider
+type IdPMetadata struct {
+	EntityID string
+	// SSOURL is the single sign-on service of the HTTP-Redirect binding
+	SSOURL       string
+	Certificates []*x509.Certificate
+}
+
+type entityDescriptor struct {
+	EntityID string `xml:"entityID,attr"`
+	IDP      *struct {
+		KeyDescriptors []struct {
+			Use          string `xml:"use,attr"`
+			Certificates []struct {
+				Data string `xml:",chardata"`
+			} `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+		SSO []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+// ParseIdPMetadata reads the EntityDescriptor of an identity provider, alone or the first one with an
+// IDPSSODescriptor in an EntitiesDescriptor. Its signing certificates and HTTP-Redirect SSO URL are required.
+func ParseIdPMetadata(doc []byte) (rs IdPMetadata, err error) {
+	var entities struct {
+		XMLName     xml.Name
+		EntityID    string             `xml:"entityID,attr"`
+		Descriptors []entityDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	}
+	if err = xml.Unmarshal(doc, &entities); err != nil {
+		return rs, fmt.Errorf("saml: metadata: %w", err)
+	}
+	descriptors := entities.Descriptors
+	if entities.XMLName.Space == NamespaceMetadata && entities.XMLName.Local == "EntityDescriptor" {
+		var one entityDescriptor
+		if err = xml.Unmarshal(doc, &one); err != nil {
+			return rs, fmt.Errorf("saml: metadata: %w", err)
+		}
+		descriptors = []entityDescriptor{one}
+	}
+
+	for _, d := range descriptors {
+		if d.IDP == nil {
+			continue
+		}
+		rs.EntityID = d.EntityID
+		for _, sso := range d.IDP.SSO {
+			if sso.Binding == BindingHTTPRedirect {
+				rs.SSOURL = sso.Location
+				break
+			}
+		}
+		for _, kd := range d.IDP.KeyDescriptors {
+			if kd.Use != "" && kd.Use != "signing" {
+				continue
+			}
+			for _, c := range kd.Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(c.Data), ""))
+				if err != nil {
+					return rs, fmt.Errorf("saml: metadata certificate: %w", err)
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return rs, fmt.Errorf("saml: metadata certificate: %w", err)
+				}
+				rs.Certificates = append(rs.Certificates, cert)
+			}
+		}
+		break
+	}
+	switch {
+	case rs.EntityID == "":
+		return rs, errors.New("saml: metadata: no IDPSSODescriptor with an entityID")
+	case rs.SSOURL == "":
+		return rs, errors.New("saml: metadata: no SingleSignOnService with the HTTP-Redirect binding")
+	case len(rs.Certificates) == 0:
+		return rs, errors.New("saml: metadata: no signing certificate")
+	}
+	return rs, nil
+}
+
+// ParseCertificates reads the PEM encoded certificates of pemCerts, at least one
+func ParseCertificates(pemCerts string) ([]*x509.Certificate, error) {
+	var rs []*x509.Certificate
+	rest := []byte(pemCerts)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("saml: certificate: %w", err)
+		}
+		rs = append(rs, cert)
+	}
+	if len(rs) == 0 {
+		return nil, errors.New("saml: no PEM certificate")
+	}
+	return rs, nil
+}
+
+// EncodeCertificates writes certs as PEM, the form ParseCertificates reads
+func EncodeCertificates(certs []*x509.Certificate) string {
+	var sb strings.Builder
+	for _, c := range certs {
+		_ = pem.Encode(&sb, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return sb.String()
+}
+
+// NewID returns a random message ID, it starts with a letter as xsd:ID requires
+func NewID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "id-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,220 @@
+// Package samltest is a fake SAML identity provider signing assertions with a key of its own, for the tests of service providers.
+//
+//	idp, _ := samltest.NewIdP("https://idp.test/metadata")
+//	req, _ := samltest.ParseAuthnRequest(authnRequestURL)
+//	resp, _ := idp.Response(samltest.ResponseOptions{Request: req, Audience: spEntityID, NameID: "bob@example.com"})
+//
+// Response returns what the browser posts to the assertion consumer service as SAMLResponse,
+// its options break it in the ways the checks of a service provider must catch.
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"ms-user/pkg/saml"
+	"ms-user/pkg/saml/xmldsig"
+)
+
+// IdP is the fake identity provider, its single sign-on URL is never called: the AuthnRequest is read from the redirect
+type IdP struct {
+	EntityID    string
+	SSOURL      string
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// NewIdP generates the key and self-signed certificate of an identity provider
+func NewIdP(entityID string) (*IdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &IdP{EntityID: entityID, SSOURL: "https://idp.test/sso", Key: key, Certificate: cert}, nil
+}
+
+// Metadata returns the IdP metadata document, the one an administrator uploads to the service provider
+func (idp *IdP) Metadata() []byte {
+	return []byte(xml.Header + `<md:EntityDescriptor xmlns:md="` + saml.NamespaceMetadata + `" entityID="` + escape(idp.EntityID) + `">` +
+		`<md:IDPSSODescriptor protocolSupportEnumeration="` + saml.NamespaceProtocol + `">` +
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="` + xmldsig.NamespaceDSig + `"><ds:X509Data><ds:X509Certificate>` +
+		base64.StdEncoding.EncodeToString(idp.Certificate.Raw) +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>` +
+		`<md:NameIDFormat>` + saml.NameIDFormatEmail + `</md:NameIDFormat>` +
+		`<md:SingleSignOnService Binding="` + saml.BindingHTTPPost + `" Location="` + escape(idp.SSOURL) + `"/>` +
+		`<md:SingleSignOnService Binding="` + saml.BindingHTTPRedirect + `" Location="` + escape(idp.SSOURL) + `"/>` +
+		`</md:IDPSSODescriptor></md:EntityDescriptor>`)
+}
+
+// AuthnRequest is what the identity provider reads of a redirect from the service provider
+type AuthnRequest struct {
+	ID         string
+	Issuer     string
+	ACSURL     string
+	RelayState string
+}
+
+// ParseAuthnRequest reads the AuthnRequest of a URL made for the HTTP-Redirect binding
+func ParseAuthnRequest(redirectURL string) (rs AuthnRequest, err error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return rs, err
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		return rs, fmt.Errorf("samltest: SAMLRequest: %w", err)
+	}
+	doc, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		return rs, fmt.Errorf("samltest: SAMLRequest: %w", err)
+	}
+	req, err := xmldsig.Parse(doc)
+	if err != nil {
+		return rs, err
+	}
+	if !req.Is(saml.NamespaceProtocol, "AuthnRequest") {
+		return rs, errors.New("samltest: not an AuthnRequest")
+	}
+	rs = AuthnRequest{ID: req.Attr("ID"), ACSURL: req.Attr("AssertionConsumerServiceURL"), RelayState: u.Query().Get("RelayState")}
+	if issuer := req.Child(saml.NamespaceAssertion, "Issuer"); issuer != nil {
+		rs.Issuer = issuer.Text()
+	}
+	return rs, nil
+}
+
+// ResponseOptions describe the Response, a zero field takes its value from the request or a valid default
+type ResponseOptions struct {
+	Request AuthnRequest
+	// Audience is the entity ID of the service provider, the issuer of the request by default
+	Audience     string
+	NameID       string
+	NameIDFormat string
+	Attributes   map[string][]string
+	// IssuedAt dates the Response, now by default; the assertion is valid for five minutes from then
+	IssuedAt time.Time
+
+	// SignResponse signs the Response, SkipAssertionSignature leaves its assertion unsigned
+	SignResponse           bool
+	SkipAssertionSignature bool
+	// Tamper edits the Response after it is signed
+	Tamper func(resp *xmldsig.Element)
+}
+
+// Response returns a base64 encoded Response answering opts.Request, as the browser posts it
+func (idp *IdP) Response(opts ResponseOptions) (string, error) {
+	resp, err := idp.ResponseElement(opts)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(resp.Bytes()), nil
+}
+
+// ResponseElement returns the Response answering opts.Request as a tree
+func (idp *IdP) ResponseElement(opts ResponseOptions) (*xmldsig.Element, error) {
+	if opts.Audience == "" {
+		opts.Audience = opts.Request.Issuer
+	}
+	if opts.NameIDFormat == "" {
+		opts.NameIDFormat = saml.NameIDFormatEmail
+	}
+	if opts.IssuedAt.IsZero() {
+		opts.IssuedAt = time.Now()
+	}
+	respID, err := saml.NewID()
+	if err != nil {
+		return nil, err
+	}
+	assertionID, err := saml.NewID()
+	if err != nil {
+		return nil, err
+	}
+	issued := opts.IssuedAt.UTC().Format(time.RFC3339)
+	expires := opts.IssuedAt.Add(5 * time.Minute).UTC().Format(time.RFC3339)
+	issuer := `<saml:Issuer>` + escape(idp.EntityID) + `</saml:Issuer>`
+
+	var attrs strings.Builder
+	if len(opts.Attributes) > 0 {
+		names := make([]string, 0, len(opts.Attributes))
+		for name := range opts.Attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		attrs.WriteString(`<saml:AttributeStatement>`)
+		for _, name := range names {
+			attrs.WriteString(`<saml:Attribute Name="` + escape(name) + `">`)
+			for _, v := range opts.Attributes[name] {
+				attrs.WriteString(`<saml:AttributeValue>` + escape(v) + `</saml:AttributeValue>`)
+			}
+			attrs.WriteString(`</saml:Attribute>`)
+		}
+		attrs.WriteString(`</saml:AttributeStatement>`)
+	}
+
+	resp, err := xmldsig.Parse([]byte(`<samlp:Response xmlns:samlp="` + saml.NamespaceProtocol + `" xmlns:saml="` + saml.NamespaceAssertion + `"` +
+		` ID="` + respID + `" Version="2.0" IssueInstant="` + issued + `" Destination="` + escape(opts.Request.ACSURL) + `"` +
+		` InResponseTo="` + escape(opts.Request.ID) + `">` + issuer +
+		`<samlp:Status><samlp:StatusCode Value="` + saml.StatusSuccess + `"/></samlp:Status>` +
+		`<saml:Assertion ID="` + assertionID + `" Version="2.0" IssueInstant="` + issued + `">` + issuer +
+		`<saml:Subject><saml:NameID Format="` + escape(opts.NameIDFormat) + `">` + escape(opts.NameID) + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + saml.ConfirmationBearer + `"><saml:SubjectConfirmationData` +
+		` InResponseTo="` + escape(opts.Request.ID) + `" NotOnOrAfter="` + expires + `" Recipient="` + escape(opts.Request.ACSURL) + `"/>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + issued + `" NotOnOrAfter="` + expires + `"><saml:AudienceRestriction>` +
+		`<saml:Audience>` + escape(opts.Audience) + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + issued + `" SessionIndex="` + assertionID + `"><saml:AuthnContext>` +
+		`<saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport</saml:AuthnContextClassRef>` +
+		`</saml:AuthnContext></saml:AuthnStatement>` + attrs.String() + `</saml:Assertion></samlp:Response>`))
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.SkipAssertionSignature {
+		if err = xmldsig.Sign(resp.Child(saml.NamespaceAssertion, "Assertion"), idp.Key, idp.Certificate); err != nil {
+			return nil, err
+		}
+	}
+	if opts.SignResponse {
+		if err = xmldsig.Sign(resp, idp.Key, idp.Certificate); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Tamper != nil {
+		opts.Tamper(resp)
+	}
+	return resp, nil
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
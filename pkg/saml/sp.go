@@ -0,0 +1,305 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"ms-user/pkg/saml/xmldsig"
+)
+
+// MaxClockSkew is the difference tolerated between the clocks of the identity provider and ms-user
+const MaxClockSkew = 3 * time.Minute
+
+// maxResponseSize bounds the base64 encoded Response read by ParseResponse
+const maxResponseSize = 512 << 10
+
+// ServiceProvider is ms-user as the service provider of one identity provider
+type ServiceProvider struct {
+	EntityID string
+	// ACSURL is the assertion consumer service the identity provider posts its Response to
+	ACSURL string
+	IdP    IdPMetadata
+}
+
+// Assertion is what a verified assertion says of the user
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	// Attributes holds the values of the attributes by their Name
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of the attribute name, "" when there is none
+func (a Assertion) Attribute(name string) string {
+	if vs := a.Attributes[name]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Metadata returns the SP metadata document the identity provider is configured with
+func (sp ServiceProvider) Metadata() ([]byte, error) {
+	type acs struct {
+		Binding   string `xml:"Binding,attr"`
+		Location  string `xml:"Location,attr"`
+		Index     int    `xml:"index,attr"`
+		IsDefault bool   `xml:"isDefault,attr"`
+	}
+	doc := struct {
+		XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+		EntityID string   `xml:"entityID,attr"`
+		SP       struct {
+			AuthnRequestsSigned        bool     `xml:"AuthnRequestsSigned,attr"`
+			WantAssertionsSigned       bool     `xml:"WantAssertionsSigned,attr"`
+			ProtocolSupportEnumeration string   `xml:"protocolSupportEnumeration,attr"`
+			NameIDFormats              []string `xml:"NameIDFormat"`
+			ACS                        acs      `xml:"AssertionConsumerService"`
+		} `xml:"SPSSODescriptor"`
+	}{EntityID: sp.EntityID}
+	doc.SP.WantAssertionsSigned = true
+	doc.SP.ProtocolSupportEnumeration = NamespaceProtocol
+	doc.SP.NameIDFormats = []string{NameIDFormatEmail, NameIDFormatPersistent}
+	doc.SP.ACS = acs{Binding: BindingHTTPPost, Location: sp.ACSURL, IsDefault: true}
+
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// AuthnRequestURL returns the URL sending the browser to the identity provider with an AuthnRequest
+// of ID requestID, relayState comes back with the Response
+func (sp ServiceProvider) AuthnRequestURL(requestID, relayState string, now time.Time) (string, error) {
+	var req bytes.Buffer
+	req.WriteString(`<samlp:AuthnRequest xmlns:samlp="` + NamespaceProtocol + `" xmlns:saml="` + NamespaceAssertion + `"`)
+	for _, a := range [][2]string{
+		{"ID", requestID},
+		{"Version", "2.0"},
+		{"IssueInstant", now.UTC().Format(time.RFC3339)},
+		{"Destination", sp.IdP.SSOURL},
+		{"AssertionConsumerServiceURL", sp.ACSURL},
+		{"ProtocolBinding", BindingHTTPPost},
+	} {
+		req.WriteString(" " + a[0] + `="`)
+		_ = xml.EscapeText(&req, []byte(a[1]))
+		req.WriteString(`"`)
+	}
+	req.WriteString(`><saml:Issuer>`)
+	_ = xml.EscapeText(&req, []byte(sp.EntityID))
+	req.WriteString(`</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"></samlp:NameIDPolicy></samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	w, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if _, err := w.Write(req.Bytes()); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(sp.IdP.SSOURL)
+	if err != nil {
+		return "", fmt.Errorf("saml: SSO URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ParseResponse verifies the base64 encoded Response posted to the assertion consumer service,
+// an answer to the AuthnRequest requestID, and returns its assertion.
+// The assertion, or the whole Response, must be signed by a certificate of the identity provider;
+// only the signed elements are read.
+func (sp ServiceProvider) ParseResponse(samlResponse, requestID string, now time.Time) (rs Assertion, err error) {
+	if len(samlResponse) > maxResponseSize {
+		return rs, errors.New("saml: the Response is too large")
+	}
+	doc, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return rs, fmt.Errorf("saml: Response: %w", err)
+	}
+	resp, err := xmldsig.Parse(doc)
+	if err != nil {
+		return rs, err
+	}
+	if !resp.Is(NamespaceProtocol, "Response") || resp.Attr("Version") != "2.0" {
+		return rs, errors.New("saml: not a SAML 2.0 Response")
+	}
+	if err = sp.checkResponse(resp, requestID); err != nil {
+		return rs, err
+	}
+
+	if resp.Child(NamespaceAssertion, "EncryptedAssertion") != nil {
+		return rs, errors.New("saml: encrypted assertions are not supported")
+	}
+	assertions := resp.ChildElements(NamespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return rs, fmt.Errorf("saml: %d assertions, want one", len(assertions))
+	}
+	assertion := assertions[0]
+
+	signed := false
+	for _, e := range []*xmldsig.Element{resp, assertion} {
+		switch err := xmldsig.Verify(e, sp.IdP.Certificates); err {
+		case nil:
+			signed = true
+		case xmldsig.ErrNotSigned:
+		default:
+			return rs, err
+		}
+	}
+	if !signed {
+		return rs, errors.New("saml: neither the Response nor its assertion is signed")
+	}
+	return sp.readAssertion(assertion, requestID, now)
+}
+
+func (sp ServiceProvider) checkResponse(resp *xmldsig.Element, requestID string) error {
+	if d, ok := resp.LookupAttr("Destination"); ok && d != sp.ACSURL {
+		return fmt.Errorf("saml: the Response is sent to %q", d)
+	}
+	if resp.Attr("InResponseTo") != requestID {
+		return errors.New("saml: the Response does not answer the request")
+	}
+	if issuer := resp.Child(NamespaceAssertion, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != sp.IdP.EntityID {
+		return fmt.Errorf("saml: the Response is issued by %q", strings.TrimSpace(issuer.Text()))
+	}
+	var code *xmldsig.Element
+	if status := resp.Child(NamespaceProtocol, "Status"); status != nil {
+		code = status.Child(NamespaceProtocol, "StatusCode")
+	}
+	if code == nil {
+		return errors.New("saml: the Response has no status")
+	}
+	if code.Attr("Value") != StatusSuccess {
+		if sub := code.Child(NamespaceProtocol, "StatusCode"); sub != nil {
+			return fmt.Errorf("saml: the identity provider answered %s (%s)", code.Attr("Value"), sub.Attr("Value"))
+		}
+		return fmt.Errorf("saml: the identity provider answered %s", code.Attr("Value"))
+	}
+	return nil
+}
+
+func (sp ServiceProvider) readAssertion(a *xmldsig.Element, requestID string, now time.Time) (rs Assertion, err error) {
+	if a.Attr("Version") != "2.0" {
+		return rs, errors.New("saml: not a SAML 2.0 assertion")
+	}
+	rs.ID = a.Attr("ID")
+	if issuer := a.Child(NamespaceAssertion, "Issuer"); issuer != nil {
+		rs.Issuer = strings.TrimSpace(issuer.Text())
+	}
+	if rs.Issuer != sp.IdP.EntityID {
+		return rs, fmt.Errorf("saml: the assertion is issued by %q", rs.Issuer)
+	}
+
+	subject := a.Child(NamespaceAssertion, "Subject")
+	if subject == nil {
+		return rs, errors.New("saml: the assertion has no subject")
+	}
+	if nameID := subject.Child(NamespaceAssertion, "NameID"); nameID != nil {
+		rs.NameID = strings.TrimSpace(nameID.Text())
+		rs.NameIDFormat = nameID.Attr("Format")
+	}
+	if rs.NameID == "" {
+		return rs, errors.New("saml: the assertion has no NameID")
+	}
+	if err = sp.checkConfirmation(subject, requestID, now); err != nil {
+		return rs, err
+	}
+	if err = sp.checkConditions(a.Child(NamespaceAssertion, "Conditions"), now); err != nil {
+		return rs, err
+	}
+
+	authn := a.Child(NamespaceAssertion, "AuthnStatement")
+	if authn == nil {
+		return rs, errors.New("saml: the assertion has no AuthnStatement")
+	}
+	rs.SessionIndex = authn.Attr("SessionIndex")
+
+	rs.Attributes = map[string][]string{}
+	for _, statement := range a.ChildElements(NamespaceAssertion, "AttributeStatement") {
+		for _, attr := range statement.ChildElements(NamespaceAssertion, "Attribute") {
+			name := attr.Attr("Name")
+			for _, v := range attr.ChildElements(NamespaceAssertion, "AttributeValue") {
+				rs.Attributes[name] = append(rs.Attributes[name], strings.TrimSpace(v.Text()))
+			}
+		}
+	}
+	return rs, nil
+}
+
+// checkConfirmation wants a bearer confirmation for this service provider, the request and now
+func (sp ServiceProvider) checkConfirmation(subject *xmldsig.Element, requestID string, now time.Time) error {
+	reason := "no bearer confirmation"
+	for _, sc := range subject.ChildElements(NamespaceAssertion, "SubjectConfirmation") {
+		data := sc.Child(NamespaceAssertion, "SubjectConfirmationData")
+		switch {
+		case sc.Attr("Method") != ConfirmationBearer || data == nil:
+			continue
+		case data.Attr("Recipient") != sp.ACSURL:
+			reason = "the confirmation is for another recipient"
+		case data.Attr("InResponseTo") != requestID:
+			reason = "the confirmation is for another request"
+		default:
+			notOnOrAfter, err := parseTime(data.Attr("NotOnOrAfter"))
+			if err != nil || !now.Before(notOnOrAfter.Add(MaxClockSkew)) {
+				reason = "the confirmation is expired"
+				continue
+			}
+			return nil
+		}
+	}
+	return errors.New("saml: " + reason)
+}
+
+// checkConditions wants the validity period to include now, and every audience restriction to name the service provider
+func (sp ServiceProvider) checkConditions(c *xmldsig.Element, now time.Time) error {
+	if c == nil {
+		return errors.New("saml: the assertion has no conditions")
+	}
+	if v, ok := c.LookupAttr("NotBefore"); ok {
+		t, err := parseTime(v)
+		if err != nil || now.Add(MaxClockSkew).Before(t) {
+			return errors.New("saml: the assertion is not valid yet")
+		}
+	}
+	if v, ok := c.LookupAttr("NotOnOrAfter"); ok {
+		t, err := parseTime(v)
+		if err != nil || !now.Before(t.Add(MaxClockSkew)) {
+			return errors.New("saml: the assertion is expired")
+		}
+	}
+	restrictions := c.ChildElements(NamespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return errors.New("saml: the assertion has no audience restriction")
+	}
+	for _, r := range restrictions {
+		ok := false
+		for _, audience := range r.ChildElements(NamespaceAssertion, "Audience") {
+			ok = ok || strings.TrimSpace(audience.Text()) == sp.EntityID
+		}
+		if !ok {
+			return errors.New("saml: the assertion is for another audience")
+		}
+	}
+	return nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(v))
+}
@@ -0,0 +1,152 @@
+package saml_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"ms-user/pkg/saml"
+	"ms-user/pkg/saml/samltest"
+	"ms-user/pkg/saml/xmldsig"
+)
+
+func newTestSP(t *testing.T) (*samltest.IdP, saml.ServiceProvider) {
+	t.Helper()
+	idp, err := samltest.NewIdP("https://idp.test/metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := saml.ParseIdPMetadata(idp.Metadata())
+	if err != nil {
+		t.Fatalf("ParseIdPMetadata: %v", err)
+	}
+	return idp, saml.ServiceProvider{EntityID: "https://sp.test/metadata", ACSURL: "https://sp.test/acs", IdP: meta}
+}
+
+// newRequest sends an AuthnRequest of sp and returns it as the identity provider reads it
+func newRequest(t *testing.T, sp saml.ServiceProvider) samltest.AuthnRequest {
+	t.Helper()
+	id, err := saml.NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := sp.AuthnRequestURL(id, "relay", time.Now())
+	if err != nil {
+		t.Fatalf("AuthnRequestURL: %v", err)
+	}
+	req, err := samltest.ParseAuthnRequest(u)
+	if err != nil {
+		t.Fatalf("ParseAuthnRequest: %v", err)
+	}
+	if req.ID != id || req.Issuer != sp.EntityID || req.ACSURL != sp.ACSURL || req.RelayState != "relay" {
+		t.Fatalf("AuthnRequest = %+v, want request %s of %s", req, id, sp.EntityID)
+	}
+	return req
+}
+
+func TestParseResponse(t *testing.T) {
+	idp, sp := newTestSP(t)
+	for name, opts := range map[string]samltest.ResponseOptions{
+		"signed assertion": {NameID: "alice@acme.example"},
+		"signed Response":  {NameID: "alice@acme.example", SignResponse: true, SkipAssertionSignature: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Request = newRequest(t, sp)
+			resp, err := idp.Response(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := sp.ParseResponse(resp, opts.Request.ID, time.Now())
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			if a.NameID != "alice@acme.example" || a.Issuer != idp.EntityID || a.NameIDFormat != saml.NameIDFormatEmail {
+				t.Errorf("assertion = %+v", a)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	idp, sp := newTestSP(t)
+	attacker, err := samltest.NewIdP(idp.EntityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		idp  *samltest.IdP
+		opts samltest.ResponseOptions
+		// otherRequest answers another request than the one the Response is posted for
+		otherRequest bool
+	}{
+		"unsigned":                     {idp: idp, opts: samltest.ResponseOptions{NameID: "alice@acme.example", SkipAssertionSignature: true}},
+		"signed with another key":      {idp: attacker, opts: samltest.ResponseOptions{NameID: "alice@acme.example"}},
+		"another audience":             {idp: idp, opts: samltest.ResponseOptions{NameID: "alice@acme.example", Audience: "https://other-sp.test"}},
+		"expired":                      {idp: idp, opts: samltest.ResponseOptions{NameID: "alice@acme.example", IssuedAt: time.Now().Add(-time.Hour)}},
+		"issued in the future":         {idp: idp, opts: samltest.ResponseOptions{NameID: "alice@acme.example", IssuedAt: time.Now().Add(time.Hour)}},
+		"replayed for another request": {idp: idp, opts: samltest.ResponseOptions{NameID: "alice@acme.example"}, otherRequest: true},
+		"edited after signing": {idp: idp, opts: samltest.ResponseOptions{NameID: "eve@acme.example", Tamper: func(resp *xmldsig.Element) {
+			resp.Walk(func(e *xmldsig.Element) {
+				if e.Is(saml.NamespaceAssertion, "NameID") {
+					e.SetText("alice@acme.example")
+				}
+			})
+		}}},
+		// signature wrapping: an assertion of the attacker, with the ID of the signed one, placed before it
+		"wrapped assertion": {idp: idp, opts: samltest.ResponseOptions{NameID: "eve@acme.example", Tamper: func(resp *xmldsig.Element) {
+			forged, err := attacker.ResponseElement(samltest.ResponseOptions{NameID: "alice@acme.example", SkipAssertionSignature: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed := resp.Child(saml.NamespaceAssertion, "Assertion")
+			evil := forged.Child(saml.NamespaceAssertion, "Assertion")
+			for i := range evil.Attrs {
+				if evil.Attrs[i].Local == "ID" {
+					evil.Attrs[i].Value = signed.Attr("ID")
+				}
+			}
+			evil.Parent = resp
+			for i, n := range resp.Children {
+				if n.Element == signed {
+					resp.Children = append(resp.Children[:i], append([]xmldsig.Node{{Element: evil}}, resp.Children[i:]...)...)
+					break
+				}
+			}
+		}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.opts.Request = newRequest(t, sp)
+			requestID := tc.opts.Request.ID
+			if tc.otherRequest {
+				requestID = newRequest(t, sp).ID
+			}
+			resp, err := tc.idp.Response(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a, err := sp.ParseResponse(resp, requestID, time.Now()); err == nil {
+				t.Errorf("ParseResponse accepted the Response, assertion %+v", a)
+			}
+		})
+	}
+}
+
+// a comment cutting the signed NameID short must not make it read as the shorter email
+func TestParseResponseReadsTheWholeNameID(t *testing.T) {
+	idp, sp := newTestSP(t)
+	req := newRequest(t, sp)
+	resp, err := idp.ResponseElement(samltest.ResponseOptions{Request: req, NameID: "alice@acme.example.evil.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := strings.Replace(string(resp.Bytes()), ">alice@acme.example.evil.example<", ">alice@acme.example<!---->.evil.example<", 1)
+	a, err := sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte(doc)), req.ID, time.Now())
+	if err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if a.NameID != "alice@acme.example.evil.example" {
+		t.Errorf("NameID = %q, want the whole signed NameID", a.NameID)
+	}
+}
@@ -0,0 +1,121 @@
+package xmldsig
+
+import (
+	"sort"
+	"strings"
+)
+
+// Canonicalize writes e in the form of Exclusive XML Canonicalization 1.0 without comments.
+// inclusive lists the prefixes of the InclusiveNamespaces PrefixList, "#default" standing for the default namespace.
+// exclude, when not nil, is left out, it is the signature of an enveloped signature transform.
+func Canonicalize(e *Element, inclusive []string, exclude *Element) []byte {
+	c := &c14n{inclusive: map[string]bool{}, exclude: exclude}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		c.inclusive[p] = true
+	}
+	c.element(e, map[string]string{})
+	return []byte(c.sb.String())
+}
+
+type c14n struct {
+	sb        strings.Builder
+	inclusive map[string]bool
+	exclude   *Element
+}
+
+type c14nAttr struct {
+	space, qname, local, value string
+}
+
+// element writes e, rendered holds the namespace declarations written by its ancestors in the output
+func (c *c14n) element(e *Element, rendered map[string]string) {
+	// the namespaces visibly utilized by e, and the inclusive ones in scope
+	used := map[string]bool{e.Prefix: true}
+	for _, a := range e.Attrs {
+		if a.Prefix != "" && a.Prefix != "xml" {
+			used[a.Prefix] = true
+		}
+	}
+	for p := range c.inclusive {
+		if _, ok := e.LookupNamespace(p); ok {
+			used[p] = true
+		}
+	}
+
+	var prefixes []string
+	for p := range used {
+		if uri, _ := e.LookupNamespace(p); rendered[p] != uri {
+			prefixes = append(prefixes, p)
+		}
+	}
+	scope := rendered
+	if len(prefixes) > 0 {
+		scope = make(map[string]string, len(rendered)+len(prefixes))
+		for k, v := range rendered {
+			scope[k] = v
+		}
+		for _, p := range prefixes {
+			scope[p], _ = e.LookupNamespace(p)
+		}
+	}
+	sort.Strings(prefixes)
+
+	attrs := make([]c14nAttr, 0, len(e.Attrs))
+	for _, a := range e.Attrs {
+		ca := c14nAttr{qname: a.Local, local: a.Local, value: a.Value}
+		if a.Prefix != "" {
+			ca.space, _ = e.LookupNamespace(a.Prefix)
+			ca.qname = a.Prefix + ":" + a.Local
+		}
+		attrs = append(attrs, ca)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := e.Local
+	if e.Prefix != "" {
+		name = e.Prefix + ":" + e.Local
+	}
+	c.sb.WriteString("<" + name)
+	for _, p := range prefixes {
+		if p == "" {
+			c.sb.WriteString(` xmlns="`)
+		} else {
+			c.sb.WriteString(` xmlns:` + p + `="`)
+		}
+		c.sb.WriteString(escapeAttr(scope[p]) + `"`)
+	}
+	for _, a := range attrs {
+		c.sb.WriteString(" " + a.qname + `="` + escapeAttr(a.value) + `"`)
+	}
+	c.sb.WriteString(">")
+	for _, child := range e.Children {
+		switch {
+		case child.Element == nil:
+			c.sb.WriteString(escapeText(child.Text))
+		case child.Element != c.exclude:
+			c.element(child.Element, scope)
+		}
+	}
+	c.sb.WriteString("</" + name + ">")
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
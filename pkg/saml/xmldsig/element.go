@@ -0,0 +1,226 @@
+// Package xmldsig verifies and makes enveloped XML signatures, the way SAML signs its messages:
+// one reference to the signed element by its ID, exclusive canonicalization and RSA with SHA-256 or SHA-512.
+//
+// Documents are parsed into an Element tree keeping the namespace prefixes, which canonicalization needs.
+// Comments are dropped and the text of an element is read whole, so a comment can not cut a signed value short.
+package xmldsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NamespaceXML is bound to the xml prefix in every document
+const NamespaceXML = "http://www.w3.org/XML/1998/namespace"
+
+// Attr is an attribute as written in the document, Prefix is empty for an unqualified one
+type Attr struct {
+	Prefix string
+	Local  string
+	Value  string
+}
+
+// Node is a child of an element: an element, or the text between elements
+type Node struct {
+	Element *Element
+	Text    string
+}
+
+// Element is an XML element. Namespaces maps the prefixes it declares to their URI, "" being the default namespace.
+type Element struct {
+	Prefix     string
+	Local      string
+	Namespaces map[string]string
+	Attrs      []Attr
+	Children   []Node
+	Parent     *Element
+}
+
+// Parse reads a document. DOCTYPE declarations are refused, they are the door to entity expansion attacks.
+func Parse(doc []byte) (*Element, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	dec.Strict = true
+
+	var root, cur *Element
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xmldsig: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && cur == nil {
+				return nil, errors.New("xmldsig: more than one root element")
+			}
+			el := &Element{Prefix: t.Name.Space, Local: t.Name.Local, Parent: cur}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					el.declare(a.Name.Local, a.Value)
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.declare("", a.Value)
+				default:
+					el.Attrs = append(el.Attrs, Attr{Prefix: a.Name.Space, Local: a.Name.Local, Value: a.Value})
+				}
+			}
+			if cur == nil {
+				root = el
+			} else {
+				cur.Children = append(cur.Children, Node{Element: el})
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.Prefix || t.Name.Local != cur.Local {
+				return nil, fmt.Errorf("xmldsig: unexpected end element %s", t.Name.Local)
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				cur.addText(string(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errors.New("xmldsig: text outside the root element")
+			}
+		case xml.Directive:
+			return nil, errors.New("xmldsig: DOCTYPE and other directives are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("xmldsig: incomplete document")
+	}
+	if err := root.checkPrefixes(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (e *Element) declare(prefix, uri string) {
+	if e.Namespaces == nil {
+		e.Namespaces = map[string]string{}
+	}
+	e.Namespaces[prefix] = uri
+}
+
+// addText appends text, merging it with the text before it, which a dropped comment may have split
+func (e *Element) addText(text string) {
+	if n := len(e.Children); n > 0 && e.Children[n-1].Element == nil {
+		e.Children[n-1].Text += text
+		return
+	}
+	e.Children = append(e.Children, Node{Text: text})
+}
+
+// checkPrefixes fails on a prefix bound to no namespace
+func (e *Element) checkPrefixes() error {
+	if _, ok := e.LookupNamespace(e.Prefix); !ok {
+		return fmt.Errorf("xmldsig: undeclared prefix %q", e.Prefix)
+	}
+	for _, a := range e.Attrs {
+		if _, ok := e.LookupNamespace(a.Prefix); a.Prefix != "" && !ok {
+			return fmt.Errorf("xmldsig: undeclared prefix %q", a.Prefix)
+		}
+	}
+	for _, c := range e.Children {
+		if c.Element != nil {
+			if err := c.Element.checkPrefixes(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LookupNamespace returns the URI prefix is bound to at e, the default namespace is "" when none is declared
+func (e *Element) LookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return NamespaceXML, true
+	}
+	for el := e; el != nil; el = el.Parent {
+		if uri, ok := el.Namespaces[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// Space is the namespace URI of e
+func (e *Element) Space() string {
+	uri, _ := e.LookupNamespace(e.Prefix)
+	return uri
+}
+
+// Is tells whether e is the element local of namespace space
+func (e *Element) Is(space, local string) bool {
+	return e.Local == local && e.Space() == space
+}
+
+// Attr returns the value of the unqualified attribute local, "" when e has none
+func (e *Element) Attr(local string) string {
+	v, _ := e.LookupAttr(local)
+	return v
+}
+
+func (e *Element) LookupAttr(local string) (string, bool) {
+	for _, a := range e.Attrs {
+		if a.Prefix == "" && a.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// ChildElements returns the child elements local of namespace space
+func (e *Element) ChildElements(space, local string) []*Element {
+	var rs []*Element
+	for _, c := range e.Children {
+		if c.Element != nil && c.Element.Is(space, local) {
+			rs = append(rs, c.Element)
+		}
+	}
+	return rs
+}
+
+// Child returns the first child element local of namespace space, nil when there is none
+func (e *Element) Child(space, local string) *Element {
+	if rs := e.ChildElements(space, local); len(rs) > 0 {
+		return rs[0]
+	}
+	return nil
+}
+
+// Text returns the text of e, without the one of its child elements
+func (e *Element) Text() string {
+	var sb strings.Builder
+	for _, c := range e.Children {
+		if c.Element == nil {
+			sb.WriteString(c.Text)
+		}
+	}
+	return sb.String()
+}
+
+// SetText replaces the children of e by text
+func (e *Element) SetText(text string) {
+	e.Children = []Node{{Text: text}}
+}
+
+// Walk calls fn with e and every element below it, in document order
+func (e *Element) Walk(fn func(*Element)) {
+	fn(e)
+	for _, c := range e.Children {
+		if c.Element != nil {
+			c.Element.Walk(fn)
+		}
+	}
+}
+
+// Bytes writes e as a document, in its exclusive canonical form
+func (e *Element) Bytes() []byte {
+	return Canonicalize(e, nil, nil)
+}
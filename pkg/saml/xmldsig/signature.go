@@ -0,0 +1,215 @@
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	// the digests of the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Namespaces and algorithms of XML Signature
+const (
+	NamespaceDSig    = "http://www.w3.org/2000/09/xmldsig#"
+	NamespaceExcC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	AlgExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	AlgEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	AlgRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	AlgRSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	AlgSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+	AlgSHA512    = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+// idAttribute names the signed element in the reference URI, like SAML does
+const idAttribute = "ID"
+
+// ErrNotSigned is returned by Verify when the element has no signature
+var ErrNotSigned = errors.New("xmldsig: the element is not signed")
+
+var (
+	signatureMethods = map[string]crypto.Hash{AlgRSASHA256: crypto.SHA256, AlgRSASHA512: crypto.SHA512}
+	digestMethods    = map[string]crypto.Hash{AlgSHA256: crypto.SHA256, AlgSHA512: crypto.SHA512}
+)
+
+// Verify checks the enveloped signature of e, a child of e referencing e by its ID attribute,
+// against the certificates trusted for the signer. The certificates of the signature itself are ignored.
+// The caller must only read the element it verified: the signature says nothing of the rest of the document.
+func Verify(e *Element, certs []*x509.Certificate) error {
+	sigs := e.ChildElements(NamespaceDSig, "Signature")
+	if len(sigs) == 0 {
+		return ErrNotSigned
+	}
+	if len(sigs) > 1 {
+		return errors.New("xmldsig: more than one signature")
+	}
+	sig := sigs[0]
+	id := e.Attr(idAttribute)
+	if id == "" {
+		return errors.New("xmldsig: the signed element has no ID")
+	}
+	if n := countID(root(e), id); n != 1 {
+		return fmt.Errorf("xmldsig: the ID %q is used by %d elements", id, n)
+	}
+
+	signedInfo := sig.Child(NamespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("xmldsig: no SignedInfo")
+	}
+	c14nMethod := signedInfo.Child(NamespaceDSig, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.Attr("Algorithm") != AlgExcC14N {
+		return errors.New("xmldsig: SignedInfo must use exclusive canonicalization")
+	}
+	sigMethod := signedInfo.Child(NamespaceDSig, "SignatureMethod")
+	if sigMethod == nil {
+		return errors.New("xmldsig: no SignatureMethod")
+	}
+	sigHash, ok := signatureMethods[sigMethod.Attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("xmldsig: unsupported signature method %q", sigMethod.Attr("Algorithm"))
+	}
+
+	refs := signedInfo.ChildElements(NamespaceDSig, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("xmldsig: %d references, want one", len(refs))
+	}
+	ref := refs[0]
+	if ref.Attr("URI") != "#"+id {
+		return fmt.Errorf("xmldsig: the reference %q is not the signed element", ref.Attr("URI"))
+	}
+	refInclusive, err := referenceTransforms(ref)
+	if err != nil {
+		return err
+	}
+	digestMethod := ref.Child(NamespaceDSig, "DigestMethod")
+	digestValue := ref.Child(NamespaceDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return errors.New("xmldsig: no DigestMethod or DigestValue")
+	}
+	digestHash, ok := digestMethods[digestMethod.Attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("xmldsig: unsupported digest method %q", digestMethod.Attr("Algorithm"))
+	}
+	want, err := decodeBase64(digestValue.Text())
+	if err != nil {
+		return fmt.Errorf("xmldsig: DigestValue: %w", err)
+	}
+	if got := digest(digestHash, Canonicalize(e, refInclusive, sig)); subtle.ConstantTimeCompare(got, want) != 1 {
+		return errors.New("xmldsig: the digest does not match, the element was changed")
+	}
+
+	sigValue := sig.Child(NamespaceDSig, "SignatureValue")
+	if sigValue == nil {
+		return errors.New("xmldsig: no SignatureValue")
+	}
+	signature, err := decodeBase64(sigValue.Text())
+	if err != nil {
+		return fmt.Errorf("xmldsig: SignatureValue: %w", err)
+	}
+	hashed := digest(sigHash, Canonicalize(signedInfo, inclusivePrefixes(c14nMethod), nil))
+	for _, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(pub, sigHash, hashed, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("xmldsig: the signature is not made by a trusted certificate")
+}
+
+// referenceTransforms accepts the enveloped signature transform followed by exclusive canonicalization,
+// it returns the InclusiveNamespaces of the latter
+func referenceTransforms(ref *Element) ([]string, error) {
+	transforms := ref.Child(NamespaceDSig, "Transforms")
+	if transforms == nil {
+		return nil, errors.New("xmldsig: no Transforms")
+	}
+	list := transforms.ChildElements(NamespaceDSig, "Transform")
+	if len(list) != 2 || list[0].Attr("Algorithm") != AlgEnveloped || list[1].Attr("Algorithm") != AlgExcC14N {
+		return nil, errors.New("xmldsig: the transforms must be enveloped-signature then exclusive canonicalization")
+	}
+	return inclusivePrefixes(list[1]), nil
+}
+
+func inclusivePrefixes(method *Element) []string {
+	if in := method.Child(NamespaceExcC14N, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.Attr("PrefixList"))
+	}
+	return nil
+}
+
+// Sign adds an enveloped RSA-SHA256 signature of e to e, after its Issuer child when there is one, as SAML wants it.
+// e needs an ID attribute unique in its document, cert is added to the KeyInfo.
+func Sign(e *Element, key *rsa.PrivateKey, cert *x509.Certificate) error {
+	id := e.Attr(idAttribute)
+	if id == "" {
+		return errors.New("xmldsig: the element has no ID")
+	}
+	digestValue := base64.StdEncoding.EncodeToString(digest(crypto.SHA256, Canonicalize(e, nil, nil)))
+
+	sig, err := Parse([]byte(`<ds:Signature xmlns:ds="` + NamespaceDSig + `"><ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="` + AlgExcC14N + `"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="` + AlgRSASHA256 + `"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + escapeAttr(id) + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + AlgEnveloped + `"></ds:Transform>` +
+		`<ds:Transform Algorithm="` + AlgExcC14N + `"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + AlgSHA256 + `"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + digestValue + `</ds:DigestValue></ds:Reference></ds:SignedInfo>` +
+		`<ds:SignatureValue></ds:SignatureValue><ds:KeyInfo><ds:X509Data><ds:X509Certificate>` +
+		base64.StdEncoding.EncodeToString(cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo></ds:Signature>`))
+	if err != nil {
+		return err
+	}
+	signedInfo := sig.Child(NamespaceDSig, "SignedInfo")
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest(crypto.SHA256, Canonicalize(signedInfo, nil, nil)))
+	if err != nil {
+		return err
+	}
+	sig.Child(NamespaceDSig, "SignatureValue").SetText(base64.StdEncoding.EncodeToString(signature))
+
+	// the signature joins the document, its namespaces are looked up through e from now on
+	sig.Parent = e
+	at := 0
+	for i, c := range e.Children {
+		if c.Element != nil && c.Element.Local == "Issuer" {
+			at = i + 1
+			break
+		}
+	}
+	e.Children = append(e.Children[:at], append([]Node{{Element: sig}}, e.Children[at:]...)...)
+	return nil
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	w := h.New()
+	w.Write(data)
+	return w.Sum(nil)
+}
+
+// decodeBase64 reads base64 split over lines, the way signatures are often written
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+func root(e *Element) *Element {
+	for e.Parent != nil {
+		e = e.Parent
+	}
+	return e
+}
+
+func countID(e *Element, id string) int {
+	n := 0
+	e.Walk(func(el *Element) {
+		if el.Attr(idAttribute) == id {
+			n++
+		}
+	})
+	return n
+}
@@ -0,0 +1,124 @@
+package xmldsig_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"ms-user/pkg/saml/xmldsig"
+)
+
+const testDoc = `<doc xmlns="urn:test"><item ID="id-1"><name>alice@acme.example.evil.example</name></item></doc>`
+
+func newSigner(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// signedDoc returns testDoc with its item signed by key
+func signedDoc(t *testing.T, key *rsa.PrivateKey, cert *x509.Certificate) string {
+	t.Helper()
+	doc, err := xmldsig.Parse([]byte(testDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xmldsig.Sign(doc.Child("urn:test", "item"), key, cert); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return string(doc.Bytes())
+}
+
+// verifyItem parses doc and verifies the signature of its last item, the one signed by signedDoc
+func verifyItem(t *testing.T, doc string, certs []*x509.Certificate) (*xmldsig.Element, error) {
+	t.Helper()
+	root, err := xmldsig.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	items := root.ChildElements("urn:test", "item")
+	item := items[len(items)-1]
+	return item, xmldsig.Verify(item, certs)
+}
+
+func TestVerify(t *testing.T) {
+	key, cert := newSigner(t)
+	item, err := verifyItem(t, signedDoc(t, key, cert), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := item.Child("urn:test", "name").Text(); got != "alice@acme.example.evil.example" {
+		t.Errorf("name = %q", got)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key, cert := newSigner(t)
+	_, otherCert := newSigner(t)
+	otherKey, _ := newSigner(t)
+	signed := signedDoc(t, key, cert)
+
+	for name, tc := range map[string]struct {
+		doc   string
+		certs []*x509.Certificate
+	}{
+		"unsigned":                {testDoc, []*x509.Certificate{cert}},
+		"signed with another key": {signedDoc(t, otherKey, cert), []*x509.Certificate{cert}},
+		"untrusted certificate":   {signed, []*x509.Certificate{otherCert}},
+		"edited after signing":    {strings.Replace(signed, "alice@", "eve@", 1), []*x509.Certificate{cert}},
+		// signature wrapping: an element of the attacker with the ID of the signed one, placed before it
+		"wrapped": {strings.Replace(signed, `<item ID="id-1">`, `<item ID="id-1"><name>eve@acme.example</name></item><item ID="id-1">`, 1), []*x509.Certificate{cert}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifyItem(t, tc.doc, tc.certs); err == nil {
+				t.Error("Verify accepted the document")
+			}
+		})
+	}
+
+	if _, err := verifyItem(t, testDoc, []*x509.Certificate{cert}); err != xmldsig.ErrNotSigned {
+		t.Errorf("Verify of an unsigned element err = %v, want ErrNotSigned", err)
+	}
+}
+
+// a comment does not change the canonical form, it must not cut the signed text short either
+func TestCommentDoesNotCutSignedText(t *testing.T) {
+	key, cert := newSigner(t)
+	doc := strings.Replace(signedDoc(t, key, cert), "acme.example.evil", "acme.example<!---->.evil", 1)
+	item, err := verifyItem(t, doc, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := item.Child("urn:test", "name").Text(); got != "alice@acme.example.evil.example" {
+		t.Errorf("name = %q, want the whole signed text", got)
+	}
+}
+
+func TestParseRefusesDoctype(t *testing.T) {
+	doc := `<!DOCTYPE doc [<!ENTITY x "y">]><doc xmlns="urn:test">&x;</doc>`
+	if _, err := xmldsig.Parse([]byte(doc)); err == nil {
+		t.Error("Parse accepted a DOCTYPE")
+	}
+}
@@ -0,0 +1,462 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is a filter expression of RFC 7644 section 3.4.2.2, see ParseFilter
+type Filter interface {
+	// Match tells whether the JSON form of a resource matches the filter
+	Match(resource map[string]interface{}) bool
+}
+
+// ParseFilter reads a filter such as `userName eq "bjensen"` or `emails[type eq "work" and value co "@example.com"]`.
+// Attribute names and operators are case-insensitive, and so are the string comparisons.
+func ParseFilter(s string) (Filter, error) {
+	p := &filterParser{}
+	if err := p.lex(s); err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected " + p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// EqualityValue returns the value of a filter made of the one comparison `attr eq "value"`,
+// which a store can look up instead of matching every resource
+func EqualityValue(f Filter, attr string) (string, bool) {
+	c, ok := f.(*compareFilter)
+	if !ok || c.op != "eq" || c.sub != "" || !strings.EqualFold(c.attr, attr) {
+		return "", false
+	}
+	v, ok := c.value.(string)
+	return v, ok
+}
+
+func invalidFilter(detail string) *Error {
+	return NewError(http.StatusBadRequest, ErrInvalidFilter, detail)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) lex(s string) error {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokenOpen, "("})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokenClose, ")"})
+			i++
+		case c == '[':
+			p.tokens = append(p.tokens, token{tokenOpenBracket, "["})
+			i++
+		case c == ']':
+			p.tokens = append(p.tokens, token{tokenCloseBracket, "]"})
+			i++
+		case c == '"':
+			end := stringEnd(s, i)
+			if end < 0 {
+				return invalidFilter("unterminated string")
+			}
+			p.tokens = append(p.tokens, token{tokenString, s[i:end]})
+			i = end
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokenWord, s[i:j]})
+			i = j
+		}
+	}
+	return nil
+}
+
+// stringEnd returns the index after the closing quote of the string starting at s[start], -1 when it is not closed
+func stringEnd(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func (p *filterParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token when it is the word kw
+func (p *filterParser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && t.kind == tokenWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	t, ok := p.peek()
+	if !ok || t.kind != kind {
+		return invalidFilter("expected " + text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (Filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (Filter, error) {
+	if p.keyword("not") {
+		f, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return &notFilter{f}, nil
+	}
+	if t, ok := p.peek(); ok && t.kind == tokenOpen {
+		return p.group()
+	}
+	return p.attrExpr()
+}
+
+func (p *filterParser) group() (Filter, error) {
+	if err := p.expect(tokenOpen, "("); err != nil {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(tokenClose, ")"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *filterParser) attrExpr() (Filter, error) {
+	t, ok := p.peek()
+	if !ok || t.kind != tokenWord {
+		return nil, invalidFilter("expected an attribute")
+	}
+	p.pos++
+	attr, sub := splitAttrPath(t.text)
+
+	if next, ok := p.peek(); ok && next.kind == tokenOpenBracket {
+		if sub != "" {
+			return nil, invalidFilter("a sub-attribute can not be filtered: " + t.text)
+		}
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePathFilter{attr: attr, inner: inner}, nil
+	}
+
+	opToken, ok := p.peek()
+	if !ok || opToken.kind != tokenWord {
+		return nil, invalidFilter("expected an operator after " + t.text)
+	}
+	p.pos++
+	op := strings.ToLower(opToken.text)
+	if op == "pr" {
+		return &compareFilter{attr: attr, sub: sub, op: op}, nil
+	}
+	switch op {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, invalidFilter("unknown operator " + opToken.text)
+	}
+
+	vt, ok := p.peek()
+	if !ok || (vt.kind != tokenString && vt.kind != tokenWord) {
+		return nil, invalidFilter("expected a value after " + opToken.text)
+	}
+	p.pos++
+	value, err := parseValue(vt)
+	if err != nil {
+		return nil, err
+	}
+	switch value.(type) {
+	case bool, nil:
+		if op != "eq" && op != "ne" {
+			return nil, invalidFilter(op + " does not compare " + vt.text)
+		}
+	}
+	return &compareFilter{attr: attr, sub: sub, op: op, value: value}, nil
+}
+
+func parseValue(t token) (interface{}, error) {
+	if t.kind == tokenString {
+		var s string
+		if err := json.Unmarshal([]byte(t.text), &s); err != nil {
+			return nil, invalidFilter("bad string " + t.text)
+		}
+		return s, nil
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, invalidFilter("bad value " + t.text)
+	}
+	return n, nil
+}
+
+// splitAttrPath splits an attribute path into its attribute and sub-attribute,
+// the URN of the core schemas may prefix it
+func splitAttrPath(path string) (attr, sub string) {
+	for _, schema := range []string{SchemaUser, SchemaGroup} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+			path = path[len(schema)+1:]
+			break
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		// an attribute of an extension schema, none is supported
+		return path, ""
+	}
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+type andFilter struct{ left, right Filter }
+
+func (f *andFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) && f.right.Match(r) }
+
+type orFilter struct{ left, right Filter }
+
+func (f *orFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) || f.right.Match(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f *notFilter) Match(r map[string]interface{}) bool { return !f.inner.Match(r) }
+
+// valuePathFilter matches when an element of the multi-valued attribute matches inner
+type valuePathFilter struct {
+	attr  string
+	inner Filter
+}
+
+func (f *valuePathFilter) Match(r map[string]interface{}) bool {
+	for _, elem := range elements(lookup(r, f.attr)) {
+		if m, ok := elem.(map[string]interface{}); ok && f.inner.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+type compareFilter struct {
+	attr, sub, op string
+	value         interface{}
+}
+
+func (f *compareFilter) Match(r map[string]interface{}) bool {
+	values := f.values(r)
+	switch {
+	case f.op == "pr":
+		return len(values) > 0
+	case f.value == nil:
+		// eq null is the absence of the attribute
+		return (len(values) == 0) == (f.op == "eq")
+	case f.op == "ne":
+		for _, v := range values {
+			if compare(v, "eq", f.value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the present values of the attribute path, the value sub-attribute of a complex multi-valued one
+func (f *compareFilter) values(r map[string]interface{}) []interface{} {
+	var rs []interface{}
+	for _, elem := range elements(lookup(r, f.attr)) {
+		v := elem
+		if m, ok := elem.(map[string]interface{}); ok {
+			sub := f.sub
+			if sub == "" {
+				sub = "value"
+			}
+			v = lookup(m, sub)
+		} else if f.sub != "" {
+			continue
+		}
+		if v == nil || v == "" {
+			continue
+		}
+		rs = append(rs, v)
+	}
+	return rs
+}
+
+func compare(v interface{}, op string, want interface{}) bool {
+	switch want := want.(type) {
+	case bool:
+		b, ok := v.(bool)
+		return ok && (b == want) == (op == "eq")
+	case float64:
+		n, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		return order(op, compareFloat(n, want))
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if t1, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			if t2, err := time.Parse(time.RFC3339Nano, want); err == nil && op != "co" && op != "sw" && op != "ew" {
+				return order(op, compareFloat(float64(t1.Sub(t2)), 0))
+			}
+		}
+		s, want = strings.ToLower(s), strings.ToLower(want)
+		switch op {
+		case "co":
+			return strings.Contains(s, want)
+		case "sw":
+			return strings.HasPrefix(s, want)
+		case "ew":
+			return strings.HasSuffix(s, want)
+		}
+		return order(op, strings.Compare(s, want))
+	}
+	return false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// order tells whether the result of a comparison satisfies op
+func order(op string, cmp int) bool {
+	switch op {
+	case "eq":
+		return cmp == 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	}
+	return false
+}
+
+// lookup returns the attribute of m named name in any case
+func lookup(m map[string]interface{}, name string) interface{} {
+	if v, ok := m[name]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// elements returns the values of a multi-valued attribute, or the single value of another one
+func elements(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	}
+	return []interface{}{v}
+}
@@ -0,0 +1,155 @@
+package scim_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"ms-user/pkg/scim"
+)
+
+// testUser is the JSON form of a user resource, as the service provider matches it
+func testUser(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var rs map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "bjensen@example.com",
+		"displayName": "Babs \"The\" Jensen",
+		"title": "",
+		"active": true,
+		"age": 42,
+		"name": {"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": [
+			{"value": "bjensen@example.com", "type": "work", "primary": true},
+			{"value": "babs@home.example", "type": "home"}
+		],
+		"meta": {"lastModified": "2024-01-02T03:04:05Z"}
+	}`), &rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestFilterMatch(t *testing.T) {
+	user := testUser(t)
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		// eq, case-insensitive names, operators and strings
+		{`userName eq "bjensen@example.com"`, true},
+		{`USERNAME EQ "BJensen@Example.com"`, true},
+		{`userName eq "jensen@example.com"`, false},
+		{`userName ne "jensen@example.com"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen@example.com"`, true},
+		{`name.familyName eq "jensen"`, true},
+		// co, sw, ew
+		{`userName co "JENSEN"`, true},
+		{`userName co "smith"`, false},
+		{`userName sw "bjen"`, true},
+		{`userName sw "jensen"`, false},
+		{`userName ew ".com"`, true},
+		{`userName ew "bjensen"`, false},
+		// pr: an empty string or a missing attribute is not present
+		{`name.givenName pr`, true},
+		{`emails pr`, true},
+		{`title pr`, false},
+		{`nickName pr`, false},
+		// booleans, numbers, null and dates
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`active ne false`, true},
+		{`nickName eq null`, true},
+		{`userName eq null`, false},
+		{`age gt 40`, true},
+		{`age le 40`, false},
+		{`meta.lastModified gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2024-01-01T00:00:00Z"`, false},
+		// multi-valued attributes
+		{`emails co "home.example"`, true},
+		{`emails.type eq "home"`, true},
+		{`emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "home" and value co "@example.com"]`, false},
+		{`emails[type eq "home"] and active eq true`, true},
+		// and, or, not
+		{`userName eq "bjensen@example.com" and active eq true`, true},
+		{`userName eq "bjensen@example.com" and active eq false`, false},
+		{`userName eq "x" or active eq true`, true},
+		{`not (userName eq "bjensen@example.com")`, false},
+		{`not (userName eq "x") and not (title pr)`, true},
+		// and binds tighter than or, parentheses group
+		{`userName eq "bjensen@example.com" or userName eq "x" and active eq false`, true},
+		{`(userName eq "bjensen@example.com" or userName eq "x") and active eq false`, false},
+		{`userName eq "x" and active eq true or title pr`, false},
+		{`userName eq "x" and (active eq true or name.givenName pr)`, false},
+		// quoting: escaped quotes and keywords within strings
+		{`displayName eq "Babs \"The\" Jensen"`, true},
+		{`displayName co "\"the\""`, true},
+		{`displayName eq "Babs" or displayName co " and "`, false},
+		{`displayName eq "a) or (userName pr"`, false},
+	} {
+		f, err := scim.ParseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s): %v", tc.filter, err)
+			continue
+		}
+		if got := f.Match(user); got != tc.want {
+			t.Errorf("ParseFilter(%s).Match = %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterRejects(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName zz "x"`,
+		`userName eq bjensen`,
+		`userName eq "unterminated`,
+		`userName eq "bad \q escape"`,
+		`(userName eq "x"`,
+		`userName eq "x")`,
+		`userName eq "x" and`,
+		`and userName eq "x"`,
+		`not userName eq "x"`,
+		`active gt true`,
+		`nickName co null`,
+		`emails[type eq "work"`,
+		`emails[type eq "work"]]`,
+		`name.givenName[value eq "x"]`,
+		`userName eq "x" userName eq "y"`,
+	} {
+		_, err := scim.ParseFilter(filter)
+		var scimErr *scim.Error
+		if !errors.As(err, &scimErr) || scimErr.Status != http.StatusBadRequest || scimErr.Type != scim.ErrInvalidFilter {
+			t.Errorf("ParseFilter(%s) err = %v, want a 400 %s", filter, err, scim.ErrInvalidFilter)
+		}
+	}
+}
+
+func TestEqualityValue(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		value  string
+		ok     bool
+	}{
+		{`userName eq "bjensen@example.com"`, "bjensen@example.com", true},
+		{`UserName EQ "bjensen@example.com"`, "bjensen@example.com", true},
+		{`userName co "bjensen"`, "", false},
+		{`userName eq "x" and active eq true`, "", false},
+		{`displayName eq "x"`, "", false},
+		{`userName.value eq "x"`, "", false},
+	} {
+		f, err := scim.ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", tc.filter, err)
+		}
+		if value, ok := scim.EqualityValue(f, "userName"); value != tc.value || ok != tc.ok {
+			t.Errorf("EqualityValue(%s) = %q, %v, want %q, %v", tc.filter, value, ok, tc.value, tc.ok)
+		}
+	}
+}
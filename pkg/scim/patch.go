@@ -0,0 +1,237 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// path is an attribute path of a PATCH operation: attr, attr.sub, attr[filter] or attr[filter].sub
+type path struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+func parsePath(s string) (p path, err error) {
+	open := strings.IndexByte(s, '[')
+	if open < 0 {
+		p.attr, p.sub = splitAttrPath(s)
+		if p.attr == "" {
+			return p, NewError(http.StatusBadRequest, ErrInvalidPath, "empty attribute in path "+s)
+		}
+		return p, nil
+	}
+
+	// the closing bracket is the first one outside a string of the filter
+	end := -1
+	for i := open + 1; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '"':
+			if j := stringEnd(s, i); j > 0 {
+				i = j - 1
+			}
+		case ']':
+			end = i
+		}
+	}
+	if end < 0 {
+		return p, NewError(http.StatusBadRequest, ErrInvalidPath, "unclosed [ in path "+s)
+	}
+	p.attr, _ = splitAttrPath(s[:open])
+	if p.filter, err = ParseFilter(s[open+1 : end]); err != nil {
+		return p, NewError(http.StatusBadRequest, ErrInvalidPath, err.Error())
+	}
+	switch rest := s[end+1:]; {
+	case rest == "":
+	case strings.HasPrefix(rest, ".") && len(rest) > 1:
+		p.sub = rest[1:]
+	default:
+		return p, NewError(http.StatusBadRequest, ErrInvalidPath, "unexpected "+rest+" in path "+s)
+	}
+	return p, nil
+}
+
+// Apply runs the operations of a PATCH request on the JSON form of a resource, in order.
+// Op is read in any case, as some clients send "Replace"; an add or replace without path sets
+// each attribute of its value, whose names may be paths such as "name.givenName".
+// Removing values that are not there is not an error, so a client can replay a removal.
+func Apply(resource map[string]interface{}, ops []PatchOperation) error {
+	for _, op := range ops {
+		var value interface{}
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return NewError(http.StatusBadRequest, ErrInvalidSyntax, "bad value of the "+op.Op+" operation")
+			}
+		}
+		kind := strings.ToLower(op.Op)
+		switch kind {
+		case "add", "replace":
+		case "remove":
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, ErrNoTarget, "remove needs a path")
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrInvalidSyntax, "unknown operation "+op.Op)
+		}
+
+		if op.Path == "" {
+			attrs, ok := value.(map[string]interface{})
+			if !ok {
+				return NewError(http.StatusBadRequest, ErrInvalidValue, "an "+kind+" without path needs an object value")
+			}
+			for name, v := range attrs {
+				p, err := parsePath(name)
+				if err != nil {
+					return err
+				}
+				if err = apply(resource, kind, p, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		p, err := parsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		if err = apply(resource, kind, p, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func apply(resource map[string]interface{}, kind string, p path, value interface{}) error {
+	key := keyOf(resource, p.attr)
+	current := resource[key]
+
+	if p.filter != nil {
+		return applyFiltered(resource, key, kind, p, value)
+	}
+
+	if p.sub != "" {
+		complexValue, ok := current.(map[string]interface{})
+		if current != nil && !ok {
+			return NewError(http.StatusBadRequest, ErrInvalidPath, p.attr+" has no sub-attributes")
+		}
+		if kind == "remove" {
+			if ok {
+				delete(complexValue, keyOf(complexValue, p.sub))
+			}
+			return nil
+		}
+		if !ok {
+			complexValue = map[string]interface{}{}
+			resource[key] = complexValue
+		}
+		complexValue[keyOf(complexValue, p.sub)] = value
+		return nil
+	}
+
+	switch kind {
+	case "remove":
+		list, isList := current.([]interface{})
+		if value == nil || !isList {
+			delete(resource, key)
+			return nil
+		}
+		// a removal naming the values to drop from a multi-valued attribute, as some clients send for members
+		kept := list[:0:0]
+		for _, elem := range list {
+			if !containsElement(elements(value), elem) {
+				kept = append(kept, elem)
+			}
+		}
+		resource[key] = kept
+	case "add":
+		if list, ok := current.([]interface{}); ok {
+			for _, v := range elements(value) {
+				if !containsElement(list, v) {
+					list = append(list, v)
+				}
+			}
+			resource[key] = list
+			return nil
+		}
+		if complexValue, ok := current.(map[string]interface{}); ok {
+			if attrs, ok := value.(map[string]interface{}); ok {
+				for k, v := range attrs {
+					complexValue[keyOf(complexValue, k)] = v
+				}
+				return nil
+			}
+		}
+		resource[key] = value
+	default:
+		resource[key] = value
+	}
+	return nil
+}
+
+// applyFiltered runs an operation on the elements of the multi-valued attribute key matching the filter of p
+func applyFiltered(resource map[string]interface{}, key, kind string, p path, value interface{}) error {
+	list, _ := resource[key].([]interface{})
+	kept := list[:0:0]
+	matched := false
+	for _, elem := range list {
+		m, ok := elem.(map[string]interface{})
+		if !ok || !p.filter.Match(m) {
+			kept = append(kept, elem)
+			continue
+		}
+		matched = true
+		switch {
+		case kind == "remove" && p.sub == "":
+			continue
+		case kind == "remove":
+			delete(m, keyOf(m, p.sub))
+		case p.sub != "":
+			m[keyOf(m, p.sub)] = value
+		default:
+			attrs, ok := value.(map[string]interface{})
+			if !ok {
+				return NewError(http.StatusBadRequest, ErrInvalidValue, "the elements of "+p.attr+" are replaced by an object")
+			}
+			for k, v := range attrs {
+				m[keyOf(m, k)] = v
+			}
+		}
+		kept = append(kept, m)
+	}
+	if !matched && kind != "remove" {
+		return NewError(http.StatusBadRequest, ErrNoTarget, "no element of "+p.attr+" matches the filter")
+	}
+	resource[key] = kept
+	return nil
+}
+
+// containsElement tells whether list holds v; complex values are the same when their value sub-attributes are
+func containsElement(list []interface{}, v interface{}) bool {
+	vm, complexValue := v.(map[string]interface{})
+	for _, elem := range list {
+		if em, ok := elem.(map[string]interface{}); ok && complexValue {
+			if ev, vv := lookup(em, "value"), lookup(vm, "value"); ev != nil && reflect.DeepEqual(ev, vv) {
+				return true
+			}
+		}
+		if reflect.DeepEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyOf returns the key of m naming the attribute name in any case, name itself when m has none
+func keyOf(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
@@ -0,0 +1,151 @@
+package scim_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"ms-user/pkg/scim"
+)
+
+// decode reads the JSON form of a resource
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var rs map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &rs); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return rs
+}
+
+const patchGroup = `{
+	"displayName": "Engineering",
+	"title": "team",
+	"name": {"givenName": "Barbara"},
+	"emails": [
+		{"value": "work@example.com", "type": "work", "primary": true},
+		{"value": "home@example.com", "type": "home"},
+		{"value": "a]b@example.com", "type": "other"}
+	],
+	"members": [{"value": "1"}, {"value": "2"}]
+}`
+
+func TestApply(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ops  []scim.PatchOperation
+		want string
+	}{
+		{
+			name: "replace without path sets each attribute, names may be paths",
+			ops:  []scim.PatchOperation{{Op: "Replace", Value: json.RawMessage(`{"displayName": "Sales", "name.givenName": "Babs"}`)}},
+			want: `{"displayName": "Sales", "name": {"givenName": "Babs"}}`,
+		},
+		{
+			name: "add appends to a multi-valued attribute once",
+			ops: []scim.PatchOperation{{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "2"}, {"value": "3"}]`)},
+				{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "3"}]`)}},
+			want: `{"members": [{"value": "1"}, {"value": "2"}, {"value": "3"}]}`,
+		},
+		{
+			name: "add merges into a complex attribute",
+			ops:  []scim.PatchOperation{{Op: "add", Path: "name", Value: json.RawMessage(`{"familyName": "Jensen"}`)}},
+			want: `{"name": {"givenName": "Barbara", "familyName": "Jensen"}}`,
+		},
+		{
+			name: "add a sub-attribute of a missing complex attribute",
+			ops:  []scim.PatchOperation{{Op: "add", Path: "meta.version", Value: json.RawMessage(`"1"`)}},
+			want: `{"meta": {"version": "1"}}`,
+		},
+		{
+			name: "replace a sub-attribute of the elements matching a value path",
+			ops:  []scim.PatchOperation{{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"new@example.com"`)}},
+			want: `{"emails": [{"value": "new@example.com", "type": "work", "primary": true}, {"value": "home@example.com", "type": "home"}, {"value": "a]b@example.com", "type": "other"}]}`,
+		},
+		{
+			name: "replace the elements matching a value path with an object",
+			ops:  []scim.PatchOperation{{Op: "replace", Path: `emails[type eq "home"]`, Value: json.RawMessage(`{"primary": true}`)}},
+			want: `{"emails": [{"value": "work@example.com", "type": "work", "primary": true}, {"value": "home@example.com", "type": "home", "primary": true}, {"value": "a]b@example.com", "type": "other"}]}`,
+		},
+		{
+			name: "a quoted bracket does not close the value path",
+			ops:  []scim.PatchOperation{{Op: "replace", Path: `emails[value eq "a]b@example.com"].type`, Value: json.RawMessage(`"home"`)}},
+			want: `{"emails": [{"value": "work@example.com", "type": "work", "primary": true}, {"value": "home@example.com", "type": "home"}, {"value": "a]b@example.com", "type": "home"}]}`,
+		},
+		{
+			name: "remove the elements matching a value path",
+			ops:  []scim.PatchOperation{{Op: "remove", Path: `emails[type eq "home" or type eq "other"]`}},
+			want: `{"emails": [{"value": "work@example.com", "type": "work", "primary": true}]}`,
+		},
+		{
+			name: "remove a sub-attribute of the elements matching a value path",
+			ops:  []scim.PatchOperation{{Op: "remove", Path: `emails[type eq "work"].primary`}},
+			want: `{"emails": [{"value": "work@example.com", "type": "work"}, {"value": "home@example.com", "type": "home"}, {"value": "a]b@example.com", "type": "other"}]}`,
+		},
+		{
+			name: "remove the members named by the value",
+			ops:  []scim.PatchOperation{{Op: "remove", Path: "members", Value: json.RawMessage(`[{"value": "1"}]`)}},
+			want: `{"members": [{"value": "2"}]}`,
+		},
+		{
+			name: "remove a value path matching nothing is not an error",
+			ops:  []scim.PatchOperation{{Op: "remove", Path: `members[value eq "9"]`}},
+			want: `{"members": [{"value": "1"}, {"value": "2"}]}`,
+		},
+		{
+			name: "remove attributes and sub-attributes",
+			ops:  []scim.PatchOperation{{Op: "remove", Path: "title"}, {Op: "remove", Path: "name.givenName"}, {Op: "remove", Path: "nickName"}},
+			want: `{"title": null, "name": {}}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resource := decode(t, patchGroup)
+			if err := scim.Apply(resource, tc.ops); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			// the attributes of want are checked, null ones must be gone
+			for k, v := range decode(t, tc.want) {
+				got, ok := resource[k]
+				if v == nil {
+					if ok {
+						t.Errorf("%s = %v, want it removed", k, got)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(got, v) {
+					t.Errorf("%s = %v, want %v", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		op       scim.PatchOperation
+		scimType string
+	}{
+		{"unknown operation", scim.PatchOperation{Op: "move", Path: "title"}, scim.ErrInvalidSyntax},
+		{"remove without path", scim.PatchOperation{Op: "remove"}, scim.ErrNoTarget},
+		{"bad value", scim.PatchOperation{Op: "replace", Path: "title", Value: json.RawMessage(`{`)}, scim.ErrInvalidSyntax},
+		{"replace without path and object", scim.PatchOperation{Op: "replace", Value: json.RawMessage(`"x"`)}, scim.ErrInvalidValue},
+		{"unclosed value path", scim.PatchOperation{Op: "replace", Path: `emails[type eq "work".value`, Value: json.RawMessage(`"x"`)}, scim.ErrInvalidPath},
+		{"bad filter in the value path", scim.PatchOperation{Op: "replace", Path: `emails[type zz "work"].value`, Value: json.RawMessage(`"x"`)}, scim.ErrInvalidPath},
+		{"text after the value path", scim.PatchOperation{Op: "replace", Path: `emails[type eq "work"]value`, Value: json.RawMessage(`"x"`)}, scim.ErrInvalidPath},
+		{"empty attribute", scim.PatchOperation{Op: "replace", Path: ".value", Value: json.RawMessage(`"x"`)}, scim.ErrInvalidPath},
+		{"sub-attribute of a simple attribute", scim.PatchOperation{Op: "replace", Path: "displayName.value", Value: json.RawMessage(`"x"`)}, scim.ErrInvalidPath},
+		{"value path matching nothing", scim.PatchOperation{Op: "replace", Path: `emails[type eq "none"].value`, Value: json.RawMessage(`"x"`)}, scim.ErrNoTarget},
+		{"elements replaced by a string", scim.PatchOperation{Op: "replace", Path: `emails[type eq "work"]`, Value: json.RawMessage(`"x"`)}, scim.ErrInvalidValue},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := scim.Apply(decode(t, patchGroup), []scim.PatchOperation{tc.op})
+			var scimErr *scim.Error
+			if !errors.As(err, &scimErr) || scimErr.Status != http.StatusBadRequest || scimErr.Type != tc.scimType {
+				t.Errorf("Apply err = %v, want a 400 %s", err, tc.scimType)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// Package scim holds the protocol pieces of the SCIM 2.0 service provider (RFC 7643 and RFC 7644):
+// the resources and messages, the errors, and the filters and patch operations applied to resources.
+//
+// Filters and patches work on the JSON form of a resource, a map as encoding/json decodes it,
+// so they apply to users and groups alike.
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Schemas of the resources and messages
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of the requests and responses
+const ContentType = "application/scim+json"
+
+// Error types of RFC 7644 section 3.12
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrTooMany       = "tooMany"
+	ErrUniqueness    = "uniqueness"
+	ErrMutability    = "mutability"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidPath   = "invalidPath"
+	ErrNoTarget      = "noTarget"
+	ErrInvalidValue  = "invalidValue"
+)
+
+// Error is a SCIM error answer, Type is empty for the statuses RFC 7644 gives no type to
+type Error struct {
+	Status int
+	Type   string
+	Detail string
+}
+
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Status: status, Type: scimType, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return e.Detail
+	}
+	return e.Type + ": " + e.Detail
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas []string `json:"schemas"`
+		Status  string   `json:"status"`
+		Type    string   `json:"scimType,omitempty"`
+		Detail  string   `json:"detail,omitempty"`
+	}{[]string{SchemaError}, strconv.Itoa(e.Status), e.Type, e.Detail})
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+// MultiValued is a value of a multi-valued attribute such as emails, or a reference such as a group member
+type MultiValued struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is the User resource, Active is nil when a request leaves it out
+type User struct {
+	Schemas      []string      `json:"schemas"`
+	ID           string        `json:"id,omitempty"`
+	ExternalID   string        `json:"externalId,omitempty"`
+	UserName     string        `json:"userName"`
+	Name         *Name         `json:"name,omitempty"`
+	DisplayName  string        `json:"displayName,omitempty"`
+	ProfileURL   string        `json:"profileUrl,omitempty"`
+	Active       *bool         `json:"active,omitempty"`
+	Emails       []MultiValued `json:"emails,omitempty"`
+	PhoneNumbers []MultiValued `json:"phoneNumbers,omitempty"`
+	Photos       []MultiValued `json:"photos,omitempty"`
+	// Groups is read-only, it lists the groups the user is a member of
+	Groups []MultiValued `json:"groups,omitempty"`
+	Meta   *Meta         `json:"meta,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	DisplayName string        `json:"displayName"`
+	Members     []MultiValued `json:"members,omitempty"`
+	Meta        *Meta         `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at startIndex, counted from 1, of total results
+func NewListResponse(resources []interface{}, startIndex, total int) ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is an operation of a PATCH request, Op is add, replace or remove in any case
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ServiceProviderConfig describes the features of the service provider, see NewServiceProviderConfig
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri,omitempty"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulk                   `json:"bulk"`
+	Filter                filter                 `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type bulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type filter struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// NewServiceProviderConfig describes a provider supporting PATCH and filters, returning at most maxResults resources
+// a page, and authenticating its clients with bearer tokens
+func NewServiceProviderConfig(maxResults int) ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas: []string{SchemaServiceProviderConfig},
+		Patch:   supported{Supported: true},
+		Filter:  filter{Supported: true, MaxResults: maxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type: "oauthbearertoken", Name: "Bearer token", Description: "A SCIM token of the business", Primary: true,
+		}},
+	}
+}
@@ -0,0 +1,43 @@
+// Package security holds the HTTP middlewares that protect the API in browsers and behind proxies.
+package security
+
+import (
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+type CORSConfig struct {
+	// AllowOrigins are exact origins, "*" or a pattern with one wildcard such as https://*.example.com
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+	// Skip exempts the routes the forms of other sites post to, such as a SAML assertion consumer service:
+	// a navigation is not a CORS request, its Origin must not be refused
+	Skip func(c *gin.Context) bool
+}
+
+// CORS answers the preflight requests and sets the CORS headers of the allowed origins
+func CORS(cfg CORSConfig) gin.HandlerFunc {
+	handler := cors.New(cors.Config{
+		AllowOrigins:     cfg.AllowOrigins,
+		AllowMethods:     cfg.AllowMethods,
+		AllowHeaders:     cfg.AllowHeaders,
+		ExposeHeaders:    cfg.ExposeHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+		AllowWildcard:    true,
+	})
+	if cfg.Skip == nil {
+		return handler
+	}
+	return func(c *gin.Context) {
+		if !cfg.Skip(c) {
+			handler(c)
+		}
+	}
+}
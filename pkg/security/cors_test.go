@@ -0,0 +1,106 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ms-user/pkg/security"
+)
+
+const acsPath = "/api/v1/saml/acs"
+
+func newCORSRouter(skip func(c *gin.Context) bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(security.CORS(security.CORSConfig{
+		AllowOrigins:     []string{"https://app.example.com", "https://*.example.org"},
+		AllowMethods:     []string{"GET", "POST"},
+		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		Skip:             skip,
+	}))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/api/v1/user/me", ok)
+	r.POST(acsPath, ok)
+	return r
+}
+
+func serve(r http.Handler, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSOrigins(t *testing.T) {
+	r := newCORSRouter(nil)
+	for _, tc := range []struct {
+		name, origin string
+		allowed      bool
+	}{
+		{"exact origin", "https://app.example.com", true},
+		{"origin of the wildcard pattern", "https://shop.example.org", true},
+		{"other origin", "https://evil.example.net", false},
+		{"other scheme", "http://app.example.com", false},
+		{"suffix of an allowed origin", "https://app.example.com.evil.net", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/v1/user/me", map[string]string{"Origin": tc.origin})
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tc.allowed {
+				if w.Code != http.StatusOK || allowOrigin != tc.origin || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+					t.Errorf("status %d, Access-Control-Allow-Origin %q, want 200 and %q with credentials", w.Code, allowOrigin, tc.origin)
+				}
+				return
+			}
+			if w.Code != http.StatusForbidden || allowOrigin != "" {
+				t.Errorf("status %d, Access-Control-Allow-Origin %q, want 403 without the header", w.Code, allowOrigin)
+			}
+		})
+	}
+
+	// a same-origin request sends no Origin and is not a CORS request
+	if w := serve(r, http.MethodGet, "/api/v1/user/me", nil); w.Code != http.StatusOK {
+		t.Errorf("request without Origin: status %d, want 200", w.Code)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	r := newCORSRouter(nil)
+	w := serve(r, http.MethodOptions, "/api/v1/user/me", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "Authorization",
+	})
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight: status %d, headers %v, want 204 for the origin with a max age of 600", w.Code, w.Header())
+	}
+}
+
+// TestCORSSkip posts the form of an identity provider to the assertion consumer service:
+// the other site is not an allowed origin, the navigation must go through all the same
+func TestCORSSkip(t *testing.T) {
+	idp := map[string]string{"Origin": "https://idp.example.net"}
+	if w := serve(newCORSRouter(nil), http.MethodPost, acsPath, idp); w.Code != http.StatusForbidden {
+		t.Fatalf("ACS without Skip: status %d, want 403", w.Code)
+	}
+
+	r := newCORSRouter(func(c *gin.Context) bool { return c.Request.URL.Path == acsPath })
+	w := serve(r, http.MethodPost, acsPath, idp)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("ACS with Skip: status %d, Access-Control-Allow-Origin %q, want 200 without the header", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	// the other routes still refuse the origin
+	if w = serve(r, http.MethodGet, "/api/v1/user/me", idp); w.Code != http.StatusForbidden {
+		t.Errorf("other route with Skip: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package security_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ms-user/pkg/security"
+)
+
+func TestHeadersMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  security.HeadersConfig
+		want map[string]string
+	}{
+		{
+			name: "every header",
+			cfg: security.HeadersConfig{HSTSMaxAge: 365 * 24 * time.Hour, HSTSIncludeSubdomains: true, FrameOptions: "DENY",
+				ContentSecurityPolicy: "default-src 'none'", ReferrerPolicy: "no-referrer"},
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+				"X-Frame-Options":           "DENY",
+				"Content-Security-Policy":   "default-src 'none'",
+				"Referrer-Policy":           "no-referrer",
+			},
+		},
+		{
+			name: "HSTS without subdomains",
+			cfg:  security.HeadersConfig{HSTSMaxAge: time.Hour, FrameOptions: "SAMEORIGIN"},
+			want: map[string]string{"Strict-Transport-Security": "max-age=3600", "X-Frame-Options": "SAMEORIGIN"},
+		},
+		{
+			name: "disabled headers",
+			cfg:  security.HeadersConfig{},
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "",
+				"Content-Security-Policy":   "",
+				"Referrer-Policy":           "",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(security.HeadersMiddleware(tc.cfg))
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+			// the headers are on the errors too
+			for _, path := range []string{"/", "/missing"} {
+				w := serve(r, http.MethodGet, path, nil)
+				for name, want := range tc.want {
+					if got := w.Header().Get(name); got != want {
+						t.Errorf("GET %s %s = %q, want %q", path, name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
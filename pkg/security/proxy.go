@@ -0,0 +1,95 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedProxies are the networks allowed to tell the client IP in X-Forwarded-For and X-Real-IP
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies accepts CIDRs and single IPs
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	rs := make(TrustedProxies, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			rs = append(rs, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+		}
+		rs = append(rs, network)
+	}
+	return rs, nil
+}
+
+func (p TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client that sent the request through the trusted proxies.
+// X-Forwarded-For is read from the right, the first hop that is not a trusted proxy is the client,
+// so a client cannot spoof its IP by sending the header itself.
+func (p TrustedProxies) ClientIP(remoteAddr string, header func(string) string) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(remoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(remoteAddr)
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !p.Contains(remote) {
+		return host
+	}
+
+	if xff := header("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		client := host
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !p.Contains(ip) {
+				break
+			}
+		}
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(header("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// ProxyMiddleware replaces the remote address of the request by the client IP behind the trusted proxies,
+// c.ClientIP() and every later handler then see the real client.
+// gin 1.7 only applies its own TrustedProxies in Engine.Run, which the cloud0 BaseApp does not call.
+func ProxyMiddleware(proxies TrustedProxies) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := proxies.ClientIP(c.Request.RemoteAddr, c.GetHeader)
+		c.Request.RemoteAddr = net.JoinHostPort(ip, "0")
+		c.Next()
+	}
+}
@@ -0,0 +1,77 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"ms-user/pkg/security"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := security.ParseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "2001:db8::/32"})
+	if err != nil || len(proxies) != 3 {
+		t.Fatalf("ParseTrustedProxies = %v, %v", proxies, err)
+	}
+	for _, bad := range []string{"proxy.internal", "10.0.0.0/33"} {
+		if _, err = security.ParseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepts it", bad)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := security.ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, remote string
+		header       map[string]string
+		want         string
+	}{
+		{"untrusted remote", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"untrusted remote with X-Real-IP", "203.0.113.9:4000", map[string]string{"X-Real-IP": "1.2.3.4"}, "203.0.113.9"},
+		{"trusted proxy", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"trusted IPv6 proxy", "[2001:db8::1]:4000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4"},
+		{"chain of trusted proxies", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.2, 10.0.0.3"}, "1.2.3.4"},
+		{"hop spoofed by the client", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "9.9.9.9, 1.2.3.4"}, "1.2.3.4"},
+		{"garbage hop", "10.0.0.1:4000", map[string]string{"X-Forwarded-For": "unknown"}, "10.0.0.1"},
+		{"X-Real-IP of a trusted proxy", "10.0.0.1:4000", map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4"},
+		{"trusted proxy without header", "10.0.0.1:4000", nil, "10.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := proxies.ClientIP(tc.remote, func(name string) string { return tc.header[name] })
+			if got != tc.want {
+				t.Errorf("ClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProxyMiddleware(t *testing.T) {
+	proxies, err := security.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(security.ProxyMiddleware(proxies))
+	r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	for _, tc := range []struct{ remote, want string }{
+		{"10.0.0.1:4000", "1.2.3.4"},
+		{"203.0.113.9:4000", "203.0.113.9"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Body.String(); got != tc.want {
+			t.Errorf("c.ClientIP() from %s = %q, want %q", tc.remote, got, tc.want)
+		}
+	}
+}
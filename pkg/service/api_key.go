@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+)
+
+// apiKeyPrefixBytes is the length of the random lookup prefix of a key, 16 hex characters
+const apiKeyPrefixBytes = 8
+
+// apiKeyAttempts bounds the prefixes drawn when a new prefix is used by another key
+const apiKeyAttempts = 3
+
+// apiKeyTouchInterval spaces the writes of the last use of a key, a script calling in a loop does not write every call
+const apiKeyTouchInterval = time.Minute
+
+var apiKeyScopes = model.SpaceList{model.APIKeyScopeRead, model.APIKeyScopeWrite, model.APIKeyScopeAdmin}
+
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// CreateAPIKey makes a key of the user, the key is only returned here.
+// Only admins get the admin scope, and a key lives at most API_KEY_MAX_TTL_DAYS.
+func (s *UserService) CreateAPIKey(ctx context.Context, userID uuid.UUID, req model.CreateAPIKeyReq) (rs model.CreateAPIKeyResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateAPIKey", tracing.WithAttributes("user.id", userID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.CreateAPIKey")
+
+	now := time.Now()
+	maxExpiry := now.AddDate(0, 0, conf.LoadEnv().APIKeyMaxTTLDays)
+	rs.ExpiresAt = maxExpiry
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) || req.ExpiresAt.After(maxExpiry) {
+			log.Error("error_400: expires_at out of range")
+			return rs, ginext.NewError(http.StatusBadRequest, "Invalid input: expires_at must be in the future and within the longest lifetime of a key")
+		}
+		rs.ExpiresAt = *req.ExpiresAt
+	}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes.Contains(scope) {
+			log.WithField("scope", scope).Error("error_400: unknown scope")
+			return rs, ginext.NewError(http.StatusBadRequest, "Invalid input: unknown scope "+scope)
+		}
+		if !rs.Scopes.Contains(scope) {
+			rs.Scopes = append(rs.Scopes, scope)
+		}
+	}
+	if rs.HasScope(model.APIKeyScopeAdmin) {
+		user, err := s.repo.GetOneUserByID(ctx, userID, nil)
+		if err != nil {
+			return rs, err
+		}
+		if user.AccountType != model.AccountTypeAdmin {
+			log.Error("error_403: the admin scope is given to admins only")
+			return rs, ginext.NewError(http.StatusForbidden, "Only admins may create a key with the admin scope")
+		}
+	}
+
+	rs.UserID = userID
+	rs.Name = strings.TrimSpace(req.Name)
+	rs.CreatedAt = now
+	for attempt := 1; ; attempt++ {
+		prefix := make([]byte, apiKeyPrefixBytes)
+		secret, err := randomToken()
+		if err == nil {
+			_, err = rand.Read(prefix)
+		}
+		if err != nil {
+			log.WithError(err).Error("error_500: cannot read random bytes")
+			return rs, ginext.NewError(http.StatusInternalServerError, "Cannot create the API key")
+		}
+		rs.ID = uuid.New()
+		rs.Prefix = hex.EncodeToString(prefix)
+		rs.Key = model.APIKeyPrefix + rs.Prefix + "_" + secret
+		rs.KeyHash = hashNonce(rs.Key)
+
+		err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+			if err := rp.CreateAPIKey(ctx, &rs.APIKey, nil); err != nil {
+				return err
+			}
+			return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditAPIKeyCreated, &userID, map[string]interface{}{
+				"key_id": rs.ID.String(), "name": rs.Name, "scopes": rs.Scopes.String(),
+			}))
+		})
+		var apiErr ginext.ApiError
+		if attempt < apiKeyAttempts && errors.As(err, &apiErr) && apiErr.Code() == http.StatusConflict {
+			// another key has the prefix, draw a new one
+			log.WithField("attempt", attempt).Warn("the prefix of the API key is taken")
+			continue
+		}
+		return rs, err
+	}
+}
+
+func (s *UserService) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx, userID, nil)
+}
+
+// RevokeAPIKey deletes a key of the user, the scripts using it are refused from now on
+func (s *UserService) RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RevokeAPIKey", tracing.WithAttributes("user.id", userID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.DeleteAPIKey(ctx, userID, id, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			tracing.WithCtx(ctx, "UserService.RevokeAPIKey").WithField("key_id", id).Error("error_404: unknown API key")
+			return ginext.NewError(http.StatusNotFound, "Unknown API key")
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditAPIKeyRevoked, &userID, map[string]interface{}{
+			"key_id": id.String(),
+		}))
+	})
+}
+
+// AuthenticateAPIKey returns the key when it is known, not expired and its user still exists, and records its use
+func (s *UserService) AuthenticateAPIKey(ctx context.Context, key string) (rs model.APIKey, err error) {
+	rest := strings.TrimPrefix(key, model.APIKeyPrefix)
+	i := strings.IndexByte(rest, '_')
+	if rest == key || i <= 0 {
+		return rs, errUnknownAPIKey
+	}
+	if rs, err = s.repo.GetAPIKeyByPrefix(ctx, rest[:i], nil); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, errUnknownAPIKey
+		}
+		return rs, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashNonce(key)), []byte(rs.KeyHash)) != 1 {
+		return rs, errUnknownAPIKey
+	}
+	now := time.Now()
+	if !now.Before(rs.ExpiresAt) {
+		return rs, errors.New("the API key expired")
+	}
+	if _, err = s.repo.GetOneUserByID(ctx, rs.UserID, nil); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return rs, errors.New("the user of the API key is deleted")
+		}
+		return rs, err
+	}
+
+	if rs.LastUsedAt == nil || now.Sub(*rs.LastUsedAt) >= apiKeyTouchInterval {
+		// the call goes on when the use can not be recorded
+		if err := s.repo.TouchAPIKey(ctx, rs.ID, now, nil); err != nil {
+			tracing.WithCtx(ctx, "UserService.AuthenticateAPIKey").WithError(err).Error("cannot record the use of the API key")
+		} else {
+			rs.LastUsedAt = &now
+		}
+	}
+	return rs, nil
+}
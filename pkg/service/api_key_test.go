@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+)
+
+// collidingRepo answers a taken prefix to the first collisions calls of CreateAPIKey
+type collidingRepo struct {
+	repo.PGInterface
+	collisions *int
+}
+
+func (r collidingRepo) Transaction(ctx context.Context, f func(rp repo.PGInterface) error) error {
+	return r.PGInterface.Transaction(ctx, func(rp repo.PGInterface) error {
+		return f(collidingRepo{PGInterface: rp, collisions: r.collisions})
+	})
+}
+
+func (r collidingRepo) CreateAPIKey(ctx context.Context, req *model.APIKey, tx *gorm.DB) error {
+	if *r.collisions > 0 {
+		*r.collisions--
+		return ginext.NewError(http.StatusConflict, repo.APIKeyPrefixTakenMessage)
+	}
+	return r.PGInterface.CreateAPIKey(ctx, req, tx)
+}
+
+func TestCreateAPIKeyDrawsANewPrefixWhenTaken(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		collisions int
+		want       int
+	}{
+		{0, http.StatusOK},
+		{apiKeyAttempts - 1, http.StatusOK},
+		{apiKeyAttempts, http.StatusConflict},
+	} {
+		collisions := tc.collisions
+		s := NewUserService(collidingRepo{PGInterface: repo.NewMemoryRepo(), collisions: &collisions}, nil, nil).(*UserService)
+		user := model.User{Email: "api-key@example.com", FullName: "API key", Password: "hashed"}
+		if err := s.repo.CreateUser(ctx, &user, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := s.CreateAPIKey(ctx, user.ID, model.CreateAPIKeyReq{Name: "etl", Scopes: model.SpaceList{model.APIKeyScopeRead}})
+		if got := errStatus(err); got != tc.want {
+			t.Errorf("%d taken prefixes: status %d, want %d: %v", tc.collisions, got, tc.want, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(rs.Prefix) != 2*apiKeyPrefixBytes || !strings.HasPrefix(rs.Key, model.APIKeyPrefix+rs.Prefix+"_") {
+			t.Errorf("%d taken prefixes: key %q with prefix %q", tc.collisions, rs.Key, rs.Prefix)
+		}
+		if got, err := s.AuthenticateAPIKey(ctx, rs.Key); err != nil || got.ID != rs.ID {
+			t.Errorf("%d taken prefixes: AuthenticateAPIKey = %s, %v, want key %s", tc.collisions, got.ID, err, rs.ID)
+		}
+		if _, err := s.AuthenticateAPIKey(ctx, rs.Key+"x"); !errors.Is(err, errUnknownAPIKey) {
+			t.Errorf("%d taken prefixes: AuthenticateAPIKey of another secret err = %v, want %v", tc.collisions, err, errUnknownAPIKey)
+		}
+	}
+}
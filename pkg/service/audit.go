@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+)
+
+// exportPageSize is the page size used to walk the whole audit log
+const exportPageSize = 1000
+
+type AuditService struct {
+	repo repo.PGInterface
+}
+
+func NewAuditService(repo repo.PGInterface) AuditInterface {
+	return &AuditService{repo: repo}
+}
+
+type AuditInterface interface {
+	ListEvents(ctx context.Context, filter model.AuditFilter) (rs []model.AuditEvent, meta ginext.BodyMeta, err error)
+	ExportEvents(ctx context.Context, filter model.AuditFilter, w io.Writer) error
+	VerifyChain(ctx context.Context) (rs model.AuditVerifyResponse, err error)
+}
+
+// auditChainBatchSize is the number of pending events appended to the chain per transaction
+const auditChainBatchSize = 500
+
+// RecordAudit queues ev for the audit chain through rp, the chain is extended by ChainAuditEvents.
+// Call it with the rp of the transaction doing the audited action so both commit together.
+func RecordAudit(ctx context.Context, rp repo.PGInterface, ev model.AuditEvent) error {
+	pending := model.NewPendingAuditEvent(ev, time.Now())
+	return rp.CreatePendingAuditEvent(ctx, &pending, nil)
+}
+
+// recordAuditAlone records an event that has no transaction of its own, e.g. a failed login
+func recordAuditAlone(ctx context.Context, r repo.PGInterface, ev model.AuditEvent) {
+	if err := RecordAudit(ctx, r, ev); err != nil {
+		tracing.WithCtx(ctx, "service.recordAuditAlone").WithError(err).WithField("type", ev.Type).Error("failed to record audit event")
+	}
+}
+
+// ChainAuditEvents appends up to limit pending events to the audit chain under the chain lock
+// and returns how many were appended
+func ChainAuditEvents(ctx context.Context, r repo.PGInterface, limit int) (chained int, err error) {
+	err = r.Transaction(ctx, func(rp repo.PGInterface) error {
+		chained = 0
+		if err := rp.LockAuditChain(ctx, nil); err != nil {
+			return err
+		}
+		pending, err := rp.GetPendingAuditEvents(ctx, limit, nil)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		var prev *model.AuditEvent
+		last, err := rp.GetLastAuditEvent(ctx, nil)
+		switch {
+		case err == nil:
+			prev = &last
+		case err != gorm.ErrRecordNotFound:
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(pending))
+		for _, p := range pending {
+			ev := p.Event()
+			audit.Chain(prev, &ev)
+			if err = rp.CreateAuditEvent(ctx, &ev, nil); err != nil {
+				return err
+			}
+			prev = &ev
+			ids = append(ids, p.ID)
+		}
+		if err = rp.DeletePendingAuditEvents(ctx, ids, nil); err != nil {
+			return err
+		}
+		chained = len(pending)
+		return nil
+	})
+	return chained, err
+}
+
+// chainAllAuditEvents appends every pending event, the audit log is read after it so it shows them
+func chainAllAuditEvents(ctx context.Context, r repo.PGInterface) error {
+	for {
+		chained, err := ChainAuditEvents(ctx, r, auditChainBatchSize)
+		if err != nil || chained < auditChainBatchSize {
+			return err
+		}
+	}
+}
+
+// AuditChainWorker appends the events recorded by the requests to the audit chain,
+// the requests never wait on the chain lock
+type AuditChainWorker struct {
+	repo     repo.PGInterface
+	interval time.Duration
+}
+
+func NewAuditChainWorker(repo repo.PGInterface, interval time.Duration) *AuditChainWorker {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &AuditChainWorker{repo: repo, interval: interval}
+}
+
+// Run chains the pending events every interval until ctx is done, then chains the last ones
+// recorded while the requests were drained
+func (w *AuditChainWorker) Run(ctx context.Context) {
+	log := tracing.WithCtx(ctx, "AuditChainWorker.Run")
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := chainAllAuditEvents(context.Background(), w.repo); err != nil {
+			log.WithError(err).Error("failed to chain audit events")
+		}
+		select {
+		case <-ctx.Done():
+			if err := chainAllAuditEvents(context.Background(), w.repo); err != nil {
+				log.WithError(err).Error("failed to chain audit events")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AuditService) ListEvents(ctx context.Context, filter model.AuditFilter) (rs []model.AuditEvent, meta ginext.BodyMeta, err error) {
+	if err = chainAllAuditEvents(ctx, s.repo); err != nil {
+		return nil, nil, err
+	}
+	rs, meta, err = s.repo.ListAuditEvents(ctx, filter, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLogQueried, nil, auditFilterMetadata(filter)))
+	return rs, meta, nil
+}
+
+// ExportEvents writes every event matching filter as JSON Lines, ordered by seq
+func (s *AuditService) ExportEvents(ctx context.Context, filter model.AuditFilter, w io.Writer) error {
+	log := tracing.WithCtx(ctx, "AuditService.ExportEvents")
+
+	recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLogExported, nil, auditFilterMetadata(filter)))
+	if err := chainAllAuditEvents(ctx, s.repo); err != nil {
+		log.WithError(err).Error("error_500: failed to chain audit events")
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	filter.PageSize = exportPageSize
+	for filter.Page = 1; ; filter.Page++ {
+		events, _, err := s.repo.ListAuditEvents(ctx, filter, nil)
+		if err != nil {
+			log.WithError(err).Error("error_500: failed to read audit events")
+			return err
+		}
+		for _, ev := range events {
+			if err = enc.Encode(ev); err != nil {
+				return ginext.NewError(http.StatusInternalServerError, err.Error())
+			}
+		}
+		if len(events) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// VerifyChain walks the whole audit log and reports the first event that breaks the hash chain
+func (s *AuditService) VerifyChain(ctx context.Context) (rs model.AuditVerifyResponse, err error) {
+	if err = chainAllAuditEvents(ctx, s.repo); err != nil {
+		return rs, err
+	}
+	var prev *model.AuditEvent
+	filter := model.AuditFilter{PageSize: exportPageSize}
+	for filter.Page = 1; ; filter.Page++ {
+		events, _, err := s.repo.ListAuditEvents(ctx, filter, nil)
+		if err != nil {
+			return rs, err
+		}
+		if err = audit.Verify(prev, events); err != nil {
+			if chainErr, ok := err.(*audit.ChainError); ok {
+				rs.BrokenAt = &chainErr.Seq
+				rs.Reason = chainErr.Reason
+				rs.Checked += chainErr.Index
+				return rs, nil
+			}
+			return rs, err
+		}
+		rs.Checked += len(events)
+		if len(events) < exportPageSize {
+			rs.Valid = true
+			return rs, nil
+		}
+		prev = &events[len(events)-1]
+	}
+}
+
+func auditFilterMetadata(filter model.AuditFilter) map[string]interface{} {
+	md := map[string]interface{}{}
+	if filter.ActorID != nil {
+		md["actor_id"] = filter.ActorID.String()
+	}
+	if filter.SubjectID != nil {
+		md["subject_id"] = filter.SubjectID.String()
+	}
+	if filter.Type != "" {
+		md["type"] = filter.Type
+	}
+	if filter.From != nil {
+		md["from"] = filter.From.UTC().Format(time.RFC3339)
+	}
+	if filter.To != nil {
+		md["to"] = filter.To.UTC().Format(time.RFC3339)
+	}
+	return md
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+)
+
+// tamperedRepo hands the audit events read back through tamper, like rows changed in the database
+type tamperedRepo struct {
+	repo.PGInterface
+	tamper func(events []model.AuditEvent) []model.AuditEvent
+}
+
+func (r tamperedRepo) ListAuditEvents(ctx context.Context, filter model.AuditFilter, tx *gorm.DB) ([]model.AuditEvent, ginext.BodyMeta, error) {
+	events, meta, err := r.PGInterface.ListAuditEvents(ctx, filter, tx)
+	if err != nil || r.tamper == nil {
+		return events, meta, err
+	}
+	return r.tamper(append([]model.AuditEvent(nil), events...)), meta, nil
+}
+
+// newAuditChain records n events and chains them, seq 1 to n
+func newAuditChain(t *testing.T, n int) *tamperedRepo {
+	t.Helper()
+	ctx := context.Background()
+	r := &tamperedRepo{PGInterface: repo.NewMemoryRepo()}
+	for i := 0; i < n; i++ {
+		userID := uuid.New()
+		ev := audit.NewEvent(ctx, model.AuditUserRegistered, &userID, map[string]interface{}{"email": fmt.Sprintf("audit-%d@example.com", i)})
+		if err := RecordAudit(ctx, r, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if chained, err := ChainAuditEvents(ctx, r, n); err != nil || chained != n {
+		t.Fatalf("ChainAuditEvents = %d, %v, want %d", chained, err, n)
+	}
+	return r
+}
+
+func TestVerifyChainPasses(t *testing.T) {
+	r := newAuditChain(t, 5)
+	rs, err := NewAuditService(r).VerifyChain(context.Background())
+	if err != nil || !rs.Valid || rs.Checked != 5 || rs.BrokenAt != nil {
+		t.Errorf("VerifyChain = %+v, %v, want 5 valid events", rs, err)
+	}
+
+	// events recorded later extend the chain
+	userID := uuid.New()
+	if err = RecordAudit(context.Background(), r, audit.NewEvent(context.Background(), model.AuditLoginFailed, &userID, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err = NewAuditService(r).VerifyChain(context.Background()); err != nil || !rs.Valid || rs.Checked != 6 {
+		t.Errorf("VerifyChain after another event = %+v, %v, want 6 valid events", rs, err)
+	}
+}
+
+func TestVerifyChainFindsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		tamper   func(events []model.AuditEvent) []model.AuditEvent
+		brokenAt int64
+		checked  int
+	}{
+		{
+			name: "edited row",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				events[2].Metadata = `{"email":"someone-else@example.com"}`
+				return events
+			},
+			brokenAt: 3, checked: 2,
+		},
+		{
+			name: "edited row with its hash recomputed",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				events[2].Metadata = `{"email":"someone-else@example.com"}`
+				events[2].Hash = audit.Hash(events[2])
+				return events
+			},
+			brokenAt: 4, checked: 3,
+		},
+		{
+			name: "deleted row",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				return append(events[:2], events[3:]...)
+			},
+			brokenAt: 4, checked: 2,
+		},
+		{
+			name: "deleted last row",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				return events[:4]
+			},
+			// the end of a chain can not be told from a truncated one without an anchor
+			brokenAt: 0, checked: 4,
+		},
+		{
+			name: "reordered rows",
+			tamper: func(events []model.AuditEvent) []model.AuditEvent {
+				events[1], events[2] = events[2], events[1]
+				return events
+			},
+			brokenAt: 3, checked: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newAuditChain(t, 5)
+			r.tamper = tc.tamper
+			rs, err := NewAuditService(r).VerifyChain(context.Background())
+			if err != nil {
+				t.Fatalf("VerifyChain: %v", err)
+			}
+			if tc.brokenAt == 0 {
+				if !rs.Valid || rs.Checked != tc.checked {
+					t.Errorf("VerifyChain = %+v, want %d valid events", rs, tc.checked)
+				}
+				return
+			}
+			if rs.Valid || rs.BrokenAt == nil || *rs.BrokenAt != tc.brokenAt || rs.Checked != tc.checked || rs.Reason == "" {
+				t.Errorf("VerifyChain = %+v (broken at %v), want broken at seq %d after %d events", rs, rs.BrokenAt, tc.brokenAt, tc.checked)
+			}
+		})
+	}
+}
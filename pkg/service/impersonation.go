@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+)
+
+// Impersonate issues to the admin an access token of the user, whose act claim names the admin.
+// No refresh token comes with it, admins can not be impersonated, and the reason is audited.
+func (s *UserService) Impersonate(ctx context.Context, adminID, userID uuid.UUID, req model.ImpersonateReq) (rs model.ImpersonationResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Impersonate", tracing.WithAttributes("user.id", userID.String(), "actor.id", adminID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.Impersonate").WithField("user_id", userID).WithField("actor_id", adminID)
+
+	if adminID == userID {
+		log.Error("error_400: an admin impersonating itself")
+		return rs, ginext.NewError(http.StatusBadRequest, "Admins can not impersonate themselves")
+	}
+	user, err := s.repo.GetOneUserByID(ctx, userID, nil)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Error("error_404: unknown user")
+			return rs, ginext.NewError(http.StatusNotFound, "Unknown user")
+		}
+		return rs, err
+	}
+	if user.AccountType == model.AccountTypeAdmin {
+		log.Error("error_403: admins can not be impersonated")
+		return rs, ginext.NewError(http.StatusForbidden, "Admins can not be impersonated")
+	}
+
+	now := time.Now()
+	rs.ID = uuid.NewString()
+	rs.UserID = userID
+	rs.ActorID = adminID
+	rs.ExpiresAt = now.Add(time.Duration(conf.LoadEnv().ImpersonationTTLMinutes) * time.Minute).Truncate(time.Second)
+	claims := model.AccessTokenClaims{
+		DeviceID: model.ImpersonationDeviceID,
+		Act:      &model.TokenActor{Subject: adminID.String()},
+		StandardClaims: jwt.StandardClaims{
+			Id:        rs.ID,
+			Subject:   userID.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: rs.ExpiresAt.Unix(),
+		},
+	}
+	if rs.Token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(conf.LoadEnv().JWTSecret)); err != nil {
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+
+	// the token is only handed out once its issue is in the audit log
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditImpersonationStarted, &userID, map[string]interface{}{
+			"impersonation_id": rs.ID, "reason": req.Reason, "expires_at": rs.ExpiresAt,
+		}))
+	})
+	if err != nil {
+		return model.ImpersonationResponse{}, err
+	}
+	metrics.TokensIssued.Inc(metrics.TokenAccess)
+	log.WithField("impersonation_id", rs.ID).Info("impersonation started")
+	return rs, nil
+}
+
+// checkImpersonator refuses an impersonation token once its admin is deleted or no longer an admin
+func (s *UserService) checkImpersonator(ctx context.Context, claims *model.AccessTokenClaims) error {
+	actorID, ok := claims.Impersonator()
+	if !ok {
+		return errors.New("invalid act claim")
+	}
+	admin, err := s.repo.GetOneUserByID(ctx, actorID, nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && admin.AccountType != model.AccountTypeAdmin) {
+		return errors.New("the impersonating admin is no longer an admin")
+	}
+	return err
+}
+
+// RecordImpersonatedRequest audits a request made with an impersonation token, the admin is its actor;
+// the impersonation of ctx tags the event
+func (s *UserService) RecordImpersonatedRequest(ctx context.Context, userID uuid.UUID, method, route string, status int) {
+	imp, ok := audit.ImpersonationFromContext(ctx)
+	if !ok {
+		return
+	}
+	ev := audit.NewEvent(ctx, model.AuditImpersonatedRequest, &userID, map[string]interface{}{
+		"method": method, "route": route, "status": status,
+	})
+	ev.ActorID = &imp.ActorID
+	recordAuditAlone(ctx, s.repo, ev)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+)
+
+// demotedRepo answers the users of demoted without their admin rights, the memory repo can not change them
+type demotedRepo struct {
+	repo.PGInterface
+	demoted map[uuid.UUID]bool
+}
+
+func (r demotedRepo) GetOneUserByID(ctx context.Context, ID uuid.UUID, tx *gorm.DB) (model.User, error) {
+	user, err := r.PGInterface.GetOneUserByID(ctx, ID, tx)
+	if r.demoted[ID] {
+		user.AccountType = ""
+	}
+	return user, err
+}
+
+func TestImpersonate(t *testing.T) {
+	ctx := context.Background()
+	r := demotedRepo{PGInterface: repo.NewMemoryRepo(), demoted: map[uuid.UUID]bool{}}
+	s := NewUserService(r, nil, nil).(*UserService)
+	admin := model.User{Email: "impersonation-admin@example.com", Password: "hashed", AccountType: model.AccountTypeAdmin}
+	otherAdmin := model.User{Email: "impersonation-other-admin@example.com", Password: "hashed", AccountType: model.AccountTypeAdmin}
+	user := model.User{Email: "impersonation-user@example.com", Password: "hashed"}
+	for _, u := range []*model.User{&admin, &otherAdmin, &user} {
+		if err := r.CreateUser(ctx, u, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := model.ImpersonateReq{Reason: "ticket 42"}
+
+	for _, tc := range []struct {
+		name   string
+		userID uuid.UUID
+		status int
+	}{
+		{"oneself", admin.ID, http.StatusBadRequest},
+		{"an unknown user", uuid.New(), http.StatusNotFound},
+		{"another admin", otherAdmin.ID, http.StatusForbidden},
+	} {
+		if _, err := s.Impersonate(ctx, admin.ID, tc.userID, req); errStatus(err) != tc.status {
+			t.Errorf("impersonate %s status = %d (%v), want %d", tc.name, errStatus(err), err, tc.status)
+		}
+	}
+
+	imp, err := s.Impersonate(ctx, admin.ID, user.ID, req)
+	if err != nil || imp.Token == "" || imp.UserID != user.ID || imp.ActorID != admin.ID {
+		t.Fatalf("Impersonate = %+v, %v", imp, err)
+	}
+	claims, userID, err := s.AuthenticateAccessToken(ctx, imp.Token)
+	if err != nil || userID != user.ID || claims.DeviceID != model.ImpersonationDeviceID || claims.Id != imp.ID {
+		t.Fatalf("AuthenticateAccessToken = %+v, %s, %v, want the user", claims, userID, err)
+	}
+	if actorID, ok := claims.Impersonator(); !ok || actorID != admin.ID {
+		t.Errorf("Impersonator = %s, %v, want the admin", actorID, ok)
+	}
+
+	// no refresh token comes with it, and the access token is not one
+	if _, err = s.RefreshToken(ctx, model.RefreshTokenReq{RefreshToken: &imp.Token}); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("refresh with the impersonation token status = %d (%v), want 401", errStatus(err), err)
+	}
+
+	// the issue is audited with its reason
+	pending, err := r.GetPendingAuditEvents(ctx, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started []model.PendingAuditEvent
+	for _, ev := range pending {
+		if ev.Type == model.AuditImpersonationStarted {
+			started = append(started, ev)
+		}
+	}
+	var metadata map[string]interface{}
+	if len(started) != 1 || json.Unmarshal([]byte(started[0].Metadata), &metadata) != nil ||
+		started[0].SubjectID == nil || *started[0].SubjectID != user.ID || metadata["impersonation_id"] != imp.ID || metadata["reason"] != req.Reason {
+		t.Errorf("audit of the impersonation: %+v", started)
+	}
+
+	// the token dies with the admin rights of its admin
+	r.demoted[admin.ID] = true
+	if _, _, err = s.AuthenticateAccessToken(ctx, imp.Token); err == nil {
+		t.Error("the impersonation token of a former admin is accepted")
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"gitlab.com/goxp/cloud0/ginext"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/servicetoken"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+type InternalAuthService struct {
+	repo     repo.PGInterface
+	services map[string]conf.InternalService
+}
+
+func NewInternalAuthService(repo repo.PGInterface, services []conf.InternalService) InternalAuthInterface {
+	s := &InternalAuthService{repo: repo, services: map[string]conf.InternalService{}}
+	for _, svc := range services {
+		s.services[svc.Name] = svc
+	}
+	return s
+}
+
+// InternalAuthInterface authenticates the services calling the /internal routes
+type InternalAuthInterface interface {
+	Authenticate(ctx context.Context, token, endpoint string) (service string, err error)
+}
+
+// Authenticate returns the service which signed token when it may call endpoint, the method and route path
+// like "POST /internal/migrate". Rejected calls are audited, with the service the token claims if any.
+func (s *InternalAuthService) Authenticate(ctx context.Context, token, endpoint string) (service string, err error) {
+	ctx, span := tracing.Start(ctx, "InternalAuthService.Authenticate", tracing.WithAttributes("endpoint", endpoint))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "InternalAuthService.Authenticate").WithField("endpoint", endpoint)
+
+	reject := func(code int, reason string, cause error) error {
+		log.WithError(cause).WithField("service", service).WithField("reason", reason).Errorf("error_%d: internal call rejected", code)
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditInternalCallRejected, nil, map[string]interface{}{
+			"endpoint": endpoint, "service": service, "reason": reason,
+		}))
+		return ginext.NewError(code, utils.MessageError()[code])
+	}
+
+	if token == "" {
+		return "", reject(http.StatusUnauthorized, "missing_token", nil)
+	}
+	service, err = servicetoken.Parse(token, func(name string) (string, bool) {
+		svc, ok := s.services[name]
+		return svc.Secret, ok
+	})
+	if err != nil {
+		return service, reject(http.StatusUnauthorized, "invalid_token", err)
+	}
+	span.SetAttributes(tracing.Attributes("service.name", service)...)
+	if !s.services[service].Allows(endpoint) {
+		return service, reject(http.StatusForbidden, "endpoint_not_allowed", nil)
+	}
+	return service, nil
+}
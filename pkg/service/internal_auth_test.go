@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/servicetoken"
+)
+
+var (
+	internalFull   = conf.InternalService{Name: "ms-order", Secret: "0123456789abcdef0123456789abcdef", Endpoints: []string{"POST /internal/migrate", "POST /internal/users/batch-get"}}
+	internalLookup = conf.InternalService{Name: "ms-mail", Secret: "fedcba9876543210fedcba9876543210", Endpoints: []string{"POST /internal/users/batch-get"}}
+)
+
+func signService(t *testing.T, service, secret string) string {
+	t.Helper()
+	token, err := servicetoken.Sign(service, secret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInternalAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	r := repo.NewMemoryRepo()
+	s := NewInternalAuthService(r, []conf.InternalService{internalFull, internalLookup})
+
+	// a token of the right service and secret, meant for another audience
+	now := time.Now()
+	otherAudience, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Audience: "ms-order", Issuer: internalFull.Name, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix(),
+	}).SignedString([]byte(internalFull.Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewUserService(r, nil, nil).(*UserService)
+	signUpPassword(t, users, "internal@example.com")
+	session, err := loginPassword(users, "internal@example.com")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, token, endpoint string
+		status                int
+		reason                string
+	}{
+		{"allowed", signService(t, internalFull.Name, internalFull.Secret), "POST /internal/migrate", http.StatusOK, ""},
+		{"allowed on its routes only", signService(t, internalLookup.Name, internalLookup.Secret), "POST /internal/users/batch-get", http.StatusOK, ""},
+		{"without token", "", "POST /internal/users/batch-get", http.StatusUnauthorized, "missing_token"},
+		{"with garbage", "not-a-token", "POST /internal/users/batch-get", http.StatusUnauthorized, "invalid_token"},
+		{"with an access token", session.Token, "POST /internal/users/batch-get", http.StatusUnauthorized, "invalid_token"},
+		{"with the secret of another service", signService(t, internalFull.Name, internalLookup.Secret), "POST /internal/users/batch-get", http.StatusUnauthorized, "invalid_token"},
+		{"of an unknown service", signService(t, "ms-unknown", internalFull.Secret), "POST /internal/users/batch-get", http.StatusUnauthorized, "invalid_token"},
+		{"with a token of another audience", otherAudience, "POST /internal/users/batch-get", http.StatusUnauthorized, "invalid_token"},
+		{"on a route the service is not allowed on", signService(t, internalLookup.Name, internalLookup.Secret), "POST /internal/migrate", http.StatusForbidden, "endpoint_not_allowed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before, err := r.GetPendingAuditEvents(ctx, 1000, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			service, err := s.Authenticate(ctx, tc.token, tc.endpoint)
+			if tc.status == http.StatusOK {
+				if err != nil {
+					t.Fatalf("Authenticate: %v", err)
+				}
+				if service == "" {
+					t.Error("Authenticate returns no service")
+				}
+				return
+			}
+			if errStatus(err) != tc.status {
+				t.Fatalf("Authenticate status = %d (%v), want %d", errStatus(err), err, tc.status)
+			}
+
+			// every refused call is audited with its reason
+			after, err := r.GetPendingAuditEvents(ctx, 1000, nil)
+			if err != nil || len(after) != len(before)+1 {
+				t.Fatalf("%d audit events after the call, want %d: %v", len(after), len(before)+1, err)
+			}
+			ev := after[len(after)-1]
+			var metadata map[string]interface{}
+			_ = json.Unmarshal([]byte(ev.Metadata), &metadata)
+			if ev.Type != model.AuditInternalCallRejected || metadata["reason"] != tc.reason || metadata["endpoint"] != tc.endpoint {
+				t.Errorf("audit event %s %s, want %s with reason %s", ev.Type, ev.Metadata, model.AuditInternalCallRejected, tc.reason)
+			}
+		})
+	}
+}
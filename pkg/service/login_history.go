@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLoginHistoryLimit = 20
+	maxLoginHistoryLimit     = 100
+)
+
+// GetLoginHistory returns the recent sign-ins of userID, most recent first
+func (s *UserService) GetLoginHistory(ctx context.Context, userID uuid.UUID, req model.LoginHistoryRequest) ([]model.LoginHistory, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLoginHistoryLimit
+	}
+	if limit > maxLoginHistoryLimit {
+		limit = maxLoginHistoryLimit
+	}
+	return s.repo.ListLoginHistory(ctx, userID, limit, nil)
+}
+
+// newLoginHistory describes the sign-in of userID from the request in ctx
+func newLoginHistory(ctx context.Context, userID uuid.UUID, deviceID string, success bool, reason string) model.LoginHistory {
+	info := audit.RequestInfoFromContext(ctx)
+	browser, os := utils.ParseUserAgent(info.UserAgent)
+	return model.LoginHistory{
+		ID:          uuid.New(),
+		UserID:      userID,
+		OccurredAt:  time.Now(),
+		IP:          info.IP,
+		UserAgent:   info.UserAgent,
+		Browser:     browser,
+		OS:          os,
+		DeviceID:    deviceID,
+		Fingerprint: deviceFingerprint(deviceID, browser, os),
+		Success:     success,
+		Reason:      reason,
+	}
+}
+
+// deviceFingerprint identifies a device by the id sent by the client,
+// or by its browser and OS when the client does not send one
+func deviceFingerprint(deviceID, browser, os string) string {
+	src := "device:" + deviceID
+	if deviceID == "" {
+		src = "ua:" + browser + "|" + os
+	}
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordSuccessfulLogin stores h through rp and queues a notification to user
+// when the account already signed in before but never from this device, and has an email
+func recordSuccessfulLogin(ctx context.Context, rp repo.PGInterface, user model.User, h model.LoginHistory) error {
+	known, err := rp.CountSuccessfulLogins(ctx, user.ID, "", nil)
+	if err != nil {
+		return err
+	}
+	fromDevice, err := rp.CountSuccessfulLogins(ctx, user.ID, h.Fingerprint, nil)
+	if err != nil {
+		return err
+	}
+	if err = rp.CreateLoginHistory(ctx, &h, nil); err != nil {
+		return err
+	}
+	if known == 0 || fromDevice > 0 || user.Email == "" {
+		return nil
+	}
+	return enqueueEmail(ctx, rp, newDeviceEmail(user, h))
+}
+
+// recordFailedLogin stores h on its own, the failed login has no transaction to join
+func recordFailedLogin(ctx context.Context, r repo.PGInterface, h model.LoginHistory) {
+	if err := r.CreateLoginHistory(ctx, &h, nil); err != nil {
+		tracing.WithCtx(ctx, "service.recordFailedLogin").WithError(err).Error("failed to record login history")
+	}
+}
+
+func newDeviceEmail(user model.User, h model.LoginHistory) model.EmailPayload {
+	device := h.Browser + " on " + h.OS
+	if h.DeviceID != "" {
+		device += " (device " + h.DeviceID + ")"
+	}
+	return model.EmailPayload{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Your account was signed in from a new device.\n\n"+
+			"Time: %s\nDevice: %s\nIP address: %s\n\n"+
+			"If this was not you, change your password now.",
+			h.OccurredAt.UTC().Format(time.RFC1123), device, h.IP),
+	}
+}
+
+// enqueueEmail adds an email to the outbox through rp, the outbox worker sends it after commit
+func enqueueEmail(ctx context.Context, rp repo.PGInterface, email model.EmailPayload) error {
+	payload, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return rp.CreateOutboxMessage(ctx, &model.OutboxMessage{
+		ID:            uuid.New(),
+		Kind:          model.OutboxKindEmail,
+		Payload:       string(payload),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}, nil)
+}
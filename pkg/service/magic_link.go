@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+	"ms-user/pkg/valid"
+)
+
+// magicLinkConsumePath is the route the emailed links point to
+const magicLinkConsumePath = "/api/v1/user/login/magic-link/consume"
+
+const invalidLinkMessage = "The link is invalid or expired"
+
+// RequestMagicLink emails a single-use sign-in link when the email has an account.
+// nonce must be kept by the browser, e.g. in a cookie, and given back to ConsumeMagicLink;
+// it is returned for unknown emails too so the answer does not tell whether the account exists.
+func (s *UserService) RequestMagicLink(ctx context.Context, req model.MagicLinkReq) (rs model.MagicLinkResponse, nonce string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RequestMagicLink")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.RequestMagicLink")
+	cfg := conf.LoadEnv()
+
+	email := model.NormalizeEmail(valid.String(req.Email))
+	if ok := utils.ValidateEmail(email); !ok {
+		log.Error("error_400: Email invalid")
+		return rs, "", ginext.NewError(http.StatusBadRequest, "Email invalid")
+	}
+
+	if nonce, err = randomToken(); err != nil {
+		log.WithError(err).Error("error_500: cannot generate the nonce")
+		return rs, "", ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	now := time.Now()
+	rs.ExpiresAt = now.Add(time.Duration(cfg.MagicLinkTTLSeconds) * time.Second)
+
+	user, err := s.repo.GetOneUserByEmail(ctx, email, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Info("magic link requested for an unknown email")
+		return rs, nonce, nil
+	}
+	if err != nil {
+		return rs, "", err
+	}
+	count, err := s.repo.CountMagicLinksSince(ctx, user.ID, now.Add(-time.Hour), nil)
+	if err != nil {
+		return rs, "", err
+	}
+	if count >= int64(cfg.MagicLinkMaxPerHour) {
+		// answered like a sent link, a 429 would tell that the account exists
+		log.Warn("too many magic links requested, none sent")
+		return rs, nonce, nil
+	}
+
+	link := model.MagicLink{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		NonceHash: hashNonce(nonce),
+		CreatedAt: now,
+		ExpiresAt: rs.ExpiresAt,
+	}
+	// the link is useless without the nonce, so it can wait in the outbox like the other emails
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateMagicLink(ctx, &link, nil); err != nil {
+			return err
+		}
+		return enqueueEmail(ctx, rp, magicLinkEmail(user, magicLinkURL(cfg.PublicBaseURL, link.ID), cfg.MagicLinkTTLSeconds))
+	})
+	if err != nil {
+		return rs, "", err
+	}
+	return rs, nonce, nil
+}
+
+// ConsumeMagicLink signs in with a link of RequestMagicLink, nonce is the one returned with the link
+func (s *UserService) ConsumeMagicLink(ctx context.Context, req model.ConsumeMagicLinkReq, nonce string) (rs model.ConfirmLoginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ConsumeMagicLink")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.ConsumeMagicLink")
+	deviceID := strings.TrimSpace(req.DeviceID)
+
+	reject := func(userID *uuid.UUID, reason, message string) error {
+		log.WithField("reason", reason).Error("error_401: magic link rejected")
+		metrics.Logins.Inc(reason)
+		if userID != nil {
+			recordFailedLogin(ctx, s.repo, newLoginHistory(ctx, *userID, deviceID, false, reason))
+		}
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, userID, map[string]interface{}{
+			"method": "magic_link", "reason": reason,
+		}))
+		return ginext.NewError(http.StatusUnauthorized, message)
+	}
+
+	id, ok := parseMagicLinkToken(req.Token)
+	if !ok {
+		return rs, reject(nil, metrics.LoginBadLink, invalidLinkMessage)
+	}
+	link, err := s.repo.GetMagicLink(ctx, id, nil)
+	if err == gorm.ErrRecordNotFound {
+		return rs, reject(nil, metrics.LoginBadLink, invalidLinkMessage)
+	}
+	if err != nil {
+		return rs, err
+	}
+	span.SetAttributes(tracing.Attributes("user.id", link.UserID.String())...)
+
+	now := time.Now()
+	if link.ConsumedAt != nil || !now.Before(link.ExpiresAt) {
+		return rs, reject(&link.UserID, metrics.LoginExpiredLink, invalidLinkMessage)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(hashNonce(nonce)), []byte(link.NonceHash)) != 1 {
+		return rs, reject(&link.UserID, metrics.LoginOtherBrowser, "Open the link in the browser it was requested from")
+	}
+	if ok, err = s.repo.ConsumeMagicLink(ctx, link.ID, now, nil); err != nil {
+		return rs, err
+	}
+	if !ok {
+		return rs, reject(&link.UserID, metrics.LoginExpiredLink, invalidLinkMessage)
+	}
+
+	user, err := s.repo.GetOneUserByID(ctx, link.UserID, nil)
+	if err != nil {
+		return rs, err
+	}
+	return s.signIn(ctx, user, deviceID, map[string]interface{}{"email": model.NormalizeEmail(user.Email), "method": "magic_link"})
+}
+
+// magicLinkToken is the link ID and its signature, base64url encoded and joined by a dot
+func magicLinkToken(id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:]) + "." + base64.RawURLEncoding.EncodeToString(signMagicLink(id))
+}
+
+func parseMagicLinkToken(token string) (uuid.UUID, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return uuid.Nil, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return uuid.Nil, false
+	}
+	id, err := uuid.FromBytes(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signMagicLink(id)) {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func signMagicLink(id uuid.UUID) []byte {
+	mac := hmac.New(sha256.New, []byte(conf.LoadEnv().JWTSecret))
+	mac.Write([]byte("magic-link:" + id.String()))
+	return mac.Sum(nil)
+}
+
+func magicLinkURL(baseURL string, id uuid.UUID) string {
+	return strings.TrimRight(baseURL, "/") + magicLinkConsumePath + "?token=" + url.QueryEscape(magicLinkToken(id))
+}
+
+func magicLinkEmail(user model.User, link string, ttlSeconds int) model.EmailPayload {
+	return model.EmailPayload{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Open this link to sign in:\n\n%s\n\n"+
+			"It works once, for %d minutes, in the browser where you asked for it.\n\n"+
+			"If you did not ask to sign in, ignore this email.",
+			link, (ttlSeconds+59)/60),
+	}
+}
+
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
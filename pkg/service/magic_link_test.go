@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+)
+
+// newMagicLinkTestService has an account for email, env sets the magic link settings of the test
+func newMagicLinkTestService(t *testing.T, email string, env map[string]string) *UserService {
+	t.Helper()
+	t.Cleanup(func() { _ = conf.SetEnv() })
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	if err := conf.SetEnv(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewUserService(repo.NewMemoryRepo(), nil, nil).(*UserService)
+	signUpPassword(t, s, email)
+	return s
+}
+
+var magicLinkPattern = regexp.MustCompile(`https?://\S+` + regexp.QuoteMeta(magicLinkConsumePath) + `\?token=\S+`)
+
+// sentMagicLinks returns the tokens of the links in the outbox, the oldest first
+func sentMagicLinks(t *testing.T, s *UserService) []string {
+	t.Helper()
+	messages, err := s.repo.GetDueOutboxMessages(context.Background(), time.Now().Add(time.Hour), 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	for _, m := range messages {
+		var email model.EmailPayload
+		if err = json.Unmarshal([]byte(m.Payload), &email); err != nil {
+			t.Fatal(err)
+		}
+		link := magicLinkPattern.FindString(email.Body)
+		if link == "" {
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, u.Query().Get("token"))
+	}
+	return tokens
+}
+
+func requestMagicLink(t *testing.T, s *UserService, email string) (model.MagicLinkResponse, string) {
+	t.Helper()
+	rs, nonce, err := s.RequestMagicLink(context.Background(), model.MagicLinkReq{Email: &email})
+	if err != nil || nonce == "" {
+		t.Fatalf("RequestMagicLink %s = %+v, %q, %v", email, rs, nonce, err)
+	}
+	return rs, nonce
+}
+
+func consumeMagicLink(s *UserService, token, nonce string) (model.ConfirmLoginResponse, error) {
+	return s.ConsumeMagicLink(context.Background(), model.ConsumeMagicLinkReq{Token: token, DeviceID: "magic-link-test"}, nonce)
+}
+
+func TestMagicLinkSignsInOnce(t *testing.T) {
+	s := newMagicLinkTestService(t, "magic@example.com", nil)
+	_, nonce := requestMagicLink(t, s, "magic@example.com")
+	tokens := sentMagicLinks(t, s)
+	if len(tokens) != 1 {
+		t.Fatalf("%d links sent, want 1", len(tokens))
+	}
+
+	session, err := consumeMagicLink(s, tokens[0], nonce)
+	if err != nil || session.Token == "" {
+		t.Fatalf("consume = %+v, %v", session, err)
+	}
+	if _, err = consumeMagicLink(s, tokens[0], nonce); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("consume again status = %d (%v), want 401", errStatus(err), err)
+	}
+}
+
+func TestMagicLinkHidesTheAccounts(t *testing.T) {
+	s := newMagicLinkTestService(t, "magic@example.com", map[string]string{"MAGIC_LINK_MAX_PER_HOUR": "1"})
+
+	// an unknown email gets the answer of a known one, and no link
+	known, _ := requestMagicLink(t, s, "magic@example.com")
+	unknown, _ := requestMagicLink(t, s, "nobody@example.com")
+	if unknown.ExpiresAt.Sub(known.ExpiresAt) > time.Second || unknown.ExpiresAt.Before(known.ExpiresAt) {
+		t.Errorf("answer for an unknown email = %+v, want %+v", unknown, known)
+	}
+	// over the hourly cap the answer is the same, no link is sent
+	capped, _ := requestMagicLink(t, s, "magic@example.com")
+	if capped.ExpiresAt.Before(known.ExpiresAt) {
+		t.Errorf("answer over the cap = %+v, want %+v", capped, known)
+	}
+	if tokens := sentMagicLinks(t, s); len(tokens) != 1 {
+		t.Errorf("%d links sent, want the first one only", len(tokens))
+	}
+}
+
+func TestMagicLinkRejects(t *testing.T) {
+	ctx := context.Background()
+	s := newMagicLinkTestService(t, "magic@example.com", nil)
+	_, nonce := requestMagicLink(t, s, "magic@example.com")
+	token := sentMagicLinks(t, s)[0]
+	_, otherNonce := requestMagicLink(t, s, "nobody@example.com")
+
+	// the first character of the signature is changed, the last one may only hold padding bits
+	parts := strings.SplitN(token, ".", 2)
+	flipped := "A"
+	if parts[1][0] == 'A' {
+		flipped = "B"
+	}
+	tampered := parts[0] + "." + flipped + parts[1][1:]
+	for _, tc := range []struct {
+		name, token, nonce string
+		message            string
+	}{
+		{"tampered signature", tampered, nonce, invalidLinkMessage},
+		{"no signature", parts[0], nonce, invalidLinkMessage},
+		{"missing nonce", token, "", "Open the link in the browser it was requested from"},
+		{"nonce of another request", token, otherNonce, "Open the link in the browser it was requested from"},
+	} {
+		_, err := consumeMagicLink(s, tc.token, tc.nonce)
+		if errStatus(err) != http.StatusUnauthorized || err.Error() != tc.message {
+			t.Errorf("consume with %s = %d (%v), want 401 %q", tc.name, errStatus(err), err, tc.message)
+		}
+	}
+
+	// the refused attempts did not use the link up, it expires though
+	id, ok := parseMagicLinkToken(token)
+	if !ok {
+		t.Fatal("the sent token does not parse")
+	}
+	link, err := s.repo.GetMagicLink(ctx, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.ExpiresAt = time.Now().Add(-time.Second)
+	if err = s.repo.CreateMagicLink(ctx, &link, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = consumeMagicLink(s, token, nonce); errStatus(err) != http.StatusUnauthorized || err.Error() != invalidLinkMessage {
+		t.Errorf("consume an expired link = %d (%v), want 401 %q", errStatus(err), err, invalidLinkMessage)
+	}
+}
@@ -0,0 +1,796 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/oidc"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/utils"
+)
+
+// Routes of the authorization server, below PUBLIC_BASE_URL which is its issuer
+const (
+	OAuthAuthorizePath = "/oauth/authorize"
+	OAuthTokenPath     = "/oauth/token"
+	OAuthUserInfoPath  = "/oauth/userinfo"
+	OAuthJWKSPath      = "/.well-known/jwks.json"
+)
+
+// maxNonceLength bounds the nonce of an authorization request, it is stored with the code
+const maxNonceLength = 255
+
+var (
+	errInvalidClient = oauth.NewError(http.StatusUnauthorized, oauth.ErrInvalidClient, "Client authentication failed")
+	errInvalidGrant  = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidGrant, "The grant is invalid, expired or revoked")
+	errInvalidToken  = oauth.NewError(http.StatusUnauthorized, oauth.ErrInvalidToken, "The access token is invalid, expired or revoked")
+)
+
+type OAuthService struct {
+	repo   repo.PGInterface
+	signer *oauth.Signer
+}
+
+func NewOAuthService(repo repo.PGInterface, signer *oauth.Signer) OAuthInterface {
+	return &OAuthService{repo: repo, signer: signer}
+}
+
+type OAuthInterface interface {
+	Discovery() oauth.Discovery
+	JWKS() oidc.JSONWebKeySet
+	Authorize(ctx context.Context, req model.OAuthAuthorizeReq) (redirectTo string, err error)
+	AuthorizeInfo(ctx context.Context, userID uuid.UUID, req model.OAuthAuthorizeReq) (rs model.OAuthAuthorizeInfo, err error)
+	Decide(ctx context.Context, userID uuid.UUID, req model.OAuthDecisionReq) (rs model.OAuthDecisionResponse, err error)
+	Token(ctx context.Context, req model.OAuthTokenReq) (rs model.OAuthTokenResponse, err error)
+	UserInfo(ctx context.Context, accessToken string) (rs model.OAuthUserInfo, err error)
+	CreateClient(ctx context.Context, req model.CreateOAuthClientReq) (rs model.CreateOAuthClientResponse, err error)
+	ListClients(ctx context.Context) ([]model.OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	ListConsents(ctx context.Context, userID uuid.UUID) ([]model.OAuthConsent, error)
+	RevokeConsent(ctx context.Context, userID uuid.UUID, clientID string) error
+}
+
+// OAuthIssuer is the issuer of the ID tokens, PUBLIC_BASE_URL
+func OAuthIssuer() string {
+	return strings.TrimRight(conf.LoadEnv().PublicBaseURL, "/")
+}
+
+func (s *OAuthService) Discovery() oauth.Discovery {
+	issuer := OAuthIssuer()
+	return oauth.Discovery{
+		Metadata: oidc.Metadata{
+			Issuer:                            issuer,
+			AuthorizationEndpoint:             issuer + OAuthAuthorizePath,
+			TokenEndpoint:                     issuer + OAuthTokenPath,
+			JWKSURI:                           issuer + OAuthJWKSPath,
+			UserinfoEndpoint:                  issuer + OAuthUserInfoPath,
+			CodeChallengeMethodsSupported:     []string{"S256"},
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+			IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		},
+		ResponseTypesSupported: []string{"code"},
+		SubjectTypesSupported:  []string{"public"},
+		GrantTypesSupported:    []string{model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials},
+		ScopesSupported:        oauth.SupportedScopes,
+		ClaimsSupported:        []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "preferred_username", "profile", "email"},
+	}
+}
+
+func (s *OAuthService) JWKS() oidc.JSONWebKeySet {
+	return s.signer.JWKS()
+}
+
+// Authorize checks an authorization request and returns where to send the browser: to the consent page
+// with the request, or back to the client with an error. A request naming an unknown client or redirect URI
+// can not be sent back and fails with a 400.
+func (s *OAuthService) Authorize(ctx context.Context, req model.OAuthAuthorizeReq) (redirectTo string, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.Authorize")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	_, _, err = s.validateAuthorization(ctx, req)
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return authorizationRedirect(req, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}}), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return withQuery(conf.LoadEnv().OAuthConsentURL, authorizeQuery(req)), nil
+}
+
+// AuthorizeInfo tells the consent page what the client asks for, and whether the user already granted it
+func (s *OAuthService) AuthorizeInfo(ctx context.Context, userID uuid.UUID, req model.OAuthAuthorizeReq) (rs model.OAuthAuthorizeInfo, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.AuthorizeInfo")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	client, scope, err := s.validateAuthorization(ctx, req)
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return rs, ginext.NewError(http.StatusBadRequest, oauthErr.Error())
+	}
+	if err != nil {
+		return rs, err
+	}
+	rs = model.OAuthAuthorizeInfo{ClientID: client.ClientID, ClientName: client.Name, Scope: scope}
+	consent, err := s.repo.GetOAuthConsent(ctx, userID, client.ClientID, nil)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return rs, err
+	}
+	rs.Consented = err == nil && oauth.Subset(scope, consent.Scope)
+	return rs, nil
+}
+
+// Decide records the answer of the user to an authorization request. An approval stores the consent
+// and gives a code to the client, a denial sends access_denied back.
+func (s *OAuthService) Decide(ctx context.Context, userID uuid.UUID, req model.OAuthDecisionReq) (rs model.OAuthDecisionResponse, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.Decide", tracing.WithAttributes("user.id", userID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.Decide").WithField("client_id", req.ClientID)
+
+	client, scope, err := s.validateAuthorization(ctx, req.OAuthAuthorizeReq)
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		rs.RedirectTo = authorizationRedirect(req.OAuthAuthorizeReq, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}})
+		return rs, nil
+	}
+	if err != nil {
+		return rs, err
+	}
+	if !req.Approve {
+		log.Info("the user denied the authorization")
+		rs.RedirectTo = authorizationRedirect(req.OAuthAuthorizeReq, url.Values{"error": {oauth.ErrAccessDenied}})
+		return rs, nil
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		log.WithError(err).Error("error_500: cannot generate the code")
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	now := time.Now()
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		consent, err := rp.GetOAuthConsent(ctx, userID, client.ClientID, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		// a consent grows with the scopes granted later, it is revoked as a whole
+		if err == gorm.ErrRecordNotFound || !oauth.Subset(scope, consent.Scope) {
+			granted := append(model.SpaceList{}, consent.Scope...)
+			for _, sc := range scope {
+				if !granted.Contains(sc) {
+					granted = append(granted, sc)
+				}
+			}
+			err = rp.SaveOAuthConsent(ctx, &model.OAuthConsent{
+				UserID: userID, ClientID: client.ClientID, Scope: granted, CreatedAt: now, UpdatedAt: now,
+			}, nil)
+			if err != nil {
+				return err
+			}
+			err = RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditOAuthConsentGranted, &userID, map[string]interface{}{
+				"client_id": client.ClientID, "scope": granted.String(),
+			}))
+			if err != nil {
+				return err
+			}
+		}
+		return rp.CreateOAuthAuthorizationCode(ctx, &model.OAuthAuthorizationCode{
+			ID:            uuid.New(),
+			CodeHash:      hashNonce(code),
+			ClientID:      client.ClientID,
+			UserID:        userID,
+			RedirectURI:   req.RedirectURI,
+			Scope:         scope,
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			CreatedAt:     now,
+			ExpiresAt:     now.Add(time.Duration(conf.LoadEnv().OAuthCodeTTLSeconds) * time.Second),
+		}, nil)
+	})
+	if err != nil {
+		return rs, err
+	}
+	rs.RedirectTo = authorizationRedirect(req.OAuthAuthorizeReq, url.Values{"code": {code}})
+	return rs, nil
+}
+
+// validateAuthorization returns the client and scopes of req. Errors about the client or the redirect URI
+// are ginext errors, the others are *oauth.Error to be sent back to the redirect URI.
+func (s *OAuthService) validateAuthorization(ctx context.Context, req model.OAuthAuthorizeReq) (client model.OAuthClient, scope model.SpaceList, err error) {
+	log := tracing.WithCtx(ctx, "OAuthService.validateAuthorization").WithField("client_id", req.ClientID)
+
+	client, err = s.repo.GetOAuthClient(ctx, req.ClientID, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_400: unknown client")
+		return client, nil, ginext.NewError(http.StatusBadRequest, "Unknown client_id")
+	}
+	if err != nil {
+		return client, nil, err
+	}
+	if !client.RedirectURIs.Contains(req.RedirectURI) {
+		log.WithField("redirect_uri", req.RedirectURI).Error("error_400: redirect_uri not registered")
+		return client, nil, ginext.NewError(http.StatusBadRequest, "redirect_uri is not registered for this client")
+	}
+
+	scope = oauth.ParseScope(req.Scope)
+	switch {
+	case req.ResponseType != "code":
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrUnsupportedResponseType, "Only the code response type is supported")
+	case !client.GrantTypes.Contains(model.GrantAuthorizationCode):
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrUnauthorizedClient, "The client may not use the authorization code grant")
+	case len(scope) == 0:
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidScope, "scope is required")
+	case !oauth.Subset(scope, client.Scopes):
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidScope, "The client may only ask for "+client.Scopes.String())
+	case req.CodeChallenge == "" && client.Public:
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "Public clients must use PKCE")
+	case req.CodeChallenge != "" && req.CodeChallengeMethod != "S256":
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "code_challenge_method must be S256")
+	case len(req.CodeChallenge) > 128:
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "code_challenge is too long")
+	case len(req.Nonce) > maxNonceLength:
+		err = oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidRequest, "nonce is too long")
+	}
+	if err != nil {
+		log.WithError(err).Error("error_400: invalid authorization request")
+		return client, nil, err
+	}
+	return client, scope, nil
+}
+
+// Token answers the token endpoint. Failures are *oauth.Error, or ginext errors for a failing database.
+func (s *OAuthService) Token(ctx context.Context, req model.OAuthTokenReq) (rs model.OAuthTokenResponse, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.Token", tracing.WithAttributes("grant_type", req.GrantType))
+	grant := req.GrantType
+	if grant != model.GrantAuthorizationCode && grant != model.GrantRefreshToken && grant != model.GrantClientCredentials {
+		grant = "other"
+	}
+	defer func() {
+		outcome := "ok"
+		var oauthErr *oauth.Error
+		if errors.As(err, &oauthErr) {
+			outcome = oauthErr.Code
+		} else if err != nil {
+			outcome = oauth.ErrServerError
+		}
+		metrics.OAuthTokenRequests.Inc(grant, outcome)
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.Token").WithField("client_id", req.ClientID).WithField("grant_type", req.GrantType)
+
+	client, err := s.authenticateClient(ctx, req)
+	if err != nil {
+		return rs, err
+	}
+	if grant == "other" {
+		log.Error("error_400: unsupported grant type")
+		return rs, oauth.NewError(http.StatusBadRequest, oauth.ErrUnsupportedGrantType, "grant_type must be one of authorization_code, refresh_token or client_credentials")
+	}
+	if !client.GrantTypes.Contains(req.GrantType) || (client.Public && req.GrantType == model.GrantClientCredentials) {
+		log.Error("error_400: grant type not allowed to the client")
+		return rs, oauth.NewError(http.StatusBadRequest, oauth.ErrUnauthorizedClient, "The client may not use this grant type")
+	}
+
+	switch req.GrantType {
+	case model.GrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req)
+	case model.GrantRefreshToken:
+		return s.refresh(ctx, client, req)
+	default:
+		// the client acts for itself, there is no user and no scope of a user
+		if req.Scope != "" {
+			return rs, oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidScope, "No scope is available to the client credentials grant")
+		}
+		rs.AccessToken, rs.ExpiresIn, err = s.accessToken(client.ClientID, client, nil)
+		if err != nil {
+			return rs, err
+		}
+		rs.TokenType = "Bearer"
+		return rs, nil
+	}
+}
+
+// authenticateClient checks the secret of a confidential client, a public client only names itself
+func (s *OAuthService) authenticateClient(ctx context.Context, req model.OAuthTokenReq) (client model.OAuthClient, err error) {
+	log := tracing.WithCtx(ctx, "OAuthService.authenticateClient").WithField("client_id", req.ClientID)
+
+	if req.ClientID == "" {
+		log.Error("error_401: no client authentication")
+		return client, errInvalidClient
+	}
+	client, err = s.repo.GetOAuthClient(ctx, req.ClientID, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_401: unknown client")
+		return client, errInvalidClient
+	}
+	if err != nil {
+		return client, err
+	}
+	if client.Public {
+		if req.ClientSecret != "" {
+			log.Error("error_401: secret sent by a public client")
+			return client, errInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashNonce(req.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		log.Error("error_401: bad client secret")
+		return client, errInvalidClient
+	}
+	return client, nil
+}
+
+func (s *OAuthService) exchangeCode(ctx context.Context, client model.OAuthClient, req model.OAuthTokenReq) (rs model.OAuthTokenResponse, err error) {
+	log := tracing.WithCtx(ctx, "OAuthService.exchangeCode").WithField("client_id", client.ClientID)
+
+	code, err := s.repo.GetOAuthAuthorizationCode(ctx, hashNonce(req.Code), nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_400: unknown code")
+		return rs, errInvalidGrant
+	}
+	if err != nil {
+		return rs, err
+	}
+	now := time.Now()
+	switch {
+	case code.ClientID != client.ClientID:
+		err = errors.New("code of another client")
+	case code.ConsumedAt != nil || !now.Before(code.ExpiresAt):
+		err = errors.New("code used or expired")
+	case code.RedirectURI != req.RedirectURI:
+		err = errors.New("redirect_uri differs from the authorization request")
+	case code.CodeChallenge == "" && req.CodeVerifier != "":
+		err = errors.New("code_verifier without a code_challenge")
+	case code.CodeChallenge != "" && subtle.ConstantTimeCompare([]byte(oidc.Challenge(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1:
+		err = errors.New("code_verifier does not match")
+	}
+	if err != nil {
+		log.WithError(err).Error("error_400: invalid code")
+		return rs, errInvalidGrant
+	}
+	user, err := s.repo.GetOneUserByID(ctx, code.UserID, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_400: the user is deleted")
+		return rs, errInvalidGrant
+	}
+	if err != nil {
+		return rs, err
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.ConsumeOAuthAuthorizationCode(ctx, code.ID, now, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Error("error_400: code used concurrently")
+			return errInvalidGrant
+		}
+		if client.GrantTypes.Contains(model.GrantRefreshToken) {
+			rs.RefreshToken, err = issueRefreshToken(ctx, rp, model.RefreshToken{
+				UserID: user.ID, ClientID: client.ClientID, Scope: code.Scope,
+			}, client.ClientID)
+		}
+		return err
+	})
+	if err != nil {
+		return rs, err
+	}
+	return s.userTokens(rs, client, user, code.Scope, code.Nonce)
+}
+
+// refresh rotates a refresh token of the client, scope may narrow the access token but not the new refresh token
+func (s *OAuthService) refresh(ctx context.Context, client model.OAuthClient, req model.OAuthTokenReq) (rs model.OAuthTokenResponse, err error) {
+	log := tracing.WithCtx(ctx, "OAuthService.refresh").WithField("client_id", client.ClientID)
+
+	claims := &RefreshTokenClaims{}
+	token, err := jwt.ParseWithClaims(req.RefreshToken, claims, keyFunc)
+	if err != nil || !token.Valid || claims.Audience != client.ClientID {
+		log.WithError(err).Error("error_400: invalid refresh token")
+		return rs, errInvalidGrant
+	}
+	parts := strings.Split(req.RefreshToken, ".")
+	stored, err := s.repo.GetRefreshTokenBySign(ctx, parts[len(parts)-1], nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && stored.ClientID != client.ClientID) {
+		log.Error("error_400: refresh token revoked or of another client")
+		return rs, errInvalidGrant
+	}
+	if err != nil {
+		return rs, err
+	}
+
+	scope := stored.Scope
+	if req.Scope != "" {
+		scope = oauth.ParseScope(req.Scope)
+		if !oauth.Subset(scope, stored.Scope) {
+			return rs, oauth.NewError(http.StatusBadRequest, oauth.ErrInvalidScope, "The scope must be within "+stored.Scope.String())
+		}
+	}
+	user, err := s.repo.GetOneUserByID(ctx, stored.UserID, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_400: the user is deleted")
+		return rs, errInvalidGrant
+	}
+	if err != nil {
+		return rs, err
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.RevokeRefreshToken(ctx, stored.ID, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Error("error_400: refresh token used concurrently")
+			return errInvalidGrant
+		}
+		rs.RefreshToken, err = issueRefreshToken(ctx, rp, model.RefreshToken{
+			UserID: user.ID, ClientID: client.ClientID, Scope: stored.Scope,
+		}, client.ClientID)
+		return err
+	})
+	if err != nil {
+		return rs, err
+	}
+	metrics.TokensRefreshed.Inc()
+	return s.userTokens(rs, client, user, scope, "")
+}
+
+// userTokens adds the access token, and the ID token when openid is granted, to rs
+func (s *OAuthService) userTokens(rs model.OAuthTokenResponse, client model.OAuthClient, user model.User, scope model.SpaceList, nonce string) (model.OAuthTokenResponse, error) {
+	var err error
+	rs.AccessToken, rs.ExpiresIn, err = s.accessToken(user.ID.String(), client, scope)
+	if err != nil {
+		return rs, err
+	}
+	rs.TokenType = "Bearer"
+	rs.Scope = scope.String()
+	if !scope.Contains(oauth.ScopeOpenID) {
+		return rs, nil
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": OAuthIssuer(),
+		"sub": user.ID.String(),
+		"aud": client.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(rs.ExpiresIn) * time.Second).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	info := userInfo(user, scope)
+	for k, v := range map[string]string{
+		"name": info.Name, "preferred_username": info.PreferredUsername, "profile": info.Profile, "email": info.Email,
+	} {
+		if v != "" {
+			claims[k] = v
+		}
+	}
+	if rs.IDToken, err = s.signer.Sign(claims); err != nil {
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	metrics.TokensIssued.Inc(metrics.TokenID)
+	return rs, nil
+}
+
+// accessToken signs an access token of subject for the client, like the sign-in of ms-user but with
+// the client as audience and the granted scope
+func (s *OAuthService) accessToken(subject string, client model.OAuthClient, scope model.SpaceList) (token string, expiresIn int, err error) {
+	hours := conf.LoadEnv().OAuthAccessTokenHours
+	token, err = utils.CreateToken(model.CreateTokenRequest{
+		UserID:  subject,
+		NumHour: hours,
+		Extra:   client.ClientID,
+		Scope:   scope.String(),
+	})
+	if err != nil {
+		return "", 0, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	metrics.TokensIssued.Inc(metrics.TokenAccess)
+	return token, hours * 3600, nil
+}
+
+// UserInfo returns the claims of the user of an access token given to a client with the openid scope.
+// The token stops working when the client is deleted or the user revokes the consent.
+func (s *OAuthService) UserInfo(ctx context.Context, accessToken string) (rs model.OAuthUserInfo, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.UserInfo")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.UserInfo")
+
+	claims := &model.AccessTokenClaims{}
+	token, err := jwt.ParseWithClaims(accessToken, claims, keyFunc)
+	if err != nil || !token.Valid || claims.Audience == "" {
+		log.WithError(err).Error("error_401: invalid access token")
+		return rs, errInvalidToken
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		log.WithError(err).Error("error_401: the token has no user")
+		return rs, errInvalidToken
+	}
+	scope := model.SpaceList(oauth.ParseScope(claims.Scope))
+	if !scope.Contains(oauth.ScopeOpenID) {
+		log.Error("error_403: openid scope not granted")
+		return rs, oauth.NewError(http.StatusForbidden, oauth.ErrInsufficientScope, "The openid scope is required")
+	}
+
+	if _, err = s.repo.GetOAuthClient(ctx, claims.Audience, nil); err == nil {
+		_, err = s.repo.GetOAuthConsent(ctx, userID, claims.Audience, nil)
+	}
+	var user model.User
+	if err == nil {
+		user, err = s.repo.GetOneUserByID(ctx, userID, nil)
+	}
+	if err == gorm.ErrRecordNotFound {
+		log.WithField("client_id", claims.Audience).Error("error_401: client, consent or user is gone")
+		return rs, errInvalidToken
+	}
+	if err != nil {
+		return rs, err
+	}
+	return userInfo(user, scope), nil
+}
+
+func userInfo(user model.User, scope model.SpaceList) model.OAuthUserInfo {
+	rs := model.OAuthUserInfo{Subject: user.ID.String()}
+	if scope.Contains(oauth.ScopeProfile) {
+		rs.Name = user.FullName
+		rs.PreferredUsername = user.DisplayName
+		rs.Profile = user.Link
+	}
+	if scope.Contains(oauth.ScopeEmail) {
+		rs.Email = user.Email
+	}
+	return rs
+}
+
+// CreateClient registers a client, the secret of a confidential client is only returned here
+func (s *OAuthService) CreateClient(ctx context.Context, req model.CreateOAuthClientReq) (rs model.CreateOAuthClientResponse, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.CreateClient")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.CreateClient")
+
+	client := model.OAuthClient{
+		ID:           uuid.New(),
+		ClientID:     uuid.NewString(),
+		Name:         strings.TrimSpace(req.Name),
+		Public:       req.Public,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		CreatedAt:    time.Now(),
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = model.SpaceList{model.GrantAuthorizationCode, model.GrantRefreshToken}
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = append(model.SpaceList{}, oauth.SupportedScopes...)
+	}
+	if actorID, ok := audit.ActorFromContext(ctx); ok {
+		client.CreatedBy = &actorID
+	}
+	if err = validateClient(client); err != nil {
+		log.WithError(err).Error("error_400: invalid client")
+		return rs, ginext.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	rs.OAuthClient = client
+	if !client.Public {
+		if rs.ClientSecret, err = randomToken(); err != nil {
+			log.WithError(err).Error("error_500: cannot generate the secret")
+			return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+		}
+		client.SecretHash = hashNonce(rs.ClientSecret)
+	}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateOAuthClient(ctx, &client, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditOAuthClientCreated, nil, map[string]interface{}{
+			"client_id": client.ClientID, "name": client.Name, "public": client.Public,
+			"grant_types": client.GrantTypes.String(), "redirect_uris": client.RedirectURIs.String(),
+		}))
+	})
+	if err != nil {
+		return rs, err
+	}
+	return rs, nil
+}
+
+// validateClient checks the settings of a new client. Redirect URIs are https, http on the loopback
+// interface, or for public clients the private-use scheme of a native app (RFC 8252).
+func validateClient(c model.OAuthClient) error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, g := range c.GrantTypes {
+		if g != model.GrantAuthorizationCode && g != model.GrantRefreshToken && g != model.GrantClientCredentials {
+			return errors.New("grant_types: unknown grant type " + g)
+		}
+	}
+	if c.Public && c.GrantTypes.Contains(model.GrantClientCredentials) {
+		return errors.New("grant_types: public clients can not use client_credentials")
+	}
+	for _, sc := range c.Scopes {
+		if !model.SpaceList(oauth.SupportedScopes).Contains(sc) {
+			return errors.New("scopes: unknown scope " + sc)
+		}
+	}
+	if c.GrantTypes.Contains(model.GrantAuthorizationCode) && len(c.RedirectURIs) == 0 {
+		return errors.New("redirect_uris is required for the authorization_code grant")
+	}
+	for _, raw := range c.RedirectURIs {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(raw, " \t\n") {
+			return errors.New("redirect_uris: " + raw + " must be an absolute URL without fragment")
+		}
+		host := u.Hostname()
+		loopback := host == "localhost" || host == "127.0.0.1" || host == "::1"
+		switch {
+		case u.Scheme == "https" && host != "":
+		case u.Scheme == "http" && loopback:
+		case u.Scheme != "https" && u.Scheme != "http" && c.Public:
+		default:
+			return errors.New("redirect_uris: " + raw + " must be https, http on localhost, or a custom scheme for public clients")
+		}
+	}
+	return nil
+}
+
+func (s *OAuthService) ListClients(ctx context.Context) ([]model.OAuthClient, error) {
+	return s.repo.ListOAuthClients(ctx, nil)
+}
+
+// DeleteClient deletes the client with its consents and refresh tokens, its access tokens stop opening userinfo
+func (s *OAuthService) DeleteClient(ctx context.Context, clientID string) (err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.DeleteClient")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.DeleteClient").WithField("client_id", clientID)
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.DeleteOAuthClient(ctx, clientID, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Error("error_404: unknown client")
+			return ginext.NewError(http.StatusNotFound, "Unknown client_id")
+		}
+		consents, err := rp.DeleteOAuthConsents(ctx, clientID, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err = rp.DeleteOAuthRefreshTokens(ctx, clientID, nil, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditOAuthClientDeleted, nil, map[string]interface{}{
+			"client_id": clientID, "consents": consents,
+		}))
+	})
+}
+
+// ListConsents returns the clients the user granted access to, with their names
+func (s *OAuthService) ListConsents(ctx context.Context, userID uuid.UUID) ([]model.OAuthConsent, error) {
+	rs, err := s.repo.ListOAuthConsents(ctx, userID, nil)
+	if err != nil || len(rs) == 0 {
+		return rs, err
+	}
+	clients, err := s.repo.ListOAuthClients(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(clients))
+	for _, c := range clients {
+		names[c.ClientID] = c.Name
+	}
+	for i := range rs {
+		rs[i].ClientName = names[rs[i].ClientID]
+	}
+	return rs, nil
+}
+
+// RevokeConsent deletes the consent of the user to the client and the refresh tokens the client holds
+func (s *OAuthService) RevokeConsent(ctx context.Context, userID uuid.UUID, clientID string) (err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.RevokeConsent", tracing.WithAttributes("user.id", userID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "OAuthService.RevokeConsent").WithField("client_id", clientID)
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		n, err := rp.DeleteOAuthConsents(ctx, clientID, &userID, nil)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Error("error_404: no consent to this client")
+			return ginext.NewError(http.StatusNotFound, "No consent was given to this client")
+		}
+		if err = rp.DeleteOAuthRefreshTokens(ctx, clientID, &userID, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditOAuthConsentRevoked, &userID, map[string]interface{}{
+			"client_id": clientID,
+		}))
+	})
+}
+
+// authorizeQuery is the query of req, passed on to the consent page
+func authorizeQuery(req model.OAuthAuthorizeReq) url.Values {
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"response_type": req.ResponseType, "client_id": req.ClientID, "redirect_uri": req.RedirectURI,
+		"scope": req.Scope, "state": req.State, "nonce": req.Nonce,
+		"code_challenge": req.CodeChallenge, "code_challenge_method": req.CodeChallengeMethod,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return q
+}
+
+// authorizationRedirect sends params and the state of req back to its redirect URI
+func authorizationRedirect(req model.OAuthAuthorizeReq, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	for k, v := range params {
+		if len(v) == 1 && v[0] == "" {
+			params.Del(k)
+		}
+	}
+	return withQuery(req.RedirectURI, params)
+}
+
+// withQuery adds params to the query of rawURL, which is known to parse
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/oidc"
+	"ms-user/pkg/repo"
+)
+
+const (
+	oauthTestRedirectURI = "http://127.0.0.1/oauth-test/callback"
+	oauthTestConsentURL  = "http://consent.test/oauth/consent"
+)
+
+// newOAuthTestService serves the discovery document and the keys of the authorization server on PUBLIC_BASE_URL,
+// the relying party of pkg/oidc verifies the ID tokens with them
+func newOAuthTestService(t *testing.T) (*OAuthService, *UserService, *oidc.Provider, model.CreateOAuthClientResponse) {
+	t.Helper()
+	signer, err := oauth.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.NewMemoryRepo()
+	s := NewOAuthService(r, signer).(*OAuthService)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(s.Discovery())
+		case OAuthJWKSPath:
+			_ = json.NewEncoder(w).Encode(s.JWKS())
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	// the configuration is reloaded once the environment is restored
+	t.Cleanup(func() { _ = conf.SetEnv() })
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+	t.Setenv("OAUTH_CONSENT_URL", oauthTestConsentURL)
+	if err = conf.SetEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := s.CreateClient(context.Background(), model.CreateOAuthClientReq{
+		Name: "OAuth test", RedirectURIs: []string{oauthTestRedirectURI},
+		GrantTypes: []string{model.GrantAuthorizationCode, model.GrantRefreshToken, model.GrantClientCredentials},
+	})
+	if err != nil || client.ClientSecret == "" {
+		t.Fatalf("CreateClient = %+v, %v", client, err)
+	}
+	rp := oidc.NewProvider(oidc.Config{Issuer: srv.URL, ClientID: client.ClientID, ClientSecret: client.ClientSecret})
+	return s, NewUserService(r, nil, nil).(*UserService), rp, client
+}
+
+// oauthErrCode is the OAuth error code of err, "" for nil or another error
+func oauthErrCode(err error) string {
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+// approve runs the authorization request of the client with PKCE through the consent page as the user,
+// it returns the code sent back to the client
+func approve(t *testing.T, s *OAuthService, client model.CreateOAuthClientResponse, userID uuid.UUID, verifier string) string {
+	t.Helper()
+	ctx := context.Background()
+	req := model.OAuthAuthorizeReq{
+		ResponseType: "code", ClientID: client.ClientID, RedirectURI: oauthTestRedirectURI,
+		Scope: "openid email profile", State: "oauth-test-state", Nonce: "oauth-test-nonce",
+		CodeChallenge: oidc.Challenge(verifier), CodeChallengeMethod: "S256",
+	}
+	redirectTo, err := s.Authorize(ctx, req)
+	if err != nil || !strings.HasPrefix(redirectTo, oauthTestConsentURL+"?") {
+		t.Fatalf("Authorize = %q, %v, want the consent page", redirectTo, err)
+	}
+	info, err := s.AuthorizeInfo(ctx, userID, req)
+	if err != nil || info.ClientID != client.ClientID || info.Consented {
+		t.Fatalf("AuthorizeInfo = %+v, %v, want the client without consent", info, err)
+	}
+	rs, err := s.Decide(ctx, userID, model.OAuthDecisionReq{OAuthAuthorizeReq: req, Approve: true})
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	back, err := url.Parse(rs.RedirectTo)
+	if err != nil || !strings.HasPrefix(rs.RedirectTo, oauthTestRedirectURI) || back.Query().Get("state") != "oauth-test-state" {
+		t.Fatalf("Decide redirects to %q, want the client with the state", rs.RedirectTo)
+	}
+	return back.Query().Get("code")
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	s, users, rp, client := newOAuthTestService(t)
+	user := model.User{Email: "oauth-user@example.com", FullName: "OAuth user", Password: "hashed"}
+	if err := s.repo.CreateUser(ctx, &user, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, _ := oidc.NewVerifier()
+	code := approve(t, s, client, user.ID, verifier)
+	exchange := model.OAuthTokenReq{GrantType: model.GrantAuthorizationCode, Code: code, RedirectURI: oauthTestRedirectURI,
+		CodeVerifier: verifier, ClientID: client.ClientID, ClientSecret: client.ClientSecret}
+
+	wrongVerifier := exchange
+	wrongVerifier.CodeVerifier = "another-verifier-of-the-right-length-0123456789"
+	if _, err := s.Token(ctx, wrongVerifier); oauthErrCode(err) != oauth.ErrInvalidGrant {
+		t.Errorf("exchange with another code_verifier err = %v, want %s", err, oauth.ErrInvalidGrant)
+	}
+	tokens, err := s.Token(ctx, exchange)
+	if err != nil || tokens.RefreshToken == "" {
+		t.Fatalf("exchange the code = %+v, %v", tokens, err)
+	}
+	idToken, err := rp.VerifyIDToken(ctx, tokens.IDToken, "oauth-test-nonce")
+	if err != nil || idToken.Subject != user.ID.String() || idToken.Email != user.Email {
+		t.Errorf("ID token = %+v, %v, want the user", idToken, err)
+	}
+	if _, err = s.Token(ctx, exchange); oauthErrCode(err) != oauth.ErrInvalidGrant {
+		t.Errorf("replay the code err = %v, want %s", err, oauth.ErrInvalidGrant)
+	}
+
+	// the access token opens userinfo only, never the first-party API
+	if info, err := s.UserInfo(ctx, tokens.AccessToken); err != nil || info.Subject != user.ID.String() || info.Email != user.Email {
+		t.Errorf("UserInfo = %+v, %v, want the user", info, err)
+	}
+	if _, _, err = users.AuthenticateAccessToken(ctx, tokens.AccessToken); err == nil {
+		t.Error("the first-party API accepts an OAuth access token")
+	}
+}
+
+func TestOAuthRefreshTokensRotate(t *testing.T) {
+	ctx := context.Background()
+	s, _, _, client := newOAuthTestService(t)
+	user := model.User{Email: "oauth-refresh@example.com", Password: "hashed"}
+	if err := s.repo.CreateUser(ctx, &user, nil); err != nil {
+		t.Fatal(err)
+	}
+	verifier, _ := oidc.NewVerifier()
+	tokens, err := s.Token(ctx, model.OAuthTokenReq{GrantType: model.GrantAuthorizationCode, Code: approve(t, s, client, user.ID, verifier),
+		RedirectURI: oauthTestRedirectURI, CodeVerifier: verifier, ClientID: client.ClientID, ClientSecret: client.ClientSecret})
+	if err != nil {
+		t.Fatalf("exchange the code: %v", err)
+	}
+
+	refresh := model.OAuthTokenReq{GrantType: model.GrantRefreshToken, RefreshToken: tokens.RefreshToken,
+		ClientID: client.ClientID, ClientSecret: client.ClientSecret}
+	refreshed, err := s.Token(ctx, refresh)
+	if err != nil || refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh = %+v, %v, want a new refresh token", refreshed, err)
+	}
+	if _, err = s.Token(ctx, refresh); oauthErrCode(err) != oauth.ErrInvalidGrant {
+		t.Errorf("replay the refresh token err = %v, want %s", err, oauth.ErrInvalidGrant)
+	}
+	if info, err := s.UserInfo(ctx, refreshed.AccessToken); err != nil || info.Subject != user.ID.String() {
+		t.Errorf("UserInfo after the refresh = %+v, %v, want the user", info, err)
+	}
+
+	// revoking the consent shuts the client out
+	if err = s.RevokeConsent(ctx, user.ID, client.ClientID); err != nil {
+		t.Fatalf("RevokeConsent: %v", err)
+	}
+	if _, err = s.UserInfo(ctx, refreshed.AccessToken); oauthErrCode(err) != oauth.ErrInvalidToken {
+		t.Errorf("UserInfo after the revocation err = %v, want %s", err, oauth.ErrInvalidToken)
+	}
+	refresh.RefreshToken = refreshed.RefreshToken
+	if _, err = s.Token(ctx, refresh); oauthErrCode(err) != oauth.ErrInvalidGrant {
+		t.Errorf("refresh after the revocation err = %v, want %s", err, oauth.ErrInvalidGrant)
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	ctx := context.Background()
+	s, _, _, client := newOAuthTestService(t)
+
+	// the client acts for itself, the token holds no user
+	own, err := s.Token(ctx, model.OAuthTokenReq{GrantType: model.GrantClientCredentials, ClientID: client.ClientID, ClientSecret: client.ClientSecret})
+	if err != nil || own.AccessToken == "" || own.RefreshToken != "" {
+		t.Fatalf("client credentials = %+v, %v, want an access token only", own, err)
+	}
+	if _, err = s.UserInfo(ctx, own.AccessToken); err == nil {
+		t.Error("UserInfo accepts a token of the client credentials grant")
+	}
+	if _, err = s.Token(ctx, model.OAuthTokenReq{GrantType: model.GrantClientCredentials, ClientID: client.ClientID, ClientSecret: "wrong"}); oauthErrCode(err) != oauth.ErrInvalidClient {
+		t.Errorf("client credentials with a bad secret err = %v, want %s", err, oauth.ErrInvalidClient)
+	}
+
+	// a deleted client is unknown
+	if err = s.DeleteClient(ctx, client.ClientID); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	if _, err = s.Token(ctx, model.OAuthTokenReq{GrantType: model.GrantClientCredentials, ClientID: client.ClientID, ClientSecret: client.ClientSecret}); oauthErrCode(err) != oauth.ErrInvalidClient {
+		t.Errorf("client credentials of a deleted client err = %v, want %s", err, oauth.ErrInvalidClient)
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/oidc"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+)
+
+// OIDCLoginPath is the route that sends the browser to a provider, the callback route is below it
+const OIDCLoginPath = "/api/v1/user/login/oidc/"
+
+const oidcFailedMessage = "The sign-in with the provider failed, try again"
+
+// errUnverifiedEmail is answered when the provider did not verify an email an account uses,
+// linking on it would hand the account to whoever typed the email at the provider
+var errUnverifiedEmail = ginext.NewError(http.StatusConflict,
+	"An account uses this email, sign in with its password or verify the email at the provider")
+
+// StartOIDCLogin prepares the sign-in at a provider and returns the URL to send the browser to.
+// state must be kept by the browser, e.g. in a cookie, and given back to FinishOIDCLogin.
+func (s *UserService) StartOIDCLogin(ctx context.Context, provider string, req model.StartOIDCLoginReq) (authURL, state string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.StartOIDCLogin")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.StartOIDCLogin")
+	cfg := conf.LoadEnv()
+
+	p, ok := s.providers[provider]
+	if !ok {
+		log.WithField("provider", provider).Error("error_404: unknown provider")
+		return "", "", ginext.NewError(http.StatusNotFound, "Unknown identity provider")
+	}
+
+	nonce, err := randomToken()
+	if err == nil {
+		state, err = randomToken()
+	}
+	var verifier string
+	if err == nil {
+		verifier, err = oidc.NewVerifier()
+	}
+	if err != nil {
+		log.WithError(err).Error("error_500: cannot generate the state")
+		return "", "", ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+
+	authURL, err = p.AuthCodeURL(ctx, state, nonce, oidc.Challenge(verifier), oidcRedirectURI(cfg.PublicBaseURL, provider))
+	if err != nil {
+		log.WithError(err).Error("error_503: provider discovery failed")
+		return "", "", ginext.NewError(http.StatusServiceUnavailable, "The identity provider is unavailable, try again later")
+	}
+	now := time.Now()
+	err = s.repo.CreateOIDCAuthRequest(ctx, &model.OIDCAuthRequest{
+		ID:           uuid.New(),
+		Provider:     provider,
+		StateHash:    hashNonce(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceID:     strings.TrimSpace(req.DeviceID),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Duration(cfg.OIDCStateTTLSeconds) * time.Second),
+	}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin handles the redirect of the provider back to ms-user: the code is exchanged,
+// the ID token verified and the user it names signed in. cookieState is the state of StartOIDCLogin.
+// A user is found by the linked identity, else by the verified email, else created.
+func (s *UserService) FinishOIDCLogin(ctx context.Context, provider string, req model.OIDCCallbackReq, cookieState string) (rs model.ConfirmLoginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.FinishOIDCLogin")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.FinishOIDCLogin").WithField("provider", provider)
+
+	// the user is not known before the ID token is verified, failures are only audited
+	reject := func(reason string, cause error) error {
+		log.WithError(cause).WithField("reason", reason).Error("error_401: oidc sign-in rejected")
+		metrics.Logins.Inc(reason)
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, nil, map[string]interface{}{
+			"method": "oidc", "provider": provider, "reason": reason,
+		}))
+		return ginext.NewError(http.StatusUnauthorized, oidcFailedMessage)
+	}
+
+	p, ok := s.providers[provider]
+	if !ok {
+		log.Error("error_404: unknown provider")
+		return rs, ginext.NewError(http.StatusNotFound, "Unknown identity provider")
+	}
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(req.State), []byte(cookieState)) != 1 {
+		return rs, reject(metrics.LoginOtherBrowser, errors.New("state does not match the cookie"))
+	}
+	authReq, err := s.repo.GetOIDCAuthRequestByState(ctx, hashNonce(req.State), nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && authReq.Provider != provider) {
+		return rs, reject(metrics.LoginBadState, errors.New("unknown state"))
+	}
+	if err != nil {
+		return rs, err
+	}
+	now := time.Now()
+	if authReq.ConsumedAt != nil || !now.Before(authReq.ExpiresAt) {
+		return rs, reject(metrics.LoginExpiredState, errors.New("state used or expired"))
+	}
+	if ok, err = s.repo.ConsumeOIDCAuthRequest(ctx, authReq.ID, now, nil); err != nil {
+		return rs, err
+	}
+	if !ok {
+		return rs, reject(metrics.LoginExpiredState, errors.New("state used concurrently"))
+	}
+	if req.Error != "" {
+		return rs, reject(metrics.LoginOIDCRejected, errors.New(req.Error+": "+req.ErrorDescription))
+	}
+
+	tokens, err := p.Exchange(ctx, req.Code, authReq.CodeVerifier, oidcRedirectURI(conf.LoadEnv().PublicBaseURL, provider))
+	if err != nil {
+		return rs, reject(metrics.LoginOIDCRejected, err)
+	}
+	idToken, err := p.VerifyIDToken(ctx, tokens.IDToken, authReq.Nonce)
+	if err != nil {
+		return rs, reject(metrics.LoginOIDCRejected, err)
+	}
+
+	user, err := s.oidcUser(ctx, provider, idToken)
+	if err != nil {
+		return rs, err
+	}
+	span.SetAttributes(tracing.Attributes("user.id", user.ID.String())...)
+
+	return s.signIn(ctx, user, authReq.DeviceID, map[string]interface{}{
+		"method": "oidc", "provider": provider, "email": model.NormalizeEmail(idToken.Email),
+	})
+}
+
+// oidcUser returns the user of the identity, linking or creating it on its first sign-in.
+// A concurrent first sign-in of the same identity or email makes the first try fail with a 409, the second finds the user.
+func (s *UserService) oidcUser(ctx context.Context, provider string, idToken oidc.IDToken) (rs model.User, err error) {
+	for attempt := 0; ; attempt++ {
+		rs, err = s.findOrCreateOIDCUser(ctx, provider, idToken)
+		var apiErr ginext.ApiError
+		if attempt > 0 || err == errUnverifiedEmail || !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+			return rs, err
+		}
+	}
+}
+
+func (s *UserService) findOrCreateOIDCUser(ctx context.Context, provider string, idToken oidc.IDToken) (rs model.User, err error) {
+	log := tracing.WithCtx(ctx, "UserService.findOrCreateOIDCUser").WithField("provider", provider)
+
+	identity, err := s.repo.GetUserIdentity(ctx, provider, idToken.Subject, nil)
+	if err == nil {
+		rs, err = s.repo.GetOneUserByID(ctx, identity.UserID, nil)
+		if err == gorm.ErrRecordNotFound {
+			log.Error("error_401: the linked user is deleted")
+			return rs, ginext.NewError(http.StatusUnauthorized, oidcFailedMessage)
+		}
+		return rs, err
+	}
+	if err != gorm.ErrRecordNotFound {
+		return rs, err
+	}
+
+	identity = model.UserIdentity{
+		ID:        uuid.New(),
+		Provider:  provider,
+		Subject:   idToken.Subject,
+		Email:     model.NormalizeEmail(idToken.Email),
+		CreatedAt: time.Now(),
+	}
+	if identity.Email != "" {
+		rs, err = s.repo.GetOneUserByEmail(ctx, identity.Email, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return rs, err
+		}
+		if err == nil {
+			if !idToken.EmailVerified {
+				log.Error("error_409: unverified email of an existing account")
+				return rs, errUnverifiedEmail
+			}
+			identity.UserID = rs.ID
+			err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+				if err := rp.CreateUserIdentity(ctx, &identity, nil); err != nil {
+					return err
+				}
+				return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditIdentityLinked, &rs.ID, map[string]interface{}{
+					"provider": provider, "subject": idToken.Subject, "email": identity.Email,
+				}))
+			})
+			return rs, err
+		}
+	}
+
+	// the account only gets an email the provider verified, it can be signed in to by email later
+	rs = model.User{FullName: idToken.Name}
+	if idToken.EmailVerified {
+		rs.Email = identity.Email
+	}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, &rs, nil); err != nil {
+			return err
+		}
+		identity.UserID = rs.ID
+		if err := rp.CreateUserIdentity(ctx, &identity, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRegistered, &rs.ID, map[string]interface{}{
+			"email": rs.Email, "method": "oidc", "provider": provider,
+		}))
+	})
+	if err != nil {
+		return rs, err
+	}
+	metrics.Registrations.Inc()
+	return rs, nil
+}
+
+func oidcRedirectURI(baseURL, provider string) string {
+	return strings.TrimRight(baseURL, "/") + OIDCLoginPath + provider + "/callback"
+}
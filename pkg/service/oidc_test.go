@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/oidc"
+	"ms-user/pkg/oidc/oidctest"
+	"ms-user/pkg/repo"
+)
+
+const oidcTestProvider = "fake"
+
+func newOIDCTestService(t *testing.T) (*UserService, *oidctest.Provider) {
+	idp := oidctest.NewProvider("ms-user-test", "oidc-test-secret")
+	t.Cleanup(idp.Close)
+	s := NewUserService(repo.NewMemoryRepo(), nil, map[string]*oidc.Provider{
+		oidcTestProvider: oidc.NewProvider(oidc.Config{Issuer: idp.Issuer(), ClientID: "ms-user-test", ClientSecret: "oidc-test-secret"}),
+	})
+	return s.(*UserService), idp
+}
+
+// oidcLogin runs a sign-in at the fake provider and returns the user of the access token.
+// tamper is "drop-cookie" to call back without the state of the cookie, "replay" to call back twice.
+func oidcLogin(t *testing.T, s *UserService, idp *oidctest.Provider, tamper string, want int) (userID string) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, state, err := s.StartOIDCLogin(ctx, oidcTestProvider, model.StartOIDCLoginReq{DeviceID: "oidc-test"})
+	if err != nil {
+		t.Fatalf("StartOIDCLogin: %v", err)
+	}
+
+	// the provider signs the user in and redirects back to the callback
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	req := model.OIDCCallbackReq{Code: callback.Query().Get("code"), State: callback.Query().Get("state")}
+
+	cookieState := state
+	if tamper == "drop-cookie" {
+		cookieState = ""
+	}
+	rs, err := s.FinishOIDCLogin(ctx, oidcTestProvider, req, cookieState)
+	if tamper == "replay" {
+		if err != nil {
+			t.Fatalf("callback before the replay: %v", err)
+		}
+		rs, err = s.FinishOIDCLogin(ctx, oidcTestProvider, req, cookieState)
+	}
+	if got := errStatus(err); got != want {
+		t.Fatalf("FinishOIDCLogin status = %d (%v), want %d", got, err, want)
+	}
+	if want != http.StatusOK {
+		return ""
+	}
+	_, id, err := s.AuthenticateAccessToken(ctx, rs.Token)
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	return id.String()
+}
+
+func TestOIDCLoginCreatesAndFindsTheAccount(t *testing.T) {
+	s, idp := newOIDCTestService(t)
+	idp.SetUser(oidctest.User{Subject: "new", Email: "oidc-new@example.com", EmailVerified: true, Name: "New"})
+	first := oidcLogin(t, s, idp, "", http.StatusOK)
+
+	// the next sign-in finds the account by the identity, whatever its email became
+	idp.SetUser(oidctest.User{Subject: "new", Email: "renamed@example.com", EmailVerified: true})
+	if again := oidcLogin(t, s, idp, "", http.StatusOK); again != first {
+		t.Errorf("second sign-in of an identity: user %s, want %s", again, first)
+	}
+}
+
+func TestOIDCLoginLinksByVerifiedEmail(t *testing.T) {
+	s, idp := newOIDCTestService(t)
+	email, password := "Oidc-Link@example.com", "Passw0rd!oidc"
+	created, err := s.CreateUser(context.Background(), model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	idp.SetUser(oidctest.User{Subject: "link", Email: "oidc-link@example.com", EmailVerified: true})
+	if linked := oidcLogin(t, s, idp, "", http.StatusOK); linked != created.ID.String() {
+		t.Errorf("sign-in with the verified email of an account: user %s, want %s", linked, created.ID)
+	}
+
+	// an unverified email never links, it is refused when an account uses it
+	idp.SetUser(oidctest.User{Subject: "unverified", Email: "oidc-link@example.com"})
+	oidcLogin(t, s, idp, "", http.StatusConflict)
+	idp.SetUser(oidctest.User{Subject: "unverified-free", Email: "oidc-free@example.com"})
+	oidcLogin(t, s, idp, "", http.StatusOK)
+}
+
+func TestOIDCLoginRejectsBadIDTokens(t *testing.T) {
+	s, idp := newOIDCTestService(t)
+	idp.SetUser(oidctest.User{Subject: "new", Email: "oidc-new@example.com", EmailVerified: true})
+	for name, hook := range map[string]func(jwt.MapClaims){
+		"nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"audience": func(c jwt.MapClaims) { c["aud"] = "another-client" },
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expiry":   func(c jwt.MapClaims) { c["exp"] = 1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			idp.SetClaimsHook(hook)
+			defer idp.SetClaimsHook(nil)
+			oidcLogin(t, s, idp, "", http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestOIDCLoginRejectsBadCallbacks(t *testing.T) {
+	s, idp := newOIDCTestService(t)
+	idp.SetUser(oidctest.User{Subject: "new", Email: "oidc-new@example.com", EmailVerified: true})
+	t.Run("without the state cookie", func(t *testing.T) { oidcLogin(t, s, idp, "drop-cookie", http.StatusUnauthorized) })
+	t.Run("replayed", func(t *testing.T) { oidcLogin(t, s, idp, "replay", http.StatusUnauthorized) })
+
+	_, _, err := s.StartOIDCLogin(context.Background(), "unknown", model.StartOIDCLoginReq{})
+	if got := errStatus(err); got != http.StatusNotFound {
+		t.Errorf("StartOIDCLogin of an unknown provider status = %d (%v), want 404", got, err)
+	}
+}
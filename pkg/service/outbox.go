@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ms-user/pkg/mailer"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxConfig tunes the delivery of the outbox messages
+type OutboxConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	// MaxBackoff caps the delay between two attempts, the delay doubles after every failure
+	MaxBackoff time.Duration
+}
+
+// OutboxWorker delivers the messages written to the outbox by the transactions of the service
+type OutboxWorker struct {
+	repo   repo.PGInterface
+	mailer mailer.Mailer
+	cfg    OutboxConfig
+}
+
+func NewOutboxWorker(repo repo.PGInterface, m mailer.Mailer, cfg OutboxConfig) *OutboxWorker {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 20
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Hour
+	}
+	return &OutboxWorker{repo: repo, mailer: m, cfg: cfg}
+}
+
+// Run delivers the due messages every PollInterval until ctx is done.
+// A batch in progress when ctx is done is finished, so no sent message is left pending.
+func (w *OutboxWorker) Run(ctx context.Context) {
+	log := tracing.WithCtx(ctx, "OutboxWorker.Run")
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.ProcessOnce(context.Background()); err != nil {
+			log.WithError(err).Error("failed to process outbox")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce delivers one batch of due messages and returns how many were sent
+func (w *OutboxWorker) ProcessOnce(ctx context.Context) (sent int, err error) {
+	err = w.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		sent = 0
+		now := time.Now()
+		msgs, err := rp.GetDueOutboxMessages(ctx, now, w.cfg.BatchSize, nil)
+		if err != nil {
+			return err
+		}
+		for i := range msgs {
+			if ctx.Err() != nil {
+				break
+			}
+			msg := &msgs[i]
+			if w.deliver(ctx, msg, now) {
+				sent++
+			}
+			if err = rp.UpdateOutboxMessage(ctx, msg, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// Lag is how long the oldest undelivered message has been waiting
+func (w *OutboxWorker) Lag(ctx context.Context) (time.Duration, error) {
+	oldest, err := w.repo.GetOldestPendingOutboxMessage(ctx, nil)
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(oldest.CreatedAt), nil
+}
+
+// deliver sends msg and updates its delivery state, it reports whether msg was sent
+func (w *OutboxWorker) deliver(ctx context.Context, msg *model.OutboxMessage, now time.Time) bool {
+	log := tracing.WithCtx(ctx, "OutboxWorker.deliver").WithField("outbox_id", msg.ID)
+
+	msg.Attempts++
+	err := w.send(ctx, msg)
+	if err == nil {
+		msg.SentAt = &now
+		msg.LastError = ""
+		return true
+	}
+
+	log.WithError(err).WithField("attempts", msg.Attempts).Error("failed to deliver outbox message")
+	msg.LastError = err.Error()
+	if msg.Attempts >= w.cfg.MaxAttempts {
+		msg.FailedAt = &now
+		return false
+	}
+	msg.NextAttemptAt = now.Add(w.backoff(msg.Attempts))
+	return false
+}
+
+func (w *OutboxWorker) send(ctx context.Context, msg *model.OutboxMessage) error {
+	switch msg.Kind {
+	case model.OutboxKindEmail:
+		var email model.EmailPayload
+		if err := json.Unmarshal([]byte(msg.Payload), &email); err != nil {
+			return err
+		}
+		return w.mailer.Send(ctx, mailer.Message{To: email.To, Subject: email.Subject, Body: email.Body})
+	}
+	return fmt.Errorf("unknown outbox message kind %q", msg.Kind)
+}
+
+func (w *OutboxWorker) backoff(attempts int) time.Duration {
+	d := w.cfg.PollInterval
+	for i := 1; i < attempts && d < w.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.cfg.MaxBackoff {
+		d = w.cfg.MaxBackoff
+	}
+	return d
+}
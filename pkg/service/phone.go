@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/phone"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/sms"
+	"ms-user/pkg/tracing"
+	"ms-user/pkg/valid"
+)
+
+// otpDigits is the length of the codes sent by SMS
+const otpDigits = 6
+
+const invalidCodeMessage = "The code is invalid or expired"
+
+// RequestPhoneLoginCode sends a sign-in code to a phone number, the account is created on the first sign-in
+func (s *UserService) RequestPhoneLoginCode(ctx context.Context, req model.PhoneOTPReq) (rs model.PhoneOTPResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RequestPhoneLoginCode")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	e164, err := normalizePhone(ctx, valid.String(req.PhoneNumber), req.Region)
+	if err != nil {
+		return rs, err
+	}
+	return s.sendCode(ctx, e164, model.OTPPurposeLogin, nil)
+}
+
+// LoginWithPhone signs in with a code of RequestPhoneLoginCode, an account is created for unknown numbers
+func (s *UserService) LoginWithPhone(ctx context.Context, req model.PhoneLoginReq) (rs model.ConfirmLoginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.LoginWithPhone")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.LoginWithPhone")
+
+	e164, err := normalizePhone(ctx, valid.String(req.PhoneNumber), req.Region)
+	if err != nil {
+		return rs, err
+	}
+	deviceID := strings.TrimSpace(valid.String(req.DeviceID))
+
+	if reason, err := s.checkCode(ctx, e164, model.OTPPurposeLogin, nil, valid.String(req.Code)); err != nil {
+		if reason == "" {
+			return rs, err
+		}
+		metrics.Logins.Inc(reason)
+		var subjectID *uuid.UUID
+		if user, err := s.repo.GetOneUserByPhone(ctx, e164, nil); err == nil {
+			subjectID = &user.ID
+			recordFailedLogin(ctx, s.repo, newLoginHistory(ctx, user.ID, deviceID, false, reason))
+		}
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, subjectID, map[string]interface{}{
+			"phone": e164, "method": "phone", "reason": reason,
+		}))
+		return rs, err
+	}
+
+	user, err := s.repo.GetOneUserByPhone(ctx, e164, nil)
+	if err == gorm.ErrRecordNotFound {
+		user, err = s.createPhoneUser(ctx, e164)
+	}
+	if err != nil {
+		log.WithError(err).Error("error_500: cannot find or create the phone user")
+		return rs, err
+	}
+	span.SetAttributes(tracing.Attributes("user.id", user.ID.String())...)
+
+	return s.signIn(ctx, user, deviceID, map[string]interface{}{"phone": e164, "method": "phone"})
+}
+
+// createPhoneUser registers an account with no email and no password for a verified phone number
+func (s *UserService) createPhoneUser(ctx context.Context, e164 string) (rs model.User, err error) {
+	now := time.Now()
+	rs = model.User{PhoneNumber: e164, PhoneE164: &e164, PhoneVerifiedAt: &now}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, &rs, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRegistered, &rs.ID, map[string]interface{}{
+			"phone": e164, "method": "phone",
+		}))
+	})
+	var apiErr ginext.ApiError
+	if errors.As(err, &apiErr) && apiErr.Code() == http.StatusConflict {
+		// a concurrent sign-in with the same number created the account first
+		return s.repo.GetOneUserByPhone(ctx, e164, nil)
+	}
+	if err != nil {
+		return rs, err
+	}
+	metrics.Registrations.Inc()
+	return rs, nil
+}
+
+// RequestPhoneVerificationCode sends a code proving that the signed in user owns a phone number
+func (s *UserService) RequestPhoneVerificationCode(ctx context.Context, userID uuid.UUID, req model.PhoneOTPReq) (rs model.PhoneOTPResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RequestPhoneVerificationCode")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	e164, err := normalizePhone(ctx, valid.String(req.PhoneNumber), req.Region)
+	if err != nil {
+		return rs, err
+	}
+	if err = s.checkPhoneFree(ctx, userID, e164); err != nil {
+		return rs, err
+	}
+	return s.sendCode(ctx, e164, model.OTPPurposeVerify, &userID)
+}
+
+// VerifyPhone sets the phone number of the user once the code of RequestPhoneVerificationCode is confirmed
+func (s *UserService) VerifyPhone(ctx context.Context, userID uuid.UUID, req model.PhoneVerifyReq) (rs model.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.VerifyPhone")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	e164, err := normalizePhone(ctx, valid.String(req.PhoneNumber), req.Region)
+	if err != nil {
+		return rs, err
+	}
+	if err = s.checkPhoneFree(ctx, userID, e164); err != nil {
+		return rs, err
+	}
+	if _, err = s.checkCode(ctx, e164, model.OTPPurposeVerify, &userID, valid.String(req.Code)); err != nil {
+		return rs, err
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.UpdateUserPhone(ctx, userID, e164, time.Now(), nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditPhoneVerified, &userID, map[string]interface{}{"phone": e164}))
+	})
+	if err != nil {
+		return rs, err
+	}
+	return s.repo.GetOneUserByID(ctx, userID, nil)
+}
+
+// checkPhoneFree fails with a 409 when another account signs in with e164
+func (s *UserService) checkPhoneFree(ctx context.Context, userID uuid.UUID, e164 string) error {
+	owner, err := s.repo.GetOneUserByPhone(ctx, e164, nil)
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		return nil
+	case err != nil:
+		return err
+	case owner.ID != userID:
+		tracing.WithCtx(ctx, "UserService.checkPhoneFree").Error("error_409: phone used by another account")
+		return ginext.NewError(http.StatusConflict, repo.PhoneTakenMessage)
+	}
+	return nil
+}
+
+// sendCode creates a code for phone and sends it by SMS, within the resend interval and the hourly limit
+func (s *UserService) sendCode(ctx context.Context, e164, purpose string, userID *uuid.UUID) (rs model.PhoneOTPResponse, err error) {
+	log := tracing.WithCtx(ctx, "UserService.sendCode")
+	cfg := conf.LoadEnv()
+	now := time.Now()
+
+	last, err := s.repo.GetLatestPhoneOTP(ctx, e164, purpose, nil)
+	switch {
+	case err == nil && now.Sub(last.CreatedAt) < time.Duration(cfg.OTPResendIntervalSeconds)*time.Second:
+		log.Error("error_429: code requested again within the resend interval")
+		return rs, ginext.NewError(http.StatusTooManyRequests, "Please wait before requesting another code")
+	case err != nil && err != gorm.ErrRecordNotFound:
+		return rs, err
+	}
+	count, err := s.repo.CountPhoneOTPsSince(ctx, e164, now.Add(-time.Hour), nil)
+	if err != nil {
+		return rs, err
+	}
+	if count >= int64(cfg.OTPMaxPerHour) {
+		log.Error("error_429: too many codes requested for the phone")
+		return rs, ginext.NewError(http.StatusTooManyRequests, "Too many codes requested for this phone number, try again later")
+	}
+
+	code, err := newOTPCode()
+	if err != nil {
+		log.WithError(err).Error("error_500: cannot generate the code")
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	otp := model.PhoneOTP{
+		Phone:     e164,
+		Purpose:   purpose,
+		UserID:    userID,
+		CodeHash:  hashOTPCode(e164, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(cfg.OTPTTLSeconds) * time.Second),
+	}
+	if err = s.repo.CreatePhoneOTP(ctx, &otp, nil); err != nil {
+		return rs, err
+	}
+
+	err = s.sms.Send(ctx, sms.Message{
+		To:   e164,
+		Body: fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, (cfg.OTPTTLSeconds+59)/60),
+	})
+	if err != nil {
+		log.WithError(err).Error("error_503: cannot send the code")
+		return rs, ginext.NewError(http.StatusServiceUnavailable, "The code could not be sent, try again later")
+	}
+	metrics.OTPsSent.Inc(purpose)
+
+	return model.PhoneOTPResponse{PhoneNumber: e164, ExpiresAt: otp.ExpiresAt}, nil
+}
+
+// checkCode consumes the latest code of phone for purpose when it matches code.
+// reason is the login metric outcome of a rejected code, it is empty when err is not about the code.
+func (s *UserService) checkCode(ctx context.Context, e164, purpose string, userID *uuid.UUID, code string) (reason string, err error) {
+	log := tracing.WithCtx(ctx, "UserService.checkCode")
+	now := time.Now()
+
+	otp, err := s.repo.GetLatestPhoneOTP(ctx, e164, purpose, nil)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+	if err == gorm.ErrRecordNotFound || otp.ConsumedAt != nil || !now.Before(otp.ExpiresAt) ||
+		(userID != nil && (otp.UserID == nil || *otp.UserID != *userID)) {
+		log.Error("error_401: no pending code")
+		return metrics.LoginExpiredCode, ginext.NewError(http.StatusUnauthorized, invalidCodeMessage)
+	}
+
+	maxAttempts := conf.LoadEnv().OTPMaxAttempts
+	if otp.Attempts >= maxAttempts {
+		log.Error("error_429: too many attempts")
+		return metrics.LoginLocked, ginext.NewError(http.StatusTooManyRequests, "Too many attempts, request a new code")
+	}
+	// the attempt is counted before the comparison so concurrent guesses can not exceed the limit
+	attempts, err := s.repo.IncrementPhoneOTPAttempts(ctx, otp.ID, nil)
+	if err != nil {
+		return "", err
+	}
+	if attempts > maxAttempts {
+		log.Error("error_429: too many attempts")
+		return metrics.LoginLocked, ginext.NewError(http.StatusTooManyRequests, "Too many attempts, request a new code")
+	}
+
+	if !hmac.Equal([]byte(hashOTPCode(e164, strings.TrimSpace(code))), []byte(otp.CodeHash)) {
+		log.Error("error_401: wrong code")
+		return metrics.LoginBadCode, ginext.NewError(http.StatusUnauthorized, invalidCodeMessage)
+	}
+	ok, err := s.repo.ConsumePhoneOTP(ctx, otp.ID, now, nil)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		log.Error("error_401: code already used")
+		return metrics.LoginExpiredCode, ginext.NewError(http.StatusUnauthorized, invalidCodeMessage)
+	}
+	return "", nil
+}
+
+func normalizePhone(ctx context.Context, raw, region string) (string, error) {
+	if region == "" {
+		region = conf.LoadEnv().PhoneDefaultRegion
+	}
+	e164, err := phone.Normalize(raw, region)
+	if err != nil {
+		tracing.WithCtx(ctx, "UserService.normalizePhone").WithError(err).Error("error_400: Phone number invalid")
+		return "", ginext.NewError(http.StatusBadRequest, "Phone number invalid: "+err.Error())
+	}
+	return e164, nil
+}
+
+func newOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
+
+// hashOTPCode binds the code to the phone number, the key keeps the 6 digits from being brute forced offline
+func hashOTPCode(e164, code string) string {
+	mac := hmac.New(sha256.New, []byte(conf.LoadEnv().JWTSecret))
+	mac.Write([]byte(e164 + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/sms"
+)
+
+const (
+	phoneTestNumber = "+84912345678"
+	phoneTestOther  = "+84987654321"
+)
+
+// newPhoneTestService sends the codes to a fake sender, env sets the OTP settings of the test
+func newPhoneTestService(t *testing.T, env map[string]string) (*UserService, *sms.FakeSender) {
+	t.Helper()
+	t.Cleanup(func() { _ = conf.SetEnv() })
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	if err := conf.SetEnv(); err != nil {
+		t.Fatal(err)
+	}
+	sender := sms.NewFakeSender()
+	return NewUserService(repo.NewMemoryRepo(), sender, nil).(*UserService), sender
+}
+
+var otpCodePattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// requestCode asks a sign-in code for phone and returns the code of the SMS
+func requestCode(t *testing.T, s *UserService, sender *sms.FakeSender, phone string) string {
+	t.Helper()
+	if _, err := s.RequestPhoneLoginCode(context.Background(), model.PhoneOTPReq{PhoneNumber: &phone}); err != nil {
+		t.Fatalf("RequestPhoneLoginCode %s: %v", phone, err)
+	}
+	sent := sender.Sent()
+	msg := sent[len(sent)-1]
+	if msg.To != phone || !otpCodePattern.MatchString(msg.Body) {
+		t.Fatalf("SMS = %+v, want a code sent to %s", msg, phone)
+	}
+	return otpCodePattern.FindString(msg.Body)
+}
+
+func loginWithCode(s *UserService, phone, code string) (model.ConfirmLoginResponse, error) {
+	return s.LoginWithPhone(context.Background(), model.PhoneLoginReq{PhoneNumber: &phone, Code: &code})
+}
+
+// wrongCode is a code of the same length that is not code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestPhoneLoginCode(t *testing.T) {
+	ctx := context.Background()
+	s, sender := newPhoneTestService(t, map[string]string{"OTP_RESEND_INTERVAL_SECONDS": "0"})
+	code := requestCode(t, s, sender, phoneTestNumber)
+
+	// a wrong code is an attempt
+	if _, err := loginWithCode(s, phoneTestNumber, wrongCode(code)); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("login with a wrong code status = %d (%v), want 401", errStatus(err), err)
+	}
+	if otp, err := s.repo.GetLatestPhoneOTP(ctx, phoneTestNumber, model.OTPPurposeLogin, nil); err != nil || otp.Attempts != 1 {
+		t.Errorf("attempts after a wrong code = %d, %v, want 1", otp.Attempts, err)
+	}
+
+	// the first sign-in creates the account, the code is used up
+	session, err := loginWithCode(s, phoneTestNumber, code)
+	if err != nil || session.Token == "" {
+		t.Fatalf("login = %+v, %v", session, err)
+	}
+	user, err := s.repo.GetOneUserByPhone(ctx, phoneTestNumber, nil)
+	if err != nil || user.PhoneVerifiedAt == nil {
+		t.Errorf("user of the phone = %+v, %v, want a verified phone", user, err)
+	}
+	if _, err = loginWithCode(s, phoneTestNumber, code); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("replay the code status = %d (%v), want 401", errStatus(err), err)
+	}
+}
+
+func TestPhoneCodeLockout(t *testing.T) {
+	s, sender := newPhoneTestService(t, map[string]string{"OTP_MAX_ATTEMPTS": "3"})
+	code := requestCode(t, s, sender, phoneTestNumber)
+
+	for i := 0; i < 3; i++ {
+		if _, err := loginWithCode(s, phoneTestNumber, wrongCode(code)); errStatus(err) != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d status = %d (%v), want 401", i+1, errStatus(err), err)
+		}
+	}
+	// once the attempts are spent even the right code is refused
+	if _, err := loginWithCode(s, phoneTestNumber, code); errStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("login after 3 wrong codes status = %d (%v), want 429", errStatus(err), err)
+	}
+}
+
+func TestPhoneCodeExpires(t *testing.T) {
+	ctx := context.Background()
+	s, sender := newPhoneTestService(t, nil)
+	code := requestCode(t, s, sender, phoneTestNumber)
+
+	otp, err := s.repo.GetLatestPhoneOTP(ctx, phoneTestNumber, model.OTPPurposeLogin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otp.ExpiresAt = time.Now().Add(-time.Second)
+	if err = s.repo.CreatePhoneOTP(ctx, &otp, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loginWithCode(s, phoneTestNumber, code); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("login with an expired code status = %d (%v), want 401", errStatus(err), err)
+	}
+}
+
+func TestPhoneCodeRateLimits(t *testing.T) {
+	phone := phoneTestNumber
+
+	// a code is not sent again within the resend interval
+	s, sender := newPhoneTestService(t, map[string]string{"OTP_RESEND_INTERVAL_SECONDS": "60"})
+	requestCode(t, s, sender, phone)
+	if _, err := s.RequestPhoneLoginCode(context.Background(), model.PhoneOTPReq{PhoneNumber: &phone}); errStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("request again within the interval status = %d (%v), want 429", errStatus(err), err)
+	}
+	if len(sender.Sent()) != 1 {
+		t.Errorf("%d SMS sent, want 1", len(sender.Sent()))
+	}
+
+	// nor more than OTP_MAX_PER_HOUR an hour
+	s, sender = newPhoneTestService(t, map[string]string{"OTP_RESEND_INTERVAL_SECONDS": "0", "OTP_MAX_PER_HOUR": "2"})
+	requestCode(t, s, sender, phone)
+	requestCode(t, s, sender, phone)
+	if _, err := s.RequestPhoneLoginCode(context.Background(), model.PhoneOTPReq{PhoneNumber: &phone}); errStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("request over the hourly limit status = %d (%v), want 429", errStatus(err), err)
+	}
+	if len(sender.Sent()) != 2 {
+		t.Errorf("%d SMS sent, want 2", len(sender.Sent()))
+	}
+	// the limit is per number
+	requestCode(t, s, sender, phoneTestOther)
+}
+
+func TestPhoneCodeIsBoundToItsNumber(t *testing.T) {
+	s, sender := newPhoneTestService(t, map[string]string{"OTP_RESEND_INTERVAL_SECONDS": "0"})
+	code := requestCode(t, s, sender, phoneTestNumber)
+
+	// without a pending code, then with a pending code of its own
+	if _, err := loginWithCode(s, phoneTestOther, code); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("login to another number status = %d (%v), want 401", errStatus(err), err)
+	}
+	otherCode := requestCode(t, s, sender, phoneTestOther)
+	if otherCode != code {
+		if _, err := loginWithCode(s, phoneTestOther, code); errStatus(err) != http.StatusUnauthorized {
+			t.Errorf("login to another number with a pending code status = %d (%v), want 401", errStatus(err), err)
+		}
+	}
+	if _, err := loginWithCode(s, phoneTestNumber, code); err != nil {
+		t.Errorf("login to the number of the code: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/tracing"
+	"time"
+)
+
+// PurgeConfig tunes the deletion of the expired rows
+type PurgeConfig struct {
+	Interval time.Duration
+	// Retention is how long the rows are kept after they expire, at least the hour
+	// the codes and links sent to a phone or a user are counted over
+	Retention time.Duration
+}
+
+// PurgeWorker deletes the one-time codes, sign-in requests and refresh tokens nobody can use anymore
+type PurgeWorker struct {
+	repo repo.PGInterface
+	cfg  PurgeConfig
+}
+
+func NewPurgeWorker(repo repo.PGInterface, cfg PurgeConfig) *PurgeWorker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	if cfg.Retention < time.Hour {
+		cfg.Retention = time.Hour
+	}
+	return &PurgeWorker{repo: repo, cfg: cfg}
+}
+
+// Run purges every Interval until ctx is done
+func (w *PurgeWorker) Run(ctx context.Context) {
+	log := tracing.WithCtx(ctx, "PurgeWorker.Run")
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.PurgeOnce(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Error("failed to purge expired rows")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce deletes the rows expired for longer than Retention and returns how many were deleted by table
+func (w *PurgeWorker) PurgeOnce(ctx context.Context) (map[string]int64, error) {
+	log := tracing.WithCtx(ctx, "PurgeWorker.PurgeOnce")
+	rs, err := w.repo.PurgeExpired(ctx, time.Now().Add(-w.cfg.Retention), nil)
+	if err != nil {
+		return rs, err
+	}
+	for table, n := range rs {
+		if n > 0 {
+			log.WithField("table", table).WithField("deleted", n).Info("purged expired rows")
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,462 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/saml"
+	"ms-user/pkg/tracing"
+)
+
+// SAMLLoginPath is the route sending the browser to the identity provider of a business,
+// the assertion consumer service and the SP metadata are below it
+const SAMLLoginPath = "/api/v1/user/login/saml/"
+
+// IsSAMLACSPath tells whether path is the assertion consumer service of a business, the identity provider posts a form to it
+func IsSAMLACSPath(path string) bool {
+	return strings.HasPrefix(path, SAMLLoginPath) && strings.HasSuffix(path, "/acs")
+}
+
+const samlFailedMessage = "The sign-in with the identity provider of the business failed, try again"
+
+var errUnknownSAMLConnection = ginext.NewError(http.StatusNotFound, "The business has no SAML sign-in")
+
+// samlProvider is the provider of the identities the identity provider of a business signs in
+func samlProvider(businessID uuid.UUID) string {
+	return "saml:" + businessID.String()
+}
+
+// samlServiceProvider returns ms-user as the service provider of the connection.
+// Every business has its own entity ID, so an assertion made for one business is refused by the others.
+func samlServiceProvider(conn model.SAMLConnection) (saml.ServiceProvider, error) {
+	certs, err := saml.ParseCertificates(conn.IdPCertificates)
+	base := strings.TrimRight(conf.LoadEnv().PublicBaseURL, "/") + SAMLLoginPath + conn.BusinessID.String()
+	return saml.ServiceProvider{
+		EntityID: base + "/metadata",
+		ACSURL:   base + "/acs",
+		IdP:      saml.IdPMetadata{EntityID: conn.IdPEntityID, SSOURL: conn.IdPSSOURL, Certificates: certs},
+	}, err
+}
+
+// samlConnection returns the connection of the business, a 404 when it has none or it is disabled and enabled is set
+func (s *UserService) samlConnection(ctx context.Context, businessID uuid.UUID, enabled bool) (rs model.SAMLConnection, err error) {
+	rs, err = s.repo.GetSAMLConnection(ctx, businessID, nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && enabled && !rs.Enabled) {
+		tracing.WithCtx(ctx, "UserService.samlConnection").WithField("business_id", businessID).
+			Error("error_404: no enabled SAML connection")
+		return rs, errUnknownSAMLConnection
+	}
+	return rs, err
+}
+
+// SAMLMetadata returns the SP metadata document the identity provider of the business is configured with
+func (s *UserService) SAMLMetadata(ctx context.Context, businessID uuid.UUID) ([]byte, error) {
+	conn, err := s.samlConnection(ctx, businessID, false)
+	if err != nil {
+		return nil, err
+	}
+	sp, _ := samlServiceProvider(conn)
+	rs, err := sp.Metadata()
+	if err != nil {
+		tracing.WithCtx(ctx, "UserService.SAMLMetadata").WithError(err).Error("error_500: cannot write the metadata")
+		return nil, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	return rs, nil
+}
+
+// StartSAMLLogin sends an AuthnRequest to the identity provider of the business and returns the URL to send the browser to.
+// relayState must be kept by the browser, e.g. in a cookie, and given back to FinishSAMLLogin.
+func (s *UserService) StartSAMLLogin(ctx context.Context, businessID uuid.UUID, req model.StartSAMLLoginReq) (authURL, relayState string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.StartSAMLLogin")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.StartSAMLLogin").WithField("business_id", businessID)
+
+	conn, err := s.samlConnection(ctx, businessID, true)
+	if err != nil {
+		return "", "", err
+	}
+	sp, err := samlServiceProvider(conn)
+	if err != nil {
+		log.WithError(err).Error("error_500: invalid certificates in the SAML connection")
+		return "", "", ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+
+	requestID, err := saml.NewID()
+	if err == nil {
+		relayState, err = randomToken()
+	}
+	now := time.Now()
+	if err == nil {
+		authURL, err = sp.AuthnRequestURL(requestID, relayState, now)
+	}
+	if err != nil {
+		log.WithError(err).Error("error_500: cannot make the AuthnRequest")
+		return "", "", ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	err = s.repo.CreateSAMLAuthRequest(ctx, &model.SAMLAuthRequest{
+		ID:             uuid.New(),
+		RequestID:      requestID,
+		BusinessID:     businessID,
+		RelayStateHash: hashNonce(relayState),
+		DeviceID:       strings.TrimSpace(req.DeviceID),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(time.Duration(conf.LoadEnv().SAMLStateTTLSeconds) * time.Second),
+	}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, relayState, nil
+}
+
+// FinishSAMLLogin handles the Response the identity provider makes the browser post to the assertion consumer service:
+// the assertion is verified and the user it names signed in. cookieState is the relay state of StartSAMLLogin.
+// A user is found by the linked identity, else by the email, else created; the email must be of a domain of the business.
+func (s *UserService) FinishSAMLLogin(ctx context.Context, businessID uuid.UUID, req model.SAMLACSReq, cookieState string) (rs model.ConfirmLoginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.FinishSAMLLogin")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.FinishSAMLLogin").WithField("business_id", businessID)
+
+	// the user is not known before the assertion is verified, failures are only audited
+	reject := func(reason string, cause error) error {
+		log.WithError(cause).WithField("reason", reason).Error("error_401: saml sign-in rejected")
+		metrics.Logins.Inc(reason)
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, nil, map[string]interface{}{
+			"method": "saml", "business_id": businessID.String(), "reason": reason,
+		}))
+		return ginext.NewError(http.StatusUnauthorized, samlFailedMessage)
+	}
+
+	conn, err := s.samlConnection(ctx, businessID, true)
+	if err != nil {
+		return rs, err
+	}
+	if req.RelayState == "" || subtle.ConstantTimeCompare([]byte(req.RelayState), []byte(cookieState)) != 1 {
+		return rs, reject(metrics.LoginOtherBrowser, errors.New("relay state does not match the cookie"))
+	}
+	authReq, err := s.repo.GetSAMLAuthRequestByRelayState(ctx, hashNonce(req.RelayState), nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && authReq.BusinessID != businessID) {
+		return rs, reject(metrics.LoginBadState, errors.New("unknown relay state"))
+	}
+	if err != nil {
+		return rs, err
+	}
+	now := time.Now()
+	if authReq.ConsumedAt != nil || !now.Before(authReq.ExpiresAt) {
+		return rs, reject(metrics.LoginExpiredState, errors.New("request answered or expired"))
+	}
+	ok, err := s.repo.ConsumeSAMLAuthRequest(ctx, authReq.ID, now, nil)
+	if err != nil {
+		return rs, err
+	}
+	if !ok {
+		return rs, reject(metrics.LoginExpiredState, errors.New("request answered concurrently"))
+	}
+
+	sp, err := samlServiceProvider(conn)
+	if err != nil {
+		log.WithError(err).Error("error_500: invalid certificates in the SAML connection")
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	assertion, err := sp.ParseResponse(req.SAMLResponse, authReq.RequestID, now)
+	if err != nil {
+		return rs, reject(metrics.LoginSAMLRejected, err)
+	}
+	email, err := samlEmail(conn, assertion)
+	if err != nil {
+		return rs, reject(metrics.LoginSAMLRejected, err)
+	}
+	// a transient NameID changes at every sign-in, the email identifies the user then
+	subject := assertion.NameID
+	if assertion.NameIDFormat == saml.NameIDFormatTransient {
+		subject = email
+	}
+	if len(subject) > 255 {
+		return rs, reject(metrics.LoginSAMLRejected, errors.New("the NameID is longer than 255 characters"))
+	}
+
+	user, err := s.samlUser(ctx, conn, subject, email, assertion.Attribute(conn.NameAttribute))
+	if err != nil {
+		return rs, err
+	}
+	span.SetAttributes(tracing.Attributes("user.id", user.ID.String())...)
+
+	return s.signIn(ctx, user, authReq.DeviceID, map[string]interface{}{
+		"method": "saml", "business_id": businessID.String(), "email": email,
+	})
+}
+
+// samlEmail returns the normalized email of the assertion, it must be of a domain of the business
+func samlEmail(conn model.SAMLConnection, assertion saml.Assertion) (string, error) {
+	email := assertion.NameID
+	if conn.EmailAttribute != "" {
+		email = assertion.Attribute(conn.EmailAttribute)
+	}
+	email = model.NormalizeEmail(email)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "", fmt.Errorf("the assertion has no email, got %q", email)
+	}
+	if domain := email[strings.LastIndex(email, "@")+1:]; !conn.Domains.Contains(domain) {
+		return "", fmt.Errorf("the email %q is not of a domain of the business", email)
+	}
+	return email, nil
+}
+
+// samlUser returns the user of the identity, linking or creating it on its first sign-in.
+// A concurrent first sign-in of the same identity or email makes the first try fail with a 409, the second finds the user.
+func (s *UserService) samlUser(ctx context.Context, conn model.SAMLConnection, subject, email, name string) (rs model.User, err error) {
+	for attempt := 0; ; attempt++ {
+		rs, err = s.findOrCreateSAMLUser(ctx, conn, subject, email, name)
+		var apiErr ginext.ApiError
+		if attempt > 0 || !errors.As(err, &apiErr) || apiErr.Code() != http.StatusConflict {
+			return rs, err
+		}
+	}
+}
+
+func (s *UserService) findOrCreateSAMLUser(ctx context.Context, conn model.SAMLConnection, subject, email, name string) (rs model.User, err error) {
+	provider := samlProvider(conn.BusinessID)
+	log := tracing.WithCtx(ctx, "UserService.findOrCreateSAMLUser").WithField("provider", provider)
+
+	identity, err := s.repo.GetUserIdentity(ctx, provider, subject, nil)
+	if err == nil {
+		rs, err = s.repo.GetOneUserByID(ctx, identity.UserID, nil)
+		if err == gorm.ErrRecordNotFound {
+			log.Error("error_401: the linked user is deleted")
+			return rs, ginext.NewError(http.StatusUnauthorized, samlFailedMessage)
+		}
+		return rs, err
+	}
+	if err != gorm.ErrRecordNotFound {
+		return rs, err
+	}
+
+	// the business owns the domain of the email, its identity provider is trusted with the accounts using it
+	identity = model.UserIdentity{
+		ID:        uuid.New(),
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+	rs, err = s.repo.GetOneUserByEmail(ctx, email, nil)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return rs, err
+	}
+	if err == nil {
+		identity.UserID = rs.ID
+		err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+			if err := rp.CreateUserIdentity(ctx, &identity, nil); err != nil {
+				return err
+			}
+			return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditIdentityLinked, &rs.ID, map[string]interface{}{
+				"provider": provider, "subject": subject, "email": email,
+			}))
+		})
+		return rs, err
+	}
+
+	// a user the business deactivated or deprovisioned over SCIM does not come back as a new account
+	if _, err = s.repo.GetSCIMUserByEmail(ctx, conn.BusinessID, email, nil); err == nil {
+		log.Error("error_401: the business deactivated the user")
+		return rs, ginext.NewError(http.StatusUnauthorized, samlFailedMessage)
+	} else if err != gorm.ErrRecordNotFound {
+		return rs, err
+	}
+
+	rs = model.User{Email: email, FullName: name}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, &rs, nil); err != nil {
+			return err
+		}
+		identity.UserID = rs.ID
+		if err := rp.CreateUserIdentity(ctx, &identity, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRegistered, &rs.ID, map[string]interface{}{
+			"email": rs.Email, "method": "saml", "business_id": conn.BusinessID.String(),
+		}))
+	})
+	if err != nil {
+		return rs, err
+	}
+	metrics.Registrations.Inc()
+	return rs, nil
+}
+
+type SAMLService struct {
+	repo repo.PGInterface
+}
+
+func NewSAMLService(repo repo.PGInterface) SAMLInterface {
+	return &SAMLService{repo: repo}
+}
+
+// SAMLInterface manages the SAML identity providers of the businesses, the sign-ins are served by UserInterface
+type SAMLInterface interface {
+	SaveConnection(ctx context.Context, businessID uuid.UUID, req model.SaveSAMLConnectionReq) (rs model.SAMLConnection, err error)
+	GetConnection(ctx context.Context, businessID uuid.UUID) (model.SAMLConnection, error)
+	ListConnections(ctx context.Context) ([]model.SAMLConnection, error)
+	DeleteConnection(ctx context.Context, businessID uuid.UUID) error
+}
+
+// SaveConnection configures the identity provider of the business. Replacing it by another identity provider
+// unlinks the identities of the former one: its subjects mean nothing to the new one.
+func (s *SAMLService) SaveConnection(ctx context.Context, businessID uuid.UUID, req model.SaveSAMLConnectionReq) (rs model.SAMLConnection, err error) {
+	ctx, span := tracing.Start(ctx, "SAMLService.SaveConnection")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "SAMLService.SaveConnection").WithField("business_id", businessID)
+
+	conn, err := newSAMLConnection(businessID, req)
+	if err != nil {
+		log.WithError(err).Error("error_400: invalid SAML connection")
+		return rs, ginext.NewError(http.StatusBadRequest, err.Error())
+	}
+	if actorID, ok := audit.ActorFromContext(ctx); ok {
+		conn.CreatedBy = &actorID
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		previous, err := rp.GetSAMLConnection(ctx, businessID, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		var unlinked int64
+		if err == nil && previous.IdPEntityID != conn.IdPEntityID {
+			if unlinked, err = rp.DeleteUserIdentities(ctx, samlProvider(businessID), nil); err != nil {
+				return err
+			}
+		}
+		if err = rp.SaveSAMLConnection(ctx, &conn, nil); err != nil {
+			return err
+		}
+		if rs, err = rp.GetSAMLConnection(ctx, businessID, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditSAMLConnectionSaved, nil, map[string]interface{}{
+			"business_id": businessID.String(), "idp_entity_id": conn.IdPEntityID, "domains": conn.Domains.String(),
+			"enabled": conn.Enabled, "unlinked_identities": unlinked,
+		}))
+	})
+	return rs, err
+}
+
+// newSAMLConnection checks the request and returns the connection it configures
+func newSAMLConnection(businessID uuid.UUID, req model.SaveSAMLConnectionReq) (rs model.SAMLConnection, err error) {
+	idp := saml.IdPMetadata{EntityID: strings.TrimSpace(req.IdPEntityID), SSOURL: strings.TrimSpace(req.IdPSSOURL)}
+	switch {
+	case strings.TrimSpace(req.MetadataXML) != "":
+		if idp.EntityID != "" || idp.SSOURL != "" || strings.TrimSpace(req.IdPCertificates) != "" {
+			return rs, errors.New("give metadata_xml or idp_entity_id, idp_sso_url and idp_certificates, not both")
+		}
+		if idp, err = saml.ParseIdPMetadata([]byte(req.MetadataXML)); err != nil {
+			return rs, err
+		}
+	case idp.EntityID == "" || idp.SSOURL == "":
+		return rs, errors.New("metadata_xml, or idp_entity_id, idp_sso_url and idp_certificates are required")
+	default:
+		if idp.Certificates, err = saml.ParseCertificates(req.IdPCertificates); err != nil {
+			return rs, err
+		}
+	}
+	if len(idp.EntityID) > 1024 {
+		return rs, errors.New("the entity ID of the identity provider is longer than 1024 characters")
+	}
+	if !strings.HasPrefix(idp.SSOURL, "https://") && !strings.HasPrefix(idp.SSOURL, "http://") {
+		return rs, fmt.Errorf("the SSO URL must be an http(s) URL, got %q", idp.SSOURL)
+	}
+
+	var domains model.SpaceList
+	for _, d := range req.Domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if !strings.Contains(d, ".") || strings.ContainsAny(d, "@/: \t") {
+			return rs, fmt.Errorf("%q is not an email domain", d)
+		}
+		if !domains.Contains(d) {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 {
+		return rs, errors.New("domains must name the email domains of the business")
+	}
+
+	now := time.Now()
+	rs = model.SAMLConnection{
+		ID:              uuid.New(),
+		BusinessID:      businessID,
+		IdPEntityID:     idp.EntityID,
+		IdPSSOURL:       idp.SSOURL,
+		IdPCertificates: saml.EncodeCertificates(idp.Certificates),
+		EmailAttribute:  strings.TrimSpace(req.EmailAttribute),
+		NameAttribute:   strings.TrimSpace(req.NameAttribute),
+		Domains:         domains,
+		Enabled:         req.Enabled == nil || *req.Enabled,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	return rs, nil
+}
+
+func (s *SAMLService) GetConnection(ctx context.Context, businessID uuid.UUID) (model.SAMLConnection, error) {
+	rs, err := s.repo.GetSAMLConnection(ctx, businessID, nil)
+	if err == gorm.ErrRecordNotFound {
+		return rs, errUnknownSAMLConnection
+	}
+	return rs, err
+}
+
+func (s *SAMLService) ListConnections(ctx context.Context) ([]model.SAMLConnection, error) {
+	return s.repo.ListSAMLConnections(ctx, nil)
+}
+
+// DeleteConnection deletes the connection of the business and unlinks the identities it signed in.
+// The accounts and their sessions are kept, they sign in another way from now on.
+func (s *SAMLService) DeleteConnection(ctx context.Context, businessID uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "SAMLService.DeleteConnection")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "SAMLService.DeleteConnection").WithField("business_id", businessID)
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.DeleteSAMLConnection(ctx, businessID, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Error("error_404: unknown SAML connection")
+			return errUnknownSAMLConnection
+		}
+		unlinked, err := rp.DeleteUserIdentities(ctx, samlProvider(businessID), nil)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditSAMLConnectionDeleted, nil, map[string]interface{}{
+			"business_id": businessID.String(), "unlinked_identities": unlinked,
+		}))
+	})
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/saml/samltest"
+)
+
+const samlTestDomain = "acme.example"
+
+// newSAMLTestService connects a business to a fake identity provider
+func newSAMLTestService(t *testing.T) (*UserService, *SAMLService, *samltest.IdP, uuid.UUID) {
+	idp, err := samltest.NewIdP("https://idp.test/metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := repo.NewMemoryRepo()
+	admin := NewSAMLService(r).(*SAMLService)
+	businessID := uuid.New()
+	_, err = admin.SaveConnection(context.Background(), businessID, model.SaveSAMLConnectionReq{
+		MetadataXML: string(idp.Metadata()), Domains: []string{samlTestDomain},
+	})
+	if err != nil {
+		t.Fatalf("SaveConnection: %v", err)
+	}
+	return NewUserService(r, nil, nil).(*UserService), admin, idp, businessID
+}
+
+// samlLogin runs a sign-in answered by idp with opts and returns the user of the access token.
+// tamper is "drop-cookie" to post without the relay state of the cookie, "replay" to post twice,
+// "comment" to cut the NameID after the domain of the business with a comment once signed.
+func samlLogin(t *testing.T, s *UserService, businessID uuid.UUID, idp *samltest.IdP, opts samltest.ResponseOptions, tamper string, want int) (userID string) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, relayState, err := s.StartSAMLLogin(ctx, businessID, model.StartSAMLLoginReq{DeviceID: "saml-test"})
+	if err != nil {
+		t.Fatalf("StartSAMLLogin: %v", err)
+	}
+	if opts.Request, err = samltest.ParseAuthnRequest(authURL); err != nil {
+		t.Fatalf("ParseAuthnRequest: %v", err)
+	}
+	resp, err := idp.ResponseElement(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(resp.Bytes())
+	if tamper == "comment" {
+		cut := strings.Replace(opts.NameID, "@"+samlTestDomain+".", "@"+samlTestDomain+"<!---->.", 1)
+		doc = strings.Replace(doc, ">"+opts.NameID+"<", ">"+cut+"<", 1)
+	}
+	req := model.SAMLACSReq{SAMLResponse: base64.StdEncoding.EncodeToString([]byte(doc)), RelayState: opts.Request.RelayState}
+
+	cookieState := relayState
+	if tamper == "drop-cookie" {
+		cookieState = ""
+	}
+	rs, err := s.FinishSAMLLogin(ctx, businessID, req, cookieState)
+	if tamper == "replay" {
+		if err != nil {
+			t.Fatalf("post before the replay: %v", err)
+		}
+		rs, err = s.FinishSAMLLogin(ctx, businessID, req, cookieState)
+	}
+	if got := errStatus(err); got != want {
+		t.Fatalf("FinishSAMLLogin status = %d (%v), want %d", got, err, want)
+	}
+	if want != http.StatusOK {
+		return ""
+	}
+	_, id, err := s.AuthenticateAccessToken(ctx, rs.Token)
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	return id.String()
+}
+
+func TestSAMLLoginCreatesAndLinksAccounts(t *testing.T) {
+	s, _, idp, businessID := newSAMLTestService(t)
+
+	// a new email of the business creates an account, the next sign-in finds it by the identity
+	alice := samltest.ResponseOptions{NameID: "alice@" + samlTestDomain}
+	first := samlLogin(t, s, businessID, idp, alice, "", http.StatusOK)
+	if again := samlLogin(t, s, businessID, idp, alice, "", http.StatusOK); again != first {
+		t.Errorf("second sign-in of an identity: user %s, want %s", again, first)
+	}
+
+	// an email of the business used by a password account links the identity to it
+	email, password := "bob@"+samlTestDomain, "Passw0rd!saml"
+	bob, err := s.CreateUser(context.Background(), model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	linked := samlLogin(t, s, businessID, idp, samltest.ResponseOptions{NameID: "Bob@" + strings.ToUpper(samlTestDomain)}, "", http.StatusOK)
+	if linked != bob.ID.String() {
+		t.Errorf("sign-in with the email of an account: user %s, want %s", linked, bob.ID)
+	}
+}
+
+func TestSAMLLoginRejects(t *testing.T) {
+	s, _, idp, businessID := newSAMLTestService(t)
+	alice := samltest.ResponseOptions{NameID: "alice@" + samlTestDomain}
+
+	t.Run("email of another domain", func(t *testing.T) {
+		samlLogin(t, s, businessID, idp, samltest.ResponseOptions{NameID: "eve@evil.example"}, "", http.StatusUnauthorized)
+	})
+	t.Run("unsigned", func(t *testing.T) {
+		samlLogin(t, s, businessID, idp, samltest.ResponseOptions{NameID: alice.NameID, SkipAssertionSignature: true}, "", http.StatusUnauthorized)
+	})
+	t.Run("without the state cookie", func(t *testing.T) {
+		samlLogin(t, s, businessID, idp, alice, "drop-cookie", http.StatusUnauthorized)
+	})
+	t.Run("replayed", func(t *testing.T) {
+		samlLogin(t, s, businessID, idp, alice, "replay", http.StatusUnauthorized)
+	})
+	t.Run("comment in the NameID", func(t *testing.T) {
+		opts := samltest.ResponseOptions{NameID: "alice@" + samlTestDomain + ".evil.example"}
+		samlLogin(t, s, businessID, idp, opts, "comment", http.StatusUnauthorized)
+	})
+}
+
+func TestSAMLLoginOfDisabledConnection(t *testing.T) {
+	s, admin, idp, businessID := newSAMLTestService(t)
+	ctx := context.Background()
+	disabled := false
+	_, err := admin.SaveConnection(ctx, businessID, model.SaveSAMLConnectionReq{
+		MetadataXML: string(idp.Metadata()), Domains: []string{samlTestDomain}, Enabled: &disabled,
+	})
+	if err != nil {
+		t.Fatalf("SaveConnection: %v", err)
+	}
+	if _, _, err = s.StartSAMLLogin(ctx, businessID, model.StartSAMLLoginReq{}); errStatus(err) != http.StatusNotFound {
+		t.Errorf("StartSAMLLogin of a disabled connection err = %v, want 404", err)
+	}
+	// the identity provider keeps reading the metadata of a disabled connection
+	if _, err = s.SAMLMetadata(ctx, businessID); err != nil {
+		t.Errorf("SAMLMetadata of a disabled connection: %v", err)
+	}
+
+	if err = admin.DeleteConnection(ctx, businessID); err != nil {
+		t.Fatalf("DeleteConnection: %v", err)
+	}
+	if _, err = admin.GetConnection(ctx, businessID); errStatus(err) != http.StatusNotFound {
+		t.Errorf("GetConnection of a deleted connection err = %v, want 404", err)
+	}
+}
@@ -0,0 +1,918 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/goxp/cloud0/ginext"
+	"gorm.io/gorm"
+
+	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/scim"
+	"ms-user/pkg/tracing"
+)
+
+// SCIMPath is the base of the SCIM endpoints, the directories of the businesses are configured with it
+const SCIMPath = "/scim/v2"
+
+// SCIMMaxResults bounds the resources of a page of a listing
+const SCIMMaxResults = 200
+
+// scimTokenPrefix marks the SCIM tokens, so a leaked one is recognized
+const scimTokenPrefix = "scim_"
+
+var errUnknownSCIMToken = ginext.NewError(http.StatusNotFound, "Unknown SCIM token")
+
+type SCIMService struct {
+	repo repo.PGInterface
+}
+
+func NewSCIMService(repo repo.PGInterface) SCIMInterface {
+	return &SCIMService{repo: repo}
+}
+
+// SCIMInterface serves the SCIM provisioning of the businesses and manages their tokens.
+// The provisioning methods answer *scim.Error for the errors of the client.
+type SCIMInterface interface {
+	CreateToken(ctx context.Context, req model.CreateSCIMTokenReq) (rs model.CreateSCIMTokenResponse, err error)
+	ListTokens(ctx context.Context, req model.ListSCIMTokensReq) ([]model.SCIMToken, error)
+	DeleteToken(ctx context.Context, id uuid.UUID) error
+	Authenticate(ctx context.Context, token string) (businessID uuid.UUID, err error)
+
+	ListUsers(ctx context.Context, businessID uuid.UUID, req model.SCIMListReq) (rs scim.ListResponse, err error)
+	GetUser(ctx context.Context, businessID, id uuid.UUID) (rs scim.User, err error)
+	CreateUser(ctx context.Context, businessID uuid.UUID, req scim.User) (rs scim.User, err error)
+	ReplaceUser(ctx context.Context, businessID, id uuid.UUID, req scim.User) (rs scim.User, err error)
+	PatchUser(ctx context.Context, businessID, id uuid.UUID, req scim.PatchRequest) (rs scim.User, err error)
+	DeleteUser(ctx context.Context, businessID, id uuid.UUID) error
+
+	ListGroups(ctx context.Context, businessID uuid.UUID, req model.SCIMListReq) (rs scim.ListResponse, err error)
+	GetGroup(ctx context.Context, businessID, id uuid.UUID) (rs scim.Group, err error)
+	CreateGroup(ctx context.Context, businessID uuid.UUID, req scim.Group) (rs scim.Group, err error)
+	ReplaceGroup(ctx context.Context, businessID, id uuid.UUID, req scim.Group) (rs scim.Group, err error)
+	PatchGroup(ctx context.Context, businessID, id uuid.UUID, req scim.PatchRequest) (rs scim.Group, err error)
+	DeleteGroup(ctx context.Context, businessID, id uuid.UUID) error
+}
+
+// CreateToken makes a token the directory of the business provisions with, it is only returned here
+func (s *SCIMService) CreateToken(ctx context.Context, req model.CreateSCIMTokenReq) (rs model.CreateSCIMTokenResponse, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.CreateToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	secret, err := randomToken()
+	if err != nil {
+		tracing.WithCtx(ctx, "SCIMService.CreateToken").WithError(err).Error("error_500: cannot read random bytes")
+		return rs, ginext.NewError(http.StatusInternalServerError, err.Error())
+	}
+	rs.Token = scimTokenPrefix + secret
+	rs.SCIMToken = model.SCIMToken{
+		ID:          uuid.New(),
+		BusinessID:  req.BusinessID,
+		Description: strings.TrimSpace(req.Description),
+		TokenHash:   hashNonce(rs.Token),
+		CreatedAt:   time.Now(),
+	}
+	if actorID, ok := audit.ActorFromContext(ctx); ok {
+		rs.CreatedBy = &actorID
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateSCIMToken(ctx, &rs.SCIMToken, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditSCIMTokenCreated, nil, map[string]interface{}{
+			"token_id": rs.ID.String(), "business_id": req.BusinessID.String(), "description": rs.Description,
+		}))
+	})
+	return rs, err
+}
+
+func (s *SCIMService) ListTokens(ctx context.Context, req model.ListSCIMTokensReq) ([]model.SCIMToken, error) {
+	var businessID *uuid.UUID
+	if req.BusinessID != "" {
+		id, err := uuid.Parse(req.BusinessID)
+		if err != nil {
+			return nil, ginext.NewError(http.StatusBadRequest, "Invalid input: business_id must be a UUID")
+		}
+		businessID = &id
+	}
+	return s.repo.ListSCIMTokens(ctx, businessID, nil)
+}
+
+// DeleteToken revokes the token, the directory using it is refused from now on
+func (s *SCIMService) DeleteToken(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.DeleteToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.DeleteSCIMToken(ctx, id, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			tracing.WithCtx(ctx, "SCIMService.DeleteToken").WithField("token_id", id).Error("error_404: unknown SCIM token")
+			return errUnknownSCIMToken
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditSCIMTokenDeleted, nil, map[string]interface{}{
+			"token_id": id.String(),
+		}))
+	})
+}
+
+// Authenticate returns the business of a SCIM token, a 401 scim.Error when the token is unknown
+func (s *SCIMService) Authenticate(ctx context.Context, token string) (businessID uuid.UUID, err error) {
+	if !strings.HasPrefix(token, scimTokenPrefix) {
+		return businessID, scim.NewError(http.StatusUnauthorized, "", "invalid SCIM token")
+	}
+	rs, err := s.repo.GetSCIMTokenByHash(ctx, hashNonce(token), nil)
+	if err == gorm.ErrRecordNotFound {
+		return businessID, scim.NewError(http.StatusUnauthorized, "", "invalid SCIM token")
+	}
+	if err != nil {
+		return businessID, err
+	}
+	return rs.BusinessID, nil
+}
+
+func scimLocation(resourceType string, id uuid.UUID) string {
+	return strings.TrimRight(conf.LoadEnv().PublicBaseURL, "/") + SCIMPath + "/" + resourceType + "s/" + id.String()
+}
+
+func scimNotFound(resourceType string, id uuid.UUID) *scim.Error {
+	return scim.NewError(http.StatusNotFound, "", resourceType+" "+id.String()+" not found")
+}
+
+func invalidValue(detail string) *scim.Error {
+	return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, detail)
+}
+
+// scimProfile is what a User resource sets on the account and on its SCIM membership
+type scimProfile struct {
+	user                  model.User
+	externalID            string
+	givenName, familyName string
+}
+
+// newSCIMProfile checks a User resource and maps it onto the fields of model.User: userName is the email of the
+// account, or the primary email when userName is not an address.
+func newSCIMProfile(req scim.User) (rs scimProfile, err error) {
+	email := strings.TrimSpace(req.UserName)
+	if email == "" {
+		return rs, invalidValue("userName is required")
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		email = ""
+		for _, e := range req.Emails {
+			if email == "" || e.Primary {
+				email = strings.TrimSpace(e.Value)
+			}
+		}
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			return rs, invalidValue("userName, or the primary email, must be an email address")
+		}
+	}
+
+	rs.user = model.User{Email: email, DisplayName: strings.TrimSpace(req.DisplayName), Link: strings.TrimSpace(req.ProfileURL)}
+	rs.externalID = strings.TrimSpace(req.ExternalID)
+	if req.Name != nil {
+		rs.givenName, rs.familyName = strings.TrimSpace(req.Name.GivenName), strings.TrimSpace(req.Name.FamilyName)
+		rs.user.FullName = strings.TrimSpace(req.Name.Formatted)
+		if rs.user.FullName == "" {
+			rs.user.FullName = strings.TrimSpace(rs.givenName + " " + rs.familyName)
+		}
+	}
+	rs.user.PhoneNumber = primaryValue(req.PhoneNumbers)
+	rs.user.Images = primaryValue(req.Photos)
+
+	for _, f := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"userName", rs.user.Email, 500}, {"name", rs.user.FullName, 255}, {"name.givenName", rs.givenName, 255},
+		{"name.familyName", rs.familyName, 255}, {"displayName", rs.user.DisplayName, 255},
+		{"profileUrl", rs.user.Link, 500}, {"phoneNumbers", rs.user.PhoneNumber, 50}, {"photos", rs.user.Images, 255},
+		{"externalId", rs.externalID, 255},
+	} {
+		if len(f.value) > f.max {
+			return rs, invalidValue(f.name + " is longer than " + strconv.Itoa(f.max) + " characters")
+		}
+	}
+	return rs, nil
+}
+
+// primaryValue returns the value of the primary element, of the first one when none is primary
+func primaryValue(values []scim.MultiValued) string {
+	rs := ""
+	for i, v := range values {
+		if i == 0 || v.Primary {
+			rs = strings.TrimSpace(v.Value)
+		}
+		if v.Primary {
+			break
+		}
+	}
+	return rs
+}
+
+// userResource is the User resource of the membership, groups are those the user is a member of
+func userResource(m model.SCIMUser, groups []scim.MultiValued) scim.User {
+	u := m.User
+	active := u.DeletedAt == nil
+	rs := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          u.ID.String(),
+		ExternalID:  m.ExternalID,
+		UserName:    u.Email,
+		DisplayName: u.DisplayName,
+		ProfileURL:  u.Link,
+		Active:      &active,
+		Emails:      []scim.MultiValued{{Value: u.Email, Type: "work", Primary: true}},
+		Groups:      groups,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      m.CreatedAt,
+			LastModified: m.UpdatedAt,
+			Location:     scimLocation("User", u.ID),
+		},
+	}
+	if u.UpdatedAt.After(m.UpdatedAt) {
+		rs.Meta.LastModified = u.UpdatedAt
+	}
+	if u.FullName != "" || m.GivenName != "" || m.FamilyName != "" {
+		rs.Name = &scim.Name{Formatted: u.FullName, GivenName: m.GivenName, FamilyName: m.FamilyName}
+	}
+	if u.PhoneNumber != "" {
+		rs.PhoneNumbers = []scim.MultiValued{{Value: u.PhoneNumber, Type: "work", Primary: true}}
+	}
+	if u.Images != "" {
+		rs.Photos = []scim.MultiValued{{Value: u.Images, Type: "photo", Primary: true}}
+	}
+	return rs
+}
+
+// scimUser returns the membership of the user in the business, a 404 when the business did not provision it
+func scimUser(ctx context.Context, rp repo.PGInterface, businessID, id uuid.UUID) (rs model.SCIMUser, err error) {
+	rs, err = rp.GetSCIMUser(ctx, id, nil)
+	if err == gorm.ErrRecordNotFound || (err == nil && (rs.BusinessID != businessID || rs.DeprovisionedAt != nil)) {
+		return rs, scimNotFound("User", id)
+	}
+	return rs, err
+}
+
+// userGroups returns the groups of each user, by user id
+func userGroups(ctx context.Context, rp repo.PGInterface, businessID uuid.UUID, userIDs []uuid.UUID) (map[uuid.UUID][]scim.MultiValued, error) {
+	members, err := rp.ListSCIMGroupMembers(ctx, businessID, nil, userIDs, nil)
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+	groups, err := rp.ListSCIMGroups(ctx, businessID, model.SCIMGroupFilter{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uuid.UUID]string, len(groups))
+	for _, g := range groups {
+		names[g.ID] = g.DisplayName
+	}
+	rs := map[uuid.UUID][]scim.MultiValued{}
+	for _, m := range members {
+		rs[m.UserID] = append(rs[m.UserID], scim.MultiValued{
+			Value: m.GroupID.String(), Display: names[m.GroupID], Ref: scimLocation("Group", m.GroupID), Type: "direct",
+		})
+	}
+	return rs, nil
+}
+
+func (s *SCIMService) GetUser(ctx context.Context, businessID, id uuid.UUID) (rs scim.User, err error) {
+	m, err := scimUser(ctx, s.repo, businessID, id)
+	if err != nil {
+		return rs, err
+	}
+	groups, err := userGroups(ctx, s.repo, businessID, []uuid.UUID{id})
+	if err != nil {
+		return rs, err
+	}
+	return userResource(m, groups[id]), nil
+}
+
+// ListUsers returns a page of the users the business provisioned that match the filter of req
+func (s *SCIMService) ListUsers(ctx context.Context, businessID uuid.UUID, req model.SCIMListReq) (rs scim.ListResponse, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.ListUsers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	f, err := parseSCIMFilter(req.Filter)
+	if err != nil {
+		return rs, err
+	}
+	// the lookups of the directories, by userName or externalId, are narrowed by the database
+	var narrow model.SCIMUserFilter
+	narrow.Email, _ = scim.EqualityValue(f, "userName")
+	narrow.ExternalID, _ = scim.EqualityValue(f, "externalId")
+
+	members, err := s.repo.ListSCIMUsers(ctx, businessID, narrow, nil)
+	if err != nil {
+		return rs, err
+	}
+	ids := make([]uuid.UUID, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	groups, err := userGroups(ctx, s.repo, businessID, ids)
+	if err != nil {
+		return rs, err
+	}
+	resources := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		resources = append(resources, userResource(m, groups[m.UserID]))
+	}
+	return scimPage(resources, f, req)
+}
+
+// CreateUser provisions a user in the business. A user the business deprovisioned comes back with its account;
+// an account that signed up by itself is taken over when the business owns the domain of its email, as the SAML
+// sign-in does.
+func (s *SCIMService) CreateUser(ctx context.Context, businessID uuid.UUID, req scim.User) (rs scim.User, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.CreateUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "SCIMService.CreateUser").WithField("business_id", businessID)
+
+	profile, err := newSCIMProfile(req)
+	if err != nil {
+		return rs, err
+	}
+	registered := false
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		now := time.Now()
+		member, err := rp.GetSCIMUserByEmail(ctx, businessID, profile.user.Email, nil)
+		switch {
+		case err == nil && member.DeprovisionedAt == nil:
+			log.Error("error_409: the user is already provisioned")
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName "+profile.user.Email+" is already provisioned")
+		case err == nil:
+			profile.user.ID = member.UserID
+			if err = rp.RestoreUser(ctx, member.UserID, nil); err != nil {
+				return err
+			}
+			if err = saveSCIMProfile(ctx, rp, &member, profile); err != nil {
+				return err
+			}
+			if err = RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserReactivated, &member.UserID, map[string]interface{}{
+				"business_id": businessID.String(), "reprovisioned": true,
+			})); err != nil {
+				return err
+			}
+			return setSCIMUserActive(ctx, rp, businessID, member.UserID, true, req.Active)
+		case err != gorm.ErrRecordNotFound:
+			return err
+		}
+
+		member = model.SCIMUser{BusinessID: businessID, ExternalID: profile.externalID, GivenName: profile.givenName,
+			FamilyName: profile.familyName, CreatedAt: now, UpdatedAt: now}
+		user, err := rp.GetOneUserByEmail(ctx, profile.user.Email, nil)
+		switch {
+		case err == nil:
+			if err = s.canTakeOver(ctx, rp, businessID, user); err != nil {
+				return err
+			}
+			profile.user.ID = user.ID
+			if err = rp.UpdateUserProfile(ctx, &profile.user, nil); err != nil {
+				return err
+			}
+			member.UserID = user.ID
+			if err = rp.CreateSCIMUser(ctx, &member, nil); err != nil {
+				return err
+			}
+			err = RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditIdentityLinked, &user.ID, map[string]interface{}{
+				"provider": "scim:" + businessID.String(), "email": user.Email,
+			}))
+		case err == gorm.ErrRecordNotFound:
+			// the account has no password, it signs in with the identity provider of the business
+			user = profile.user
+			if err = rp.CreateUser(ctx, &user, nil); err != nil {
+				return err
+			}
+			profile.user.ID, member.UserID = user.ID, user.ID
+			if err = rp.CreateSCIMUser(ctx, &member, nil); err != nil {
+				return err
+			}
+			registered = true
+			err = RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRegistered, &user.ID, map[string]interface{}{
+				"email": user.Email, "method": "scim", "business_id": businessID.String(),
+			}))
+		}
+		if err != nil {
+			return err
+		}
+		return setSCIMUserActive(ctx, rp, businessID, member.UserID, true, req.Active)
+	})
+	if err != nil {
+		return rs, err
+	}
+	if registered {
+		metrics.Registrations.Inc()
+	}
+	return s.GetUser(ctx, businessID, profile.user.ID)
+}
+
+// canTakeOver tells whether the business may provision an existing account: the account is no admin, the domain
+// of its email is one of the SAML connection of the business and no other business provisioned it.
+// An admin may impersonate anyone, a directory taking its account over could deactivate or rename it
+func (s *SCIMService) canTakeOver(ctx context.Context, rp repo.PGInterface, businessID uuid.UUID, user model.User) error {
+	log := tracing.WithCtx(ctx, "SCIMService.canTakeOver").WithField("business_id", businessID)
+	taken := scim.NewError(http.StatusConflict, scim.ErrUniqueness, "userName "+user.Email+" is used by another account")
+
+	if user.AccountType == model.AccountTypeAdmin {
+		log.Error("error_409: the account is an admin")
+		return taken
+	}
+	conn, err := rp.GetSAMLConnection(ctx, businessID, nil)
+	if err == gorm.ErrRecordNotFound {
+		log.Error("error_409: the business has no SAML connection owning the domain of the email")
+		return taken
+	}
+	if err != nil {
+		return err
+	}
+	domain := model.NormalizeEmail(user.Email)
+	domain = domain[strings.LastIndexByte(domain, '@')+1:]
+	if !conn.Domains.Contains(domain) {
+		log.Error("error_409: the business does not own the domain of the email")
+		return taken
+	}
+	if _, err = rp.GetSCIMUser(ctx, user.ID, nil); err == nil {
+		log.Error("error_409: the account is provisioned by another business")
+		return taken
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return nil
+}
+
+// saveSCIMProfile writes the profile on the account and the membership
+func saveSCIMProfile(ctx context.Context, rp repo.PGInterface, member *model.SCIMUser, profile scimProfile) error {
+	profile.user.ID = member.UserID
+	if err := rp.UpdateUserProfile(ctx, &profile.user, nil); err != nil {
+		return err
+	}
+	member.ExternalID, member.GivenName, member.FamilyName = profile.externalID, profile.givenName, profile.familyName
+	member.DeprovisionedAt = nil
+	return rp.UpdateSCIMUser(ctx, member, nil)
+}
+
+// setSCIMUserActive deactivates or reactivates the user when active asks for it. A deactivated user is soft-deleted:
+// it can not sign in, its refresh tokens are revoked and its access tokens are refused.
+func setSCIMUserActive(ctx context.Context, rp repo.PGInterface, businessID, userID uuid.UUID, wasActive bool, active *bool) error {
+	if active == nil || *active == wasActive {
+		return nil
+	}
+	metadata := map[string]interface{}{"business_id": businessID.String()}
+	if *active {
+		if err := rp.RestoreUser(ctx, userID, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserReactivated, &userID, metadata))
+	}
+	if err := rp.SoftDeleteUser(ctx, userID, nil); err != nil {
+		return err
+	}
+	if err := rp.DeleteUserRefreshTokens(ctx, userID, nil); err != nil {
+		return err
+	}
+	return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserDeactivated, &userID, metadata))
+}
+
+// ReplaceUser sets the attributes of the user, an omitted active leaves the user as it is
+func (s *SCIMService) ReplaceUser(ctx context.Context, businessID, id uuid.UUID, req scim.User) (rs scim.User, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.ReplaceUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	profile, err := newSCIMProfile(req)
+	if err != nil {
+		return rs, err
+	}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		member, err := scimUser(ctx, rp, businessID, id)
+		if err != nil {
+			return err
+		}
+		if err = saveSCIMProfile(ctx, rp, &member, profile); err != nil {
+			return err
+		}
+		if err = RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserUpdated, &id, map[string]interface{}{
+			"business_id": businessID.String(), "method": "scim",
+		})); err != nil {
+			return err
+		}
+		return setSCIMUserActive(ctx, rp, businessID, id, member.User.DeletedAt == nil, req.Active)
+	})
+	if err != nil {
+		return rs, err
+	}
+	return s.GetUser(ctx, businessID, id)
+}
+
+// PatchUser applies the operations to the user resource, then replaces the user with the result
+func (s *SCIMService) PatchUser(ctx context.Context, businessID, id uuid.UUID, req scim.PatchRequest) (rs scim.User, err error) {
+	current, err := s.GetUser(ctx, businessID, id)
+	if err != nil {
+		return rs, err
+	}
+	var patched scim.User
+	if err = patchResource(current, req, &patched); err != nil {
+		return rs, err
+	}
+	return s.ReplaceUser(ctx, businessID, id, patched)
+}
+
+// DeleteUser deprovisions the user: it is deactivated, leaves its groups and is no longer a resource of the business
+func (s *SCIMService) DeleteUser(ctx context.Context, businessID, id uuid.UUID) (err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.DeleteUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		member, err := scimUser(ctx, rp, businessID, id)
+		if err != nil {
+			return err
+		}
+		if err = rp.SoftDeleteUser(ctx, id, nil); err != nil {
+			return err
+		}
+		if err = rp.DeleteUserRefreshTokens(ctx, id, nil); err != nil {
+			return err
+		}
+		if err = rp.DeleteSCIMGroupMembersOfUser(ctx, id, nil); err != nil {
+			return err
+		}
+		now := time.Now()
+		member.DeprovisionedAt = &now
+		if err = rp.UpdateSCIMUser(ctx, &member, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserDeprovisioned, &id, map[string]interface{}{
+			"business_id": businessID.String(),
+		}))
+	})
+}
+
+// groupResource is the Group resource of g, users are the provisioned users of the business by id
+func groupResource(g model.SCIMGroup, memberIDs []uuid.UUID, users map[uuid.UUID]model.User) scim.Group {
+	rs := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          g.ID.String(),
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     scimLocation("Group", g.ID),
+		},
+	}
+	for _, id := range memberIDs {
+		u, ok := users[id]
+		if !ok {
+			continue
+		}
+		display := u.DisplayName
+		if display == "" {
+			display = u.Email
+		}
+		rs.Members = append(rs.Members, scim.MultiValued{
+			Value: id.String(), Display: display, Ref: scimLocation("User", id), Type: "User",
+		})
+	}
+	return rs
+}
+
+// provisionedUsers returns the users the business provisioned, by id
+func provisionedUsers(ctx context.Context, rp repo.PGInterface, businessID uuid.UUID) (map[uuid.UUID]model.User, error) {
+	members, err := rp.ListSCIMUsers(ctx, businessID, model.SCIMUserFilter{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[uuid.UUID]model.User, len(members))
+	for _, m := range members {
+		rs[m.UserID] = m.User
+	}
+	return rs, nil
+}
+
+// groupMembers returns the member ids of each group, by group id
+func groupMembers(ctx context.Context, rp repo.PGInterface, businessID uuid.UUID, groupIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	members, err := rp.ListSCIMGroupMembers(ctx, businessID, groupIDs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs := map[uuid.UUID][]uuid.UUID{}
+	for _, m := range members {
+		rs[m.GroupID] = append(rs[m.GroupID], m.UserID)
+	}
+	return rs, nil
+}
+
+func (s *SCIMService) GetGroup(ctx context.Context, businessID, id uuid.UUID) (rs scim.Group, err error) {
+	g, err := s.repo.GetSCIMGroup(ctx, businessID, id, nil)
+	if err == gorm.ErrRecordNotFound {
+		return rs, scimNotFound("Group", id)
+	}
+	if err != nil {
+		return rs, err
+	}
+	members, err := groupMembers(ctx, s.repo, businessID, []uuid.UUID{id})
+	if err != nil {
+		return rs, err
+	}
+	users, err := provisionedUsers(ctx, s.repo, businessID)
+	if err != nil {
+		return rs, err
+	}
+	return groupResource(g, members[id], users), nil
+}
+
+// ListGroups returns a page of the groups of the business that match the filter of req
+func (s *SCIMService) ListGroups(ctx context.Context, businessID uuid.UUID, req model.SCIMListReq) (rs scim.ListResponse, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.ListGroups")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	f, err := parseSCIMFilter(req.Filter)
+	if err != nil {
+		return rs, err
+	}
+	var narrow model.SCIMGroupFilter
+	narrow.DisplayName, _ = scim.EqualityValue(f, "displayName")
+	narrow.ExternalID, _ = scim.EqualityValue(f, "externalId")
+
+	groups, err := s.repo.ListSCIMGroups(ctx, businessID, narrow, nil)
+	if err != nil {
+		return rs, err
+	}
+	ids := make([]uuid.UUID, len(groups))
+	for i, g := range groups {
+		ids[i] = g.ID
+	}
+	members, err := groupMembers(ctx, s.repo, businessID, ids)
+	if err != nil {
+		return rs, err
+	}
+	users, err := provisionedUsers(ctx, s.repo, businessID)
+	if err != nil {
+		return rs, err
+	}
+	resources := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, groupResource(g, members[g.ID], users))
+	}
+	return scimPage(resources, f, req)
+}
+
+// checkGroup checks the group resource and returns the ids of its members, which must be users of the business.
+// The display name is unique in the business, except for the group id being replaced.
+func checkGroup(ctx context.Context, rp repo.PGInterface, businessID, id uuid.UUID, req scim.Group) ([]uuid.UUID, error) {
+	name := strings.TrimSpace(req.DisplayName)
+	switch {
+	case name == "":
+		return nil, invalidValue("displayName is required")
+	case len(name) > 255:
+		return nil, invalidValue("displayName is longer than 255 characters")
+	case len(strings.TrimSpace(req.ExternalID)) > 255:
+		return nil, invalidValue("externalId is longer than 255 characters")
+	}
+	same, err := rp.ListSCIMGroups(ctx, businessID, model.SCIMGroupFilter{DisplayName: name}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range same {
+		if g.ID != id {
+			return nil, scim.NewError(http.StatusConflict, scim.ErrUniqueness, "displayName "+name+" is used by another group")
+		}
+	}
+
+	users, err := provisionedUsers(ctx, rp, businessID)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]uuid.UUID, 0, len(req.Members))
+	for _, m := range req.Members {
+		userID, err := uuid.Parse(m.Value)
+		if _, ok := users[userID]; err != nil || !ok {
+			return nil, invalidValue("member " + m.Value + " is not a user of the business")
+		}
+		rs = append(rs, userID)
+	}
+	return rs, nil
+}
+
+func (s *SCIMService) CreateGroup(ctx context.Context, businessID uuid.UUID, req scim.Group) (rs scim.Group, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.CreateGroup")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	now := time.Now()
+	g := model.SCIMGroup{ID: uuid.New(), BusinessID: businessID, DisplayName: strings.TrimSpace(req.DisplayName),
+		ExternalID: strings.TrimSpace(req.ExternalID), CreatedAt: now, UpdatedAt: now}
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		members, err := checkGroup(ctx, rp, businessID, g.ID, req)
+		if err != nil {
+			return err
+		}
+		if err = rp.CreateSCIMGroup(ctx, &g, nil); err != nil {
+			return err
+		}
+		return rp.SetSCIMGroupMembers(ctx, g.ID, businessID, members, nil)
+	})
+	if err != nil {
+		return rs, err
+	}
+	return s.GetGroup(ctx, businessID, g.ID)
+}
+
+func (s *SCIMService) ReplaceGroup(ctx context.Context, businessID, id uuid.UUID, req scim.Group) (rs scim.Group, err error) {
+	ctx, span := tracing.Start(ctx, "SCIMService.ReplaceGroup")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		g, err := rp.GetSCIMGroup(ctx, businessID, id, nil)
+		if err == gorm.ErrRecordNotFound {
+			return scimNotFound("Group", id)
+		}
+		if err != nil {
+			return err
+		}
+		members, err := checkGroup(ctx, rp, businessID, id, req)
+		if err != nil {
+			return err
+		}
+		g.DisplayName, g.ExternalID = strings.TrimSpace(req.DisplayName), strings.TrimSpace(req.ExternalID)
+		if err = rp.UpdateSCIMGroup(ctx, &g, nil); err != nil {
+			return err
+		}
+		return rp.SetSCIMGroupMembers(ctx, id, businessID, members, nil)
+	})
+	if err != nil {
+		return rs, err
+	}
+	return s.GetGroup(ctx, businessID, id)
+}
+
+// PatchGroup applies the operations to the group resource, then replaces the group with the result
+func (s *SCIMService) PatchGroup(ctx context.Context, businessID, id uuid.UUID, req scim.PatchRequest) (rs scim.Group, err error) {
+	current, err := s.GetGroup(ctx, businessID, id)
+	if err != nil {
+		return rs, err
+	}
+	var patched scim.Group
+	if err = patchResource(current, req, &patched); err != nil {
+		return rs, err
+	}
+	return s.ReplaceGroup(ctx, businessID, id, patched)
+}
+
+func (s *SCIMService) DeleteGroup(ctx context.Context, businessID, id uuid.UUID) error {
+	return s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		ok, err := rp.DeleteSCIMGroup(ctx, businessID, id, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return scimNotFound("Group", id)
+		}
+		return nil
+	})
+}
+
+func parseSCIMFilter(s string) (scim.Filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	return scim.ParseFilter(s)
+}
+
+// scimPage keeps the resources matching f and returns the page of req
+func scimPage(resources []interface{}, f scim.Filter, req model.SCIMListReq) (rs scim.ListResponse, err error) {
+	matched := resources
+	if f != nil {
+		matched = resources[:0:0]
+		for _, r := range resources {
+			m, err := resourceMap(r)
+			if err != nil {
+				return rs, err
+			}
+			if f.Match(m) {
+				matched = append(matched, r)
+			}
+		}
+	}
+
+	start, count := req.StartIndex, SCIMMaxResults
+	if start < 1 {
+		start = 1
+	}
+	if req.Count != nil && *req.Count < count {
+		count = *req.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+	var page []interface{}
+	if start <= len(matched) {
+		end := start - 1 + count
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page = matched[start-1 : end]
+	}
+	return scim.NewListResponse(page, start, len(matched)), nil
+}
+
+// resourceMap returns the JSON form of a resource, the one filters and patches work on
+func resourceMap(resource interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var rs map[string]interface{}
+	err = json.Unmarshal(b, &rs)
+	return rs, err
+}
+
+// patchResource applies the operations of req to current and decodes the result into patched
+func patchResource(current interface{}, req scim.PatchRequest, patched interface{}) error {
+	if len(req.Operations) == 0 {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "Operations is required")
+	}
+	m, err := resourceMap(current)
+	if err != nil {
+		return err
+	}
+	if err = scim.Apply(m, req.Operations); err != nil {
+		return err
+	}
+	// some directories send booleans as strings, such as "active": "False"
+	if key := activeKey(m); key != "" {
+		if v, ok := m[key].(string); ok {
+			b, err := strconv.ParseBool(strings.ToLower(v))
+			if err != nil {
+				return invalidValue("active must be a boolean")
+			}
+			m[key] = b
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, patched); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return invalidValue("bad value of " + typeErr.Field)
+		}
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, err.Error())
+	}
+	return nil
+}
+
+func activeKey(m map[string]interface{}) string {
+	for k := range m {
+		if strings.EqualFold(k, "active") {
+			return k
+		}
+	}
+	return ""
+}
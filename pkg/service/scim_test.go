@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"ms-user/pkg/model"
+	"ms-user/pkg/repo"
+	"ms-user/pkg/saml/samltest"
+	"ms-user/pkg/scim"
+)
+
+const scimTestDomain = "acme.example"
+
+// newSCIMTestService gives a business a SCIM token, the users sign in with the UserService on the same repo
+func newSCIMTestService(t *testing.T) (*SCIMService, *UserService, uuid.UUID) {
+	t.Helper()
+	r := repo.NewMemoryRepo()
+	s := NewSCIMService(r).(*SCIMService)
+	businessID := uuid.New()
+	token, err := s.CreateToken(context.Background(), model.CreateSCIMTokenReq{BusinessID: businessID})
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	if got, err := s.Authenticate(context.Background(), token.Token); err != nil || got != businessID {
+		t.Fatalf("Authenticate = %s, %v, want the business", got, err)
+	}
+	return s, NewUserService(r, nil, nil).(*UserService), businessID
+}
+
+// connectSAML gives the business a SAML connection owning scimTestDomain
+func connectSAML(t *testing.T, s *SCIMService, businessID uuid.UUID) {
+	t.Helper()
+	idp, err := samltest.NewIdP("https://idp.test/metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSAMLService(s.repo).(*SAMLService).SaveConnection(context.Background(), businessID, model.SaveSAMLConnectionReq{
+		MetadataXML: string(idp.Metadata()), Domains: []string{scimTestDomain},
+	})
+	if err != nil {
+		t.Fatalf("SaveConnection: %v", err)
+	}
+}
+
+func scimTestUser(email string) scim.User {
+	active := true
+	return scim.User{Schemas: []string{scim.SchemaUser}, UserName: email, Active: &active,
+		Name: &scim.Name{GivenName: "Scim", FamilyName: "Test"}}
+}
+
+// signUpPassword creates an account signing in with a password
+func TestSCIMProvisionsUsers(t *testing.T) {
+	ctx := context.Background()
+	s, _, businessID := newSCIMTestService(t)
+
+	// a new userName creates an account, the same userName again is a uniqueness error
+	created, err := s.CreateUser(ctx, businessID, scimTestUser("scim-new@"+scimTestDomain))
+	if err != nil || created.ID == "" {
+		t.Fatalf("CreateUser = %+v, %v", created, err)
+	}
+	if _, err = s.CreateUser(ctx, businessID, scimTestUser("scim-new@"+scimTestDomain)); errStatus(err) != http.StatusConflict {
+		t.Errorf("CreateUser twice status = %d (%v), want 409", errStatus(err), err)
+	}
+	id := uuid.MustParse(created.ID)
+	if _, err = s.GetUser(ctx, uuid.New(), id); errStatus(err) != http.StatusNotFound {
+		t.Errorf("GetUser of another business status = %d (%v), want 404", errStatus(err), err)
+	}
+	list, err := s.ListUsers(ctx, businessID, model.SCIMListReq{Filter: `userName eq "SCIM-NEW@` + scimTestDomain + `"`})
+	if err != nil || list.TotalResults != 1 {
+		t.Errorf("ListUsers with a filter = %+v, %v, want the user", list, err)
+	}
+	if _, err = s.ListUsers(ctx, businessID, model.SCIMListReq{Filter: `userName eq`}); errStatus(err) != http.StatusBadRequest {
+		t.Errorf("ListUsers with a bad filter status = %d (%v), want 400", errStatus(err), err)
+	}
+}
+
+func TestSCIMTakesOverAccountsOfItsDomain(t *testing.T) {
+	ctx := context.Background()
+	s, users, businessID := newSCIMTestService(t)
+	existing := signUpPassword(t, users, "scim-user@"+scimTestDomain)
+
+	// an existing account is only taken over when a SAML connection of the business covers its domain
+	if _, err := s.CreateUser(ctx, businessID, scimTestUser(existing.Email)); errStatus(err) != http.StatusConflict {
+		t.Errorf("take over without SAML connection status = %d (%v), want 409", errStatus(err), err)
+	}
+	connectSAML(t, s, businessID)
+	linked, err := s.CreateUser(ctx, businessID, scimTestUser(existing.Email))
+	if err != nil || linked.ID != existing.ID.String() {
+		t.Fatalf("take over = %+v, %v, want the account %s", linked, err, existing.ID)
+	}
+
+	// a business provisioning the account first keeps it
+	other := uuid.New()
+	connectSAML(t, s, other)
+	if _, err = s.CreateUser(ctx, other, scimTestUser(existing.Email)); errStatus(err) != http.StatusConflict {
+		t.Errorf("take over an account of another business status = %d (%v), want 409", errStatus(err), err)
+	}
+
+	// an admin account is never taken over, even in the domain of the business
+	admin := model.User{Email: "scim-admin@" + scimTestDomain, Password: "hashed", AccountType: model.AccountTypeAdmin}
+	if err = s.repo.CreateUser(ctx, &admin, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateUser(ctx, businessID, scimTestUser(admin.Email)); errStatus(err) != http.StatusConflict {
+		t.Errorf("take over an admin status = %d (%v), want 409", errStatus(err), err)
+	}
+	if _, err = s.repo.GetSCIMUser(ctx, admin.ID, nil); err == nil {
+		t.Error("the admin is provisioned by the business")
+	}
+}
+
+func TestSCIMDeactivatesUsers(t *testing.T) {
+	ctx := context.Background()
+	s, users, businessID := newSCIMTestService(t)
+	connectSAML(t, s, businessID)
+	email := "scim-user@" + scimTestDomain
+	existing := signUpPassword(t, users, email)
+	session, err := loginPassword(users, email)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err = s.CreateUser(ctx, businessID, scimTestUser(email)); err != nil {
+		t.Fatalf("take over: %v", err)
+	}
+
+	// deactivating the account shuts its sessions out, reactivating it lets it sign in again
+	deactivate := scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp},
+		Operations: []scim.PatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage("false")}}}
+	patched, err := s.PatchUser(ctx, businessID, existing.ID, deactivate)
+	if err != nil || patched.Active == nil || *patched.Active {
+		t.Fatalf("deactivate = %+v, %v", patched, err)
+	}
+	if _, _, err = users.AuthenticateAccessToken(ctx, session.Token); err == nil {
+		t.Error("the access token of a deactivated user is accepted")
+	}
+	if _, err = loginPassword(users, email); err == nil {
+		t.Error("a deactivated user signs in")
+	}
+	deactivate.Operations[0].Value = json.RawMessage("true")
+	if _, err = s.PatchUser(ctx, businessID, existing.ID, deactivate); err != nil {
+		t.Fatalf("reactivate: %v", err)
+	}
+	if _, err = loginPassword(users, email); err != nil {
+		t.Errorf("a reactivated user signs in: %v", err)
+	}
+}
+
+func TestSCIMGroups(t *testing.T) {
+	ctx := context.Background()
+	s, _, businessID := newSCIMTestService(t)
+	alice, err := s.CreateUser(ctx, businessID, scimTestUser("alice@"+scimTestDomain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := s.CreateUser(ctx, businessID, scimTestUser("bob@"+scimTestDomain))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// groups hold provisioned users only
+	group, err := s.CreateGroup(ctx, businessID, scim.Group{Schemas: []string{scim.SchemaGroup}, DisplayName: "Engineering",
+		Members: []scim.MultiValued{{Value: alice.ID}, {Value: bob.ID}}})
+	if err != nil || len(group.Members) != 2 {
+		t.Fatalf("CreateGroup = %+v, %v, want two members", group, err)
+	}
+	_, err = s.CreateGroup(ctx, businessID, scim.Group{Schemas: []string{scim.SchemaGroup}, DisplayName: "Sales",
+		Members: []scim.MultiValued{{Value: uuid.NewString()}}})
+	if errStatus(err) != http.StatusBadRequest {
+		t.Errorf("CreateGroup with an unknown member status = %d (%v), want 400", errStatus(err), err)
+	}
+
+	// deprovisioning removes the user from the directory and its groups
+	if err = s.DeleteUser(ctx, businessID, uuid.MustParse(bob.ID)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err = s.GetUser(ctx, businessID, uuid.MustParse(bob.ID)); errStatus(err) != http.StatusNotFound {
+		t.Errorf("GetUser of a deprovisioned user status = %d (%v), want 404", errStatus(err), err)
+	}
+	if group, err = s.GetGroup(ctx, businessID, uuid.MustParse(group.ID)); err != nil || len(group.Members) != 1 || group.Members[0].Value != alice.ID {
+		t.Errorf("GetGroup after the deprovisioning = %+v, %v, want alice only", group, err)
+	}
+}
+
+func TestSCIMTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	s, _, businessID := newSCIMTestService(t)
+	tokens, err := s.ListTokens(ctx, model.ListSCIMTokensReq{BusinessID: businessID.String()})
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("ListTokens = %+v, %v, want the token", tokens, err)
+	}
+	token, err := s.CreateToken(ctx, model.CreateSCIMTokenReq{BusinessID: businessID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteToken(ctx, token.ID); err != nil {
+		t.Fatalf("DeleteToken: %v", err)
+	}
+	if _, err = s.Authenticate(ctx, token.Token); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("Authenticate with a revoked token status = %d (%v), want 401", errStatus(err), err)
+	}
+	if _, err = s.Authenticate(ctx, "not-a-scim-token"); errStatus(err) != http.StatusUnauthorized {
+		t.Errorf("Authenticate with another token status = %d (%v), want 401", errStatus(err), err)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"gitlab.com/goxp/cloud0/ginext"
+	"gitlab.com/goxp/cloud0/logger"
+
+	"ms-user/conf"
+	"ms-user/pkg/model"
+	"ms-user/pkg/oauth"
+	"ms-user/pkg/scim"
+)
+
+func TestMain(m *testing.M) {
+	if err := conf.SetEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logger.Init("ms-user-test")
+	os.Exit(m.Run())
+}
+
+// errStatus is the HTTP status the handlers would answer err with
+func errStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var apiErr ginext.ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code()
+	}
+	var scimErr *scim.Error
+	if errors.As(err, &scimErr) {
+		return scimErr.Status
+	}
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// signUpPassword creates a password account of email
+func signUpPassword(t *testing.T, s *UserService, email string) model.User {
+	t.Helper()
+	password := "Passw0rd!test"
+	user, err := s.CreateUser(context.Background(), model.CreateUserReq{Email: &email, Password: &password})
+	if err != nil {
+		t.Fatalf("CreateUser %s: %v", email, err)
+	}
+	return user
+}
+
+// loginPassword signs in as the account of signUpPassword
+func loginPassword(s *UserService, email string) (model.ConfirmLoginResponse, error) {
+	password := "Passw0rd!test"
+	return s.Login(context.Background(), model.CreateUserReq{Email: &email, Password: &password})
+}
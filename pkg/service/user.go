@@ -8,12 +8,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/praslar/lib/common"
 	"gitlab.com/goxp/cloud0/ginext"
-	"gitlab.com/goxp/cloud0/logger"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"ms-user/conf"
+	"ms-user/pkg/audit"
+	"ms-user/pkg/metrics"
 	"ms-user/pkg/model"
+	"ms-user/pkg/oidc"
 	"ms-user/pkg/repo"
+	"ms-user/pkg/sms"
+	"ms-user/pkg/tracing"
 	"ms-user/pkg/utils"
 	"ms-user/pkg/valid"
 	"net/http"
@@ -23,18 +27,42 @@ import (
 
 type UserService struct {
 	repo repo.PGInterface
+	sms  sms.SMSSender
+	// providers are the OpenID Connect providers users sign in with, by name
+	providers map[string]*oidc.Provider
 }
 
-func NewUserService(repo repo.PGInterface) UserInterface {
-	return &UserService{repo: repo}
+func NewUserService(repo repo.PGInterface, sender sms.SMSSender, providers map[string]*oidc.Provider) UserInterface {
+	return &UserService{repo: repo, sms: sender, providers: providers}
 }
 
 type UserInterface interface {
 	TestMsUser(ctx context.Context) error
 	CreateUser(ctx context.Context, req model.CreateUserReq) (rs model.User, err error)
 	Login(ctx context.Context, req model.CreateUserReq) (rs model.ConfirmLoginResponse, err error)
+	RefreshToken(ctx context.Context, req model.RefreshTokenReq) (rs model.ConfirmLoginResponse, err error)
 	ParseAccessToken(str string) (*model.AccessTokenClaims, error)
+	AuthenticateAccessToken(ctx context.Context, str string) (claims *model.AccessTokenClaims, userID uuid.UUID, err error)
+	AuthenticateAPIKey(ctx context.Context, key string) (rs model.APIKey, err error)
+	CreateAPIKey(ctx context.Context, userID uuid.UUID, req model.CreateAPIKeyReq) (rs model.CreateAPIKeyResponse, err error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id uuid.UUID) error
+	Impersonate(ctx context.Context, adminID, userID uuid.UUID, req model.ImpersonateReq) (rs model.ImpersonationResponse, err error)
+	RecordImpersonatedRequest(ctx context.Context, userID uuid.UUID, method, route string, status int)
 	GetOneUserByID(ctx context.Context, userID uuid.UUID) (res model.User, er error)
+	BatchGetUsers(ctx context.Context, req model.BatchGetUsersReq) (rs model.BatchGetUsersResult, err error)
+	GetLoginHistory(ctx context.Context, userID uuid.UUID, req model.LoginHistoryRequest) ([]model.LoginHistory, error)
+	RequestPhoneLoginCode(ctx context.Context, req model.PhoneOTPReq) (rs model.PhoneOTPResponse, err error)
+	LoginWithPhone(ctx context.Context, req model.PhoneLoginReq) (rs model.ConfirmLoginResponse, err error)
+	RequestPhoneVerificationCode(ctx context.Context, userID uuid.UUID, req model.PhoneOTPReq) (rs model.PhoneOTPResponse, err error)
+	VerifyPhone(ctx context.Context, userID uuid.UUID, req model.PhoneVerifyReq) (rs model.User, err error)
+	RequestMagicLink(ctx context.Context, req model.MagicLinkReq) (rs model.MagicLinkResponse, nonce string, err error)
+	ConsumeMagicLink(ctx context.Context, req model.ConsumeMagicLinkReq, nonce string) (rs model.ConfirmLoginResponse, err error)
+	StartOIDCLogin(ctx context.Context, provider string, req model.StartOIDCLoginReq) (authURL, state string, err error)
+	FinishOIDCLogin(ctx context.Context, provider string, req model.OIDCCallbackReq, cookieState string) (rs model.ConfirmLoginResponse, err error)
+	SAMLMetadata(ctx context.Context, businessID uuid.UUID) ([]byte, error)
+	StartSAMLLogin(ctx context.Context, businessID uuid.UUID, req model.StartSAMLLoginReq) (authURL, relayState string, err error)
+	FinishSAMLLogin(ctx context.Context, businessID uuid.UUID, req model.SAMLACSReq, cookieState string) (rs model.ConfirmLoginResponse, err error)
 }
 
 type AccessTokenClaims struct {
@@ -51,7 +79,7 @@ type RefreshTokenClaims struct {
 }
 
 func (s *UserService) TestMsUser(ctx context.Context) error {
-	log := logger.WithCtx(ctx, "UserService.TestMsUser")
+	log := tracing.WithCtx(ctx, "UserService.TestMsUser")
 
 	if err := s.repo.TestMsUser(ctx); err != nil {
 		return err
@@ -63,10 +91,15 @@ func (s *UserService) TestMsUser(ctx context.Context) error {
 }
 
 func (s *UserService) CreateUser(ctx context.Context, req model.CreateUserReq) (rs model.User, err error) {
-	log := logger.WithCtx(ctx, "UserService.CreateUser")
+	ctx, span := tracing.Start(ctx, "UserService.CreateUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.CreateUser")
 
-	//get email
-	email := strings.Trim(valid.String(req.Email), "")
+	//get email, the account is identified by its normalized form
+	email := strings.TrimSpace(valid.String(req.Email))
 
 	// validate email
 	if ok := utils.ValidateEmail(email); !ok {
@@ -74,15 +107,16 @@ func (s *UserService) CreateUser(ctx context.Context, req model.CreateUserReq) (
 		return rs, ginext.NewError(http.StatusBadRequest, "Email invalid")
 	}
 
-	user, err := s.repo.GetOneUserByEmail(ctx, email, nil)
-	if err != nil && err != gorm.ErrRecordNotFound {
-		return rs, err
+	_, err = s.repo.GetOneUserByEmail(ctx, email, nil)
+	if err == nil {
+		log.Error("error_409: This account has been existed")
+		return rs, ginext.NewError(http.StatusConflict, repo.AccountExistsMessage)
 	}
-	if user.Email == email {
-		log.Error("error_400: This account has been existed")
-		return rs, ginext.NewError(http.StatusBadRequest, "This account has been existed")
+	if err != gorm.ErrRecordNotFound {
+		return rs, err
 	}
 	common.Sync(req, &rs)
+	rs.Email = email
 
 	// verify password
 	if err = utils.VerifyPassword(valid.String(req.Password)); err != nil {
@@ -91,99 +125,261 @@ func (s *UserService) CreateUser(ctx context.Context, req model.CreateUserReq) (
 	}
 
 	// hash password
+	_, hashSpan := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
 	hashPass, err := bcrypt.GenerateFromPassword([]byte(valid.String(req.Password)), bcrypt.DefaultCost)
+	hashSpan.End()
 	if err != nil {
 		return rs, ginext.NewError(http.StatusBadRequest, "Cannot encode password")
 	} else {
 		rs.Password = string(hashPass)
 	}
 
-	if err = s.repo.CreateUser(ctx, &rs, nil); err != nil {
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if err := rp.CreateUser(ctx, &rs, nil); err != nil {
+			return err
+		}
+		return RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRegistered, &rs.ID, map[string]interface{}{"email": model.NormalizeEmail(email)}))
+	})
+	if err != nil {
 		return rs, err
 	}
+	metrics.Registrations.Inc()
 
 	return rs, nil
 }
 
 func (s *UserService) Login(ctx context.Context, req model.CreateUserReq) (rs model.ConfirmLoginResponse, err error) {
-	log := logger.WithCtx(ctx, "UserService.Login")
+	ctx, span := tracing.Start(ctx, "UserService.Login")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.Login")
 
 	//get email
-	email := strings.Trim(valid.String(req.Email), " ")
+	email := model.NormalizeEmail(valid.String(req.Email))
+	deviceID := strings.TrimSpace(valid.String(req.DeviceID))
 	user, err := s.repo.GetOneUserByEmail(ctx, email, nil)
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			metrics.Logins.Inc(metrics.LoginUnknownUser)
+			recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, nil, map[string]interface{}{
+				"email": email, "reason": metrics.LoginUnknownUser,
+			}))
+		}
 		return rs, err
 	}
 
+	span.SetAttributes(tracing.Attributes("user.id", user.ID.String())...)
+
+	if locked, err := s.passwordLocked(ctx, user.ID); err != nil {
+		return rs, err
+	} else if locked {
+		log.Error("error_429: password login locked")
+		metrics.Logins.Inc(metrics.LoginLocked)
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, &user.ID, map[string]interface{}{
+			"email": email, "reason": metrics.LoginLocked,
+		}))
+		recordFailedLogin(ctx, s.repo, newLoginHistory(ctx, user.ID, deviceID, false, metrics.LoginLocked))
+		return rs, ginext.NewError(http.StatusTooManyRequests, "Too many failed logins, try again later")
+	}
+
 	// check password
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(valid.String(req.Password))); err != nil {
+	_, compareSpan := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(valid.String(req.Password)))
+	compareSpan.End()
+	if err != nil {
 		log.Error("error_400: Password incorrect in Login - UserService")
+		metrics.Logins.Inc(metrics.LoginBadPassword)
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditLoginFailed, &user.ID, map[string]interface{}{
+			"email": email, "reason": metrics.LoginBadPassword,
+		}))
+		recordFailedLogin(ctx, s.repo, newLoginHistory(ctx, user.ID, deviceID, false, metrics.LoginBadPassword))
 		return rs, ginext.NewError(http.StatusUnauthorized, "account or password incorrect")
 	}
 
-	// create refresh_token
-	rs.RefreshToken, err = s.CreateRefreshToken(ctx, user.ID, "")
+	return s.signIn(ctx, user, deviceID, map[string]interface{}{"email": email})
+}
+
+// passwordLocked tells if LOGIN_MAX_FAILURES wrong passwords were given for the user within LOGIN_LOCKOUT_MINUTES,
+// without a successful sign-in since; the lock lifts as the oldest of them leaves the window
+func (s *UserService) passwordLocked(ctx context.Context, userID uuid.UUID) (bool, error) {
+	cfg := conf.LoadEnv()
+	since := time.Now().Add(-time.Duration(cfg.LoginLockoutMinutes) * time.Minute)
+	failures, err := s.repo.CountLoginFailures(ctx, userID, metrics.LoginBadPassword, since, nil)
+	if err != nil {
+		return false, err
+	}
+	return failures >= int64(cfg.LoginMaxFailures), nil
+}
+
+// signIn issues the tokens of an authenticated user, metadata describes the login in the audit log
+func (s *UserService) signIn(ctx context.Context, user model.User, deviceID string, metadata map[string]interface{}) (rs model.ConfirmLoginResponse, err error) {
+	// create refresh_token, the successful login is audited and added to the login history with it
+	history := newLoginHistory(ctx, user.ID, deviceID, true, "")
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if rs.RefreshToken, err = s.createRefreshToken(ctx, rp, user.ID, deviceID, ""); err != nil {
+			return err
+		}
+		ev := audit.NewEvent(ctx, model.AuditLoginSucceeded, &user.ID, metadata)
+		if err = RecordAudit(ctx, rp, ev); err != nil {
+			return err
+		}
+		return recordSuccessfulLogin(ctx, rp, user, history)
+	})
 	if err != nil {
 		return rs, ginext.NewError(http.StatusBadRequest, utils.MessageError()[http.StatusBadRequest])
 	}
 
 	// create access_token
+	_, signSpan := tracing.Start(ctx, "jwt.Sign")
+	rs.Token, err = utils.CreateToken(model.CreateTokenRequest{
+		UserID:   user.ID.String(),
+		NumHour:  conf.LoadEnv().NumHourExpToken,
+		Extra:    "",
+		DeviceID: deviceID,
+	})
+	signSpan.End()
+	if err != nil {
+		return rs, ginext.NewError(http.StatusBadRequest, utils.MessageError()[http.StatusBadRequest])
+	}
+	metrics.TokensIssued.Inc(metrics.TokenAccess)
+	metrics.Logins.Inc(metrics.LoginSuccess)
+
+	return rs, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token,
+// the used refresh token is revoked so it can not be replayed
+func (s *UserService) RefreshToken(ctx context.Context, req model.RefreshTokenReq) (rs model.ConfirmLoginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RefreshToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	log := tracing.WithCtx(ctx, "UserService.RefreshToken")
+	unauthorized := ginext.NewError(http.StatusUnauthorized, utils.MessageError()[http.StatusUnauthorized])
+
+	str := valid.String(req.RefreshToken)
+	claims, err := s.ParseRefreshToken(str)
+	if err != nil {
+		log.WithError(err).Error("error_401: invalid refresh token")
+		return rs, unauthorized
+	}
+	userID, err := utils.ExtractUserID(claims.Subject)
+	if err != nil {
+		log.WithError(err).Error("error_401: invalid userID in refresh token")
+		return rs, unauthorized
+	}
+	parts := strings.Split(str, ".")
+	stored, err := s.repo.GetRefreshTokenBySign(ctx, parts[len(parts)-1], nil)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.WithField("user_id", userID).Error("error_401: refresh token was revoked")
+			return rs, unauthorized
+		}
+		return rs, err
+	}
+	if stored.ClientID != "" {
+		log.WithField("client_id", stored.ClientID).Error("error_401: refresh token of an OAuth client")
+		return rs, unauthorized
+	}
+
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		rs.RefreshToken, err = s.createRefreshToken(ctx, rp, userID, claims.DeviceID, claims.Audience)
+		return err
+	})
+	if err != nil {
+		return rs, err
+	}
+
 	rs.Token, err = utils.CreateToken(model.CreateTokenRequest{
-		UserID:  user.ID.String(),
-		NumHour: conf.LoadEnv().NumHourExpToken,
-		Extra:   "",
+		UserID:   userID.String(),
+		NumHour:  conf.LoadEnv().NumHourExpToken,
+		Extra:    "",
+		DeviceID: claims.DeviceID,
 	})
 	if err != nil {
 		return rs, ginext.NewError(http.StatusBadRequest, utils.MessageError()[http.StatusBadRequest])
 	}
+	metrics.TokensIssued.Inc(metrics.TokenAccess)
+	metrics.TokensRefreshed.Inc()
 
 	return rs, nil
 }
 
-// CreateRefreshToken makes a new refresh token, store information in DB then return the token string
-func (s *UserService) CreateRefreshToken(ctx context.Context, userID uuid.UUID, extra string) (string, error) {
+// CreateRefreshToken makes a new refresh token, store information in DB then return the token string.
+// events are added to the audit log in the same transaction.
+func (s *UserService) CreateRefreshToken(ctx context.Context, userID uuid.UUID, deviceID, extra string, events ...model.AuditEvent) (signed string, err error) {
+	err = s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+		if signed, err = s.createRefreshToken(ctx, rp, userID, deviceID, extra); err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if err = RecordAudit(ctx, rp, ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return signed, nil
+}
+
+// createRefreshToken stores a new refresh token of userID on deviceID through rp,
+// the previous one of the user is revoked
+func (s *UserService) createRefreshToken(ctx context.Context, rp repo.PGInterface, userID uuid.UUID, deviceID, extra string) (signed string, err error) {
+	// delete existing refresh token in this device
+	if err = rp.DeleteRefreshToken(ctx, userID, nil); err != nil {
+		return "", err
+	}
+	return issueRefreshToken(ctx, rp, model.RefreshToken{UserID: userID, DeviceID: deviceID}, extra)
+}
+
+// issueRefreshToken signs a refresh token of row.UserID whose audience is aud, and stores row with its signature
+func issueRefreshToken(ctx context.Context, rp repo.PGInterface, row model.RefreshToken, aud string) (signed string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateRefreshToken")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	now := time.Now().Unix()
 	expiresAt := now + int64((time.Duration(conf.LoadEnv().RefreshTokenTTLInDays) * time.Hour * 24).Seconds())
 	claims := &RefreshTokenClaims{
+		DeviceID: row.DeviceID,
 		StandardClaims: jwt.StandardClaims{
-			Audience:  extra,
+			// a unique id keeps two tokens issued in the same second apart
+			Id:        uuid.NewString(),
+			Audience:  aud,
 			IssuedAt:  now,
 			ExpiresAt: expiresAt,
 			Issuer:    "ms-user",
-			Subject:   userID.String(),
+			Subject:   row.UserID.String(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(conf.LoadEnv().JWTSecret))
+	signed, err = token.SignedString([]byte(conf.LoadEnv().JWTSecret))
 	if err != nil {
 		return "", err
 	}
 
 	parts := strings.Split(signed, ".")
-	dto := model.RefreshToken{
-		Sign:   parts[2],
-		UserID: userID,
-	}
-	err = s.repo.Transaction(context.Background(), func(rp repo.PGInterface) error {
-		// delete existing refresh token in this device
-		if err = s.repo.DeleteRefreshToken(ctx, userID, nil); err != nil {
-			return err
-		}
+	row.Sign = parts[2]
+	row.ExpiredAt = time.Unix(expiresAt, 0)
 
-		// create new refresh token
-		if err = s.repo.CreateRefreshToken(ctx, &dto, nil); err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
+	// create new refresh token
+	if err = rp.CreateRefreshToken(ctx, &row, nil); err != nil {
 		return "", err
 	}
+	metrics.TokensIssued.Inc(metrics.TokenRefresh)
 
 	return signed, nil
 }
 
-func (s *UserService) keyFunc(token *jwt.Token) (interface{}, error) {
+func keyFunc(token *jwt.Token) (interface{}, error) {
 	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 		return nil, fmt.Errorf("unexpected signing method: %s", token.Header["alg"])
 	}
@@ -192,7 +388,7 @@ func (s *UserService) keyFunc(token *jwt.Token) (interface{}, error) {
 
 // ParseRefreshToken ...
 func (s *UserService) ParseRefreshToken(str string) (*RefreshTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(str, &RefreshTokenClaims{}, s.keyFunc)
+	token, err := jwt.ParseWithClaims(str, &RefreshTokenClaims{}, keyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -203,9 +399,10 @@ func (s *UserService) ParseRefreshToken(str string) (*RefreshTokenClaims, error)
 	return claims, nil
 }
 
-// Parse Access token ...
+// ParseAccessToken checks an access token of the sign-in of ms-user.
+// Those given to OAuth clients have the client as audience and are refused: they only open /oauth/userinfo.
 func (s *UserService) ParseAccessToken(str string) (*model.AccessTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(str, &model.AccessTokenClaims{}, s.keyFunc)
+	token, err := jwt.ParseWithClaims(str, &model.AccessTokenClaims{}, keyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -213,10 +410,140 @@ func (s *UserService) ParseAccessToken(str string) (*model.AccessTokenClaims, er
 		return nil, errors.New("invalid token")
 	}
 	claims, _ := token.Claims.(*model.AccessTokenClaims)
+	if claims.Audience != "" {
+		return nil, errors.New("token is issued to an OAuth client")
+	}
 	return claims, nil
 }
 
+// AuthenticateAccessToken checks the access token like ParseAccessToken and that its user still exists:
+// the tokens of a user a business deactivated or deprovisioned are refused before they expire.
+// An impersonation token also needs its admin to still be an admin.
+func (s *UserService) AuthenticateAccessToken(ctx context.Context, str string) (claims *model.AccessTokenClaims, userID uuid.UUID, err error) {
+	if claims, err = s.ParseAccessToken(str); err != nil {
+		return nil, userID, err
+	}
+	if userID, err = utils.ExtractUserID(claims.Subject); err != nil {
+		return nil, userID, err
+	}
+	if _, err = s.repo.GetOneUserByID(ctx, userID, nil); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, userID, errors.New("the user of the token is deleted")
+		}
+		return nil, userID, err
+	}
+	if claims.Act != nil {
+		if err = s.checkImpersonator(ctx, claims); err != nil {
+			return nil, userID, err
+		}
+	}
+	return claims, userID, nil
+}
+
 // Get one user by id
 func (s *UserService) GetOneUserByID(ctx context.Context, ID uuid.UUID) (res model.User, err error) {
-	return s.repo.GetOneUserByID(ctx, ID, nil)
+	ctx, span := tracing.Start(ctx, "UserService.GetOneUserByID", tracing.WithAttributes("user.id", ID.String()))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	res, err = s.repo.GetOneUserByID(ctx, ID, nil)
+	if err != nil {
+		return res, err
+	}
+	// reading somebody else's account is audited
+	if actorID, ok := audit.ActorFromContext(ctx); ok && actorID != ID {
+		recordAuditAlone(ctx, s.repo, audit.NewEvent(ctx, model.AuditUserRead, &ID, nil))
+	}
+	return res, nil
+}
+
+// MaxBatchGetUsers bounds the ids and emails of one BatchGetUsers call
+const MaxBatchGetUsers = 100
+
+// BatchGetUsers loads the users of req.IDs with one query and those of req.Emails with another.
+// The users are in the order of the ids then of the emails, without duplicates; MissingEmails are normalized.
+func (s *UserService) BatchGetUsers(ctx context.Context, req model.BatchGetUsersReq) (rs model.BatchGetUsersResult, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.BatchGetUsers", tracing.WithAttributes("user.count", len(req.IDs)+len(req.Emails)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if len(req.IDs)+len(req.Emails) > MaxBatchGetUsers {
+		return rs, ginext.NewError(http.StatusBadRequest, fmt.Sprintf("At most %d users can be requested at once", MaxBatchGetUsers))
+	}
+	emails := make([]string, 0, len(req.Emails))
+	for _, e := range req.Emails {
+		if e = model.NormalizeEmail(e); e != "" {
+			emails = append(emails, e)
+		}
+	}
+
+	byID, err := s.repo.GetUsersByIDs(ctx, req.IDs, nil)
+	if err != nil {
+		return rs, err
+	}
+	byEmail, err := s.repo.GetUsersByEmails(ctx, emails, nil)
+	if err != nil {
+		return rs, err
+	}
+
+	users := make(map[uuid.UUID]model.User, len(byID))
+	for _, u := range byID {
+		users[u.ID] = u
+	}
+	seen := make(map[uuid.UUID]bool, len(req.IDs)+len(emails))
+	for _, id := range req.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if u, ok := users[id]; ok {
+			rs.Users = append(rs.Users, u)
+		} else {
+			rs.MissingIDs = append(rs.MissingIDs, id)
+		}
+	}
+
+	emailUsers := make(map[string]model.User, len(byEmail))
+	for _, u := range byEmail {
+		emailUsers[model.NormalizeEmail(u.Email)] = u
+	}
+	seenEmail := make(map[string]bool, len(emails))
+	for _, e := range emails {
+		if seenEmail[e] {
+			continue
+		}
+		seenEmail[e] = true
+		u, ok := emailUsers[e]
+		if !ok {
+			rs.MissingEmails = append(rs.MissingEmails, e)
+			continue
+		}
+		if !seen[u.ID] {
+			seen[u.ID] = true
+			rs.Users = append(rs.Users, u)
+		}
+	}
+
+	// reading somebody else's account is audited, like in GetOneUserByID
+	if actorID, ok := audit.ActorFromContext(ctx); ok {
+		auditErr := s.repo.Transaction(ctx, func(rp repo.PGInterface) error {
+			for i := range rs.Users {
+				if rs.Users[i].ID == actorID {
+					continue
+				}
+				if err := RecordAudit(ctx, rp, audit.NewEvent(ctx, model.AuditUserRead, &rs.Users[i].ID, nil)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if auditErr != nil {
+			tracing.WithCtx(ctx, "UserService.BatchGetUsers").WithError(auditErr).Error("failed to record audit events")
+		}
+	}
+	return rs, nil
 }
@@ -0,0 +1,70 @@
+// Package servicetoken signs and reads the tokens other services call the /internal routes of ms-user with.
+//
+// A service token is an HS256 JWT issued by the calling service, signed with the secret of that service in
+// INTERNAL_SERVICES, for the Audience audience, and living at most MaxTTL. It is not an access token:
+// its audience keeps it out of the user routes, and the access tokens out of the /internal routes.
+package servicetoken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Audience is the aud claim of every service token
+const Audience = "ms-user-internal"
+
+// MaxTTL bounds the lifetime of a token, a leaked one is soon useless
+const MaxTTL = 5 * time.Minute
+
+// Skew backdates iat, a token issued on a host whose clock is slightly ahead is not refused as issued in the future
+const Skew = 30 * time.Second
+
+// Sign makes a token of service valid for ttl from now
+func Sign(service, secret string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl+Skew > MaxTTL {
+		return "", fmt.Errorf("service token lifetime must be within %s", MaxTTL-Skew)
+	}
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		Audience:  Audience,
+		Issuer:    service,
+		Subject:   service,
+		IssuedAt:  now.Add(-Skew).Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// Parse returns the service which signed token, secret returns the secret of a service or false for an unknown one.
+// The service claimed by a rejected token is returned with the error, it is not authenticated.
+func Parse(token string, secret func(service string) (string, bool)) (string, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		key, ok := secret(claims.Issuer)
+		if !ok {
+			return nil, fmt.Errorf("unknown service %q", claims.Issuer)
+		}
+		return []byte(key), nil
+	})
+	switch {
+	case err != nil:
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Inner != nil {
+			err = vErr.Inner
+		}
+		return claims.Issuer, err
+	case !claims.VerifyAudience(Audience, true):
+		return claims.Issuer, fmt.Errorf("audience %q is not %s", claims.Audience, Audience)
+	case claims.IssuedAt == 0 || claims.ExpiresAt == 0:
+		return claims.Issuer, errors.New("iat and exp are required")
+	case time.Duration(claims.ExpiresAt-claims.IssuedAt)*time.Second > MaxTTL:
+		return claims.Issuer, fmt.Errorf("the token lives longer than %s", MaxTTL)
+	}
+	return claims.Issuer, nil
+}
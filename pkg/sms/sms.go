@@ -0,0 +1,74 @@
+// Package sms sends the text messages of the service through a pluggable driver.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gitlab.com/goxp/cloud0/logger"
+)
+
+const (
+	DriverLog = "log"
+)
+
+type Message struct {
+	// To is an E.164 phone number
+	To   string
+	Body string
+}
+
+type SMSSender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	Driver string
+}
+
+// New returns the sender of cfg.Driver, the log driver is used when it is empty
+func New(cfg Config) (SMSSender, error) {
+	switch cfg.Driver {
+	case "", DriverLog:
+		return LogSender{}, nil
+	}
+	return nil, fmt.Errorf("unsupported sms driver %q", cfg.Driver)
+}
+
+// LogSender only writes the messages to the log, for local development
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	logger.WithCtx(ctx, "sms.LogSender").
+		WithField("to", msg.To).
+		Info(msg.Body)
+	return nil
+}
+
+// FakeSender records the messages instead of sending them, for tests
+type FakeSender struct {
+	mu   sync.Mutex
+	sent []Message
+	Err  error
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{}
+}
+
+func (s *FakeSender) Send(_ context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *FakeSender) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/goxp/cloud0/logger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type logFieldsKey struct{}
+
+// WithLogFields adds fields to every line WithCtx logs for ctx, e.g. to tag the lines of a request
+func WithLogFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	if prev, ok := ctx.Value(logFieldsKey{}).(logrus.Fields); ok {
+		for k, v := range prev {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, logFieldsKey{}, merged)
+}
+
+// WithCtx is logger.WithCtx with the trace_id and span_id of the current span, and the fields of WithLogFields
+func WithCtx(ctx context.Context, tag string) *logrus.Entry {
+	l := logger.WithCtx(ctx, tag)
+
+	// gin.Context only resolves string keys, the span lives in the request context
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.WithFields(logrus.Fields{
+			"trace_id": sc.TraceID().String(),
+			"span_id":  sc.SpanID().String(),
+		})
+	}
+	if fields, ok := ctx.Value(logFieldsKey{}).(logrus.Fields); ok {
+		l = l.WithFields(fields)
+	}
+	return l
+}
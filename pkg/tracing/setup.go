@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// Exporters of OTEL_TRACES_EXPORTER
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+type Config struct {
+	// Exporter is ExporterNone or ExporterOTLP
+	Exporter    string
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+}
+
+// Init installs the TracerProvider described by cfg and the W3C propagators as the OpenTelemetry globals.
+// Without exporter spans are still created, so logs keep their trace_id, but they are dropped.
+func Init(cfg Config) (*sdktrace.TracerProvider, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	switch cfg.Exporter {
+	case "", ExporterNone:
+	case ExporterOTLP:
+		exporter, err := newOTLPExporter(cfg.Endpoint, cfg.Headers)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q", cfg.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp, nil
+}
+
+// newOTLPExporter sends to endpoint + /v1/traces, endpoint is the collector base URL (http://localhost:4318)
+func newOTLPExporter(endpoint string, headers map[string]string) (*otlptrace.Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("OTLP endpoint must be an http(s) URL, got %q", endpoint)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+		otlptracehttp.WithHeaders(headers),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// ParseHeaders reads the OTEL_EXPORTER_OTLP_HEADERS format: key1=value1,key2=value2
+func ParseHeaders(v string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return headers
+}
@@ -0,0 +1,59 @@
+// Package tracing follows requests through handlers, services and the database with OpenTelemetry.
+// Spans go through the global TracerProvider installed by Init, the W3C trace context and baggage
+// are propagated, and the spans are exported with OTLP over HTTP.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the spans started by ms-user itself
+const instrumentationName = "ms-user"
+
+// Start starts a span with the global TracerProvider, as a child of the span or remote span context in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// WithAttributes sets the attributes of a new span from key, value pairs
+func WithAttributes(kv ...interface{}) trace.SpanStartOption {
+	return trace.WithAttributes(Attributes(kv...)...)
+}
+
+// Attributes converts key, value pairs, values other than strings, integers, floats and booleans are formatted
+func Attributes(kv ...interface{}) []attribute.KeyValue {
+	rs := make([]attribute.KeyValue, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key := attribute.Key(fmt.Sprint(kv[i]))
+		switch v := kv[i+1].(type) {
+		case string:
+			rs = append(rs, key.String(v))
+		case int:
+			rs = append(rs, key.Int(v))
+		case int64:
+			rs = append(rs, key.Int64(v))
+		case float64:
+			rs = append(rs, key.Float64(v))
+		case bool:
+			rs = append(rs, key.Bool(v))
+		default:
+			rs = append(rs, key.String(fmt.Sprint(v)))
+		}
+	}
+	return rs
+}
+
+// RecordError records err on span and marks the span as failed, a nil err is ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
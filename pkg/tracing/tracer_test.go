@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRecordError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(instrumentationName)
+
+	_, failed := tracer.Start(context.Background(), "failed")
+	RecordError(failed, errors.New("database is down"))
+	failed.End()
+	_, ok := tracer.Start(context.Background(), "ok")
+	RecordError(ok, nil)
+	ok.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended", len(spans))
+	}
+	if st := spans[0].Status(); st.Code != codes.Error || st.Description != "database is down" {
+		t.Errorf("status of the failed span = %+v", st)
+	}
+	if events := spans[0].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("events of the failed span = %+v", events)
+	}
+	if st := spans[1].Status(); st.Code != codes.Unset || len(spans[1].Events()) != 0 {
+		t.Errorf("a nil error changed the span: %+v, %+v", st, spans[1].Events())
+	}
+}
@@ -22,6 +22,8 @@ func CreateToken(req model.CreateTokenRequest) (res string, err error) {
 		Subject: req.UserID, // later we should use this field for userID instead of Issuer
 	}
 	mainClain := model.AccessTokenClaims{
+		DeviceID:       req.DeviceID,
+		Scope:          req.Scope,
 		StandardClaims: *claims,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mainClain)
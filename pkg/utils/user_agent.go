@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"strings"
+)
+
+// uaBrowsers is checked in order, tokens of Chromium based browsers come before "Chrome"
+// and "Chrome" comes before "Safari" since every Chrome user agent contains Safari
+var uaBrowsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"Version/", "Safari"},
+	{"curl/", "curl"},
+	{"PostmanRuntime/", "Postman"},
+	{"okhttp/", "OkHttp"},
+	{"Dart/", "Dart"},
+}
+
+var uaSystems = []struct {
+	token string
+	name  string
+}{
+	{"Windows NT", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// ParseUserAgent returns the browser with its major version and the operating system of ua,
+// "Other" when it is not recognized
+func ParseUserAgent(ua string) (browser, os string) {
+	browser, os = "Other", "Other"
+	for _, b := range uaBrowsers {
+		idx := strings.Index(ua, b.token)
+		if idx < 0 {
+			continue
+		}
+		browser = b.name
+		version := ua[idx+len(b.token):]
+		if end := strings.IndexAny(version, ". ;)"); end >= 0 {
+			version = version[:end]
+		}
+		if version != "" {
+			browser += " " + version
+		}
+		break
+	}
+	for _, s := range uaSystems {
+		if strings.Contains(ua, s.token) {
+			os = s.name
+			break
+		}
+	}
+	return browser, os
+}
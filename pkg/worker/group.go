@@ -0,0 +1,84 @@
+// Package worker runs the background loops of the service and stops them in order on shutdown.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/goxp/cloud0/logger"
+)
+
+// Group runs named workers until Stop is called, a worker must return once its context is done
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel, running: map[string]int{}}
+}
+
+// Go starts fn in its own goroutine, a panic stops only this worker
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	g.mu.Lock()
+	g.running[name]++
+	g.mu.Unlock()
+	g.wg.Add(1)
+
+	go func() {
+		log := logger.Tag("worker.Group").WithField("worker", name)
+		defer func() {
+			if rc := recover(); rc != nil {
+				log.Errorf("worker panic: %v", rc)
+			}
+			g.mu.Lock()
+			if g.running[name]--; g.running[name] == 0 {
+				delete(g.running, name)
+			}
+			g.mu.Unlock()
+			g.wg.Done()
+		}()
+
+		log.Info("worker started")
+		fn(g.ctx)
+		log.Info("worker stopped")
+	}()
+}
+
+// Stop cancels the workers and waits for them to return, or for ctx to be done.
+// The error lists the workers still running when ctx is done.
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("workers still running: %s", strings.Join(g.Running(), ", "))
+	}
+}
+
+// Running returns the names of the workers that have not returned yet
+func (g *Group) Running() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	names := make([]string, 0, len(g.running))
+	for name := range g.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}